@@ -7,10 +7,13 @@ import (
 	"os"
 
 	"github.com/danecwalker/otari/internal/commands"
+	"github.com/danecwalker/otari/internal/output"
 	"github.com/danecwalker/otari/internal/podman"
 	"github.com/danecwalker/otari/internal/spinners"
 	"github.com/danecwalker/otari/internal/systemd"
 	"github.com/danecwalker/otari/internal/utils"
+	"github.com/danecwalker/otari/pkg/otari"
+	"github.com/danecwalker/otari/pkg/spinner"
 	"github.com/fatih/color"
 	"github.com/urfave/cli/v3"
 )
@@ -20,10 +23,66 @@ var commit = "none"
 var date = "unknown"
 
 func main() {
-	printLogo()
-
 	cmd := &cli.Command{
 		Name: "otari",
+		Before: func(ctx context.Context, c *cli.Command) (context.Context, error) {
+			mode, err := output.ParseMode(c.String("output"), spinner.IsTerminal(os.Stdout))
+			if err != nil {
+				return ctx, err
+			}
+			output.Set(mode, c.Bool("quiet"))
+			var stateDir string
+			if c.Bool("central-state") {
+				if stateDir, err = utils.StateLocation(); err != nil {
+					return ctx, fmt.Errorf("failed to locate the state directory: %w", err)
+				}
+			}
+			commands.SetGlobals(otari.Options{
+				Parallelism: int(c.Int("parallel")),
+				Wait:        c.Bool("wait"),
+				WaitTimeout: c.Duration("timeout"),
+				StateDir:    stateDir,
+			})
+			// keep machine readable output clean
+			if mode == output.Human && !c.Bool("quiet") && !jsonRequested(c) {
+				printLogo()
+			}
+			return ctx, nil
+		},
+		Flags: []cli.Flag{
+			&cli.StringSliceFlag{
+				Name:  "env-file",
+				Usage: "Read variables for ${VAR} interpolation from this file instead of the .env file next to the stack file",
+			},
+			&cli.IntFlag{
+				Name:  "parallel",
+				Value: otari.DefaultParallelism,
+				Usage: "How many images to pull or build at the same time",
+			},
+			&cli.BoolFlag{
+				Name:  "wait",
+				Usage: "Wait for another otari process changing the stack instead of failing",
+			},
+			&cli.DurationFlag{
+				Name:  "timeout",
+				Usage: "How long to wait for another otari process changing the stack, implies --wait",
+			},
+			&cli.BoolFlag{
+				Name:    "central-state",
+				Usage:   "Keep lock files and history in $XDG_STATE_HOME/otari instead of next to the stack file",
+				Sources: cli.EnvVars("OTARI_CENTRAL_STATE"),
+			},
+			&cli.StringFlag{
+				Name:    "output",
+				Aliases: []string{"o"},
+				Usage:   "Output mode: human, plain or json, plain unless stdout is a terminal",
+			},
+			&cli.BoolFlag{
+				Name:    "quiet",
+				Aliases: []string{"q"},
+				Usage:   "Only print failures and the data a command was asked for",
+			},
+		},
 		Commands: []*cli.Command{
 			{
 				Name:  "version",
@@ -43,11 +102,115 @@ func main() {
 						Usage:   "Path to the stack definition file",
 						Aliases: []string{"f"},
 					},
+					&cli.BoolFlag{
+						Name:  "dry-run",
+						Usage: "Show what would change without applying it",
+					},
+					&cli.BoolFlag{
+						Name:  "no-rollback",
+						Usage: "Leave a failed deployment in place instead of rolling back to the last successful revision",
+					},
+					&cli.DurationFlag{
+						Name:  "health-grace",
+						Value: otari.DefaultHealthGrace,
+						Usage: "How long changed containers with a healthcheck have to become healthy",
+					},
+					&cli.BoolFlag{
+						Name:  "frozen",
+						Usage: "Fail if the lock file is missing or does not pin the image of every container",
+					},
+				},
+				Action: func(ctx context.Context, c *cli.Command) error {
+					stackPath := c.String("file")
+					if c.Bool("dry-run") {
+						commands.Plan(ctx, stackPath, c.StringSlice("env-file"))
+						return nil
+					}
+					systemCheck()
+					commands.Start(ctx, stackPath, c.StringSlice("env-file"), c.Bool("no-rollback"), c.Duration("health-grace"), c.Bool("frozen"))
+					return nil
+				},
+			},
+			{
+				Name:  "plan",
+				Usage: "Show what starting the stack would change",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:    "file",
+						Value:   "",
+						Usage:   "Path to the stack definition file",
+						Aliases: []string{"f"},
+					},
+				},
+				Action: func(ctx context.Context, c *cli.Command) error {
+					stackPath := c.String("file")
+					commands.Plan(ctx, stackPath, c.StringSlice("env-file"))
+					return nil
+				},
+			},
+			{
+				Name:  "build",
+				Usage: "Rebuild the images of the stack or a specific container",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:    "file",
+						Value:   "",
+						Usage:   "Path to the stack definition file",
+						Aliases: []string{"f"},
+					},
+					&cli.BoolFlag{
+						Name:  "no-cache",
+						Usage: "Do not use cached layers",
+					},
+				},
+				Arguments: []cli.Argument{
+					&cli.StringArg{
+						Name:      "container",
+						UsageText: "Name of the container to build the image of (optional)",
+					},
 				},
 				Action: func(ctx context.Context, c *cli.Command) error {
 					stackPath := c.String("file")
 					systemCheck()
-					commands.Start(ctx, stackPath)
+					commands.Build(ctx, stackPath, c.StringSlice("env-file"), c.StringArg("container"), c.Bool("no-cache"))
+					return nil
+				},
+			},
+			{
+				Name:  "pull",
+				Usage: "Pull every image of the stack and pin the digests its tags point to now",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:    "file",
+						Value:   "",
+						Usage:   "Path to the stack definition file",
+						Aliases: []string{"f"},
+					},
+				},
+				Action: func(ctx context.Context, c *cli.Command) error {
+					stackPath := c.String("file")
+					commands.Pull(ctx, stackPath, c.StringSlice("env-file"))
+					return nil
+				},
+			},
+			{
+				Name:  "lock",
+				Usage: "Pin the image of every container to a digest in the lock file",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:    "file",
+						Value:   "",
+						Usage:   "Path to the stack definition file",
+						Aliases: []string{"f"},
+					},
+					&cli.BoolFlag{
+						Name:  "update",
+						Usage: "Pull every image again and pin it to the digest its tag points to now",
+					},
+				},
+				Action: func(ctx context.Context, c *cli.Command) error {
+					stackPath := c.String("file")
+					commands.Lock(ctx, stackPath, c.StringSlice("env-file"), c.Bool("update"))
 					return nil
 				},
 			},
@@ -65,7 +228,7 @@ func main() {
 				Action: func(ctx context.Context, c *cli.Command) error {
 					stackPath := c.String("file")
 					systemCheck()
-					commands.Stop(ctx, stackPath)
+					commands.Stop(ctx, stackPath, c.StringSlice("env-file"))
 					return nil
 				},
 			},
@@ -83,10 +246,165 @@ func main() {
 				Action: func(ctx context.Context, c *cli.Command) error {
 					stackPath := c.String("file")
 					systemCheck()
-					commands.Remove(ctx, stackPath)
+					commands.Remove(ctx, stackPath, c.StringSlice("env-file"))
+					return nil
+				},
+			},
+			{
+				Name:    "status",
+				Aliases: []string{"ps"},
+				Usage:   "Show the live state of every stack resource",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:    "file",
+						Value:   "",
+						Usage:   "Path to the stack definition file",
+						Aliases: []string{"f"},
+					},
+					&cli.BoolFlag{
+						Name:  "json",
+						Usage: "Print the status as JSON",
+					},
+					&cli.BoolFlag{
+						Name:  "problems",
+						Usage: "Only show resources that are defined but missing or deployed but undefined, exit with code 2 if there are any",
+					},
+				},
+				Action: func(ctx context.Context, c *cli.Command) error {
+					stackPath := c.String("file")
+					commands.Status(ctx, stackPath, c.StringSlice("env-file"), c.Bool("json"), c.Bool("problems"))
+					return nil
+				},
+			},
+			{
+				Name:  "history",
+				Usage: "List the deployed revisions of the stack",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:    "file",
+						Value:   "",
+						Usage:   "Path to the stack definition file",
+						Aliases: []string{"f"},
+					},
+				},
+				Action: func(ctx context.Context, c *cli.Command) error {
+					stackPath := c.String("file")
+					commands.History(ctx, stackPath, c.StringSlice("env-file"))
+					return nil
+				},
+			},
+			{
+				Name:  "rollback",
+				Usage: "Roll the stack back to an earlier revision",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:    "file",
+						Value:   "",
+						Usage:   "Path to the stack definition file",
+						Aliases: []string{"f"},
+					},
+				},
+				Arguments: []cli.Argument{
+					&cli.IntArg{
+						Name:      "revision",
+						UsageText: "Revision to roll back to, defaults to the last successful revision before the current one (optional)",
+					},
+				},
+				Action: func(ctx context.Context, c *cli.Command) error {
+					stackPath := c.String("file")
+					systemCheck()
+					commands.Rollback(ctx, stackPath, c.StringSlice("env-file"), c.IntArg("revision"))
 					return nil
 				},
 			},
+			{
+				Name:  "drift",
+				Usage: "Detect changes made to the stack outside of otari",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:    "file",
+						Value:   "",
+						Usage:   "Path to the stack definition file",
+						Aliases: []string{"f"},
+					},
+					&cli.BoolFlag{
+						Name:  "repair",
+						Usage: "Start the stack again to repair any drift",
+					},
+				},
+				Action: func(ctx context.Context, c *cli.Command) error {
+					stackPath := c.String("file")
+					if c.Bool("repair") {
+						systemCheck()
+					}
+					commands.Drift(ctx, stackPath, c.StringSlice("env-file"), c.Bool("repair"))
+					return nil
+				},
+			},
+			{
+				Name:  "import",
+				Usage: "Import stacks from other tools",
+				Commands: []*cli.Command{
+					{
+						Name:  "compose",
+						Usage: "Convert a docker-compose or podman-compose file into an otari stack",
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:    "dest",
+								Value:   "",
+								Usage:   "Path of the stack definition file to write",
+								Aliases: []string{"d"},
+							},
+							&cli.BoolFlag{
+								Name:  "force",
+								Usage: "Overwrite the destination file if it exists",
+							},
+						},
+						Arguments: []cli.Argument{
+							&cli.StringArg{
+								Name:      "file",
+								UsageText: "Path to the compose file (optional)",
+							},
+						},
+						Action: func(ctx context.Context, c *cli.Command) error {
+							commands.ImportCompose(ctx, c.StringArg("file"), c.String("dest"), c.Bool("force"))
+							return nil
+						},
+					},
+				},
+			},
+			{
+				Name:  "export",
+				Usage: "Export the stack for other tools",
+				Commands: []*cli.Command{
+					{
+						Name:  "kube",
+						Usage: "Write the stack as a Kubernetes manifest for podman kube play or a cluster",
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:    "file",
+								Value:   "",
+								Usage:   "Path to the stack definition file",
+								Aliases: []string{"f"},
+							},
+							&cli.StringFlag{
+								Name:    "dest",
+								Value:   "",
+								Usage:   "Path of the manifest to write",
+								Aliases: []string{"d"},
+							},
+							&cli.BoolFlag{
+								Name:  "force",
+								Usage: "Overwrite the destination file if it exists",
+							},
+						},
+						Action: func(ctx context.Context, c *cli.Command) error {
+							commands.ExportKube(ctx, c.String("file"), c.StringSlice("env-file"), c.String("dest"), c.Bool("force"))
+							return nil
+						},
+					},
+				},
+			},
 			{
 				Name:  "logs",
 				Usage: "View logs for the stack or a specific container",
@@ -108,11 +426,11 @@ func main() {
 					stackPath := c.String("file")
 					containerName := c.StringArg("container")
 					if containerName == "" {
-						fmt.Println(utils.Error("Please specify a container name."))
+						output.Error("Please specify a container name.")
 						return nil
 					}
 					systemCheck()
-					commands.Logs(ctx, stackPath, containerName)
+					commands.Logs(ctx, stackPath, c.StringSlice("env-file"), containerName)
 					return nil
 				},
 			},
@@ -124,6 +442,18 @@ func main() {
 	}
 }
 
+// jsonRequested reports whether the command that runs was asked for JSON
+// with its --json flag. Before actions run once the whole command line is
+// parsed, so the flags of subcommands hold their values.
+func jsonRequested(c *cli.Command) bool {
+	for _, sub := range c.Commands {
+		if sub.IsSet("json") && sub.Bool("json") || jsonRequested(sub) {
+			return true
+		}
+	}
+	return false
+}
+
 func printLogo() {
 	logo := `
  ██████╗ ████████╗ █████╗ ██████╗ ██╗
@@ -135,20 +465,27 @@ func printLogo() {
 `
 
 	c := color.New(color.FgMagenta, color.Bold)
-	c.Println(logo)
+	c.Fprintln(output.Text(), logo)
 
-	fmt.Println(color.WhiteString("A modern container orchestration tool"))
-	fmt.Println()
+	fmt.Fprintln(output.Text(), color.WhiteString("A modern container orchestration tool"))
+	fmt.Fprintln(output.Text())
 }
 
 func systemCheck() {
 	sp := spinners.DefaultSpinner()
+	fail := func(msg string) {
+		sp.FinishWithError(msg)
+		// the spinner is discarded when quiet and kept off stdout in JSON mode
+		if output.Quiet() || output.Current() == output.JSON {
+			output.Error(msg)
+		}
+	}
 	sp.SetMessage("Checking for Podman...")
 
 	// Check if Podman is installed and get version
 	installed, podmanVersion := podman.PodmanVersion()
 	if !installed {
-		sp.FinishWithError("Podman is not installed. Please install Podman to use Otari.")
+		fail("Podman is not installed. Please install Podman to use Otari.")
 		os.Exit(1)
 	}
 
@@ -160,7 +497,7 @@ func systemCheck() {
 	podmanMajorVersion, podmanMinorVersion, _ := podman.ParsePodmanVersion(podmanVersion)
 	// must be greater than or equal to 4.4.0
 	if podmanMajorVersion < 4 || (podmanMajorVersion == 4 && podmanMinorVersion < 4) {
-		sp.FinishWithError("Podman version 4.4.0 or higher is required. Please upgrade Podman to use Otari.")
+		fail("Podman version 4.4.0 or higher is required. Please upgrade Podman to use Otari.")
 		os.Exit(1)
 	}
 
@@ -169,7 +506,7 @@ func systemCheck() {
 	sp.SetMessage("Checking if systemd is running...")
 	// check if systemd is running
 	if !systemd.IsSystemdRunning() {
-		sp.FinishWithError("systemd is not running. Otari requires systemd to manage containers.")
+		fail("systemd is not running. Otari requires systemd to manage containers.")
 		os.Exit(1)
 	}
 
@@ -180,20 +517,20 @@ func systemCheck() {
 	// check for user lingering
 	lingeringEnabled, err := systemd.IsUserLingeringEnabled()
 	if err != nil {
-		sp.FinishWithError(fmt.Sprintf("Failed to check user lingering: %v", err))
+		fail(fmt.Sprintf("Failed to check user lingering: %v", err))
 		os.Exit(1)
 	}
 
 	if !lingeringEnabled {
-		sp.FinishWithError("User lingering is not enabled. Please enable user lingering to use Otari.")
+		fail("User lingering is not enabled. Please enable user lingering to use Otari.")
 
-		fmt.Println()
+		fmt.Fprintln(output.Text())
 
-		fmt.Println("To enable user lingering, run the following command:")
+		fmt.Fprintln(output.Text(), "To enable user lingering, run the following command:")
 		user := os.Getenv("USER")
-		fmt.Println()
-		fmt.Println(color.YellowString("    sudo loginctl enable-linger %s", user))
-		fmt.Println()
+		fmt.Fprintln(output.Text())
+		fmt.Fprintln(output.Text(), color.YellowString("    sudo loginctl enable-linger %s", user))
+		fmt.Fprintln(output.Text())
 
 		os.Exit(1)
 	}
@@ -1,78 +1,238 @@
 package changes
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
 	"time"
 
 	"github.com/BurntSushi/toml"
 	"github.com/danecwalker/otari/internal/definition"
+	"github.com/danecwalker/otari/internal/generate"
 	"github.com/danecwalker/otari/internal/hasher"
+	"github.com/danecwalker/otari/internal/kube"
+	"github.com/danecwalker/otari/internal/podman"
+	"github.com/danecwalker/otari/internal/systemd"
 	"github.com/danecwalker/otari/internal/utils"
 )
 
+// StackDataVersion is the current lock file version.
+//
+// Version 1 lock files predate resource namespacing, they are read as
+// version 2 lock files using definition.LegacyNameTemplate.
+const StackDataVersion = 2
+
 type StackData struct {
-	Version     int               `toml:"version"`
-	GeneratedAt time.Time         `toml:"generated_at"`
-	Containers  map[string]string `toml:"containers,omitempty"`
-	Volumes     map[string]string `toml:"volumes,omitempty"`
-	Networks    map[string]string `toml:"networks,omitempty"`
+	Version      int               `toml:"version"`
+	GeneratedAt  time.Time         `toml:"generated_at"`
+	NameTemplate string            `toml:"name_template"`
+	Mode         string            `toml:"mode,omitempty"`
+	Containers   map[string]string `toml:"containers,omitempty"`
+	Volumes      map[string]string `toml:"volumes,omitempty"`
+	Networks     map[string]string `toml:"networks,omitempty"`
+	Pods         map[string]string `toml:"pods,omitempty"`
+	Secrets      map[string]string `toml:"secrets,omitempty"`
+	// Quadlets holds the hashes of the generated quadlet files, keyed by
+	// file name, to detect changes made outside of otari.
+	Quadlets map[string]string `toml:"quadlets,omitempty"`
+	// Images holds the digests the images of the containers resolved to,
+	// keyed by container name. Deployments reference the pinned digests.
+	Images map[string]LockedImage `toml:"images,omitempty"`
+	// Builds holds the hashes of the build contexts of the containers that
+	// are built, keyed by container name, to rebuild them when they change.
+	Builds map[string]string `toml:"builds,omitempty"`
 }
 
-func DetectChanges(ctx context.Context, newStack *definition.Stack) (new *definition.Stack, deleted *definition.Stack, total int, err error) {
-	lockPath := newStack.StackName + ".lock"
+var ErrUnsupportedVersion = errors.New("unsupported stack data version")
+
+// LoadStackData reads the lock file of a stack. It returns nil without an
+// error if the stack has not been deployed yet.
+func LoadStackData(stack *definition.Stack) (*StackData, error) {
+	lockPath := stack.LockPath()
 	// check if lock file exists
 	if !utils.PathExists(lockPath) {
-		// no lock file, everything is new
-		return newStack, nil, -1, nil
+		return nil, nil
 	}
 
 	// read existing stack file
 	data, err := os.ReadFile(lockPath)
 	if err != nil {
-		return nil, nil, -1, err
+		return nil, err
 	}
 
 	var stackData StackData
 	if err := toml.Unmarshal(data, &stackData); err != nil {
-		return nil, nil, -1, err
+		return nil, err
 	}
 
-	if stackData.Version != 1 {
-		// unsupported version, treat everything as new
-		return newStack, nil, -1, fmt.Errorf("unsupported stack data version: %d", stackData.Version)
+	switch stackData.Version {
+	case 1:
+		// resources of version 1 stacks are not namespaced
+		stackData.Version = StackDataVersion
+		stackData.NameTemplate = definition.LegacyNameTemplate
+	case StackDataVersion:
+	default:
+		return nil, fmt.Errorf("%w: %d", ErrUnsupportedVersion, stackData.Version)
+	}
+
+	return &stackData, nil
+}
+
+// ResolveNaming sets the name template of a stack that does not define one.
+// Deployed stacks keep the template they were deployed with, so stacks from
+// before namespacing keep their resource names, new stacks use the default.
+func ResolveNaming(stack *definition.Stack) (*StackData, error) {
+	stackData, err := LoadStackData(stack)
+	if err != nil {
+		return nil, err
+	}
+
+	if stack.NameTemplate == "" {
+		if stackData != nil && stackData.NameTemplate != "" {
+			stack.NameTemplate = stackData.NameTemplate
+		} else {
+			stack.NameTemplate = definition.DefaultNameTemplate
+		}
+	}
+
+	return stackData, nil
+}
+
+// Renamed reports whether the stack is named differently than when it was
+// deployed. All its resources then have to be recreated under the new names.
+func Renamed(stack *definition.Stack, stackData *StackData) bool {
+	return stackData != nil && stackData.NameTemplate != stack.EffectiveNameTemplate()
+}
+
+// DeployMode returns the mode the stack was deployed with. Lock files
+// written before kube mode existed were deployed as quadlets.
+func (d *StackData) DeployMode() definition.DeployMode {
+	if d == nil || d.Mode == "" {
+		return definition.DeployModeQuadlet
+	}
+	return definition.DeployMode(d.Mode)
+}
+
+// ModeChanged reports whether the stack is deployed differently than when
+// it was last applied. Its resources then have to be recreated, see
+// Replaced.
+func ModeChanged(stack *definition.Stack, stackData *StackData) bool {
+	return stackData != nil && stackData.DeployMode() != stack.EffectiveMode()
+}
+
+// Replaced reports whether the deployed resources of a kind are removed
+// when the stack is applied because they are named or deployed differently.
+// Networks and secrets are the same in every mode, volume quadlets are
+// replaced by the claims of a kube manifest.
+func Replaced(kind string, stack *definition.Stack, stackData *StackData) bool {
+	if Renamed(stack, stackData) {
+		return true
+	}
+	if !ModeChanged(stack, stackData) {
+		return false
+	}
+	switch kind {
+	case generate.KindContainer, generate.KindPod:
+		return true
+	case generate.KindVolume:
+		return stackData.DeployMode() == definition.DeployModeQuadlet
+	}
+	return false
+}
+
+// DeployedMode returns the mode the stack is deployed with, which is the
+// mode of its lock file or, if it has not been deployed, of its definition.
+func DeployedMode(stack *definition.Stack, stackData *StackData) definition.DeployMode {
+	if stackData == nil {
+		return stack.EffectiveMode()
+	}
+	return stackData.DeployMode()
+}
+
+// DetectChanges compares the stack with its lock file and returns the
+// resources that are new or changed and those that were deleted. Drifted
+// resources, as seen through runtime and services, count as changed.
+func DetectChanges(ctx context.Context, newStack *definition.Stack, runtime podman.Runtime, services systemd.ServiceManager) (new *definition.Stack, deleted *definition.Stack, total int, err error) {
+	stackData, err := LoadStackData(newStack)
+	if err != nil {
+		if errors.Is(err, ErrUnsupportedVersion) {
+			// unsupported version, treat everything as new
+			return newStack, nil, -1, err
+		}
+		return nil, nil, -1, err
+	}
+	if stackData == nil {
+		// no lock file, everything is new
+		return newStack, nil, -1, nil
 	}
 
 	// stack data contains hashes of existing resources
 	existingContainers := stackData.Containers
 	existingVolumes := stackData.Volumes
 	existingNetworks := stackData.Networks
+	existingPods := stackData.Pods
+	existingSecrets := stackData.Secrets
+
+	// resources of a renamed stack are recreated under their new names, the
+	// resources of a stack that changed its deploy mode are recreated too
+	renamed := Renamed(newStack, stackData) || ModeChanged(newStack, stackData)
+
+	// resources that drifted are regenerated and recreated
+	drifted := make(map[string]map[string]bool)
+	if !renamed {
+		drifts, err := DetectDrift(ctx, newStack.StackName, stackData, utils.OutputLocation(), runtime, services)
+		if err != nil {
+			return nil, nil, -1, err
+		}
+		for _, d := range drifts {
+			if drifted[d.Kind] == nil {
+				drifted[d.Kind] = make(map[string]bool)
+			}
+			drifted[d.Kind][d.Name] = true
+		}
+	}
 
 	new = &definition.Stack{
-		Containers: make(map[string]*definition.Container),
-		Volumes:    make(map[string]*definition.Volume),
-		Networks:   make(map[string]*definition.Network),
+		StackName:    newStack.StackName,
+		NameTemplate: newStack.NameTemplate,
+		Mode:         newStack.Mode,
+		Containers:   make(map[string]*definition.Container),
+		Volumes:      make(map[string]*definition.Volume),
+		Networks:     make(map[string]*definition.Network),
+		Pods:         make(map[string]*definition.Pod),
+		Secrets:      make(map[string]*definition.Secret),
 	}
 	deleted = &definition.Stack{
-		Containers: make(map[string]*definition.Container),
-		Volumes:    make(map[string]*definition.Volume),
-		Networks:   make(map[string]*definition.Network),
+		StackName:    newStack.StackName,
+		NameTemplate: stackData.NameTemplate,
+		Mode:         stackData.DeployMode(),
+		Containers:   make(map[string]*definition.Container),
+		Volumes:      make(map[string]*definition.Volume),
+		Networks:     make(map[string]*definition.Network),
+		Pods:         make(map[string]*definition.Pod),
+		Secrets:      make(map[string]*definition.Secret),
 	}
 
+	// a drifted kube manifest is regenerated by treating every container as
+	// modified
+	kubeDrifted := len(drifted[generate.KindKube]) > 0
+
 	// detect new and modified containers
 	for name, container := range newStack.Containers {
 		hash, err := hasher.MarshalHashableB58(container)
 		if err != nil {
 			return nil, nil, -1, err
 		}
-		if existingHash, ok := existingContainers[name]; !ok || existingHash != hash {
+		if existingHash, ok := existingContainers[name]; renamed || kubeDrifted || !ok || existingHash != hash || drifted[generate.KindContainer][name] || BuildChanged(name, container, stackData) {
 			new.Containers[name] = container
 		}
 	}
 	// detect deleted containers
 	for name := range existingContainers {
-		if _, ok := newStack.Containers[name]; !ok {
+		if _, ok := newStack.Containers[name]; !ok || Replaced(generate.KindContainer, newStack, stackData) {
 			deleted.Containers[name] = &definition.Container{ContainerName: name}
 		}
 	}
@@ -82,13 +242,13 @@ func DetectChanges(ctx context.Context, newStack *definition.Stack) (new *defini
 		if err != nil {
 			return nil, nil, -1, err
 		}
-		if existingHash, ok := existingVolumes[name]; !ok || existingHash != hash {
+		if existingHash, ok := existingVolumes[name]; renamed || !ok || existingHash != hash || drifted[generate.KindVolume][name] {
 			new.Volumes[name] = volume
 		}
 	}
 	// detect deleted volumes
 	for name := range existingVolumes {
-		if _, ok := newStack.Volumes[name]; !ok {
+		if _, ok := newStack.Volumes[name]; !ok || Replaced(generate.KindVolume, newStack, stackData) {
 			deleted.Volumes[name] = &definition.Volume{VolumeName: name}
 		}
 	}
@@ -98,19 +258,54 @@ func DetectChanges(ctx context.Context, newStack *definition.Stack) (new *defini
 		if err != nil {
 			return nil, nil, -1, err
 		}
-		if existingHash, ok := existingNetworks[name]; !ok || existingHash != hash {
+		if existingHash, ok := existingNetworks[name]; renamed || !ok || existingHash != hash || drifted[generate.KindNetwork][name] {
 			new.Networks[name] = network
 		}
 	}
 	// detect deleted networks
 	for name := range existingNetworks {
-		if _, ok := newStack.Networks[name]; !ok {
+		if _, ok := newStack.Networks[name]; !ok || Replaced(generate.KindNetwork, newStack, stackData) {
 			deleted.Networks[name] = &definition.Network{NetworkName: name}
 		}
 	}
 
+	// detect new and modified pods
+	for name, pod := range newStack.Pods {
+		hash, err := hasher.MarshalHashableB58(pod)
+		if err != nil {
+			return nil, nil, -1, err
+		}
+		if existingHash, ok := existingPods[name]; renamed || !ok || existingHash != hash || drifted[generate.KindPod][name] {
+			new.Pods[name] = pod
+		}
+	}
+	// detect deleted pods
+	for name := range existingPods {
+		if _, ok := newStack.Pods[name]; !ok || Replaced(generate.KindPod, newStack, stackData) {
+			deleted.Pods[name] = &definition.Pod{PodName: name}
+		}
+	}
+
+	// detect new and rotated secrets
+	for name, secret := range newStack.Secrets {
+		hash, err := hasher.MarshalHashableB58(secret)
+		if err != nil {
+			return nil, nil, -1, err
+		}
+		if existingHash, ok := existingSecrets[name]; renamed || !ok || existingHash != hash || drifted[KindSecret][name] {
+			new.Secrets[name] = secret
+		}
+	}
+	// detect deleted secrets
+	for name := range existingSecrets {
+		if _, ok := newStack.Secrets[name]; !ok || Replaced(KindSecret, newStack, stackData) {
+			deleted.Secrets[name] = &definition.Secret{SecretName: name}
+		}
+	}
+
 	totalChanges := len(new.Containers) + len(deleted.Containers) + len(new.Volumes) + len(deleted.Volumes) +
-		len(new.Networks) + len(deleted.Networks)
+		len(new.Networks) + len(deleted.Networks) + len(new.Pods) + len(deleted.Pods) +
+		len(new.Secrets) + len(deleted.Secrets)
 
 	return new, deleted, totalChanges, nil
 }
@@ -120,10 +315,17 @@ func SaveStackData(stack *definition.Stack) error {
 		Containers: make(map[string]string),
 		Volumes:    make(map[string]string),
 		Networks:   make(map[string]string),
+		Pods:       make(map[string]string),
+		Secrets:    make(map[string]string),
+		Images:     LockedImages(stack),
+		Builds:     make(map[string]string),
 	}
 	for name, container := range stack.Containers {
 		if container.Build != nil {
 			container.Image = nil // do not hash build info
+			if container.Build.ContextHash != "" {
+				stackData.Builds[name] = container.Build.ContextHash
+			}
 		}
 		hash, err := hasher.MarshalHashableB58(container)
 		if err != nil {
@@ -145,23 +347,79 @@ func SaveStackData(stack *definition.Stack) error {
 		}
 		stackData.Networks[name] = hash
 	}
+	for name, pod := range stack.Pods {
+		hash, err := hasher.MarshalHashableB58(pod)
+		if err != nil {
+			return err
+		}
+		stackData.Pods[name] = hash
+	}
+	for name, secret := range stack.Secrets {
+		hash, err := hasher.MarshalHashableB58(secret)
+		if err != nil {
+			return err
+		}
+		stackData.Secrets[name] = hash
+	}
 
-	stackData.Version = 1
-	stackData.GeneratedAt = time.Now().UTC().Truncate(time.Second)
-
-	lockPath := stack.StackName + ".lock"
-	f, err := os.Create(lockPath)
+	quadlets, err := hashQuadlets(stack, utils.OutputLocation())
 	if err != nil {
 		return err
 	}
-	defer f.Close()
+	stackData.Quadlets = quadlets
 
-	enc := toml.NewEncoder(f)
+	stackData.Version = StackDataVersion
+	stackData.NameTemplate = stack.EffectiveNameTemplate()
+	stackData.Mode = string(stack.EffectiveMode())
+	stackData.GeneratedAt = time.Now().UTC().Truncate(time.Second)
+
+	return WriteStackData(stack, &stackData)
+}
+
+// WriteStackData writes the lock file of a stack.
+func WriteStackData(stack *definition.Stack, stackData *StackData) error {
+	lockPath := stack.LockPath()
+	var buf bytes.Buffer
+	enc := toml.NewEncoder(&buf)
 	enc.Indent = ""
-	err = enc.Encode(stackData)
-	if err != nil {
+	if err := enc.Encode(stackData); err != nil {
+		return err
+	}
+
+	// the central state directory may not exist yet
+	if err := os.MkdirAll(filepath.Dir(lockPath), 0755); err != nil {
 		return err
 	}
+	// an interrupted write must not leave a truncated lock file
+	return utils.WriteFileAtomic(lockPath, buf.Bytes(), 0644)
+}
 
-	return nil
+// QuadletFileNames returns the names of the files generated for the stack.
+func QuadletFileNames(stack *definition.Stack) []string {
+	var fileNames []string
+	for _, kind := range generate.Kinds {
+		for _, name := range generate.Names(stack, kind) {
+			fileNames = append(fileNames, stack.ResourceName(name)+"."+kind)
+		}
+	}
+	if stack.IsKube() {
+		fileNames = append(fileNames, kube.FileNames(stack)...)
+	}
+	return fileNames
+}
+
+// hashQuadlets hashes the quadlets of the stack written to outputDir.
+func hashQuadlets(stack *definition.Stack, outputDir string) (map[string]string, error) {
+	out := make(map[string]string)
+	for _, fileName := range QuadletFileNames(stack) {
+		content, err := os.ReadFile(filepath.Join(outputDir, fileName))
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		out[fileName] = HashQuadlet(content)
+	}
+	return out, nil
 }
@@ -0,0 +1,193 @@
+package changes
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/danecwalker/otari/internal/definition"
+	"github.com/danecwalker/otari/internal/generate"
+	"github.com/danecwalker/otari/internal/hasher"
+	"github.com/danecwalker/otari/internal/kube"
+	"github.com/danecwalker/otari/internal/podman"
+	"github.com/danecwalker/otari/internal/quadlets"
+	"github.com/danecwalker/otari/internal/systemd"
+)
+
+// KindSecret is the kind of podman secrets, which have no quadlet.
+const KindSecret = "secret"
+
+type DriftType string
+
+const (
+	// DriftQuadletModified means the quadlet on disk was edited after otari
+	// wrote it.
+	DriftQuadletModified DriftType = "quadlet-modified"
+	// DriftQuadletMissing means the quadlet otari wrote was deleted.
+	DriftQuadletMissing DriftType = "quadlet-missing"
+	// DriftResourceMissing means podman no longer has the resource although
+	// its unit is active, e.g. after a manual `podman rm`.
+	DriftResourceMissing DriftType = "resource-missing"
+)
+
+// Drift is a divergence between the lock file and what is deployed.
+type Drift struct {
+	Kind         string
+	Name         string
+	ResourceName string
+	Type         DriftType
+}
+
+func (d *Drift) String() string {
+	switch d.Type {
+	case DriftQuadletModified:
+		return fmt.Sprintf("quadlet of %s '%s' was modified outside of otari", d.Kind, d.ResourceName)
+	case DriftQuadletMissing:
+		return fmt.Sprintf("quadlet of %s '%s' is missing", d.Kind, d.ResourceName)
+	case DriftResourceMissing:
+		return fmt.Sprintf("%s '%s' does not exist in podman", d.Kind, d.ResourceName)
+	}
+	return fmt.Sprintf("%s '%s' drifted", d.Kind, d.ResourceName)
+}
+
+// HashQuadlet returns the hash of a quadlet file recorded in the lock file.
+func HashQuadlet(content []byte) string {
+	h := hasher.NewHash()
+	h.Hasher.Write(content)
+	return hasher.EncodeB58(h.Hasher.Sum(nil))
+}
+
+// DetectDrift compares the resources recorded in the lock file with the
+// quadlets in outputDir and the resources podman actually has.
+func DetectDrift(ctx context.Context, stackName string, stackData *StackData, outputDir string, runtime podman.Runtime, services systemd.ServiceManager) ([]*Drift, error) {
+	if stackData == nil {
+		return nil, nil
+	}
+
+	drifts, err := quadletDrift(stackName, stackData, outputDir)
+	if err != nil {
+		return nil, err
+	}
+
+	deployed := &definition.Stack{StackName: stackName, NameTemplate: stackData.NameTemplate}
+	check := func(kind string, names map[string]string, unitName func(string) string) {
+		for _, name := range sortedKeys(names) {
+			resourceName := deployed.ResourceName(name)
+			// containers are removed when their unit stops, only resources
+			// of active units have to exist
+			state, err := services.GetUnitState(unitName(resourceName))
+			if err != nil || state.ActiveState != "active" {
+				continue
+			}
+			if !runtime.ResourceExists(ctx, kind, resourceName) {
+				drifts = append(drifts, &Drift{Kind: kind, Name: name, ResourceName: resourceName, Type: DriftResourceMissing})
+			}
+		}
+	}
+	check(generate.KindNetwork, quadletNames(stackData.Networks, deployed, generate.KindNetwork, stackData), quadlets.NetworkServiceName)
+	check(generate.KindVolume, stackData.Volumes, quadlets.VolumeServiceName)
+	check(generate.KindPod, stackData.Pods, quadlets.PodServiceName)
+	check(generate.KindContainer, stackData.Containers, quadlets.ContainerServiceName)
+
+	for _, name := range sortedKeys(stackData.Secrets) {
+		resourceName := deployed.ResourceName(name)
+		if !runtime.SecretExists(ctx, resourceName) {
+			drifts = append(drifts, &Drift{Kind: KindSecret, Name: name, ResourceName: resourceName, Type: DriftResourceMissing})
+		}
+	}
+
+	return drifts, nil
+}
+
+// quadletDrift compares the quadlets in outputDir with the hashes recorded
+// when they were generated.
+func quadletDrift(stackName string, stackData *StackData, outputDir string) ([]*Drift, error) {
+	deployed := &definition.Stack{StackName: stackName, NameTemplate: stackData.NameTemplate}
+
+	var drifts []*Drift
+	for _, kind := range generate.Kinds {
+		for _, name := range sortedKeys(stackData.Hashes(kind)) {
+			resourceName := deployed.ResourceName(name)
+			fileName := resourceName + "." + kind
+			expected, ok := stackData.Quadlets[fileName]
+			if !ok {
+				// no quadlet recorded, e.g. host networks or older lock files
+				continue
+			}
+
+			content, err := os.ReadFile(filepath.Join(outputDir, fileName))
+			switch {
+			case os.IsNotExist(err):
+				drifts = append(drifts, &Drift{Kind: kind, Name: name, ResourceName: resourceName, Type: DriftQuadletMissing})
+			case err != nil:
+				return nil, err
+			case HashQuadlet(content) != expected:
+				drifts = append(drifts, &Drift{Kind: kind, Name: name, ResourceName: resourceName, Type: DriftQuadletModified})
+			}
+		}
+	}
+
+	if stackData.DeployMode() == definition.DeployModeKube {
+		for _, fileName := range kube.FileNames(deployed) {
+			expected, ok := stackData.Quadlets[fileName]
+			if !ok {
+				continue
+			}
+
+			content, err := os.ReadFile(filepath.Join(outputDir, fileName))
+			switch {
+			case os.IsNotExist(err):
+				drifts = append(drifts, &Drift{Kind: generate.KindKube, Name: stackName, ResourceName: fileName, Type: DriftQuadletMissing})
+			case err != nil:
+				return nil, err
+			case HashQuadlet(content) != expected:
+				drifts = append(drifts, &Drift{Kind: generate.KindKube, Name: stackName, ResourceName: fileName, Type: DriftQuadletModified})
+			}
+		}
+	}
+	return drifts, nil
+}
+
+// quadletNames filters names down to the resources that have a recorded
+// quadlet, which skips host networks podman provides itself.
+func quadletNames(names map[string]string, deployed *definition.Stack, kind string, stackData *StackData) map[string]string {
+	out := make(map[string]string, len(names))
+	for name, hash := range names {
+		if _, ok := stackData.Quadlets[deployed.ResourceName(name)+"."+kind]; ok {
+			out[name] = hash
+		}
+	}
+	return out
+}
+
+// Hashes returns the hashes of the resources of the given kind, keyed by
+// resource name.
+func (d *StackData) Hashes(kind string) map[string]string {
+	if d == nil {
+		return nil
+	}
+	switch kind {
+	case generate.KindNetwork:
+		return d.Networks
+	case generate.KindVolume:
+		return d.Volumes
+	case generate.KindPod:
+		return d.Pods
+	case generate.KindContainer:
+		return d.Containers
+	case KindSecret:
+		return d.Secrets
+	}
+	return nil
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
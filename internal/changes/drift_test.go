@@ -0,0 +1,67 @@
+package changes
+
+// Tests detection of quadlets changed outside of otari.
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/danecwalker/otari/internal/definition"
+	"github.com/danecwalker/otari/internal/fake"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQuadletDrift(t *testing.T) {
+	dir := t.TempDir()
+	web := []byte("[Container]\nImage=nginx\n")
+	db := []byte("[Container]\nImage=postgres\n")
+	network := []byte("[Network]\n")
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "test-web.container"), web, 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "test-db.container"), []byte("[Container]\nImage=mysql\n"), 0o644))
+
+	stackData := &StackData{
+		Version:      StackDataVersion,
+		NameTemplate: definition.DefaultNameTemplate,
+		Containers:   map[string]string{"web": "a", "db": "b", "legacy": "c"},
+		Networks:     map[string]string{"backend": "d", "host": "e"},
+		Quadlets: map[string]string{
+			"test-web.container":   HashQuadlet(web),
+			"test-db.container":    HashQuadlet(db),
+			"test-backend.network": HashQuadlet(network),
+		},
+	}
+
+	drifts, err := quadletDrift("test", stackData, dir)
+	require.NoError(t, err)
+	require.Len(t, drifts, 2)
+
+	assert.Equal(t, "backend", drifts[0].Name)
+	assert.Equal(t, DriftQuadletMissing, drifts[0].Type)
+	assert.Equal(t, "db", drifts[1].Name)
+	assert.Equal(t, DriftQuadletModified, drifts[1].Type)
+	assert.Equal(t, "test-db", drifts[1].ResourceName)
+}
+
+func TestResourceDrift(t *testing.T) {
+	ctx := context.Background()
+	host := fake.New()
+	stackData := &StackData{
+		Version:      StackDataVersion,
+		NameTemplate: definition.DefaultNameTemplate,
+		Secrets:      map[string]string{"token": "a"},
+	}
+
+	drifts, err := DetectDrift(ctx, "test", stackData, t.TempDir(), host, host)
+	require.NoError(t, err)
+	require.Len(t, drifts, 1)
+	assert.Equal(t, KindSecret, drifts[0].Kind)
+	assert.Equal(t, DriftResourceMissing, drifts[0].Type)
+
+	require.NoError(t, host.CreateSecret(ctx, "test-token", []byte("s3cret")))
+	drifts, err = DetectDrift(ctx, "test", stackData, t.TempDir(), host, host)
+	require.NoError(t, err)
+	assert.Empty(t, drifts)
+}
@@ -0,0 +1,83 @@
+package changes
+
+import (
+	"fmt"
+
+	"github.com/danecwalker/otari/internal/definition"
+)
+
+// LockedImage is the image a container was deployed with and the digest it
+// resolved to.
+type LockedImage struct {
+	Image  string `toml:"image"`
+	Digest string `toml:"digest"`
+}
+
+// imageName returns the image of a container as it is recorded in the lock
+// file, build containers use the image otari tags their build with.
+func imageName(stack *definition.Stack, name string, container *definition.Container) string {
+	if container.Build != nil {
+		return fmt.Sprintf("%s_%s", stack.StackName, name)
+	}
+	if container.Image == nil {
+		return ""
+	}
+	return container.Image.String()
+}
+
+// PinImages pins the images of the containers to the digests recorded in
+// the lock file. A container is only pinned while it uses the image the
+// digest was resolved for, and images referenced by digest need no pin.
+func PinImages(stack *definition.Stack, stackData *StackData) {
+	if stackData == nil {
+		return
+	}
+	for name, container := range stack.Containers {
+		locked, ok := stackData.Images[name]
+		if !ok || locked.Image != imageName(stack, name, container) {
+			continue
+		}
+		if container.Image != nil && container.Image.Digest != "" {
+			continue
+		}
+		container.ImageDigest = locked.Digest
+	}
+}
+
+// LockedImages returns the images of the containers that are pinned to a
+// digest, keyed by container name.
+func LockedImages(stack *definition.Stack) map[string]LockedImage {
+	images := make(map[string]LockedImage)
+	for name, container := range stack.Containers {
+		if container.ImageDigest == "" {
+			continue
+		}
+		images[name] = LockedImage{Image: imageName(stack, name, container), Digest: container.ImageDigest}
+	}
+	return images
+}
+
+// Unpinned returns the names of the containers whose image the lock file
+// does not pin, built images are never pinned.
+func Unpinned(stack *definition.Stack) []string {
+	var names []string
+	for name, container := range stack.Containers {
+		if container.Build != nil || container.Image == nil || container.Image.Digest != "" {
+			continue
+		}
+		if container.ImageDigest == "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// BuildChanged reports whether the build context of a container changed
+// since it was deployed. Contexts that were not hashed never count as
+// changed.
+func BuildChanged(name string, container *definition.Container, stackData *StackData) bool {
+	if container.Build == nil || container.Build.ContextHash == "" || stackData == nil {
+		return false
+	}
+	return stackData.Builds[name] != container.Build.ContextHash
+}
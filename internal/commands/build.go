@@ -0,0 +1,25 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"os"
+
+	"github.com/danecwalker/otari/internal/output"
+	"github.com/danecwalker/otari/pkg/otari"
+)
+
+func Build(ctx context.Context, stackPath string, envFiles []string, containerName string, noCache bool) {
+	engine, observer := newEngine()
+	stack := loadStack(engine, observer, stackPath, envFiles, 1)
+
+	if err := engine.Build(ctx, stack, containerName, noCache); err != nil {
+		if errors.Is(err, otari.ErrContainerNotFound) {
+			output.Error("Container '" + containerName + "' not found in stack definition")
+			os.Exit(1)
+		}
+		exitWithError(observer, "Failed to build images", err, 1)
+	}
+
+	output.Success("Images built successfully!")
+}
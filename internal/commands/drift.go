@@ -0,0 +1,82 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/danecwalker/otari/internal/changes"
+	"github.com/danecwalker/otari/internal/output"
+	"github.com/danecwalker/otari/internal/podman"
+	"github.com/danecwalker/otari/internal/spinners"
+	"github.com/danecwalker/otari/internal/systemd"
+	"github.com/danecwalker/otari/internal/utils"
+	"github.com/fatih/color"
+)
+
+// Exit codes of Drift, so monitoring can alert on changes made outside of
+// otari.
+const (
+	DriftExitNone    = 0
+	DriftExitError   = 1
+	DriftExitDrifted = 2
+)
+
+// Drift reports resources that were changed outside of otari. With repair
+// set the stack is started again, which regenerates drifted quadlets and
+// recreates missing resources.
+func Drift(ctx context.Context, stackPath string, envFiles []string, repair bool) {
+	engine, observer := newEngine()
+	loaded := loadStack(engine, observer, stackPath, envFiles, DriftExitError)
+	stack := loaded.Definition
+
+	stackData, err := changes.LoadStackData(stack)
+	if err != nil {
+		output.Error("Failed to read stack lock file", err.Error())
+		os.Exit(DriftExitError)
+	}
+	if stackData == nil {
+		output.Info(fmt.Sprintf("Stack '%s' has not been deployed yet.", stack.StackName))
+		os.Exit(DriftExitNone)
+	}
+
+	sp := spinners.DefaultSpinner()
+	sp.SetMessage("Detecting drift...")
+	drifts, err := changes.DetectDrift(ctx, stack.StackName, stackData, utils.OutputLocation(), podman.DefaultRuntime(), systemd.DefaultManager())
+	if err != nil {
+		sp.FinishWithError("Failed to detect drift.")
+		color.New(color.FgWhite).Fprintln(output.Text(), "    "+err.Error())
+		os.Exit(DriftExitError)
+	}
+	if len(drifts) == 0 {
+		sp.FinishWithSuccess(fmt.Sprintf("Stack '%s' matches its lock file.", stack.StackName))
+		os.Exit(DriftExitNone)
+	}
+	sp.FinishWithInfo(fmt.Sprintf("Detected %d divergence(s).", len(drifts)))
+
+	fmt.Fprintln(output.Text())
+	for _, d := range drifts {
+		color.New(color.FgYellow).Fprintf(output.Text(), "  ! %s\n", d)
+		color.New(color.FgWhite).Fprintf(output.Text(), "      repair: %s\n", driftRepair(d))
+	}
+	fmt.Fprintln(output.Text())
+
+	if !repair {
+		output.Info("Run 'otari drift --repair' to repair the stack.")
+		os.Exit(DriftExitDrifted)
+	}
+
+	output.Info("Repairing stack...")
+	Start(ctx, stackPath, envFiles, false, 0, false)
+}
+
+// driftRepair describes how starting the stack repairs a divergence.
+func driftRepair(d *changes.Drift) string {
+	switch d.Type {
+	case changes.DriftQuadletModified, changes.DriftQuadletMissing:
+		return fmt.Sprintf("regenerate the quadlet and restart %s '%s'", d.Kind, d.ResourceName)
+	case changes.DriftResourceMissing:
+		return fmt.Sprintf("recreate %s '%s'", d.Kind, d.ResourceName)
+	}
+	return "restart the stack"
+}
@@ -0,0 +1,307 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/danecwalker/otari/internal/output"
+	"github.com/danecwalker/otari/internal/spinners"
+	"github.com/danecwalker/otari/internal/utils"
+	"github.com/danecwalker/otari/pkg/otari"
+	"github.com/danecwalker/otari/pkg/spinner"
+	"github.com/fatih/color"
+)
+
+// reporter renders the steps reported by the engine as spinners, or as
+// JSON events in JSON output mode. Images are pulled and built in
+// parallel, their steps are rendered as the lines of a spinner group
+// instead. When quiet only failed steps are reported.
+type reporter struct {
+	sp *spinner.Spinner
+	// group holds a line per image step in flight.
+	group *spinner.Group
+	lines map[string]*imageLine
+	// failed is set once a step reported its failure, the error returned
+	// for it then needs no headline of its own.
+	failed bool
+}
+
+func (o *reporter) OnEvent(e otari.Event) {
+	if e.Type == otari.EventFailed {
+		o.failed = true
+	}
+	switch {
+	case output.Current() == output.JSON:
+		if e.Type == otari.EventFailed || !output.Quiet() {
+			o.emit(e)
+		}
+		return
+	case output.Quiet():
+		if e.Type == otari.EventFailed {
+			fmt.Fprintln(output.Text(), utils.Error(e.Message))
+		}
+		return
+	}
+	if e.Kind == otari.KindImage {
+		o.onImageEvent(e)
+		return
+	}
+	switch e.Type {
+	case otari.EventStarted:
+		o.spinner().SetMessage(e.Message)
+	case otari.EventProgress:
+		o.spinner().Println(color.New(color.FgWhite).Sprintf(" >  %s", e.Message))
+	case otari.EventSucceeded:
+		o.spinner().FinishWithSuccess(e.Message)
+		o.sp = nil
+	case otari.EventSkipped:
+		o.spinner().FinishWithInfo(e.Message)
+		o.sp = nil
+	case otari.EventFailed:
+		o.spinner().FinishWithError(e.Message)
+		o.sp = nil
+	case otari.EventNotice:
+		if o.group != nil {
+			o.group.Println(utils.Info(e.Message))
+		} else {
+			fmt.Fprintln(output.Text(), utils.Info(e.Message))
+		}
+	}
+}
+
+// emit writes the event as a line of JSON.
+func (o *reporter) emit(e otari.Event) {
+	event := output.Event{
+		Action:   e.Action,
+		Kind:     e.Kind,
+		Resource: e.Name,
+		Status:   string(e.Type),
+		Message:  e.Message,
+	}
+	if e.Type == otari.EventNotice {
+		event.Status = output.StatusInfo
+	}
+	if e.Err != nil {
+		event.Error = e.Err.Error()
+	}
+	output.Emit(event)
+}
+
+// onImageEvent renders the step of an image as a line of the group.
+func (o *reporter) onImageEvent(e otari.Event) {
+	if o.group == nil {
+		o.group = spinners.DefaultGroup()
+		o.lines = make(map[string]*imageLine)
+	}
+	l, ok := o.lines[e.Name]
+	if !ok {
+		l = &imageLine{line: o.group.Add(e.Message)}
+		o.lines[e.Name] = l
+	}
+	switch e.Type {
+	case otari.EventStarted:
+		l.line.SetMessage(e.Message)
+	case otari.EventProgress:
+		l.line.SetDetail(l.progress(e.Message))
+	case otari.EventSucceeded:
+		l.line.FinishWithSuccess(e.Message)
+	case otari.EventSkipped:
+		l.line.FinishWithInfo(e.Message)
+	case otari.EventFailed:
+		l.line.FinishWithError(e.Message)
+	}
+	if e.Type == otari.EventSucceeded || e.Type == otari.EventSkipped || e.Type == otari.EventFailed {
+		delete(o.lines, e.Name)
+		if o.group.Active() == 0 {
+			o.group.Stop()
+			o.group = nil
+		}
+	}
+}
+
+// spinner returns the spinner of the current step, starting one if needed.
+func (o *reporter) spinner() *spinner.Spinner {
+	if o.sp == nil {
+		o.sp = spinners.DefaultSpinner()
+	}
+	return o.sp
+}
+
+// imageLine is the line of an image that is pulled or built.
+type imageLine struct {
+	line *spinner.Line
+	// blobs are the layers podman copies, and whether they are done.
+	blobs map[string]bool
+}
+
+// progress turns a line of podman output into the detail shown after the
+// message of the line, e.g. "2/5 layers 12.0MiB / 27.1MiB". Only the
+// progress bars of the podman CLI carry the bytes copied, the pull stream
+// of the API announces each layer once and moves on to the config when all
+// of them are copied.
+func (l *imageLine) progress(output string) string {
+	output = strings.TrimSpace(output)
+	if strings.HasPrefix(output, "Copying config ") && len(l.blobs) > 0 {
+		for id := range l.blobs {
+			l.blobs[id] = true
+		}
+		return fmt.Sprintf("%d/%d layers", len(l.blobs), len(l.blobs))
+	}
+	blob, ok := strings.CutPrefix(output, "Copying blob ")
+	if !ok {
+		// build steps and other messages are shown as they are
+		return output
+	}
+	fields := strings.Fields(blob)
+	if len(fields) == 0 {
+		return output
+	}
+	if l.blobs == nil {
+		l.blobs = make(map[string]bool)
+	}
+	id := fields[0]
+	l.blobs[id] = l.blobs[id] || strings.Contains(blob, "done") || strings.Contains(blob, "skipped")
+	done := 0
+	for _, finished := range l.blobs {
+		if finished {
+			done++
+		}
+	}
+	detail := fmt.Sprintf("%d/%d layers", done, len(l.blobs))
+	// a progress bar ends in the bytes copied so far
+	if i := strings.LastIndex(blob, "]"); i >= 0 {
+		if bytes := strings.TrimSpace(blob[i+1:]); bytes != "" {
+			detail += " " + bytes
+		}
+	}
+	return detail
+}
+
+// globals holds the engine options set by global flags.
+var globals otari.Options
+
+// SetGlobals sets the engine options set by global flags: Parallelism,
+// Wait, WaitTimeout and StateDir.
+func SetGlobals(opts otari.Options) {
+	globals = opts
+}
+
+func newEngine() (*otari.Engine, *reporter) {
+	return newEngineWith(otari.Options{})
+}
+
+// newEngineWith returns an engine configured by opts that reports to a
+// spinner observer.
+func newEngineWith(opts otari.Options) (*otari.Engine, *reporter) {
+	observer := &reporter{}
+	opts.Observer = observer
+	opts.Parallelism = globals.Parallelism
+	opts.Wait = globals.Wait
+	opts.WaitTimeout = globals.WaitTimeout
+	opts.StateDir = globals.StateDir
+	return otari.New(opts), observer
+}
+
+// loadStack loads the stack at stackPath, exiting with exitCode if it
+// cannot be loaded.
+func loadStack(engine *otari.Engine, observer *reporter, stackPath string, envFiles []string, exitCode int) *otari.Stack {
+	stack, err := engine.Load(stackPath, envFiles)
+	if err != nil {
+		exitWithError(observer, "Failed to load stack", err, exitCode)
+	}
+	return stack
+}
+
+// validateStack validates the stack, exiting with exitCode if it breaks
+// any rule.
+func validateStack(engine *otari.Engine, observer *reporter, stack *otari.Stack, exitCode int) {
+	if err := engine.Validate(stack); err != nil {
+		exitWithError(observer, "Failed to validate stack", err, exitCode)
+	}
+	output.Success("Stack validated successfully!")
+}
+
+// exitWithError prints why an engine call failed and exits with exitCode.
+// headline is printed unless a failed step already told the user.
+func exitWithError(observer *reporter, headline string, err error, exitCode int) {
+	if observer.group != nil {
+		observer.group.Stop()
+	}
+	var validationErr *otari.ValidationError
+	var loadErr *otari.LoadError
+	switch {
+	case errors.As(err, &validationErr):
+		var problems []string
+		for _, problem := range validationErr.Problems {
+			problems = append(problems, "• "+problem)
+		}
+		output.Error("Failed to validate stack:", problems...)
+	case errors.As(err, &loadErr):
+		switch loadErr.Op {
+		case otari.LoadParse:
+			output.Error("Failed to parse stack definition", loadErr.Err.Error())
+		case otari.LoadLock:
+			output.Error("Failed to read stack lock file", loadErr.Err.Error())
+		default:
+			output.Error("Failed to read "+loadErr.Path, loadErr.Err.Error())
+		}
+	default:
+		cause := err
+		var rolledBack *otari.RolledBackError
+		if errors.As(err, &rolledBack) {
+			cause = rolledBack.Err
+		}
+		// the failed step already told the user what failed, unless the
+		// output is read by a script
+		stepFailed := observer.failed && output.Current() != output.JSON
+		if stepFailed {
+			if unwrapped := errors.Unwrap(cause); unwrapped != nil {
+				cause = unwrapped
+			}
+		}
+		details := []string{cause.Error()}
+		if hint := journalHint(err); hint != "" {
+			details = append(details, hint)
+		}
+		if errors.Is(err, otari.ErrStackLocked) && !globals.Wait && globals.WaitTimeout == 0 {
+			details = append(details, "Run it again with --wait to wait until the lock is released.")
+		}
+		if stepFailed {
+			for _, detail := range details {
+				color.New(color.FgWhite).Fprintln(output.Text(), "    "+detail)
+			}
+		} else {
+			output.Error(headline, details...)
+		}
+		if rolledBack != nil {
+			output.Info(fmt.Sprintf("Rolled back to revision %d, fix the stack and start it again.", rolledBack.Revision))
+		}
+	}
+	os.Exit(exitCode)
+}
+
+// journalHint points the user at the journal of a container or stack unit
+// that failed.
+func journalHint(err error) string {
+	var resourceErr *otari.ResourceError
+	if !errors.As(err, &resourceErr) {
+		return ""
+	}
+	journalctl := "journalctl --user"
+	if utils.Rootful() {
+		journalctl = "journalctl"
+	}
+	switch resourceErr.Kind {
+	case otari.KindContainer:
+		if resourceErr.Op == "remove" {
+			return ""
+		}
+		return "Please check the container logs using '" + journalctl + " -xe -t " + resourceErr.Name + "' for more details."
+	case otari.KindStack:
+		// the .kube unit of a stack is named after it
+		return "Please check the logs using '" + journalctl + " -xe -u " + resourceErr.Name + ".service' for more details."
+	}
+	return ""
+}
@@ -0,0 +1,71 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/danecwalker/otari/internal/generate"
+	"github.com/danecwalker/otari/internal/kube"
+	"github.com/danecwalker/otari/internal/output"
+	"github.com/danecwalker/otari/internal/utils"
+	"github.com/fatih/color"
+)
+
+// ExportKube writes the stack as a Kubernetes manifest to outputPath, which
+// defaults to the manifest file name used in kube mode.
+func ExportKube(ctx context.Context, stackPath string, envFiles []string, outputPath string, force bool) {
+	engine, observer := newEngine()
+	loaded := loadStack(engine, observer, stackPath, envFiles, 1)
+	if err := engine.Validate(loaded); err != nil {
+		exitWithError(observer, "Failed to validate stack", err, 1)
+	}
+	stack := loaded.Definition
+
+	if outputPath == "" {
+		outputPath = kube.ManifestFileName(stack)
+	}
+	if utils.PathExists(outputPath) && !force {
+		output.Error(outputPath+" already exists", "Use --force to overwrite it.")
+		os.Exit(1)
+	}
+
+	manifest, err := generate.Manifest(stack, kube.Generator())
+	if err != nil {
+		output.Error("Failed to generate Kubernetes manifest", err.Error())
+		os.Exit(1)
+	}
+
+	if err := os.WriteFile(outputPath, manifest, 0644); err != nil {
+		output.Error("Failed to write "+outputPath, err.Error())
+		os.Exit(1)
+	}
+
+	output.Success(fmt.Sprintf("Exported stack '%s' to '%s'.", stack.StackName, outputPath))
+
+	var notes []string
+	var networks []string
+	for _, name := range generate.Names(stack, generate.KindNetwork) {
+		networks = append(networks, stack.ResourceName(name))
+	}
+	if len(networks) > 0 {
+		notes = append(notes, "Networks have no manifest, attach the pods with 'podman kube play --network "+strings.Join(networks, ",")+"'.")
+	}
+	if len(stack.Secrets) > 0 {
+		notes = append(notes, "Secrets are referenced by name and have to be created as Kubernetes secrets.")
+	}
+	for _, container := range stack.Containers {
+		if len(container.Depends) > 0 {
+			notes = append(notes, "Dependencies between containers are not part of the manifest, all pods start at once.")
+			break
+		}
+	}
+	if len(notes) == 0 {
+		return
+	}
+	fmt.Fprintln(output.Text())
+	for _, note := range notes {
+		color.New(color.FgYellow).Fprintf(output.Text(), "    • %s\n", note)
+	}
+}
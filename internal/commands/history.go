@@ -0,0 +1,66 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/danecwalker/otari/internal/output"
+	"github.com/danecwalker/otari/pkg/otari"
+)
+
+func History(ctx context.Context, stackPath string, envFiles []string) {
+	engine, observer := newEngine()
+	stack := loadStack(engine, observer, stackPath, envFiles, 1)
+
+	revisions, err := engine.History(stack)
+	if err != nil {
+		output.Error("Failed to read stack history", err.Error())
+		os.Exit(1)
+	}
+	if len(revisions) == 0 {
+		output.Info(fmt.Sprintf("Stack '%s' has no history yet.", stack.Name()))
+		return
+	}
+
+	w := tabwriter.NewWriter(output.Text(), 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "REVISION\tCREATED\tSTATUS\tIMAGES\tNOTE")
+	for i := len(revisions) - 1; i >= 0; i-- {
+		revision := revisions[i]
+		note := ""
+		if revision.RollbackOf != 0 {
+			note = fmt.Sprintf("rollback to %d", revision.RollbackOf)
+		}
+		if revision.Error != "" {
+			note = revision.Error
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\n",
+			revision.Number, revision.CreatedAt.Local().Format("2006-01-02 15:04:05"), revision.Status, formatImages(revision), note)
+	}
+	w.Flush()
+}
+
+// formatImages lists the image digests of a revision as unit@digest.
+func formatImages(revision *otari.Revision) string {
+	if len(revision.Images) == 0 {
+		return "-"
+	}
+	var images []string
+	for unit, digest := range revision.Images {
+		images = append(images, unit+"@"+shortDigest(digest))
+	}
+	sort.Strings(images)
+	return strings.Join(images, ",")
+}
+
+func Rollback(ctx context.Context, stackPath string, envFiles []string, revision int) {
+	engine, observer := newEngine()
+	stack := loadStack(engine, observer, stackPath, envFiles, 1)
+
+	if err := engine.Rollback(ctx, stack, revision); err != nil {
+		exitWithError(observer, "Failed to roll back stack", err, 1)
+	}
+}
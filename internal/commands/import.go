@@ -0,0 +1,78 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/danecwalker/otari/internal/compose"
+	"github.com/danecwalker/otari/internal/output"
+	"github.com/danecwalker/otari/internal/utils"
+	"github.com/fatih/color"
+)
+
+// composeFileNames lists the compose files looked for when none is given,
+// in the order docker compose looks for them.
+var composeFileNames = []string{
+	"compose.yaml",
+	"compose.yml",
+	"docker-compose.yaml",
+	"docker-compose.yml",
+	"podman-compose.yaml",
+	"podman-compose.yml",
+}
+
+// ImportCompose converts a compose file into an otari stack definition
+// written to outputPath.
+func ImportCompose(ctx context.Context, composePath, outputPath string, force bool) {
+	if composePath == "" {
+		for _, name := range composeFileNames {
+			if utils.PathExists(name) {
+				composePath = name
+				break
+			}
+		}
+		if composePath == "" {
+			output.Error("No compose file found", "Please specify the compose file to import.")
+			os.Exit(1)
+		}
+	}
+	if outputPath == "" {
+		outputPath = utils.DefaultStackPath()
+	}
+
+	if utils.PathExists(outputPath) && !force {
+		output.Error(outputPath+" already exists", "Use --force to overwrite it.")
+		os.Exit(1)
+	}
+
+	c, err := os.ReadFile(composePath)
+	if err != nil {
+		output.Error("Failed to read "+composePath, err.Error())
+		os.Exit(1)
+	}
+
+	result, err := compose.Convert(c)
+	if err != nil {
+		output.Error("Failed to convert "+composePath, err.Error())
+		os.Exit(1)
+	}
+
+	if err := os.WriteFile(outputPath, result.Stack, 0644); err != nil {
+		output.Error("Failed to write "+outputPath, err.Error())
+		os.Exit(1)
+	}
+
+	output.Success(fmt.Sprintf("Imported '%s' into '%s'.", composePath, outputPath))
+
+	if len(result.Warnings) == 0 {
+		return
+	}
+	fmt.Fprintln(output.Text())
+	output.Info(fmt.Sprintf("%d compose feature(s) could not be translated:", len(result.Warnings)))
+	for _, w := range result.Warnings {
+		color.New(color.FgYellow).Fprintf(output.Text(), "    • %s\n", w)
+	}
+	fmt.Fprintln(output.Text())
+	color.New(color.FgWhite).Fprintln(output.Text(), "    Review "+outputPath+" before starting the stack.")
+}
@@ -0,0 +1,18 @@
+package commands
+
+import (
+	"context"
+
+	"github.com/danecwalker/otari/internal/output"
+)
+
+func Lock(ctx context.Context, stackPath string, envFiles []string, update bool) {
+	engine, observer := newEngine()
+	stack := loadStack(engine, observer, stackPath, envFiles, 1)
+
+	if err := engine.Lock(ctx, stack, update); err != nil {
+		exitWithError(observer, "Failed to lock stack images", err, 1)
+	}
+
+	output.Success("Stack images locked, start the stack to deploy the pinned images.")
+}
@@ -2,47 +2,24 @@ package commands
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
 
-	"github.com/danecwalker/otari/internal/definition"
-	"github.com/danecwalker/otari/internal/systemd"
-	"github.com/danecwalker/otari/internal/utils"
-	"github.com/fatih/color"
+	"github.com/danecwalker/otari/internal/output"
+	"github.com/danecwalker/otari/pkg/otari"
 )
 
-func Logs(ctx context.Context, stackPath string, containerName string) {
-	if stackPath == "" {
-		stackPath = utils.DefaultStackPath()
-	}
-	c, err := os.ReadFile(stackPath)
-	if err != nil {
-		fmt.Println(utils.Error("Failed to read " + stackPath))
-		color.New(color.FgWhite).Println("    " + err.Error())
-		os.Exit(1)
-	}
-
-	stack, err := definition.Parse(c)
-	if err != nil {
-		fmt.Println(utils.Error("Failed to parse stack definition"))
-		color.New(color.FgWhite).Println("    " + err.Error())
-		os.Exit(1)
-	}
+func Logs(ctx context.Context, stackPath string, envFiles []string, containerName string) {
+	engine, observer := newEngine()
+	stack := loadStack(engine, observer, stackPath, envFiles, 1)
 
-	// check if container name is valid
-	if containerName != "" {
-		if _, exists := stack.Containers[containerName]; !exists {
-			fmt.Println(utils.Error("Container '" + containerName + "' not found in stack definition"))
+	if err := engine.Logs(ctx, stack, containerName, output.Text()); err != nil {
+		if errors.Is(err, otari.ErrContainerNotFound) {
+			output.Error("Container '" + containerName + "' not found in stack definition")
 			os.Exit(1)
 		}
+		exitWithError(observer, "Failed to get logs", err, 1)
 	}
-
-	logs, err := systemd.GetLogs(containerName)
-	if err != nil {
-		fmt.Println(utils.Error("Failed to get logs"))
-		color.New(color.FgWhite).Println("    " + err.Error())
-		os.Exit(1)
-	}
-
-	fmt.Println(string(logs))
+	fmt.Fprintln(output.Text())
 }
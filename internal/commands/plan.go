@@ -0,0 +1,93 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/danecwalker/otari/internal/output"
+	"github.com/danecwalker/otari/pkg/otari"
+	"github.com/fatih/color"
+)
+
+// Exit codes of Plan, so CI can gate on pending changes.
+const (
+	PlanExitNoChanges = 0
+	PlanExitError     = 1
+	PlanExitChanges   = 2
+)
+
+func Plan(ctx context.Context, stackPath string, envFiles []string) {
+	engine, observer := newEngine()
+	stack := loadStack(engine, observer, stackPath, envFiles, PlanExitError)
+	validateStack(engine, observer, stack, PlanExitError)
+
+	p, err := engine.Plan(ctx, stack)
+	if err != nil {
+		exitWithError(observer, "Failed to compute plan", err, PlanExitError)
+	}
+
+	fmt.Fprintln(output.Text())
+	for _, change := range p.Changes {
+		printChange(change)
+	}
+
+	for _, change := range p.Changes {
+		if change.Diff == "" {
+			continue
+		}
+		fmt.Fprintln(output.Text())
+		printDiff(change.Diff)
+	}
+
+	fmt.Fprintln(output.Text())
+	summary := fmt.Sprintf("Plan: %d to add, %d to modify, %d to delete, %d unchanged.",
+		p.Count(otari.ActionAdd), p.Count(otari.ActionModify), p.Count(otari.ActionDelete), p.Count(otari.ActionUnchanged))
+
+	if !p.Pending() {
+		output.Success(summary)
+		os.Exit(PlanExitNoChanges)
+	}
+
+	output.Info(summary)
+	os.Exit(PlanExitChanges)
+}
+
+func printChange(change *otari.Change) {
+	var symbol string
+	var c *color.Color
+	switch change.Action {
+	case otari.ActionAdd:
+		symbol, c = "+", color.New(color.FgGreen)
+	case otari.ActionModify:
+		symbol, c = "~", color.New(color.FgYellow)
+	case otari.ActionDelete:
+		symbol, c = "-", color.New(color.FgRed)
+	default:
+		symbol, c = "=", color.New(color.FgWhite)
+	}
+
+	line := fmt.Sprintf("  %s %s '%s' (%s)", symbol, change.Kind, change.Name, change.Action)
+	if change.Action == otari.ActionUnchanged && change.Diff != "" {
+		line += " - quadlet differs from the one on disk"
+	}
+	c.Fprintln(output.Text(), line)
+}
+
+func printDiff(d string) {
+	for _, line := range strings.Split(strings.TrimSuffix(d, "\n"), "\n") {
+		switch {
+		case strings.HasPrefix(line, "+++"), strings.HasPrefix(line, "---"):
+			color.New(color.Bold).Fprintln(output.Text(), line)
+		case strings.HasPrefix(line, "@@"):
+			color.New(color.FgCyan).Fprintln(output.Text(), line)
+		case strings.HasPrefix(line, "+"):
+			color.New(color.FgGreen).Fprintln(output.Text(), line)
+		case strings.HasPrefix(line, "-"):
+			color.New(color.FgRed).Fprintln(output.Text(), line)
+		default:
+			fmt.Fprintln(output.Text(), line)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/danecwalker/otari/internal/output"
+)
+
+func Pull(ctx context.Context, stackPath string, envFiles []string) {
+	engine, observer := newEngine()
+	stack := loadStack(engine, observer, stackPath, envFiles, 1)
+
+	pulled, err := engine.Pull(ctx, stack)
+	if err != nil {
+		exitWithError(observer, "Failed to pull images", err, 1)
+	}
+	if len(pulled) == 0 {
+		output.Info("No images to pull in the stack.")
+		return
+	}
+
+	w := tabwriter.NewWriter(output.Text(), 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "IMAGE\tCONTAINERS\tDIGEST\tSTATUS")
+	changed := 0
+	for _, image := range pulled {
+		status := "unchanged"
+		switch {
+		case image.Previous == "":
+			status = "pulled"
+		case image.Changed():
+			status = "changed from " + shortDigest(image.Previous)
+			changed++
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", image.Image, strings.Join(image.Containers, ","), shortDigest(image.Digest), status)
+	}
+	w.Flush()
+
+	if changed > 0 {
+		output.Success(fmt.Sprintf("%d image(s) changed, start the stack to restart the containers that use them.", changed))
+	} else {
+		output.Success("All images are up to date.")
+	}
+}
@@ -0,0 +1,140 @@
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/danecwalker/otari/internal/output"
+	"github.com/danecwalker/otari/internal/status"
+	"github.com/fatih/color"
+)
+
+// Exit codes of Status with problemsOnly set, so scripts can detect
+// resources that are missing or undefined.
+const (
+	StatusExitOK       = 0
+	StatusExitError    = 1
+	StatusExitProblems = 2
+)
+
+func Status(ctx context.Context, stackPath string, envFiles []string, asJSON, problemsOnly bool) {
+	engine, observer := newEngine()
+	loaded := loadStack(engine, observer, stackPath, envFiles, StatusExitError)
+
+	st, err := engine.Status(ctx, loaded)
+	if err != nil {
+		output.Error("Failed to collect stack status", err.Error())
+		os.Exit(StatusExitError)
+	}
+
+	problems := st.Problems()
+	if problemsOnly {
+		st.Resources = problems
+	}
+
+	// in JSON output mode the status is a single line like the events
+	jsonMode := output.Current() == output.JSON
+	if asJSON || jsonMode {
+		enc := json.NewEncoder(output.Stdout())
+		if !jsonMode {
+			enc.SetIndent("", "  ")
+		}
+		if err := enc.Encode(st); err != nil {
+			output.Error("Failed to encode stack status", err.Error())
+			os.Exit(StatusExitError)
+		}
+	} else {
+		printStatus(st)
+	}
+
+	if problemsOnly && len(problems) > 0 {
+		os.Exit(StatusExitProblems)
+	}
+}
+
+func printStatus(st *status.Status) {
+	if !st.Deployed {
+		output.Info(fmt.Sprintf("Stack '%s' has not been deployed yet.", st.Stack))
+		fmt.Fprintln(output.Text())
+	}
+	if len(st.Resources) == 0 {
+		output.Success(fmt.Sprintf("No resources to show for stack '%s'.", st.Stack))
+		return
+	}
+
+	w := tabwriter.NewWriter(output.Text(), 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "KIND\tNAME\tUNIT\tSTATE\tHEALTH\tUPTIME\tRESTARTS\tPORTS\tIMAGE")
+	for _, r := range st.Resources {
+		state := "-"
+		switch {
+		case r.Problem != status.ProblemNone:
+			state = string(r.Problem)
+		case r.ActiveState != "":
+			state = r.ActiveState + "/" + r.SubState
+		case r.Present:
+			state = "present"
+		}
+
+		health, uptime, restarts, ports, image := "-", "-", "-", "-", "-"
+		if ct := r.Container; ct != nil {
+			if ct.Health != "" {
+				health = ct.Health
+			}
+			if ct.Running {
+				uptime = formatUptime(time.Duration(ct.UptimeSeconds) * time.Second)
+			}
+			restarts = strconv.Itoa(ct.RestartCount)
+			if len(ct.Ports) > 0 {
+				ports = strings.Join(ct.Ports, ",")
+			}
+			if ct.ImageDigest != "" {
+				image = shortDigest(ct.ImageDigest)
+			}
+		}
+
+		unit := r.Unit
+		if unit == "" {
+			unit = "-"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			r.Kind, r.Name, unit, state, health, uptime, restarts, ports, image)
+	}
+	w.Flush()
+
+	fmt.Fprintln(output.Text())
+	for _, r := range st.Resources {
+		switch r.Problem {
+		case status.ProblemMissing:
+			color.New(color.FgYellow).Fprintf(output.Text(), "  ! %s '%s' is defined but not deployed\n", r.Kind, r.Name)
+		case status.ProblemUndefined:
+			color.New(color.FgRed).Fprintf(output.Text(), "  ! %s '%s' is deployed but not defined in the stack file\n", r.Kind, r.Name)
+		}
+	}
+}
+
+func formatUptime(d time.Duration) string {
+	switch {
+	case d >= 24*time.Hour:
+		return fmt.Sprintf("%dd%dh", int(d.Hours())/24, int(d.Hours())%24)
+	case d >= time.Hour:
+		return fmt.Sprintf("%dh%dm", int(d.Hours()), int(d.Minutes())%60)
+	case d >= time.Minute:
+		return fmt.Sprintf("%dm%ds", int(d.Minutes()), int(d.Seconds())%60)
+	}
+	return fmt.Sprintf("%ds", int(d.Seconds()))
+}
+
+// shortDigest shortens an image digest to the 12 characters podman shows.
+func shortDigest(digest string) string {
+	digest = strings.TrimPrefix(digest, "sha256:")
+	if len(digest) > 12 {
+		digest = digest[:12]
+	}
+	return digest
+}
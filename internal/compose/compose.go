@@ -0,0 +1,727 @@
+package compose
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Warning describes a compose feature that could not be translated.
+type Warning struct {
+	// Path locates the feature in the compose file, e.g. services.web.cap_add.
+	Path    string
+	Message string
+}
+
+func (w Warning) String() string {
+	return w.Path + ": " + w.Message
+}
+
+type Result struct {
+	// Stack is the otari stack definition.
+	Stack    []byte
+	Warnings []Warning
+}
+
+// Convert translates a docker-compose or podman-compose file into an otari
+// stack definition. Features otari has no equivalent for are dropped and
+// reported as warnings.
+func Convert(data []byte) (*Result, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	if len(doc.Content) == 0 {
+		return nil, fmt.Errorf("compose file is empty")
+	}
+	root := resolve(doc.Content[0])
+	if root.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("compose file must be a mapping")
+	}
+
+	c := &converter{
+		containers: mapping(),
+		volumes:    mapping(),
+		networks:   mapping(),
+		secrets:    mapping(),
+	}
+	for _, p := range pairs(root) {
+		switch {
+		case p.key == "version", strings.HasPrefix(p.key, "x-"):
+			// obsolete and extension fields carry no configuration
+		case p.key == "name":
+			c.name = p.value.Value
+		case p.key == "services":
+			for _, svc := range pairs(p.value) {
+				c.convertService(svc.key, svc.value)
+			}
+		case p.key == "volumes":
+			for _, vol := range pairs(p.value) {
+				c.convertVolume(vol.key, vol.value)
+			}
+		case p.key == "networks":
+			for _, net := range pairs(p.value) {
+				c.convertNetwork(net.key, net.value)
+			}
+		case p.key == "secrets":
+			for _, secret := range pairs(p.value) {
+				c.convertSecret(secret.key, secret.value)
+			}
+		default:
+			c.warn(p.key, "top-level '%s' is not supported", p.key)
+		}
+	}
+
+	if c.hostNetwork {
+		if _, ok := lookup(c.networks, "host"); !ok {
+			host := mapping()
+			add(host, "driver", str("host"))
+			add(c.networks, "host", host)
+		}
+	}
+
+	stack := mapping()
+	if c.name != "" {
+		add(stack, "name", str(c.name))
+	}
+	for _, section := range []struct {
+		key  string
+		node *yaml.Node
+	}{
+		{"containers", c.containers},
+		{"volumes", c.volumes},
+		{"networks", c.networks},
+		{"secrets", c.secrets},
+	} {
+		if len(section.node.Content) > 0 {
+			add(stack, section.key, section.node)
+		}
+	}
+
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(stack); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+
+	return &Result{
+		Stack:    buf.Bytes(),
+		Warnings: c.warnings,
+	}, nil
+}
+
+type converter struct {
+	warnings []Warning
+	// name is the compose project name, which names the stack
+	name string
+
+	containers *yaml.Node
+	volumes    *yaml.Node
+	networks   *yaml.Node
+	secrets    *yaml.Node
+	// hostNetwork is set if a service uses network_mode: host
+	hostNetwork bool
+}
+
+func (c *converter) warn(path, format string, args ...any) {
+	c.warnings = append(c.warnings, Warning{
+		Path:    path,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+func (c *converter) convertService(name string, node *yaml.Node) {
+	path := "services." + name
+	container := mapping()
+
+	var image *yaml.Node
+	var build *yaml.Node
+	for _, p := range pairs(node) {
+		keyPath := path + "." + p.key
+		switch p.key {
+		case "image":
+			image = str(value(p.value))
+		case "build":
+			build = c.convertBuild(keyPath, p.value)
+		case "entrypoint":
+			add(container, "entrypoint", stringOrList(p.value))
+		case "environment":
+			add(container, "environment", c.convertEnvironment(p.value, true))
+		case "env_file":
+			add(container, "env_file", c.convertEnvFile(keyPath, p.value))
+		case "healthcheck":
+			add(container, "healthcheck", c.convertHealthcheck(keyPath, p.value))
+		case "init":
+			add(container, "init", plain(p.value.Value))
+		case "labels":
+			add(container, "labels", c.convertEnvironment(p.value, false))
+		case "networks":
+			var networks []string
+			if p.value.Kind == yaml.SequenceNode {
+				networks = scalars(p.value)
+			} else {
+				for _, net := range pairs(p.value) {
+					networks = append(networks, net.key)
+					if len(pairs(net.value)) > 0 {
+						c.warn(keyPath+"."+net.key, "network settings such as aliases and static addresses are not supported")
+					}
+				}
+			}
+			add(container, "networks", list(networks...))
+		case "network_mode":
+			switch p.value.Value {
+			case "host":
+				c.hostNetwork = true
+				add(container, "networks", list("host"))
+			case "bridge":
+			default:
+				c.warn(keyPath, "network mode '%s' is not supported", p.value.Value)
+			}
+		case "ports":
+			add(container, "ports", c.convertPorts(keyPath, p.value))
+		case "restart":
+			// quote "no" so YAML 1.1 parsers do not read a bool
+			add(container, "restart", quoted(p.value.Value))
+		case "volumes":
+			add(container, "volumes", c.convertVolumeMounts(keyPath, p.value))
+		case "depends_on":
+			add(container, "depends", c.convertDependsOn(keyPath, p.value))
+		case "secrets":
+			add(container, "secrets", c.convertServiceSecrets(keyPath, p.value))
+		case "container_name":
+			c.warn(keyPath, "containers are named by the stack's name_template")
+		case "command":
+			c.warn(keyPath, "overriding the image command is not supported, use entrypoint")
+		case "expose":
+			c.warn(keyPath, "not needed, containers on a shared network reach each other on every port")
+		default:
+			if strings.HasPrefix(p.key, "x-") {
+				continue
+			}
+			c.warn(keyPath, "'%s' is not supported", p.key)
+		}
+	}
+
+	// image or build go first
+	source := mapping()
+	switch {
+	case build != nil:
+		if image != nil {
+			// compose tags the built image with the image name
+			add(build, "tags", list(image.Value))
+		}
+		add(source, "build", build)
+	case image != nil:
+		add(source, "image", image)
+	default:
+		c.warn(path, "service has neither an image nor a build")
+	}
+	container.Content = append(source.Content, container.Content...)
+
+	add(c.containers, name, container)
+}
+
+func (c *converter) convertBuild(path string, node *yaml.Node) *yaml.Node {
+	build := mapping()
+	if node.Kind == yaml.ScalarNode {
+		add(build, "context", str(value(node)))
+		return build
+	}
+	for _, p := range pairs(node) {
+		switch p.key {
+		case "context":
+			add(build, "context", str(value(p.value)))
+		case "dockerfile":
+			add(build, "containerfile", str(value(p.value)))
+		case "args":
+			add(build, "args", c.convertEnvironment(p.value, true))
+		case "target":
+			add(build, "target", str(value(p.value)))
+		case "tags":
+			add(build, "tags", list(scalars(p.value)...))
+		default:
+			c.warn(path+"."+p.key, "'%s' is not supported", p.key)
+		}
+	}
+	return build
+}
+
+// convertEnvironment converts a map or a list of KEY=VALUE entries into a
+// map. With passthrough set, keys without a value are taken from the host
+// and become ${KEY} references.
+func (c *converter) convertEnvironment(node *yaml.Node, passthrough bool) *yaml.Node {
+	env := mapping()
+	if node.Kind == yaml.SequenceNode {
+		for _, item := range scalars(node) {
+			key, val, ok := strings.Cut(item, "=")
+			if !ok && passthrough {
+				val = "${" + key + "}"
+			}
+			add(env, key, str(val))
+		}
+		return env
+	}
+	for _, p := range pairs(node) {
+		if p.value.Tag == "!!null" {
+			if passthrough {
+				add(env, p.key, str("${"+p.key+"}"))
+			} else {
+				add(env, p.key, str(""))
+			}
+			continue
+		}
+		add(env, p.key, str(value(p.value)))
+	}
+	return env
+}
+
+func (c *converter) convertEnvFile(path string, node *yaml.Node) *yaml.Node {
+	if node.Kind == yaml.ScalarNode {
+		return str(value(node))
+	}
+	var files []string
+	for i, item := range node.Content {
+		item = resolve(item)
+		if item.Kind == yaml.ScalarNode {
+			files = append(files, value(item))
+			continue
+		}
+		file, _ := lookup(item, "path")
+		if file == nil {
+			c.warn(fmt.Sprintf("%s[%d]", path, i), "env file without a path")
+			continue
+		}
+		files = append(files, value(file))
+		if required, ok := lookup(item, "required"); ok && required.Value == "false" {
+			c.warn(fmt.Sprintf("%s[%d]", path, i), "optional env files are not supported, '%s' must exist", file.Value)
+		}
+	}
+	return list(files...)
+}
+
+func (c *converter) convertHealthcheck(path string, node *yaml.Node) *yaml.Node {
+	hc := mapping()
+	for _, p := range pairs(node) {
+		switch p.key {
+		case "test":
+			add(hc, "test", stringOrList(p.value))
+		case "interval", "timeout", "start_period":
+			add(hc, p.key, str(p.value.Value))
+		case "start_interval":
+			c.warn(path+"."+p.key, "'%s' is not supported by podman, it is dropped", p.key)
+		case "retries", "disable":
+			add(hc, p.key, plain(p.value.Value))
+		default:
+			c.warn(path+"."+p.key, "'%s' is not supported", p.key)
+		}
+	}
+	return hc
+}
+
+func (c *converter) convertPorts(path string, node *yaml.Node) *yaml.Node {
+	ports := list()
+	for i, item := range node.Content {
+		item = resolve(item)
+		itemPath := fmt.Sprintf("%s[%d]", path, i)
+
+		var port string
+		if item.Kind == yaml.ScalarNode {
+			port = item.Value
+		} else {
+			var target, published, hostIP, protocol string
+			for _, p := range pairs(item) {
+				switch p.key {
+				case "target":
+					target = p.value.Value
+				case "published":
+					published = p.value.Value
+				case "host_ip":
+					hostIP = p.value.Value
+				case "protocol":
+					protocol = p.value.Value
+				case "mode", "name", "app_protocol":
+				default:
+					c.warn(itemPath+"."+p.key, "'%s' is not supported", p.key)
+				}
+			}
+			if target == "" {
+				c.warn(itemPath, "port without a target is skipped")
+				continue
+			}
+			port = target
+			if published != "" {
+				port = published + ":" + target
+			}
+			if hostIP != "" {
+				port = hostIP + ":" + port
+			}
+			if protocol != "" {
+				port += "/" + protocol
+			}
+		}
+
+		if !portWithHostRe.MatchString(port) {
+			container := strings.SplitN(port, "/", 2)[0]
+			c.warn(itemPath, "port %s is published on the same host port, compose picks a random one", container)
+		}
+		ports.Content = append(ports.Content, quoted(port))
+	}
+	return ports
+}
+
+// portWithHostRe matches ports that name a host port, e.g. 8080:80 or
+// 127.0.0.1:8080:80/udp.
+var portWithHostRe = regexp.MustCompile(`^(?:.*:)?[0-9-]+:[0-9-]+(?:/\w+)?$`)
+
+func (c *converter) convertVolumeMounts(path string, node *yaml.Node) *yaml.Node {
+	mounts := list()
+	for i, item := range node.Content {
+		item = resolve(item)
+		itemPath := fmt.Sprintf("%s[%d]", path, i)
+
+		var source, target string
+		var options []string
+		if item.Kind == yaml.ScalarNode {
+			parts := strings.Split(value(item), ":")
+			if len(parts) == 1 {
+				c.warn(itemPath, "anonymous volume '%s' is not supported, declare a named volume", parts[0])
+				continue
+			}
+			source, target = parts[0], parts[1]
+			if len(parts) > 2 {
+				for _, opt := range strings.Split(parts[2], ",") {
+					switch opt {
+					case "rw", "ro", "z", "Z":
+						options = append(options, opt)
+					default:
+						c.warn(itemPath, "mount option '%s' is not supported", opt)
+					}
+				}
+			}
+		} else {
+			var ok bool
+			source, target, options, ok = c.convertLongMount(itemPath, item)
+			if !ok {
+				continue
+			}
+		}
+
+		if strings.HasPrefix(source, "~") {
+			source = "${HOME}" + strings.TrimPrefix(source, "~")
+		}
+		mount := source + ":" + target
+		if len(options) > 0 {
+			mount += ":" + strings.Join(options, ",")
+		}
+		mounts.Content = append(mounts.Content, str(mount))
+	}
+	return mounts
+}
+
+// convertLongMount converts a volume mount in long syntax. It reports false
+// if the mount has no otari equivalent.
+func (c *converter) convertLongMount(path string, node *yaml.Node) (source, target string, options []string, ok bool) {
+	for _, p := range pairs(node) {
+		switch p.key {
+		case "type":
+			if p.value.Value != "volume" && p.value.Value != "bind" {
+				c.warn(path, "%s mounts are not supported", p.value.Value)
+				return "", "", nil, false
+			}
+		case "source":
+			source = value(p.value)
+		case "target":
+			target = value(p.value)
+		case "read_only":
+			if p.value.Value == "true" {
+				options = append(options, "ro")
+			}
+		case "bind":
+			for _, bp := range pairs(p.value) {
+				if bp.key == "selinux" {
+					options = append(options, bp.value.Value)
+					continue
+				}
+				c.warn(path+".bind."+bp.key, "'%s' is not supported", bp.key)
+			}
+		default:
+			c.warn(path+"."+p.key, "'%s' is not supported", p.key)
+		}
+	}
+	if source == "" {
+		c.warn(path, "anonymous volume for '%s' is not supported, declare a named volume", target)
+		return "", "", nil, false
+	}
+	return source, target, options, true
+}
+
+// dependsConditions maps compose dependency conditions to otari ones.
+var dependsConditions = map[string]string{
+	"service_started":                "started",
+	"service_healthy":                "healthy",
+	"service_completed_successfully": "completed_successfully",
+}
+
+func (c *converter) convertDependsOn(path string, node *yaml.Node) *yaml.Node {
+	if node.Kind == yaml.SequenceNode {
+		return list(scalars(node)...)
+	}
+
+	depends := mapping()
+	conditional := false
+	for _, p := range pairs(node) {
+		dep := mapping()
+		for _, dp := range pairs(p.value) {
+			switch dp.key {
+			case "condition":
+				condition, ok := dependsConditions[dp.value.Value]
+				if !ok {
+					c.warn(path+"."+p.key+".condition", "condition '%s' is not supported", dp.value.Value)
+					continue
+				}
+				if condition != "started" {
+					conditional = true
+					add(dep, "condition", str(condition))
+				}
+			default:
+				c.warn(path+"."+p.key+"."+dp.key, "'%s' is not supported", dp.key)
+			}
+		}
+		add(depends, p.key, dep)
+	}
+
+	if !conditional {
+		// every dependency only has to be started
+		names := make([]string, 0, len(depends.Content)/2)
+		for i := 0; i < len(depends.Content); i += 2 {
+			names = append(names, depends.Content[i].Value)
+		}
+		return list(names...)
+	}
+	for i := 1; i < len(depends.Content); i += 2 {
+		if len(depends.Content[i].Content) == 0 {
+			add(depends.Content[i], "condition", str("started"))
+		}
+	}
+	return depends
+}
+
+func (c *converter) convertServiceSecrets(path string, node *yaml.Node) *yaml.Node {
+	secrets := list()
+	for i, item := range node.Content {
+		item = resolve(item)
+		if item.Kind == yaml.ScalarNode {
+			secrets.Content = append(secrets.Content, str(item.Value))
+			continue
+		}
+		secret := mapping()
+		for _, p := range pairs(item) {
+			switch p.key {
+			case "source", "target", "mode":
+				add(secret, p.key, str(p.value.Value))
+			default:
+				c.warn(fmt.Sprintf("%s[%d].%s", path, i, p.key), "'%s' is not supported", p.key)
+			}
+		}
+		secrets.Content = append(secrets.Content, secret)
+	}
+	return secrets
+}
+
+func (c *converter) convertVolume(name string, node *yaml.Node) {
+	for _, p := range pairs(node) {
+		c.warn("volumes."+name+"."+p.key, "'%s' is not supported", p.key)
+	}
+	add(c.volumes, name, mapping())
+}
+
+// networkDrivers lists the compose network drivers otari supports.
+var networkDrivers = map[string]bool{
+	"bridge":  true,
+	"host":    true,
+	"macvlan": true,
+	"ipvlan":  true,
+}
+
+func (c *converter) convertNetwork(name string, node *yaml.Node) {
+	network := mapping()
+	for _, p := range pairs(node) {
+		path := "networks." + name + "." + p.key
+		switch p.key {
+		case "driver":
+			if !networkDrivers[p.value.Value] {
+				c.warn(path, "driver '%s' is not supported, using bridge", p.value.Value)
+				continue
+			}
+			add(network, "driver", str(p.value.Value))
+		default:
+			c.warn(path, "'%s' is not supported", p.key)
+		}
+	}
+	add(c.networks, name, network)
+}
+
+func (c *converter) convertSecret(name string, node *yaml.Node) {
+	secret := mapping()
+	for _, p := range pairs(node) {
+		path := "secrets." + name + "." + p.key
+		switch p.key {
+		case "file", "environment":
+			add(secret, p.key, str(value(p.value)))
+		default:
+			c.warn(path, "'%s' is not supported", p.key)
+		}
+	}
+	if len(secret.Content) == 0 {
+		c.warn("secrets."+name, "secret has no file or environment source")
+	}
+	add(c.secrets, name, secret)
+}
+
+// bareVariableRe matches $VAR references, which otari only supports as ${VAR}.
+var bareVariableRe = regexp.MustCompile(`\$\$|\$([A-Za-z_][A-Za-z0-9_]*)`)
+
+// braceVariables rewrites $VAR to ${VAR}, leaving $$ escapes alone.
+func braceVariables(s string) string {
+	return bareVariableRe.ReplaceAllStringFunc(s, func(m string) string {
+		if m == "$$" {
+			return m
+		}
+		return "${" + m[1:] + "}"
+	})
+}
+
+// value returns the interpolation-ready value of a scalar.
+func value(n *yaml.Node) string {
+	return braceVariables(resolve(n).Value)
+}
+
+type pair struct {
+	key   string
+	value *yaml.Node
+}
+
+// pairs returns the entries of a mapping with aliases resolved and merge
+// keys (<<) expanded. Entries of the mapping override merged ones.
+func pairs(n *yaml.Node) []pair {
+	n = resolve(n)
+	if n == nil || n.Kind != yaml.MappingNode {
+		return nil
+	}
+
+	var out []pair
+	index := make(map[string]int)
+	set := func(p pair, override bool) {
+		if i, ok := index[p.key]; ok {
+			if override {
+				out[i] = p
+			}
+			return
+		}
+		index[p.key] = len(out)
+		out = append(out, p)
+	}
+
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		key := resolve(n.Content[i])
+		if key.Value != "<<" {
+			continue
+		}
+		merged := resolve(n.Content[i+1])
+		sources := []*yaml.Node{merged}
+		if merged.Kind == yaml.SequenceNode {
+			sources = merged.Content
+		}
+		for _, source := range sources {
+			for _, p := range pairs(source) {
+				set(p, false)
+			}
+		}
+	}
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		key := resolve(n.Content[i])
+		if key.Value == "<<" {
+			continue
+		}
+		set(pair{key: key.Value, value: resolve(n.Content[i+1])}, true)
+	}
+	return out
+}
+
+func resolve(n *yaml.Node) *yaml.Node {
+	for n != nil && n.Kind == yaml.AliasNode {
+		n = n.Alias
+	}
+	return n
+}
+
+func lookup(n *yaml.Node, key string) (*yaml.Node, bool) {
+	for _, p := range pairs(n) {
+		if p.key == key {
+			return p.value, true
+		}
+	}
+	return nil, false
+}
+
+func scalars(n *yaml.Node) []string {
+	n = resolve(n)
+	if n.Kind == yaml.ScalarNode {
+		return []string{value(n)}
+	}
+	var out []string
+	for _, item := range n.Content {
+		out = append(out, value(item))
+	}
+	return out
+}
+
+func stringOrList(n *yaml.Node) *yaml.Node {
+	n = resolve(n)
+	if n.Kind == yaml.ScalarNode {
+		return str(value(n))
+	}
+	return list(scalars(n)...)
+}
+
+func mapping() *yaml.Node {
+	return &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+}
+
+func list(values ...string) *yaml.Node {
+	n := &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+	for _, v := range values {
+		n.Content = append(n.Content, str(v))
+	}
+	return n
+}
+
+func str(v string) *yaml.Node {
+	return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: v}
+}
+
+func quoted(v string) *yaml.Node {
+	n := str(v)
+	n.Style = yaml.DoubleQuotedStyle
+	return n
+}
+
+// plain returns a scalar whose type yaml resolves, e.g. a bool or int.
+func plain(v string) *yaml.Node {
+	if _, err := strconv.ParseFloat(v, 64); err != nil && v != "true" && v != "false" {
+		return str(v)
+	}
+	return &yaml.Node{Kind: yaml.ScalarNode, Value: v}
+}
+
+func add(m *yaml.Node, key string, value *yaml.Node) {
+	m.Content = append(m.Content, str(key), value)
+}
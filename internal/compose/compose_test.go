@@ -0,0 +1,203 @@
+package compose
+
+// Tests the translation of compose files into otari stacks.
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/danecwalker/otari/internal/definition"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const composeFile = `
+version: "3.9"
+name: shop
+
+x-defaults: &defaults
+  restart: unless-stopped
+  labels:
+    team: web
+
+services:
+  web:
+    <<: *defaults
+    image: nginx:1.27
+    ports:
+      - "8080:80"
+      - target: 443
+        published: 8443
+        host_ip: 127.0.0.1
+        protocol: tcp
+      - "9000"
+    volumes:
+      - ./html:/usr/share/nginx/html:ro,cached
+      - static:/static
+      - type: tmpfs
+        target: /tmp
+    environment:
+      - MODE=$APP_MODE
+      - API_KEY
+    depends_on:
+      api:
+        condition: service_healthy
+      cache:
+        condition: service_started
+    networks:
+      frontend:
+        aliases: [www]
+    container_name: shop-web
+    cap_add: [NET_ADMIN]
+
+  api:
+    build:
+      context: ./api
+      dockerfile: Containerfile
+      args:
+        VERSION: "1.0"
+      cache_from: [api:latest]
+    image: shop/api:dev
+    env_file:
+      - api.env
+      - path: local.env
+        required: false
+    command: ["serve"]
+    healthcheck:
+      test: ["CMD", "curl", "-f", "http://localhost/health"]
+      interval: 10s
+      timeout: 5s
+      retries: 3
+      start_interval: 2s
+    restart: "no"
+    secrets:
+      - db_password
+      - source: api_token
+        target: token
+        uid: "1000"
+    networks: [frontend, backend]
+
+  cache:
+    image: redis:7
+    network_mode: host
+    depends_on: [api]
+
+volumes:
+  static:
+  data:
+    driver: local
+
+networks:
+  frontend:
+  backend:
+    driver: overlay
+    internal: true
+
+secrets:
+  db_password:
+    file: ./db_password.txt
+  api_token:
+    environment: API_TOKEN
+
+configs:
+  nginx:
+    file: ./nginx.conf
+`
+
+func TestConvert(t *testing.T) {
+	result, err := Convert([]byte(composeFile))
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "api.env"), []byte("PORT=80\n"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "local.env"), nil, 0o644))
+
+	stack, err := definition.ParseWithOptions(result.Stack, definition.ParseOptions{
+		Dir: dir,
+		LookupEnv: func(name string) (string, bool) {
+			return "value-of-" + name, true
+		},
+	})
+	require.NoError(t, err, string(result.Stack))
+	assert.Equal(t, "shop", stack.Name)
+
+	web := stack.Containers["web"]
+	require.NotNil(t, web)
+	assert.Equal(t, "nginx:1.27", web.Image.String())
+	assert.Equal(t, "unless-stopped", web.RestartPolicy.Condition)
+	assert.Equal(t, definition.MapArray{"team": "web"}, web.Labels)
+	require.Len(t, web.Ports, 3)
+	assert.Equal(t, "127.0.0.1", web.Ports[1].IP)
+	assert.Equal(t, 8443, web.Ports[1].HostPort.Start)
+	assert.Equal(t, 443, web.Ports[1].ContainerPort.Start)
+	require.Len(t, web.Volumes, 2)
+	assert.Equal(t, "./html:/usr/share/nginx/html:ro", web.Volumes[0].String())
+	assert.Equal(t, "static:/static", web.Volumes[1].String())
+	assert.Equal(t, definition.MapArray{"MODE": "value-of-APP_MODE", "API_KEY": "value-of-API_KEY"}, web.Environment)
+	assert.Equal(t, definition.Dependencies{
+		{Name: "api", Condition: definition.DependencyConditionHealthy},
+		{Name: "cache", Condition: definition.DependencyConditionStarted},
+	}, web.Depends)
+	assert.Equal(t, []string{"frontend"}, web.Networks)
+
+	api := stack.Containers["api"]
+	require.NotNil(t, api)
+	require.NotNil(t, api.Build)
+	assert.Equal(t, "./api", api.Build.Context)
+	assert.Equal(t, "Containerfile", api.Build.ContainerFile)
+	assert.Equal(t, []string{"shop/api:dev"}, api.Build.Tags)
+	assert.Equal(t, definition.MapArray{"VERSION": "1.0"}, api.Build.Args)
+	assert.Equal(t, definition.EnvFiles{"api.env", "local.env"}, api.EnvFile)
+	assert.Equal(t, "no", api.RestartPolicy.Condition)
+	require.NotNil(t, api.Healthcheck)
+	assert.Equal(t, 10*time.Second, api.Healthcheck.Interval)
+	assert.Equal(t, 3, api.Healthcheck.Retries)
+	require.Len(t, api.Secrets, 2)
+	assert.Equal(t, "api_token", api.Secrets[1].Source)
+	assert.Equal(t, "token", api.Secrets[1].Target)
+
+	cache := stack.Containers["cache"]
+	require.NotNil(t, cache)
+	assert.Equal(t, []string{"host"}, cache.Networks)
+	assert.Equal(t, definition.Dependencies{{Name: "api", Condition: definition.DependencyConditionStarted}}, cache.Depends)
+	require.Contains(t, stack.Networks, "host")
+	assert.Equal(t, definition.NetworkDriverHost, stack.Networks["host"].Driver)
+
+	assert.Contains(t, stack.Volumes, "static")
+	assert.Contains(t, stack.Volumes, "data")
+	assert.Contains(t, stack.Networks, "frontend")
+	assert.Equal(t, "./db_password.txt", stack.Secrets["db_password"].File)
+	assert.Equal(t, "API_TOKEN", stack.Secrets["api_token"].Environment)
+
+	var warned []string
+	for _, w := range result.Warnings {
+		warned = append(warned, w.Path)
+	}
+	assert.ElementsMatch(t, []string{
+		"services.web.ports[2]",
+		"services.web.volumes[0]",
+		"services.web.volumes[2]",
+		"services.web.networks.frontend",
+		"services.web.container_name",
+		"services.web.cap_add",
+		"services.api.build.cache_from",
+		"services.api.env_file[1]",
+		"services.api.command",
+		"services.api.healthcheck.start_interval",
+		"services.api.secrets[1].uid",
+		"volumes.data.driver",
+		"networks.backend.driver",
+		"networks.backend.internal",
+		"configs",
+	}, warned)
+}
+
+func TestConvertInvalid(t *testing.T) {
+	_, err := Convert([]byte(""))
+	assert.Error(t, err)
+
+	_, err = Convert([]byte("- not a mapping"))
+	assert.Error(t, err)
+}
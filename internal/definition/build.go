@@ -11,6 +11,15 @@ type Build struct {
 	Tags          []string `yaml:"tags"`
 	Args          MapArray `yaml:"args"`
 	Target        string   `yaml:"target"`
+
+	// ContextHash is the hash of the containerfile and the build context,
+	// once it was computed. It is tracked in the lock file on its own
+	// rather than as part of the hash of the container.
+	ContextHash string `yaml:"-"`
+	// Dir is the directory a relative Context is resolved against, the
+	// directory of the stack file. It is not part of the hash, moving the
+	// stack does not rebuild its images.
+	Dir string `yaml:"-"`
 }
 
 func (b *Build) UnmarshalYAML(value *yaml.Node) error {
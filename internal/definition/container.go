@@ -2,34 +2,41 @@ package definition
 
 import (
 	"github.com/danecwalker/otari/internal/hasher"
-	"github.com/danecwalker/otari/internal/systemd"
 )
 
 type Container struct {
-	ContainerName string      `yaml:"-"`
-	Entrypoint    StringArray `yaml:"entrypoint"`
-	Environment   MapArray    `yaml:"environment"`
-	// Healthcheck   *Healthcheck  `yaml:"healthcheck"`
-	Image         *Image        `yaml:"image"`
-	Build         *Build        `yaml:"build"`
-	Init          bool          `yaml:"init"`
-	Labels        MapArray      `yaml:"labels"`
-	Networks      []string      `yaml:"networks"`
-	Ports         []PortMap     `yaml:"ports"`
-	RestartPolicy RestartPolicy `yaml:"restart"`
-	Volumes       []VolumeMap   `yaml:"volumes"`
-	Depends       []string      `yaml:"depends"`
+	ContainerName string            `yaml:"-"`
+	Entrypoint    StringArray       `yaml:"entrypoint"`
+	Environment   MapArray          `yaml:"environment"`
+	EnvFile       EnvFiles          `yaml:"env_file"`
+	Healthcheck   *Healthcheck      `yaml:"healthcheck"`
+	Image         *Image            `yaml:"image"`
+	Build         *Build            `yaml:"build"`
+	PullPolicy    PullPolicy        `yaml:"pull_policy"`
+	Init          bool              `yaml:"init"`
+	Labels        MapArray          `yaml:"labels"`
+	Networks      []string          `yaml:"networks"`
+	Ports         []PortMap         `yaml:"ports"`
+	RestartPolicy RestartPolicy     `yaml:"restart"`
+	Volumes       []VolumeMap       `yaml:"volumes"`
+	Depends       Dependencies      `yaml:"depends"`
+	Pod           string            `yaml:"pod"`
+	Secrets       []ContainerSecret `yaml:"secrets"`
+	// ImageDigest is the digest the lock file pins the image to.
+	ImageDigest string `yaml:"-"`
 }
 
-// type Healthcheck struct {
-// 	Test          StringArray   `yaml:"test"`
-// 	Interval      time.Duration `yaml:"interval"`
-// 	Timeout       time.Duration `yaml:"timeout"`
-// 	Retries       int           `yaml:"retries"`
-// 	StartPeriod   time.Duration `yaml:"start_period"`
-// 	StartInterval time.Duration `yaml:"start_interval"`
-// 	Disable       bool          `yaml:"disable"`
-// }
+// ImageRef returns the image reference the container runs, pinned to
+// ImageDigest if the lock file pins it. Built images are never pinned.
+func (c *Container) ImageRef() string {
+	if c.Image == nil {
+		return ""
+	}
+	if c.ImageDigest != "" && c.Build == nil {
+		return c.Image.WithDigest(c.ImageDigest)
+	}
+	return c.Image.String()
+}
 
 func (c *Container) MarshalHash(h *hasher.Hash) error {
 	if c == nil {
@@ -38,12 +45,15 @@ func (c *Container) MarshalHash(h *hasher.Hash) error {
 	h.Hasher.Write([]byte(c.ContainerName))
 	c.Entrypoint.MarshalHash(h)
 	c.Environment.MarshalHash(h)
-	// if c.Healthcheck != nil {
-	// 	c.Healthcheck.MarshalHash(h)
-	// }
+	if c.Healthcheck != nil {
+		c.Healthcheck.MarshalHash(h)
+	}
 	if c.Image != nil {
 		c.Image.MarshalHash(h)
 	}
+	if c.ImageDigest != "" && c.Build == nil {
+		h.Hasher.Write([]byte(c.ImageDigest))
+	}
 	if c.Build != nil {
 		c.Build.MarshalHash(h)
 	}
@@ -63,34 +73,10 @@ func (c *Container) MarshalHash(h *hasher.Hash) error {
 	for _, vol := range c.Volumes {
 		vol.MarshalHash(h)
 	}
-	for _, dep := range c.Depends {
-		h.Hasher.Write([]byte(dep))
-	}
-	return nil
-}
-
-func (c *Container) Start() error {
-	return systemd.StartUnit(c.ContainerName)
-}
-
-func (c *Container) Stop() error {
-	return systemd.StopUnit(c.ContainerName)
-}
-
-func (c *Container) Restart() error {
-	return systemd.RestartUnit(c.ContainerName)
-}
-
-func (c *Container) Remove() error {
-	// Stop the container unit
-	if err := systemd.StopUnit(c.ContainerName); err != nil {
-		return err
-	}
-
-	// Remove the container quadlet
-	if err := systemd.DeleteUnitFile(c.ContainerName + ".container"); err != nil {
-		return err
+	c.Depends.MarshalHash(h)
+	h.Hasher.Write([]byte(c.Pod))
+	for _, secret := range c.Secrets {
+		secret.MarshalHash(h)
 	}
-
 	return nil
 }
@@ -4,6 +4,7 @@ package definition
 
 import (
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"gopkg.in/yaml.v3"
@@ -91,6 +92,30 @@ entrypoint:
 				Entrypoint: StringArray("/bin/sh -c"),
 			},
 		},
+		{
+			name: "Container with healthcheck",
+			yamlData: `image: redis:alpine
+healthcheck:
+  test: ["CMD", "redis-cli", "ping"]
+  interval: 10s
+  timeout: 5s
+  retries: 3
+  start_period: 30s`,
+			expected: &Container{
+				Image: &Image{
+					Image:     "redis",
+					Tag:       "alpine",
+					FullyQual: false,
+				},
+				Healthcheck: &Healthcheck{
+					Test:        HealthcheckTest{"CMD", "redis-cli", "ping"},
+					Interval:    10 * time.Second,
+					Timeout:     5 * time.Second,
+					Retries:     3,
+					StartPeriod: 30 * time.Second,
+				},
+			},
+		},
 	}
 
 	for _, tt := range tests {
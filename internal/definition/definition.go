@@ -1,24 +1,95 @@
 package definition
 
 import (
+	"path/filepath"
+
 	"gopkg.in/yaml.v3"
 )
 
 type Stack struct {
-	StackName  string                `yaml:"-"`
+	StackName string `yaml:"-"`
+	// Name sets the stack name, which otherwise is the name of the stack
+	// file, or of its directory for the default otari.yaml and otari.yml.
+	Name string `yaml:"name"`
+	// NameTemplate controls how resources are named on the host, see
+	// ResourceName. "{stack}" and "{name}" are replaced by the stack and
+	// resource names.
+	NameTemplate string `yaml:"name_template"`
+	// Mode selects between per-resource quadlets and a single .kube quadlet,
+	// see DeployMode.
+	Mode DeployMode `yaml:"mode"`
+
 	Containers map[string]*Container `yaml:"containers"`
 	Volumes    map[string]*Volume    `yaml:"volumes"`
 	Networks   map[string]*Network   `yaml:"networks"`
+	Pods       map[string]*Pod       `yaml:"pods"`
+	Secrets    map[string]*Secret    `yaml:"secrets"`
+
+	// Dir is the directory of the stack file, relative bind mounts and
+	// build contexts are resolved against it. Empty resolves them against
+	// the current working directory.
+	Dir string `yaml:"-"`
+	// StateDir is where the lock file of the stack and the .otari directory
+	// with its history are kept. Empty keeps them in the current working
+	// directory.
+	StateDir string `yaml:"-"`
+}
+
+// LockPath returns the path of the lock file of the stack.
+func (s *Stack) LockPath() string {
+	return filepath.Join(s.StateDir, s.StackName+".lock")
+}
+
+// ParseOptions controls how variables and relative paths in a stack
+// definition are resolved.
+type ParseOptions struct {
+	// Dir is the directory of the stack file. Its .env file is read and
+	// env_file paths of containers are relative to it.
+	Dir string
+	// EnvFiles replace the .env file in Dir.
+	EnvFiles []string
+	// LookupEnv looks up variables of the process, which take precedence
+	// over env files. Defaults to os.LookupEnv.
+	LookupEnv func(string) (string, bool)
 }
 
+// Parse parses a stack definition, interpolating variables from the
+// process environment.
 func Parse(data []byte) (*Stack, error) {
+	return ParseWithOptions(data, ParseOptions{})
+}
+
+func ParseWithOptions(data []byte, opts ParseOptions) (*Stack, error) {
+	lookup, err := environment(opts.Dir, opts.EnvFiles, opts.LookupEnv)
+	if err != nil {
+		return nil, err
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	if err := interpolateNode(&doc, lookup); err != nil {
+		return nil, err
+	}
+
 	var s Stack
-	if err := yaml.Unmarshal(data, &s); err != nil {
+	if len(doc.Content) > 0 {
+		if err := doc.Decode(&s); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := s.applyEnvFiles(opts.Dir); err != nil {
 		return nil, err
 	}
 
+	s.Dir = opts.Dir
 	for name, container := range s.Containers {
 		container.ContainerName = name
+		if container.Build != nil {
+			container.Build.Dir = opts.Dir
+		}
 	}
 
 	for name, volume := range s.Volumes {
@@ -38,5 +109,21 @@ func Parse(data []byte) (*Stack, error) {
 		network.NetworkName = name
 	}
 
+	for name, pod := range s.Pods {
+		if pod == nil {
+			pod = &Pod{}
+			s.Pods[name] = pod
+		}
+		pod.PodName = name
+	}
+
+	for name, secret := range s.Secrets {
+		if secret == nil {
+			secret = &Secret{}
+			s.Secrets[name] = secret
+		}
+		secret.SecretName = name
+	}
+
 	return &s, nil
 }
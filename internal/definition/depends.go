@@ -0,0 +1,93 @@
+package definition
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/danecwalker/otari/internal/hasher"
+	"gopkg.in/yaml.v3"
+)
+
+type DependencyCondition string
+
+const (
+	DependencyConditionStarted               DependencyCondition = "started"
+	DependencyConditionHealthy               DependencyCondition = "healthy"
+	DependencyConditionCompletedSuccessfully DependencyCondition = "completed_successfully"
+)
+
+type Dependency struct {
+	Name      string
+	Condition DependencyCondition
+}
+
+// Dependencies is a custom type to handle YAML unmarshalling of container
+// dependencies.
+//
+// Values can be provided as an array of container names, which wait for the
+// dependency to be started, or as a map of container names to a condition.
+type Dependencies []Dependency
+
+func (d *Dependencies) UnmarshalYAML(node *yaml.Node) error {
+	var result Dependencies
+
+	switch node.Kind {
+	case yaml.SequenceNode:
+		var names []string
+		if err := node.Decode(&names); err != nil {
+			return fmt.Errorf("failed to decode sequence node: %w", err)
+		}
+		for _, name := range names {
+			result = append(result, Dependency{
+				Name:      name,
+				Condition: DependencyConditionStarted,
+			})
+		}
+	case yaml.MappingNode:
+		var items map[string]*struct {
+			Condition DependencyCondition `yaml:"condition"`
+		}
+		if err := node.Decode(&items); err != nil {
+			return fmt.Errorf("failed to decode mapping node: %w", err)
+		}
+		for name, item := range items {
+			condition := DependencyConditionStarted
+			if item != nil && item.Condition != "" {
+				condition = item.Condition
+			}
+			result = append(result, Dependency{
+				Name:      name,
+				Condition: condition,
+			})
+		}
+		// map order is random, keep the hash stable
+		sort.Slice(result, func(i, j int) bool {
+			return result[i].Name < result[j].Name
+		})
+	default:
+		return fmt.Errorf("unsupported YAML node kind for Dependencies: %v", node.Kind)
+	}
+
+	*d = result
+
+	return nil
+}
+
+// Names returns the names of the containers depended upon.
+func (d Dependencies) Names() []string {
+	names := make([]string, 0, len(d))
+	for _, dep := range d {
+		names = append(names, dep.Name)
+	}
+	return names
+}
+
+func (d Dependencies) MarshalHash(h *hasher.Hash) {
+	for _, dep := range d {
+		h.Hasher.Write([]byte(dep.Name))
+		// started is the default, leave it out so existing lock files stay valid
+		if dep.Condition != DependencyConditionStarted {
+			h.Hasher.Write([]byte(":" + dep.Condition))
+		}
+	}
+}
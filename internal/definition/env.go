@@ -0,0 +1,187 @@
+package definition
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// EnvFiles is a custom type to handle YAML unmarshalling of env_file.
+//
+// Values can be provided as a single path or as an array of paths.
+type EnvFiles []string
+
+func (ef *EnvFiles) UnmarshalYAML(node *yaml.Node) error {
+	switch node.Kind {
+	case yaml.ScalarNode:
+		*ef = EnvFiles{node.Value}
+	case yaml.SequenceNode:
+		var result []string
+		if err := node.Decode(&result); err != nil {
+			return err
+		}
+		*ef = result
+	default:
+		return fmt.Errorf("unsupported YAML node kind for env_file: %v", node.Kind)
+	}
+	return nil
+}
+
+// ReadEnvFile reads a file of KEY=VALUE lines, see ParseEnvFile.
+func ReadEnvFile(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	env, err := ParseEnvFile(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return env, nil
+}
+
+// ParseEnvFile parses KEY=VALUE lines as found in .env files. Blank lines
+// and lines starting with # are skipped, an "export " prefix is ignored.
+// Values may be single quoted (taken literally) or double quoted (\n, \t,
+// \" and \\ are unescaped). Unquoted values end at a " #" comment.
+func ParseEnvFile(r io.Reader) (map[string]string, error) {
+	env := make(map[string]string)
+	scanner := bufio.NewScanner(r)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+
+		key, value, ok := strings.Cut(line, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return nil, fmt.Errorf("line %d: expected KEY=VALUE", lineNo)
+		}
+
+		value, err := parseEnvValue(strings.TrimSpace(value))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNo, err)
+		}
+		env[key] = value
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return env, nil
+}
+
+func parseEnvValue(value string) (string, error) {
+	if value == "" {
+		return "", nil
+	}
+
+	switch quote := value[0]; quote {
+	case '\'':
+		end := strings.IndexByte(value[1:], '\'')
+		if end < 0 {
+			return "", fmt.Errorf("unterminated single quoted value")
+		}
+		return value[1 : end+1], nil
+	case '"':
+		var b strings.Builder
+		for i := 1; i < len(value); i++ {
+			switch c := value[i]; {
+			case c == '"':
+				return b.String(), nil
+			case c == '\\' && i+1 < len(value):
+				i++
+				switch value[i] {
+				case 'n':
+					b.WriteByte('\n')
+				case 't':
+					b.WriteByte('\t')
+				default:
+					b.WriteByte(value[i])
+				}
+			default:
+				b.WriteByte(c)
+			}
+		}
+		return "", fmt.Errorf("unterminated double quoted value")
+	}
+
+	if i := strings.Index(value, " #"); i >= 0 {
+		value = strings.TrimSpace(value[:i])
+	}
+	return value, nil
+}
+
+// environment builds the variable lookup used for interpolation. Variables
+// of the process take precedence over env files, later env files over
+// earlier ones. Without env files the .env file in dir is used if present.
+func environment(dir string, envFiles []string, lookupEnv func(string) (string, bool)) (func(string) (string, bool), error) {
+	if lookupEnv == nil {
+		lookupEnv = os.LookupEnv
+	}
+
+	if len(envFiles) == 0 && dir != "" {
+		dotEnv := filepath.Join(dir, ".env")
+		if _, err := os.Stat(dotEnv); err == nil {
+			envFiles = []string{dotEnv}
+		}
+	}
+
+	fileEnv := make(map[string]string)
+	for _, path := range envFiles {
+		env, err := ReadEnvFile(path)
+		if err != nil {
+			return nil, err
+		}
+		for key, value := range env {
+			fileEnv[key] = value
+		}
+	}
+
+	return func(name string) (string, bool) {
+		if value, ok := lookupEnv(name); ok {
+			return value, true
+		}
+		value, ok := fileEnv[name]
+		return value, ok
+	}, nil
+}
+
+// applyEnvFiles merges the env_file entries of every container into its
+// environment. Variables set in environment take precedence.
+func (s *Stack) applyEnvFiles(dir string) error {
+	for name, container := range s.Containers {
+		if container == nil || len(container.EnvFile) == 0 {
+			continue
+		}
+
+		merged := make(MapArray)
+		for _, path := range container.EnvFile {
+			if !filepath.IsAbs(path) {
+				path = filepath.Join(dir, path)
+			}
+			env, err := ReadEnvFile(path)
+			if err != nil {
+				return fmt.Errorf("container '%s': %w", name, err)
+			}
+			for key, value := range env {
+				merged[key] = value
+			}
+		}
+		for key, value := range container.Environment {
+			merged[key] = value
+		}
+		container.Environment = merged
+	}
+	return nil
+}
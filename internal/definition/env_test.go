@@ -0,0 +1,110 @@
+package definition
+
+// Tests .env files and env_file entries of containers.
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/danecwalker/otari/internal/hasher"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseEnvFile(t *testing.T) {
+	env, err := ParseEnvFile(strings.NewReader(`
+# database settings
+DB_HOST=db
+export DB_PORT=5432
+DB_NAME = app # inline comment
+SINGLE='keep ${AS} # is'
+DOUBLE="line\nbreak"
+EMPTY=
+`))
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"DB_HOST": "db",
+		"DB_PORT": "5432",
+		"DB_NAME": "app",
+		"SINGLE":  "keep ${AS} # is",
+		"DOUBLE":  "line\nbreak",
+		"EMPTY":   "",
+	}, env)
+
+	_, err = ParseEnvFile(strings.NewReader("NOT A VARIABLE"))
+	assert.Error(t, err)
+}
+
+func writeFile(t *testing.T, dir, name, content string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+	return path
+}
+
+func TestParseDotEnv(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, ".env", "TAG=1.27\nPORT=8080\n")
+	staging := writeFile(t, dir, "staging.env", "TAG=1.28\n")
+	data := []byte(`
+containers:
+  web:
+    image: nginx:${TAG}
+    environment:
+      PORT: ${PORT:-80}
+`)
+	noEnv := lookupFrom(nil)
+
+	stack, err := ParseWithOptions(data, ParseOptions{Dir: dir, LookupEnv: noEnv})
+	require.NoError(t, err)
+	assert.Equal(t, "1.27", stack.Containers["web"].Image.Tag)
+	assert.Equal(t, "8080", stack.Containers["web"].Environment["PORT"])
+
+	// env files replace .env
+	stack, err = ParseWithOptions(data, ParseOptions{Dir: dir, EnvFiles: []string{staging}, LookupEnv: noEnv})
+	require.NoError(t, err)
+	assert.Equal(t, "1.28", stack.Containers["web"].Image.Tag)
+	assert.Equal(t, "80", stack.Containers["web"].Environment["PORT"])
+
+	// the process environment wins over env files
+	stack, err = ParseWithOptions(data, ParseOptions{Dir: dir, LookupEnv: lookupFrom(map[string]string{"TAG": "1.29"})})
+	require.NoError(t, err)
+	assert.Equal(t, "1.29", stack.Containers["web"].Image.Tag)
+}
+
+func TestContainerEnvFile(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "web.env", "MODE=production\nDEBUG=false\n")
+	data := []byte(`
+containers:
+  web:
+    image: nginx:latest
+    env_file: web.env
+    environment:
+      DEBUG: "true"
+`)
+
+	stack, err := ParseWithOptions(data, ParseOptions{Dir: dir, LookupEnv: lookupFrom(nil)})
+	require.NoError(t, err)
+	assert.Equal(t, MapArray{"MODE": "production", "DEBUG": "true"}, stack.Containers["web"].Environment)
+	before, err := hasher.MarshalHashableB58(stack.Containers["web"])
+	require.NoError(t, err)
+
+	// editing the env file changes the container hash
+	writeFile(t, dir, "web.env", "MODE=staging\n")
+	stack, err = ParseWithOptions(data, ParseOptions{Dir: dir, LookupEnv: lookupFrom(nil)})
+	require.NoError(t, err)
+	after, err := hasher.MarshalHashableB58(stack.Containers["web"])
+	require.NoError(t, err)
+	assert.NotEqual(t, before, after)
+
+	_, err = ParseWithOptions([]byte(`
+containers:
+  web:
+    image: nginx:latest
+    env_file: [missing.env]
+`), ParseOptions{Dir: dir, LookupEnv: lookupFrom(nil)})
+	assert.Error(t, err)
+}
@@ -0,0 +1,113 @@
+package definition
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/danecwalker/otari/internal/hasher"
+	"gopkg.in/yaml.v3"
+)
+
+type Healthcheck struct {
+	Test        HealthcheckTest `yaml:"test"`
+	Interval    time.Duration   `yaml:"interval"`
+	Timeout     time.Duration   `yaml:"timeout"`
+	Retries     int             `yaml:"retries"`
+	StartPeriod time.Duration   `yaml:"start_period"`
+	// StartInterval is parsed to be rejected by validation, podman has no
+	// equivalent of the compose option.
+	StartInterval time.Duration `yaml:"start_interval"`
+	Disable       bool          `yaml:"disable"`
+}
+
+// HealthcheckTest is the test of a healthcheck, either a shell command or
+// a list in the compose style ["CMD", args...] or ["CMD-SHELL", command]
+// forms.
+type HealthcheckTest []string
+
+func (t *HealthcheckTest) UnmarshalYAML(node *yaml.Node) error {
+	switch node.Kind {
+	case yaml.ScalarNode:
+		*t = HealthcheckTest{node.Value}
+	case yaml.SequenceNode:
+		var args []string
+		if err := node.Decode(&args); err != nil {
+			return err
+		}
+		*t = HealthcheckTest(args)
+	}
+	return nil
+}
+
+func (t HealthcheckTest) String() string {
+	return strings.Join(t, " ")
+}
+
+func (t HealthcheckTest) MarshalHash(h *hasher.Hash) {
+	h.Hasher.Write([]byte(t.String()))
+}
+
+// IsDisabled reports whether the healthcheck has been turned off, either
+// explicitly or by using the compose style NONE test.
+func (hc *Healthcheck) IsDisabled() bool {
+	return hc.Disable || strings.TrimSpace(hc.Test.String()) == "NONE"
+}
+
+// Command returns the shell command to run for the healthcheck with any
+// compose style CMD / CMD-SHELL prefix removed. The arguments of the CMD
+// form are quoted, so they reach the command as they were listed.
+func (hc *Healthcheck) Command() string {
+	if len(hc.Test) > 1 {
+		switch hc.Test[0] {
+		case "CMD-SHELL":
+			return strings.TrimSpace(strings.Join(hc.Test[1:], " "))
+		case "CMD":
+			return shellJoin(hc.Test[1:])
+		}
+		return shellJoin(hc.Test)
+	}
+	cmd := strings.TrimSpace(hc.Test.String())
+	if rest, ok := strings.CutPrefix(cmd, "CMD-SHELL "); ok {
+		return strings.TrimSpace(rest)
+	}
+	if rest, ok := strings.CutPrefix(cmd, "CMD "); ok {
+		return strings.TrimSpace(rest)
+	}
+	return cmd
+}
+
+// shellSafe matches arguments that need no quoting.
+var shellSafe = regexp.MustCompile(`^[A-Za-z0-9_@%+=:,./-]+$`)
+
+// shellJoin joins args into a shell command, quoting those that need it.
+func shellJoin(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		if shellSafe.MatchString(arg) {
+			quoted[i] = arg
+			continue
+		}
+		quoted[i] = "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+	}
+	return strings.Join(quoted, " ")
+}
+
+func (hc *Healthcheck) MarshalHash(h *hasher.Hash) error {
+	if hc == nil {
+		return nil
+	}
+	hc.Test.MarshalHash(h)
+	h.Hasher.Write([]byte(hc.Interval.String()))
+	h.Hasher.Write([]byte(hc.Timeout.String()))
+	h.Hasher.Write([]byte(fmt.Sprintf(":%d", hc.Retries)))
+	h.Hasher.Write([]byte(hc.StartPeriod.String()))
+	h.Hasher.Write([]byte(hc.StartInterval.String()))
+	if hc.Disable {
+		h.Hasher.Write([]byte{1})
+	} else {
+		h.Hasher.Write([]byte{0})
+	}
+	return nil
+}
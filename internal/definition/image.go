@@ -58,6 +58,15 @@ func (img *Image) String() string {
 	return sb.String()
 }
 
+// WithDigest returns the reference of the image pinned to digest instead of
+// its tag.
+func (img *Image) WithDigest(digest string) string {
+	pinned := *img
+	pinned.Tag = ""
+	pinned.Digest = digest
+	return pinned.String()
+}
+
 func ParseImage(imageRef string) (*Image, error) {
 	m := imageRefRe.FindStringSubmatch(imageRef)
 	if m == nil {
@@ -0,0 +1,147 @@
+package definition
+
+import (
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Interpolate replaces variables in s:
+//
+//	${VAR}          value of VAR, empty if unset
+//	${VAR:-default} default if VAR is unset or empty
+//	${VAR-default}  default if VAR is unset
+//	${VAR:?error}   fails with error if VAR is unset or empty
+//	${VAR?error}    fails with error if VAR is unset
+//	$$              a literal $
+//
+// Any other $ is kept as is.
+func Interpolate(s string, lookup func(string) (string, bool)) (string, error) {
+	if !strings.Contains(s, "$") {
+		return s, nil
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '$' || i+1 == len(s) {
+			b.WriteByte(s[i])
+			continue
+		}
+
+		switch s[i+1] {
+		case '$':
+			b.WriteByte('$')
+			i++
+		case '{':
+			end := strings.IndexByte(s[i+2:], '}')
+			if end < 0 {
+				return "", fmt.Errorf("unterminated variable in '%s'", s)
+			}
+			value, err := expandVariable(s[i+2:i+2+end], lookup)
+			if err != nil {
+				return "", err
+			}
+			b.WriteString(value)
+			i += end + 2
+		default:
+			b.WriteByte('$')
+		}
+	}
+	return b.String(), nil
+}
+
+func expandVariable(expr string, lookup func(string) (string, bool)) (string, error) {
+	name, op, arg := expr, "", ""
+	if i := strings.IndexAny(expr, ":-?"); i >= 0 {
+		name = expr[:i]
+		op = expr[i:]
+		if strings.HasPrefix(op, ":-") || strings.HasPrefix(op, ":?") {
+			op, arg = op[:2], op[2:]
+		} else {
+			op, arg = op[:1], op[1:]
+		}
+	}
+	if !isVariableName(name) {
+		return "", fmt.Errorf("invalid variable name '%s' in '${%s}'", name, expr)
+	}
+
+	value, set := lookup(name)
+	switch op {
+	case "":
+		return value, nil
+	case ":-":
+		if !set || value == "" {
+			return arg, nil
+		}
+	case "-":
+		if !set {
+			return arg, nil
+		}
+	case ":?":
+		if !set || value == "" {
+			return "", requiredVariableError(name, arg)
+		}
+	case "?":
+		if !set {
+			return "", requiredVariableError(name, arg)
+		}
+	default:
+		return "", fmt.Errorf("invalid variable expression '${%s}'", expr)
+	}
+	return value, nil
+}
+
+func requiredVariableError(name, message string) error {
+	if message == "" {
+		return fmt.Errorf("required variable '%s' is not set", name)
+	}
+	return fmt.Errorf("required variable '%s' is not set: %s", name, message)
+}
+
+func isVariableName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i, c := range name {
+		switch {
+		case c == '_', c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z':
+		case c >= '0' && c <= '9' && i > 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// interpolateNode interpolates every scalar value below node. Mapping keys
+// are left untouched.
+func interpolateNode(node *yaml.Node, lookup func(string) (string, bool)) error {
+	switch node.Kind {
+	case yaml.DocumentNode, yaml.SequenceNode:
+		for _, child := range node.Content {
+			if err := interpolateNode(child, lookup); err != nil {
+				return err
+			}
+		}
+	case yaml.MappingNode:
+		for i := 1; i < len(node.Content); i += 2 {
+			if err := interpolateNode(node.Content[i], lookup); err != nil {
+				return err
+			}
+		}
+	case yaml.ScalarNode:
+		value, err := Interpolate(node.Value, lookup)
+		if err != nil {
+			return fmt.Errorf("line %d: %w", node.Line, err)
+		}
+		if value != node.Value {
+			node.Value = value
+			if node.Style&(yaml.SingleQuotedStyle|yaml.DoubleQuotedStyle|yaml.LiteralStyle|yaml.FoldedStyle) == 0 {
+				// let yaml resolve the type of the new value, e.g. ports
+				node.Tag = ""
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package definition
+
+// Tests variable interpolation in stack definitions.
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func lookupFrom(env map[string]string) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		value, ok := env[name]
+		return value, ok
+	}
+}
+
+func TestInterpolate(t *testing.T) {
+	lookup := lookupFrom(map[string]string{
+		"TAG":   "1.27",
+		"EMPTY": "",
+	})
+
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+		err      string
+	}{
+		{name: "no variables", input: "nginx:latest", expected: "nginx:latest"},
+		{name: "variable", input: "nginx:${TAG}", expected: "nginx:1.27"},
+		{name: "unset variable", input: "nginx:${MISSING}", expected: "nginx:"},
+		{name: "default when unset", input: "${MISSING:-latest}", expected: "latest"},
+		{name: "default when empty", input: "${EMPTY:-latest}", expected: "latest"},
+		{name: "default only when unset", input: "${EMPTY-latest}", expected: ""},
+		{name: "default not used", input: "${TAG:-latest}", expected: "1.27"},
+		{name: "required", input: "${TAG:?tag is required}", expected: "1.27"},
+		{name: "required unset", input: "${MISSING:?tag is required}", err: "required variable 'MISSING' is not set: tag is required"},
+		{name: "required empty", input: "${EMPTY:?}", err: "required variable 'EMPTY' is not set"},
+		{name: "required only when unset", input: "${EMPTY?}", expected: ""},
+		{name: "escaped dollar", input: "echo $$HOME", expected: "echo $HOME"},
+		{name: "bare dollar", input: "echo $HOME", expected: "echo $HOME"},
+		{name: "unterminated", input: "${TAG", err: "unterminated variable"},
+		{name: "invalid name", input: "${1TAG}", err: "invalid variable name"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			value, err := Interpolate(tt.input, lookup)
+			if tt.err != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, value)
+		})
+	}
+}
+
+func TestParseInterpolatesValues(t *testing.T) {
+	stack, err := ParseWithOptions([]byte(`
+containers:
+  web:
+    image: nginx:${TAG:-latest}
+    ports:
+      - ${PORT}:80
+    environment:
+      GREETING: "hello ${NAME}"
+      LITERAL: 'cost $$5'
+`), ParseOptions{LookupEnv: lookupFrom(map[string]string{"PORT": "8080", "NAME": "world"})})
+	require.NoError(t, err)
+
+	web := stack.Containers["web"]
+	assert.Equal(t, "latest", web.Image.Tag)
+	require.Len(t, web.Ports, 1)
+	assert.Equal(t, "hello world", web.Environment["GREETING"])
+	assert.Equal(t, "cost $5", web.Environment["LITERAL"])
+}
+
+func TestParseRequiredVariable(t *testing.T) {
+	_, err := ParseWithOptions([]byte(`
+containers:
+  web:
+    image: ${IMAGE:?set IMAGE to deploy}
+`), ParseOptions{LookupEnv: lookupFrom(nil)})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "line 4")
+	assert.Contains(t, err.Error(), "set IMAGE to deploy")
+}
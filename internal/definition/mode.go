@@ -0,0 +1,27 @@
+package definition
+
+// DeployMode controls how a stack is deployed.
+type DeployMode string
+
+const (
+	// DeployModeQuadlet deploys every resource as its own quadlet. It is the
+	// default.
+	DeployModeQuadlet DeployMode = "quadlet"
+	// DeployModeKube deploys the stack as a Kubernetes manifest run by a
+	// single .kube quadlet.
+	DeployModeKube DeployMode = "kube"
+)
+
+// EffectiveMode returns the deploy mode, falling back to the default if
+// none is set.
+func (s *Stack) EffectiveMode() DeployMode {
+	if s.Mode == "" {
+		return DeployModeQuadlet
+	}
+	return s.Mode
+}
+
+// IsKube reports whether the stack is deployed as a Kubernetes manifest.
+func (s *Stack) IsKube() bool {
+	return s.EffectiveMode() == DeployModeKube
+}
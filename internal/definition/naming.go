@@ -0,0 +1,36 @@
+package definition
+
+import "strings"
+
+const (
+	// DefaultNameTemplate prefixes every resource with the stack name so
+	// stacks on the same host do not collide.
+	DefaultNameTemplate = "{stack}-{name}"
+	// LegacyNameTemplate is the naming used before resources were
+	// namespaced. Stacks deployed with it keep using it until their
+	// template is changed explicitly.
+	LegacyNameTemplate = "{name}"
+
+	// StackLabel is set on every podman resource otari creates.
+	StackLabel = "otari.stack"
+)
+
+// ResourceName returns the name podman and systemd know a stack resource
+// by, e.g. the container name or the quadlet file name without extension.
+func (s *Stack) ResourceName(name string) string {
+	return strings.NewReplacer("{stack}", s.StackName, "{name}", name).Replace(s.EffectiveNameTemplate())
+}
+
+// EffectiveNameTemplate returns the name template, falling back to the
+// default if none is set.
+func (s *Stack) EffectiveNameTemplate() string {
+	if s.NameTemplate == "" {
+		return DefaultNameTemplate
+	}
+	return s.NameTemplate
+}
+
+// StackLabelValue returns the value of the StackLabel label.
+func (s *Stack) StackLabelValue() string {
+	return StackLabel + "=" + s.StackName
+}
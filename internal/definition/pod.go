@@ -0,0 +1,29 @@
+package definition
+
+import "github.com/danecwalker/otari/internal/hasher"
+
+type Pod struct {
+	PodName  string      `yaml:"-"`
+	Ports    []PortMap   `yaml:"ports"`
+	Networks []string    `yaml:"networks"`
+	Volumes  []VolumeMap `yaml:"volumes"`
+	UserNS   string      `yaml:"userns"`
+}
+
+func (p *Pod) MarshalHash(h *hasher.Hash) error {
+	if p == nil {
+		return nil
+	}
+	h.Hasher.Write([]byte(p.PodName))
+	for _, port := range p.Ports {
+		port.MarshalHash(h)
+	}
+	for _, net := range p.Networks {
+		h.Hasher.Write([]byte(net))
+	}
+	for _, vol := range p.Volumes {
+		vol.MarshalHash(h)
+	}
+	h.Hasher.Write([]byte(p.UserNS))
+	return nil
+}
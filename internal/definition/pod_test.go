@@ -0,0 +1,36 @@
+package definition
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParsePods(t *testing.T) {
+	data := `
+containers:
+  web:
+    image: nginx:latest
+    pod: app
+pods:
+  app:
+    ports:
+      - '8080:80'
+    networks:
+      - app-network
+    userns: keep-id
+  empty:
+networks:
+  app-network:
+`
+	stack, err := Parse([]byte(data))
+	assert.NoError(t, err)
+
+	assert.Len(t, stack.Pods, 2)
+	assert.Equal(t, "app", stack.Pods["app"].PodName)
+	assert.Equal(t, "empty", stack.Pods["empty"].PodName)
+	assert.Equal(t, []string{"app-network"}, stack.Pods["app"].Networks)
+	assert.Equal(t, "keep-id", stack.Pods["app"].UserNS)
+	assert.Equal(t, "0.0.0.0:8080:80/tcp", stack.Pods["app"].Ports[0].String())
+	assert.Equal(t, "app", stack.Containers["web"].Pod)
+}
@@ -0,0 +1,33 @@
+package definition
+
+// PullPolicy controls when the image of a container is pulled.
+type PullPolicy string
+
+const (
+	// PullMissing pulls images that are not present locally. It is the
+	// default.
+	PullMissing PullPolicy = "missing"
+	// PullAlways pulls the image on every start.
+	PullAlways PullPolicy = "always"
+	// PullNewer pulls the image on every start if the registry has a
+	// different image for its tag.
+	PullNewer PullPolicy = "newer"
+	// PullNever never pulls, the image has to be present locally.
+	PullNever PullPolicy = "never"
+)
+
+// EffectivePullPolicy returns the pull policy of the container, falling
+// back to the default if none is set.
+func (c *Container) EffectivePullPolicy() PullPolicy {
+	if c.PullPolicy == "" {
+		return PullMissing
+	}
+	return c.PullPolicy
+}
+
+// Refreshes reports whether the image of the container is pulled on every
+// start, so a moved tag is picked up.
+func (c *Container) Refreshes() bool {
+	policy := c.EffectivePullPolicy()
+	return policy == PullAlways || policy == PullNewer
+}
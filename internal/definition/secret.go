@@ -0,0 +1,152 @@
+package definition
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/danecwalker/otari/internal/hasher"
+	"gopkg.in/yaml.v3"
+)
+
+type Secret struct {
+	SecretName  string `yaml:"-"`
+	File        string `yaml:"file"`
+	Environment string `yaml:"environment"`
+	Stdin       bool   `yaml:"stdin"`
+
+	// value is only held in memory, it is never written to disk by otari
+	value []byte
+}
+
+// Value returns the resolved secret value.
+func (s *Secret) Value() []byte {
+	return s.value
+}
+
+// Resolve reads the secret value from its source. A relative File is read
+// from dir, the directory of the stack file.
+func (s *Secret) Resolve(dir string, stdin io.Reader) error {
+	switch {
+	case s.File != "":
+		path := s.File
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(dir, path)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to read secret '%s': %w", s.SecretName, err)
+		}
+		s.value = data
+	case s.Environment != "":
+		value, ok := os.LookupEnv(s.Environment)
+		if !ok {
+			return fmt.Errorf("secret '%s' references unset environment variable '%s'", s.SecretName, s.Environment)
+		}
+		s.value = []byte(value)
+	case s.Stdin:
+		data, err := io.ReadAll(stdin)
+		if err != nil {
+			return fmt.Errorf("failed to read secret '%s' from stdin: %w", s.SecretName, err)
+		}
+		s.value = []byte(strings.TrimRight(string(data), "\r\n"))
+	default:
+		return fmt.Errorf("secret '%s' has no source", s.SecretName)
+	}
+	return nil
+}
+
+// Digest returns a hash of the secret value, safe to store on disk.
+func (s *Secret) Digest() string {
+	h := hasher.NewHash()
+	h.Hasher.Write(s.value)
+	return hasher.EncodeB58(h.Hasher.Sum(nil))
+}
+
+func (s *Secret) MarshalHash(h *hasher.Hash) error {
+	if s == nil {
+		return nil
+	}
+	h.Hasher.Write([]byte(s.SecretName))
+	h.Hasher.Write([]byte(s.File))
+	h.Hasher.Write([]byte(s.Environment))
+	// only a digest of the value ends up in the hash
+	h.Hasher.Write([]byte(s.Digest()))
+	return nil
+}
+
+type SecretType string
+
+const (
+	SecretTypeMount SecretType = "mount"
+	SecretTypeEnv   SecretType = "env"
+)
+
+// ContainerSecret references a stack secret from a container.
+//
+// It can be given as the secret name, which mounts the secret at
+// /run/secrets/<name>, or as a map with a source, type and target.
+type ContainerSecret struct {
+	Source string     `yaml:"source"`
+	Type   SecretType `yaml:"type"`
+	Target string     `yaml:"target"`
+	Mode   string     `yaml:"mode"`
+
+	// digest of the referenced secret so that rotation changes the hash
+	digest string
+}
+
+func (cs *ContainerSecret) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		var source string
+		if err := value.Decode(&source); err != nil {
+			return err
+		}
+		*cs = ContainerSecret{
+			Source: source,
+			Type:   SecretTypeMount,
+		}
+		return nil
+	}
+
+	type containerSecretAlias ContainerSecret
+	var csa containerSecretAlias
+	if err := value.Decode(&csa); err != nil {
+		return err
+	}
+	if csa.Type == "" {
+		csa.Type = SecretTypeMount
+	}
+	*cs = ContainerSecret(csa)
+	return nil
+}
+
+func (cs ContainerSecret) MarshalHash(h *hasher.Hash) {
+	h.Hasher.Write([]byte(cs.Source))
+	h.Hasher.Write([]byte(cs.Type))
+	h.Hasher.Write([]byte(cs.Target))
+	h.Hasher.Write([]byte(cs.Mode))
+	h.Hasher.Write([]byte(cs.digest))
+}
+
+// ResolveSecrets reads the value of every secret in the stack and records
+// their digests on the containers that reference them.
+func (s *Stack) ResolveSecrets(stdin io.Reader) error {
+	for _, secret := range s.Secrets {
+		if err := secret.Resolve(s.Dir, stdin); err != nil {
+			return err
+		}
+	}
+
+	for _, container := range s.Containers {
+		for i, ref := range container.Secrets {
+			if secret, ok := s.Secrets[ref.Source]; ok {
+				container.Secrets[i].digest = secret.Digest()
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,76 @@
+package definition
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/danecwalker/otari/internal/hasher"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSecrets(t *testing.T) {
+	data := `
+containers:
+  db:
+    image: postgres:16
+    secrets:
+      - db_password
+      - source: api_key
+        type: env
+        target: API_KEY
+secrets:
+  db_password:
+    environment: OTARI_TEST_DB_PASSWORD
+  api_key:
+    stdin: true
+`
+	stack, err := Parse([]byte(data))
+	assert.NoError(t, err)
+
+	assert.Equal(t, "db_password", stack.Secrets["db_password"].SecretName)
+	assert.Equal(t, "OTARI_TEST_DB_PASSWORD", stack.Secrets["db_password"].Environment)
+	assert.True(t, stack.Secrets["api_key"].Stdin)
+
+	refs := stack.Containers["db"].Secrets
+	assert.Len(t, refs, 2)
+	assert.Equal(t, "db_password", refs[0].Source)
+	assert.Equal(t, SecretTypeMount, refs[0].Type)
+	assert.Equal(t, "api_key", refs[1].Source)
+	assert.Equal(t, SecretTypeEnv, refs[1].Type)
+	assert.Equal(t, "API_KEY", refs[1].Target)
+}
+
+func TestSecretRotationChangesHash(t *testing.T) {
+	data := `
+containers:
+  db:
+    image: postgres:16
+    secrets:
+      - db_password
+secrets:
+  db_password:
+    environment: OTARI_TEST_DB_PASSWORD
+`
+	hashes := func(value string) (string, string) {
+		t.Setenv("OTARI_TEST_DB_PASSWORD", value)
+		stack, err := Parse([]byte(data))
+		assert.NoError(t, err)
+		assert.NoError(t, stack.ResolveSecrets(strings.NewReader("")))
+
+		secretHash, err := hasher.MarshalHashableB58(stack.Secrets["db_password"])
+		assert.NoError(t, err)
+		containerHash, err := hasher.MarshalHashableB58(stack.Containers["db"])
+		assert.NoError(t, err)
+		return secretHash, containerHash
+	}
+
+	s1, c1 := hashes("hunter2")
+	s2, c2 := hashes("hunter2")
+	s3, c3 := hashes("correct horse battery staple")
+
+	assert.Equal(t, s1, s2)
+	assert.Equal(t, c1, c2)
+	assert.NotEqual(t, s1, s3)
+	assert.NotEqual(t, c1, c3)
+	assert.NotContains(t, s1, "hunter2")
+}
@@ -0,0 +1,143 @@
+package diff
+
+import (
+	"fmt"
+	"strings"
+)
+
+type opKind int
+
+const (
+	opEqual opKind = iota
+	opDelete
+	opInsert
+)
+
+type op struct {
+	kind opKind
+	line string
+	// position in a and b before this op is applied
+	ai, bi int
+}
+
+// Lines splits text into lines, dropping the trailing newline.
+func Lines(text string) []string {
+	if text == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+}
+
+// editScript computes the shortest edit script turning a into b using the
+// longest common subsequence of lines. Quadlets are small so the quadratic
+// table is fine.
+func editScript(a, b []string) []op {
+	n, m := len(a), len(b)
+	lcs := make([][]int, n+1)
+	for i := range lcs {
+		lcs[i] = make([]int, m+1)
+	}
+	for i := n - 1; i >= 0; i-- {
+		for j := m - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	var ops []op
+	i, j := 0, 0
+	for i < n || j < m {
+		switch {
+		case i < n && j < m && a[i] == b[j]:
+			ops = append(ops, op{kind: opEqual, line: a[i], ai: i, bi: j})
+			i++
+			j++
+		case j >= m || (i < n && lcs[i+1][j] >= lcs[i][j+1]):
+			ops = append(ops, op{kind: opDelete, line: a[i], ai: i, bi: j})
+			i++
+		default:
+			ops = append(ops, op{kind: opInsert, line: b[j], ai: i, bi: j})
+			j++
+		}
+	}
+	return ops
+}
+
+// Unified returns a unified diff between oldText and newText with the given
+// number of context lines. It returns an empty string when both are equal.
+func Unified(oldName, newName, oldText, newText string, context int) string {
+	if oldText == newText {
+		return ""
+	}
+
+	a, b := Lines(oldText), Lines(newText)
+	ops := editScript(a, b)
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "--- %s\n", oldName)
+	fmt.Fprintf(&sb, "+++ %s\n", newName)
+
+	for start := 0; start < len(ops); {
+		// find the next change
+		first := start
+		for first < len(ops) && ops[first].kind == opEqual {
+			first++
+		}
+		if first == len(ops) {
+			break
+		}
+
+		// extend the hunk while changes are within 2*context lines of each other
+		last := first
+		for k := first + 1; k < len(ops); k++ {
+			if ops[k].kind != opEqual {
+				if k-last > 2*context {
+					break
+				}
+				last = k
+			}
+		}
+
+		from := max(first-context, start)
+		to := min(last+context+1, len(ops))
+
+		oldCount, newCount := 0, 0
+		for _, o := range ops[from:to] {
+			if o.kind != opInsert {
+				oldCount++
+			}
+			if o.kind != opDelete {
+				newCount++
+			}
+		}
+
+		fmt.Fprintf(&sb, "@@ -%s +%s @@\n", hunkRange(ops[from].ai, oldCount), hunkRange(ops[from].bi, newCount))
+		for _, o := range ops[from:to] {
+			switch o.kind {
+			case opEqual:
+				sb.WriteString(" " + o.line + "\n")
+			case opDelete:
+				sb.WriteString("-" + o.line + "\n")
+			case opInsert:
+				sb.WriteString("+" + o.line + "\n")
+			}
+		}
+
+		start = to
+	}
+
+	return sb.String()
+}
+
+func hunkRange(start, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", start)
+	}
+	if count == 1 {
+		return fmt.Sprintf("%d", start+1)
+	}
+	return fmt.Sprintf("%d,%d", start+1, count)
+}
@@ -0,0 +1,82 @@
+package diff
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUnified(t *testing.T) {
+	tests := []struct {
+		name     string
+		old      string
+		new      string
+		expected string
+	}{
+		{
+			name:     "Equal",
+			old:      "a\nb\n",
+			new:      "a\nb\n",
+			expected: "",
+		},
+		{
+			name: "New file",
+			old:  "",
+			new:  "a\nb\n",
+			expected: `--- old
++++ new
+@@ -0,0 +1,2 @@
++a
++b
+`,
+		},
+		{
+			name: "Deleted file",
+			old:  "a\n",
+			new:  "",
+			expected: `--- old
++++ new
+@@ -1 +0,0 @@
+-a
+`,
+		},
+		{
+			name: "Modified line with context",
+			old:  "[Container]\nImage=redis:7\nNetwork=a.network\nVolume=x:/x\nLabel=a=b\n",
+			new:  "[Container]\nImage=redis:8\nNetwork=a.network\nVolume=x:/x\nLabel=a=b\n",
+			expected: `--- old
++++ new
+@@ -1,4 +1,4 @@
+ [Container]
+-Image=redis:7
++Image=redis:8
+ Network=a.network
+ Volume=x:/x
+`,
+		},
+		{
+			name: "Separate hunks",
+			old:  "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n",
+			new:  "one\n2\n3\n4\n5\n6\n7\n8\n9\nten\n",
+			expected: `--- old
++++ new
+@@ -1,3 +1,3 @@
+-1
++one
+ 2
+ 3
+@@ -8,3 +8,3 @@
+ 8
+ 9
+-10
++ten
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, Unified("old", "new", tt.old, tt.new, 2))
+		})
+	}
+}
@@ -0,0 +1,350 @@
+// Package fake is an in-memory podman runtime and systemd service manager
+// for tests. It records every call and simulates the state of units, so
+// whole stack operations can run without a real host.
+package fake
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/danecwalker/otari/internal/definition"
+	"github.com/danecwalker/otari/internal/generate"
+	"github.com/danecwalker/otari/internal/podman"
+	"github.com/danecwalker/otari/internal/quadlets"
+	"github.com/danecwalker/otari/internal/systemd"
+	"github.com/danecwalker/otari/internal/utils"
+)
+
+// Host implements podman.Runtime and systemd.ServiceManager. Like systemd
+// it treats "name" and "name.service" as the same unit, units started by
+// their bare name are containers, as otari starts them that way.
+type Host struct {
+	mu sync.Mutex
+
+	// Calls records every call that changes state, e.g. "start demo-web".
+	Calls []string
+	// Images lists the images present locally.
+	Images map[string]bool
+	// Digests holds the digest of every local image, pulled images get a
+	// digest derived from their name unless one is set. Images without one
+	// never came from a registry.
+	Digests map[string]string
+	// Secrets holds the value of every stored secret.
+	Secrets map[string][]byte
+	// Health is the health reported for running containers. Containers
+	// without an entry are healthy.
+	Health map[string]string
+	// Exits makes a container exit with the given status as soon as it
+	// started, like a container that runs to completion.
+	Exits map[string]int
+	// Failures makes the call with the same description fail.
+	Failures map[string]error
+	// Logs is returned by GetLogs.
+	Logs map[string][]byte
+
+	units      map[string]*systemd.UnitState
+	containers map[string]bool
+}
+
+var (
+	_ podman.Runtime         = (*Host)(nil)
+	_ systemd.ServiceManager = (*Host)(nil)
+)
+
+func New() *Host {
+	return &Host{
+		Images:     make(map[string]bool),
+		Digests:    make(map[string]string),
+		Secrets:    make(map[string][]byte),
+		Health:     make(map[string]string),
+		Exits:      make(map[string]int),
+		Failures:   make(map[string]error),
+		Logs:       make(map[string][]byte),
+		units:      make(map[string]*systemd.UnitState),
+		containers: make(map[string]bool),
+	}
+}
+
+// unitKey returns the full name of a unit.
+func unitKey(unitName string) string {
+	if !strings.Contains(unitName, ".") {
+		return unitName + ".service"
+	}
+	return unitName
+}
+
+// record appends call to Calls and returns the failure configured for it.
+func (h *Host) record(format string, args ...any) error {
+	call := fmt.Sprintf(format, args...)
+	h.Calls = append(h.Calls, call)
+	return h.Failures[call]
+}
+
+// Reset forgets the recorded calls.
+func (h *Host) Reset() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.Calls = nil
+}
+
+// Recorded returns the recorded calls starting with prefix.
+func (h *Host) Recorded(prefix string) []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var out []string
+	for _, call := range h.Calls {
+		if strings.HasPrefix(call, prefix) {
+			out = append(out, call)
+		}
+	}
+	return out
+}
+
+// Active reports whether the unit is running.
+func (h *Host) Active(unitName string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.active(unitName)
+}
+
+func (h *Host) active(unitName string) bool {
+	state, ok := h.units[unitKey(unitName)]
+	return ok && state.ActiveState == "active"
+}
+
+func (h *Host) ImageExists(ctx context.Context, image string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.Images[image] {
+		return true
+	}
+	// like podman, images are known by the digest they were pulled at
+	if _, digest, ok := strings.Cut(image, "@"); ok {
+		for local := range h.Images {
+			if h.Digests[local] == digest {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (h *Host) PullImage(ctx context.Context, image string, policy definition.PullPolicy, progress func(line string)) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if err := h.record("pull %s", image); err != nil {
+		return err
+	}
+	progress("Trying to pull " + image + "...")
+	h.Images[image] = true
+	if h.Digests[image] == "" {
+		h.Digests[image] = Digest(image)
+	}
+	return nil
+}
+
+// Digest returns the digest pulled images get by default.
+func Digest(image string) string {
+	return fmt.Sprintf("sha256:%x", sha256.Sum256([]byte(image)))
+}
+
+func (h *Host) ImageDigest(ctx context.Context, image string) (string, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !h.Images[image] {
+		return "", fmt.Errorf("%s: image not known", image)
+	}
+	return h.Digests[image], nil
+}
+
+func (h *Host) BuildImage(ctx context.Context, build *definition.Build, image string, noCache bool, progress func(line string)) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	call := "build " + image
+	if noCache {
+		call += " --no-cache"
+	}
+	if err := h.record("%s", call); err != nil {
+		return err
+	}
+	progress("STEP 1/1: FROM " + build.Context)
+	h.Images[image] = true
+	h.Digests[image] = Digest(image)
+	return nil
+}
+
+func (h *Host) ActiveContainers(ctx context.Context) ([]string, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var names []string
+	for name := range h.containers {
+		if h.active(name) {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	return names, nil
+}
+
+func (h *Host) InspectContainer(ctx context.Context, containerName string) (*podman.ContainerState, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	state, ok := h.units[unitKey(containerName)]
+	if !ok || !h.containers[containerName] {
+		return nil, fmt.Errorf("no such container %s", containerName)
+	}
+	if state.ActiveState != "active" {
+		return &podman.ContainerState{Status: "exited", ExitCode: state.ExecMainStatus}, nil
+	}
+	health, ok := h.Health[containerName]
+	if !ok {
+		health = "healthy"
+	}
+	return &podman.ContainerState{Status: "running", Running: true, Health: health}, nil
+}
+
+// ResourceExists reports resources as existing while their unit runs, as
+// quadlet units create them when started.
+func (h *Host) ResourceExists(ctx context.Context, resourceType, name string) bool {
+	unitName := name
+	switch resourceType {
+	case generate.KindNetwork:
+		unitName = quadlets.NetworkServiceName(name)
+	case generate.KindVolume:
+		unitName = quadlets.VolumeServiceName(name)
+	case generate.KindPod:
+		unitName = quadlets.PodServiceName(name)
+	}
+	return h.Active(unitName)
+}
+
+func (h *Host) RemoveVolume(ctx context.Context, volumeName string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.record("remove volume %s", volumeName)
+}
+
+func (h *Host) RemoveNetwork(ctx context.Context, networkName string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.record("remove network %s", networkName)
+}
+
+func (h *Host) CreateSecret(ctx context.Context, secretName string, value []byte, labels ...string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if err := h.record("create secret %s", secretName); err != nil {
+		return err
+	}
+	h.Secrets[secretName] = value
+	return nil
+}
+
+func (h *Host) RemoveSecret(ctx context.Context, secretName string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if err := h.record("remove secret %s", secretName); err != nil {
+		return err
+	}
+	if _, ok := h.Secrets[secretName]; !ok {
+		return fmt.Errorf("no such secret %s", secretName)
+	}
+	delete(h.Secrets, secretName)
+	return nil
+}
+
+func (h *Host) SecretExists(ctx context.Context, secretName string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, ok := h.Secrets[secretName]
+	return ok
+}
+
+func (h *Host) ReloadDaemon() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.record("daemon-reload")
+}
+
+func (h *Host) StartUnit(unitName string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if err := h.record("start %s", unitName); err != nil {
+		return err
+	}
+	h.run(unitName)
+	return nil
+}
+
+func (h *Host) StopUnit(unitName string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if err := h.record("stop %s", unitName); err != nil {
+		return err
+	}
+	if state, ok := h.units[unitKey(unitName)]; ok {
+		state.ActiveState, state.SubState = "inactive", "dead"
+	}
+	return nil
+}
+
+func (h *Host) RestartUnit(unitName string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if err := h.record("restart %s", unitName); err != nil {
+		return err
+	}
+	h.run(unitName)
+	return nil
+}
+
+// run moves the unit into the state it has after being started.
+func (h *Host) run(unitName string) {
+	if !strings.Contains(unitName, ".") {
+		h.containers[unitName] = true
+	}
+	state := &systemd.UnitState{LoadState: "loaded", ActiveState: "active", SubState: "running", Result: "success"}
+	if status, ok := h.Exits[strings.TrimSuffix(unitName, ".service")]; ok {
+		state.ActiveState, state.SubState, state.ExecMainStatus = "inactive", "dead", status
+		if status != 0 {
+			state.ActiveState, state.SubState, state.Result = "failed", "failed", "exit-code"
+		}
+	}
+	h.units[unitKey(unitName)] = state
+}
+
+func (h *Host) DeleteUnitFile(fileName string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if err := h.record("delete %s", fileName); err != nil {
+		return err
+	}
+	err := os.Remove(filepath.Join(utils.OutputLocation(), fileName))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (h *Host) GetUnitState(unitName string) (*systemd.UnitState, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	state, ok := h.units[unitKey(unitName)]
+	if !ok {
+		return &systemd.UnitState{LoadState: "not-found", ActiveState: "inactive", SubState: "dead"}, nil
+	}
+	copied := *state
+	return &copied, nil
+}
+
+func (h *Host) GetLogs(unitName string) ([]byte, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.Logs[unitName], nil
+}
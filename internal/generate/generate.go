@@ -2,88 +2,179 @@ package generate
 
 import (
 	"fmt"
+	"sort"
 
 	"github.com/danecwalker/otari/internal/definition"
-	"github.com/danecwalker/otari/internal/spinners"
-	"github.com/danecwalker/otari/internal/utils"
 )
 
 type Generator interface {
 	GenerateContainer(stack *definition.Stack, name string) ([]byte, error)
 	GenerateNetwork(stack *definition.Stack, name string) ([]byte, error)
 	GenerateVolume(stack *definition.Stack, name string) ([]byte, error)
+	GeneratePod(stack *definition.Stack, name string) ([]byte, error)
 }
 
-func Generate(stack, new *definition.Stack, outputPath string, generator Generator) error {
-	for name, network := range stack.Networks {
-		if _, exists := new.Networks[name]; !exists {
-			continue
-		}
-		if network.Driver == definition.NetworkDriverHost {
-			continue
-		}
-		sp := spinners.DefaultSpinner()
-		network.NetworkName = name
-		sp.SetMessage(fmt.Sprintf("Generating configuration for network '%s'", network.NetworkName))
-		out, err := generator.GenerateNetwork(stack, network.NetworkName)
-		if err != nil {
-			sp.FinishWithError(fmt.Sprintf("Failed to generate configuration for network '%s': %v", network.NetworkName, err))
-			return err
-		}
+// Resource kinds, which double as the quadlet file extension.
+const (
+	KindNetwork   = "network"
+	KindVolume    = "volume"
+	KindPod       = "pod"
+	KindContainer = "container"
+)
 
-		err = utils.WriteToFile(outputPath, network.NetworkName+".network", out)
-		if err != nil {
-			sp.FinishWithError(fmt.Sprintf("Failed to generate configuration for network '%s': %v", network.NetworkName, err))
-			return err
-		}
+// Kinds lists the resource kinds in the order they are generated.
+var Kinds = []string{KindNetwork, KindVolume, KindPod, KindContainer}
 
-		sp.FinishWithSuccess(fmt.Sprintf("Generated configuration for network '%s'", network.NetworkName))
-	}
+// Quadlet is the rendered unit file of a single stack resource.
+type Quadlet struct {
+	Kind     string
+	Name     string
+	FileName string
+	Content  []byte
+}
 
-	for name, volume := range stack.Volumes {
-		if _, exists := new.Volumes[name]; !exists {
-			continue
+// Names returns the sorted names of the resources of the given kind that
+// produce a quadlet file. Host networks are skipped as podman provides them.
+func Names(stack *definition.Stack, kind string) []string {
+	var names []string
+	switch kind {
+	case KindNetwork:
+		for name, network := range stack.Networks {
+			if network.Driver == definition.NetworkDriverHost {
+				continue
+			}
+			names = append(names, name)
 		}
-		sp := spinners.DefaultSpinner()
-		volume.VolumeName = name
-		sp.SetMessage(fmt.Sprintf("Generating configuration for volume '%s'", volume.VolumeName))
-		out, err := generator.GenerateVolume(stack, volume.VolumeName)
-		if err != nil {
-			sp.FinishWithError(fmt.Sprintf("Failed to generate configuration for volume '%s': %v", volume.VolumeName, err))
-			return err
+	case KindVolume:
+		for name := range stack.Volumes {
+			names = append(names, name)
 		}
-
-		err = utils.WriteToFile(outputPath, volume.VolumeName+".volume", out)
-		if err != nil {
-			sp.FinishWithError(fmt.Sprintf("Failed to generate configuration for volume '%s': %v", volume.VolumeName, err))
-			return err
+	case KindPod:
+		for name := range stack.Pods {
+			names = append(names, name)
+		}
+	case KindContainer:
+		for name := range stack.Containers {
+			names = append(names, name)
 		}
-		sp.FinishWithSuccess(fmt.Sprintf("Generated configuration for volume '%s'", volume.VolumeName))
 	}
+	sort.Strings(names)
+	return names
+}
 
-	for name, container := range stack.Containers {
-		if _, exists := new.Containers[name]; !exists {
-			continue
-		}
-		sp := spinners.DefaultSpinner()
+// Has reports whether the stack contains a resource of the given kind.
+func Has(stack *definition.Stack, kind, name string) bool {
+	if stack == nil {
+		return false
+	}
+	switch kind {
+	case KindNetwork:
+		_, ok := stack.Networks[name]
+		return ok
+	case KindVolume:
+		_, ok := stack.Volumes[name]
+		return ok
+	case KindPod:
+		_, ok := stack.Pods[name]
+		return ok
+	case KindContainer:
+		_, ok := stack.Containers[name]
+		return ok
+	}
+	return false
+}
+
+// RenderOne renders the quadlet for a single resource without writing it.
+func RenderOne(stack *definition.Stack, kind, name string, generator Generator) (*Quadlet, error) {
+	var out []byte
+	var err error
+	switch kind {
+	case KindNetwork:
+		stack.Networks[name].NetworkName = name
+		out, err = generator.GenerateNetwork(stack, name)
+	case KindVolume:
+		stack.Volumes[name].VolumeName = name
+		out, err = generator.GenerateVolume(stack, name)
+	case KindPod:
+		stack.Pods[name].PodName = name
+		out, err = generator.GeneratePod(stack, name)
+	case KindContainer:
+		container := stack.Containers[name]
 		container.ContainerName = name
 		if container.Build != nil {
 			container.Image = &definition.Image{}
 			container.Image.Image = fmt.Sprintf("%s_%s", stack.StackName, container.ContainerName)
 		}
-		sp.SetMessage(fmt.Sprintf("Generating configuration for container '%s'", container.ContainerName))
-		out, err := generator.GenerateContainer(stack, container.ContainerName)
+		out, err = generator.GenerateContainer(stack, name)
+	default:
+		return nil, fmt.Errorf("unknown resource kind '%s'", kind)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &Quadlet{
+		Kind:     kind,
+		Name:     name,
+		FileName: stack.ResourceName(name) + "." + kind,
+		Content:  out,
+	}, nil
+}
+
+// Render renders the quadlets of every resource in the stack into memory.
+// In kube mode the .kube quadlet and its manifest replace the quadlets of
+// everything but networks.
+func Render(stack *definition.Stack, generator Generator) ([]*Quadlet, error) {
+	out, err := renderKinds(stack, generatedKinds(stack), generator)
+	if err != nil {
+		return nil, err
+	}
+	if stack.IsKube() {
+		files, err := renderKube(stack)
 		if err != nil {
-			sp.FinishWithError(fmt.Sprintf("Failed to generate configuration for container '%s': %v", container.ContainerName, err))
-			return err
+			return nil, fmt.Errorf("failed to generate Kubernetes manifest: %w", err)
 		}
+		out = append(out, files...)
+	}
+	return out, nil
+}
+
+func renderKinds(stack *definition.Stack, kinds []string, generator Generator) ([]*Quadlet, error) {
+	var out []*Quadlet
+	for _, kind := range kinds {
+		for _, name := range Names(stack, kind) {
+			q, err := RenderOne(stack, kind, name, generator)
+			if err != nil {
+				return nil, fmt.Errorf("failed to generate configuration for %s '%s': %w", kind, name, err)
+			}
+			out = append(out, q)
+		}
+	}
+	return out, nil
+}
 
-		err = utils.WriteToFile(outputPath, container.ContainerName+".container", out)
+// Changed renders the quadlets of the resources in new, along with the
+// .kube unit and manifest of a stack in kube mode.
+func Changed(stack, new *definition.Stack, generator Generator) ([]*Quadlet, error) {
+	var out []*Quadlet
+	for _, kind := range generatedKinds(stack) {
+		for _, name := range Names(stack, kind) {
+			if !Has(new, kind, name) {
+				continue
+			}
+			q, err := RenderOne(stack, kind, name, generator)
+			if err != nil {
+				return nil, fmt.Errorf("failed to generate configuration for %s '%s': %w", kind, name, err)
+			}
+			out = append(out, q)
+		}
+	}
+	if stack.IsKube() {
+		files, err := renderKube(stack)
 		if err != nil {
-			sp.FinishWithError(fmt.Sprintf("Failed to generate configuration for container '%s': %v", container.ContainerName, err))
-			return err
+			return nil, fmt.Errorf("failed to generate Kubernetes manifest for stack '%s': %w", stack.StackName, err)
 		}
-		sp.FinishWithSuccess(fmt.Sprintf("Generated configuration for container '%s'", container.ContainerName))
+		out = append(out, files...)
 	}
-	return nil
+	return out, nil
 }
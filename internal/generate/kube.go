@@ -0,0 +1,60 @@
+package generate
+
+import (
+	"bytes"
+
+	"github.com/danecwalker/otari/internal/definition"
+	"github.com/danecwalker/otari/internal/kube"
+)
+
+// KindKube is the kind of the .kube quadlet and the manifest it plays,
+// which replace the quadlets of containers, pods and volumes in kube mode.
+const KindKube = "kube"
+
+var _ Generator = (*kube.KubeGenerator)(nil)
+
+// Manifest renders every resource of the stack with generator and joins
+// the results into a single multi document YAML stream.
+func Manifest(stack *definition.Stack, generator Generator) ([]byte, error) {
+	rendered, err := renderKinds(stack, Kinds, generator)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	for _, q := range rendered {
+		if len(q.Content) == 0 {
+			continue
+		}
+		if buf.Len() > 0 {
+			buf.WriteString("---\n")
+		}
+		buf.Write(q.Content)
+	}
+	return buf.Bytes(), nil
+}
+
+// generatedKinds returns the kinds that get a quadlet of their own. In kube
+// mode only networks do, everything else is part of the manifest.
+func generatedKinds(stack *definition.Stack) []string {
+	if stack.IsKube() {
+		return []string{KindNetwork}
+	}
+	return Kinds
+}
+
+// renderKube renders the .kube quadlet of a stack and its manifest.
+func renderKube(stack *definition.Stack) ([]*Quadlet, error) {
+	manifest, err := Manifest(stack, kube.Generator())
+	if err != nil {
+		return nil, err
+	}
+	unit, err := kube.Quadlet(stack)
+	if err != nil {
+		return nil, err
+	}
+	return []*Quadlet{
+		{Kind: KindKube, Name: stack.StackName, FileName: kube.QuadletFileName(stack), Content: unit},
+		{Kind: KindKube, Name: stack.StackName, FileName: kube.ManifestFileName(stack), Content: manifest},
+	}, nil
+}
@@ -0,0 +1,252 @@
+// Package history keeps the revisions of a deployed stack: the quadlets
+// and lock file of every deployment along with the digests of the images it
+// ran, so a stack can be rolled back to an earlier revision.
+package history
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/danecwalker/otari/internal/utils"
+)
+
+// Keep is the number of revisions kept per stack, older ones are pruned.
+const Keep = 20
+
+type Status string
+
+const (
+	// StatusDeployed revisions started successfully.
+	StatusDeployed Status = "deployed"
+	// StatusFailed revisions failed to start and were not kept running.
+	StatusFailed Status = "failed"
+)
+
+var ErrNotFound = errors.New("revision not found")
+
+const (
+	metadataFile = "revision.toml"
+	lockFile     = "stack.lock"
+	quadletsDir  = "quadlets"
+)
+
+type Revision struct {
+	Number    int       `toml:"revision"`
+	CreatedAt time.Time `toml:"created_at"`
+	Status    Status    `toml:"status"`
+	// RollbackOf is the revision that was restored, if this revision is a
+	// rollback.
+	RollbackOf int `toml:"rollback_of,omitempty"`
+	// Error is why the deployment failed.
+	Error string `toml:"error,omitempty"`
+	// Images holds the digest of the image every container ran, keyed by
+	// the name of its unit.
+	Images map[string]string `toml:"images,omitempty"`
+
+	dir string
+}
+
+// Dir returns the directory the revisions of a stack are kept in, inside
+// the state directory of the stack.
+func Dir(stateDir, stackName string) string {
+	return filepath.Join(stateDir, ".otari", "history", stackName)
+}
+
+// List returns the revisions of a stack, oldest first.
+func List(stateDir, stackName string) ([]*Revision, error) {
+	entries, err := os.ReadDir(Dir(stateDir, stackName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var revisions []*Revision
+	for _, entry := range entries {
+		if _, err := strconv.Atoi(entry.Name()); err != nil || !entry.IsDir() {
+			continue
+		}
+		revision, err := read(filepath.Join(Dir(stateDir, stackName), entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, revision)
+	}
+	slices.SortFunc(revisions, func(a, b *Revision) int { return a.Number - b.Number })
+	return revisions, nil
+}
+
+func read(dir string) (*Revision, error) {
+	data, err := os.ReadFile(filepath.Join(dir, metadataFile))
+	if err != nil {
+		return nil, err
+	}
+	var revision Revision
+	if err := toml.Unmarshal(data, &revision); err != nil {
+		return nil, fmt.Errorf("failed to read revision %s: %w", filepath.Base(dir), err)
+	}
+	revision.dir = dir
+	return &revision, nil
+}
+
+// Get returns a revision of a stack.
+func Get(stateDir, stackName string, number int) (*Revision, error) {
+	dir := filepath.Join(Dir(stateDir, stackName), strconv.Itoa(number))
+	if !utils.PathExists(dir) {
+		return nil, fmt.Errorf("%w: %d", ErrNotFound, number)
+	}
+	return read(dir)
+}
+
+// Latest returns the newest revision of a stack, or nil if it has none.
+func Latest(stateDir, stackName string) (*Revision, error) {
+	revisions, err := List(stateDir, stackName)
+	if err != nil || len(revisions) == 0 {
+		return nil, err
+	}
+	return revisions[len(revisions)-1], nil
+}
+
+// LastGood returns the newest deployed revision of a stack older than
+// before, or nil if there is none. Pass 0 to consider every revision.
+func LastGood(stateDir, stackName string, before int) (*Revision, error) {
+	revisions, err := List(stateDir, stackName)
+	if err != nil {
+		return nil, err
+	}
+	for i := len(revisions) - 1; i >= 0; i-- {
+		revision := revisions[i]
+		if revision.Status == StatusDeployed && (before == 0 || revision.Number < before) {
+			return revision, nil
+		}
+	}
+	return nil, nil
+}
+
+// Record stores revision as the next revision of a stack. The lock file
+// at lockPath and the given quadlets in outputDir are copied into it, files
+// that do not exist are skipped.
+func Record(stateDir, stackName string, revision *Revision, lockPath, outputDir string, fileNames []string) error {
+	latest, err := Latest(stateDir, stackName)
+	if err != nil {
+		return err
+	}
+	revision.Number = 1
+	if latest != nil {
+		revision.Number = latest.Number + 1
+	}
+	if revision.CreatedAt.IsZero() {
+		revision.CreatedAt = time.Now().UTC().Truncate(time.Second)
+	}
+	revision.dir = filepath.Join(Dir(stateDir, stackName), strconv.Itoa(revision.Number))
+
+	if err := os.MkdirAll(filepath.Join(revision.dir, quadletsDir), 0o755); err != nil {
+		return err
+	}
+	if err := copyFile(lockPath, filepath.Join(revision.dir, lockFile)); err != nil {
+		return err
+	}
+	for _, fileName := range fileNames {
+		if err := copyFile(filepath.Join(outputDir, fileName), filepath.Join(revision.dir, quadletsDir, fileName)); err != nil {
+			return err
+		}
+	}
+
+	f, err := os.Create(filepath.Join(revision.dir, metadataFile))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := toml.NewEncoder(f).Encode(revision); err != nil {
+		return err
+	}
+
+	return prune(stateDir, stackName)
+}
+
+// copyFile copies src to dst unless src does not exist.
+func copyFile(src, dst string) error {
+	data, err := os.ReadFile(src)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	return os.WriteFile(dst, data, 0o644)
+}
+
+// prune removes all but the newest Keep revisions.
+func prune(stateDir, stackName string) error {
+	revisions, err := List(stateDir, stackName)
+	if err != nil {
+		return err
+	}
+	for len(revisions) > Keep {
+		if err := os.RemoveAll(revisions[0].dir); err != nil {
+			return err
+		}
+		revisions = revisions[1:]
+	}
+	return nil
+}
+
+// Remove deletes the history of a stack.
+func Remove(stateDir, stackName string) error {
+	return os.RemoveAll(Dir(stateDir, stackName))
+}
+
+// Quadlets returns the content of the quadlets of the revision, keyed by
+// file name.
+func (r *Revision) Quadlets() (map[string][]byte, error) {
+	entries, err := os.ReadDir(filepath.Join(r.dir, quadletsDir))
+	if err != nil {
+		return nil, err
+	}
+	quadlets := make(map[string][]byte)
+	for _, entry := range entries {
+		data, err := os.ReadFile(filepath.Join(r.dir, quadletsDir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		quadlets[entry.Name()] = data
+	}
+	return quadlets, nil
+}
+
+// Lock returns the lock file of the revision, or nil if it has none.
+func (r *Revision) Lock() ([]byte, error) {
+	data, err := os.ReadFile(filepath.Join(r.dir, lockFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return data, err
+}
+
+// PinImage points the Image= line of a container quadlet at digest, so
+// the quadlet runs the exact image it ran before even if its tag moved.
+func PinImage(quadlet []byte, digest string) []byte {
+	lines := strings.Split(string(quadlet), "\n")
+	for i, line := range lines {
+		image, ok := strings.CutPrefix(line, "Image=")
+		if !ok {
+			continue
+		}
+		// drop the tag or digest of the reference
+		if at := strings.Index(image, "@"); at != -1 {
+			image = image[:at]
+		} else if colon := strings.LastIndex(image, ":"); colon > strings.LastIndex(image, "/") {
+			image = image[:colon]
+		}
+		lines[i] = "Image=" + image + "@" + digest
+	}
+	return []byte(strings.Join(lines, "\n"))
+}
@@ -0,0 +1,75 @@
+package history
+
+// Tests recording, listing and pruning revisions and pinning images.
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecord(t *testing.T) {
+	t.Chdir(t.TempDir())
+	stateDir := t.TempDir()
+	outputDir := "stack"
+	require.NoError(t, os.MkdirAll(outputDir, 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(outputDir, "demo-web.container"), []byte("[Container]\nImage=nginx:1.27\n"), 0o644))
+	require.NoError(t, os.WriteFile("demo.lock", []byte("version = 2\n"), 0o644))
+
+	require.NoError(t, Record(stateDir, "demo", &Revision{Status: StatusDeployed}, "demo.lock", outputDir, []string{"demo-web.container", "demo-missing.container"}))
+	require.NoError(t, Record(stateDir, "demo", &Revision{Status: StatusFailed, Error: "boom"}, "", outputDir, []string{"demo-web.container"}))
+
+	revisions, err := List(stateDir, "demo")
+	require.NoError(t, err)
+	require.Len(t, revisions, 2)
+	assert.Equal(t, 1, revisions[0].Number)
+	assert.Equal(t, 2, revisions[1].Number)
+	assert.Equal(t, "boom", revisions[1].Error)
+
+	quadlets, err := revisions[0].Quadlets()
+	require.NoError(t, err)
+	assert.Equal(t, map[string][]byte{"demo-web.container": []byte("[Container]\nImage=nginx:1.27\n")}, quadlets)
+	lock, err := revisions[0].Lock()
+	require.NoError(t, err)
+	assert.Equal(t, []byte("version = 2\n"), lock)
+	lock, err = revisions[1].Lock()
+	require.NoError(t, err)
+	assert.Nil(t, lock)
+
+	good, err := LastGood(stateDir, "demo", 0)
+	require.NoError(t, err)
+	assert.Equal(t, 1, good.Number)
+	good, err = LastGood(stateDir, "demo", 1)
+	require.NoError(t, err)
+	assert.Nil(t, good)
+
+	_, err = Get(stateDir, "demo", 3)
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	// only the newest revisions are kept
+	for range Keep {
+		require.NoError(t, Record(stateDir, "demo", &Revision{Status: StatusDeployed}, "demo.lock", outputDir, nil))
+	}
+	revisions, err = List(stateDir, "demo")
+	require.NoError(t, err)
+	assert.Len(t, revisions, Keep)
+	assert.Equal(t, 3, revisions[0].Number)
+}
+
+func TestPinImage(t *testing.T) {
+	digest := "sha256:0123abcd"
+	tests := map[string]string{
+		"Image=nginx:1.27":              "Image=nginx@" + digest,
+		"Image=docker.io/library/nginx": "Image=docker.io/library/nginx@" + digest,
+		"Image=localhost:5000/web:dev":  "Image=localhost:5000/web@" + digest,
+		"Image=nginx@sha256:ffff":       "Image=nginx@" + digest,
+		"ContainerName=demo-web":        "ContainerName=demo-web",
+	}
+	for line, want := range tests {
+		quadlet := "[Container]\n" + line + "\n"
+		assert.Equal(t, "[Container]\n"+want+"\n", string(PinImage([]byte(quadlet), digest)), line)
+	}
+}
@@ -0,0 +1,206 @@
+// Package journal makes writing the quadlets of a deployment
+// transactional. Files are staged next to their destination first and then
+// swapped in with renames, while a journal records which files are part of
+// the deployment and keeps a backup of those they replace. A deployment
+// that is interrupted can be completed or reverted by the next run.
+package journal
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/danecwalker/otari/internal/utils"
+)
+
+type Phase string
+
+const (
+	// PhaseStaged journals were interrupted before every file was swapped
+	// in, they are reverted.
+	PhaseStaged Phase = "staged"
+	// PhaseSwapped journals were interrupted after every file was swapped
+	// in, they are completed.
+	PhaseSwapped Phase = "swapped"
+)
+
+const (
+	journalFile = "journal.toml"
+	backupDir   = "backup"
+	// stagedSuffix is not a quadlet extension, so systemd ignores staged
+	// files.
+	stagedSuffix = ".staged"
+)
+
+// Journal records a deployment in progress.
+type Journal struct {
+	Stack     string    `toml:"stack"`
+	StartedAt time.Time `toml:"started_at"`
+	OutputDir string    `toml:"output_dir"`
+	Phase     Phase     `toml:"phase"`
+	Files     []File    `toml:"files"`
+
+	dir string
+}
+
+// File is a file written or removed by the deployment.
+type File struct {
+	Name string `toml:"name"`
+	// Existed is set if the file replaces one, which is kept as a backup.
+	Existed bool `toml:"existed"`
+	// Removed is set if the deployment removes the file.
+	Removed bool `toml:"removed,omitempty"`
+}
+
+// Dir returns the directory the journal of a stack is kept in, inside the
+// state directory of the stack.
+func Dir(stateDir, stackName string) string {
+	return filepath.Join(stateDir, ".otari", "journal", stackName)
+}
+
+// Load returns the journal of an interrupted deployment of the stack, or
+// nil if there is none.
+func Load(stateDir, stackName string) (*Journal, error) {
+	dir := Dir(stateDir, stackName)
+	data, err := os.ReadFile(filepath.Join(dir, journalFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var j Journal
+	if err := toml.Unmarshal(data, &j); err != nil {
+		return nil, fmt.Errorf("failed to parse journal: %w", err)
+	}
+	j.dir = dir
+	return &j, nil
+}
+
+// Stage writes files next to their destination in outputDir, keyed by
+// their names, and journals them. Files without content are removed by the
+// swap instead. Nothing in outputDir changes until Swap.
+func Stage(stateDir, stackName, outputDir string, files map[string][]byte) (*Journal, error) {
+	j := &Journal{
+		Stack:     stackName,
+		StartedAt: time.Now().UTC().Truncate(time.Second),
+		OutputDir: outputDir,
+		Phase:     PhaseStaged,
+		dir:       Dir(stateDir, stackName),
+	}
+	if err := os.MkdirAll(filepath.Join(j.dir, backupDir), 0o755); err != nil {
+		return nil, err
+	}
+	for _, name := range slices.Sorted(maps.Keys(files)) {
+		if err := j.stage(name, files[name]); err != nil {
+			return nil, errors.Join(err, j.Revert())
+		}
+	}
+	if err := j.save(); err != nil {
+		return nil, errors.Join(err, j.Revert())
+	}
+	return j, nil
+}
+
+func (j *Journal) stage(name string, content []byte) error {
+	file := File{Name: name}
+	current, err := os.ReadFile(filepath.Join(j.OutputDir, name))
+	if err == nil {
+		file.Existed = true
+		if err := utils.WriteFileAtomic(filepath.Join(j.dir, backupDir, name), current, 0o644); err != nil {
+			return fmt.Errorf("failed to back up '%s': %w", name, err)
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	if content == nil {
+		file.Removed = true
+		j.Files = append(j.Files, file)
+		return nil
+	}
+	// journaled before it is written, so a failure removes it again
+	j.Files = append(j.Files, file)
+	if err := utils.WriteFileAtomic(j.staged(name), content, 0o644); err != nil {
+		return fmt.Errorf("failed to stage '%s': %w", name, err)
+	}
+	return nil
+}
+
+// Swap moves the staged files to their destination. A file is replaced
+// atomically, but the journal only counts as swapped once all of them are.
+func (j *Journal) Swap() error {
+	for _, file := range j.Files {
+		path := filepath.Join(j.OutputDir, file.Name)
+		if file.Removed {
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("failed to remove '%s': %w", file.Name, err)
+			}
+			continue
+		}
+		err := os.Rename(j.staged(file.Name), path)
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to swap in '%s': %w", file.Name, err)
+		}
+	}
+	j.Phase = PhaseSwapped
+	return j.save()
+}
+
+// Revert restores the files the deployment replaced or removed, removes
+// those it added and drops the journal.
+func (j *Journal) Revert() error {
+	var errs []error
+	for _, file := range j.Files {
+		if err := os.Remove(j.staged(file.Name)); err != nil && !os.IsNotExist(err) {
+			errs = append(errs, err)
+		}
+		path := filepath.Join(j.OutputDir, file.Name)
+		if !file.Existed {
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				errs = append(errs, err)
+			}
+			continue
+		}
+		backup, err := os.ReadFile(filepath.Join(j.dir, backupDir, file.Name))
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to read the backup of '%s': %w", file.Name, err))
+			continue
+		}
+		if err := utils.WriteFileAtomic(path, backup, 0o644); err != nil {
+			errs = append(errs, fmt.Errorf("failed to restore '%s': %w", file.Name, err))
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		// keep the journal to try again
+		return err
+	}
+	return j.Done()
+}
+
+// Done drops the journal once the deployment finished, whether it
+// succeeded or failed and was handled.
+func (j *Journal) Done() error {
+	return os.RemoveAll(j.dir)
+}
+
+// Clear drops the journal of the stack, if any.
+func Clear(stateDir, stackName string) error {
+	return os.RemoveAll(Dir(stateDir, stackName))
+}
+
+func (j *Journal) staged(name string) string {
+	return filepath.Join(j.OutputDir, "."+name+stagedSuffix)
+}
+
+func (j *Journal) save() error {
+	var buf bytes.Buffer
+	if err := toml.NewEncoder(&buf).Encode(j); err != nil {
+		return err
+	}
+	return utils.WriteFileAtomic(filepath.Join(j.dir, journalFile), buf.Bytes(), 0o644)
+}
@@ -0,0 +1,100 @@
+package journal
+
+// Tests staging, swapping and reverting the files of a deployment.
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSwap(t *testing.T) {
+	t.Chdir(t.TempDir())
+	stateDir := t.TempDir()
+	outputDir := "stack"
+	require.NoError(t, os.MkdirAll(outputDir, 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(outputDir, "demo-web.container"), []byte("old"), 0o644))
+
+	j, err := Stage(stateDir, "demo", outputDir, map[string][]byte{"demo-web.container": []byte("new"), "demo-db.container": []byte("db")})
+	require.NoError(t, err)
+
+	// nothing changes until the swap
+	web, err := os.ReadFile(filepath.Join(outputDir, "demo-web.container"))
+	require.NoError(t, err)
+	assert.Equal(t, "old", string(web))
+	assert.NoFileExists(t, filepath.Join(outputDir, "demo-db.container"))
+
+	loaded, err := Load(stateDir, "demo")
+	require.NoError(t, err)
+	assert.Equal(t, PhaseStaged, loaded.Phase)
+	assert.Equal(t, []File{{Name: "demo-db.container"}, {Name: "demo-web.container", Existed: true}}, loaded.Files)
+
+	require.NoError(t, j.Swap())
+	web, err = os.ReadFile(filepath.Join(outputDir, "demo-web.container"))
+	require.NoError(t, err)
+	assert.Equal(t, "new", string(web))
+	entries, err := os.ReadDir(outputDir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 2)
+
+	loaded, err = Load(stateDir, "demo")
+	require.NoError(t, err)
+	assert.Equal(t, PhaseSwapped, loaded.Phase)
+	require.NoError(t, loaded.Done())
+	loaded, err = Load(stateDir, "demo")
+	require.NoError(t, err)
+	assert.Nil(t, loaded)
+}
+
+func TestRevert(t *testing.T) {
+	t.Chdir(t.TempDir())
+	stateDir := t.TempDir()
+	outputDir := "stack"
+	require.NoError(t, os.MkdirAll(outputDir, 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(outputDir, "demo-web.container"), []byte("old"), 0o644))
+
+	j, err := Stage(stateDir, "demo", outputDir, map[string][]byte{"demo-web.container": []byte("new"), "demo-db.container": []byte("db")})
+	require.NoError(t, err)
+	// interrupted after swapping in one of the files
+	require.NoError(t, os.Rename(j.staged("demo-web.container"), filepath.Join(outputDir, "demo-web.container")))
+
+	loaded, err := Load(stateDir, "demo")
+	require.NoError(t, err)
+	require.NoError(t, loaded.Revert())
+
+	entries, err := os.ReadDir(outputDir)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	web, err := os.ReadFile(filepath.Join(outputDir, "demo-web.container"))
+	require.NoError(t, err)
+	assert.Equal(t, "old", string(web))
+	assert.NoDirExists(t, Dir(stateDir, "demo"))
+}
+
+func TestSwapRemovesFiles(t *testing.T) {
+	t.Chdir(t.TempDir())
+	stateDir := t.TempDir()
+	outputDir := "stack"
+	require.NoError(t, os.MkdirAll(outputDir, 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(outputDir, "demo-old.container"), []byte("old"), 0o644))
+
+	j, err := Stage(stateDir, "demo", outputDir, map[string][]byte{"demo-old.container": nil, "demo-gone.container": nil})
+	require.NoError(t, err)
+	assert.FileExists(t, filepath.Join(outputDir, "demo-old.container"))
+	assert.Equal(t, []File{{Name: "demo-gone.container", Removed: true}, {Name: "demo-old.container", Existed: true, Removed: true}}, j.Files)
+
+	require.NoError(t, j.Swap())
+	assert.NoFileExists(t, filepath.Join(outputDir, "demo-old.container"))
+
+	// reverting restores the removed file from its backup
+	loaded, err := Load(stateDir, "demo")
+	require.NoError(t, err)
+	require.NoError(t, loaded.Revert())
+	old, err := os.ReadFile(filepath.Join(outputDir, "demo-old.container"))
+	require.NoError(t, err)
+	assert.Equal(t, "old", string(old))
+	assert.NoDirExists(t, Dir(stateDir, "demo"))
+}
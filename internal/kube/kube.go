@@ -0,0 +1,501 @@
+package kube
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"path"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/danecwalker/otari/internal/definition"
+	"github.com/danecwalker/otari/internal/utils"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// appLabel selects the pods of a workload.
+	appLabel = "app"
+
+	// annotations podman kube play maps to options that have no Kubernetes
+	// equivalent
+	initAnnotation   = "io.podman.annotations.init/"
+	userNSAnnotation = "io.podman.annotations.userns"
+
+	// defaultVolumeSize is requested by generated claims. Podman ignores it,
+	// a cluster needs some size to bind the claim.
+	defaultVolumeSize = "1Gi"
+)
+
+// KubeGenerator renders stack resources as Kubernetes manifests that podman
+// kube play and a cluster can both run.
+//
+// Containers become Deployments, or Pods if they should not always be
+// restarted, and stack pods become Pods with all their containers. Volumes
+// become PersistentVolumeClaims and container environments ConfigMaps.
+// Networks have no manifest, they are passed to podman by the .kube quadlet.
+type KubeGenerator struct{}
+
+// Generator returns a generate.Generator producing Kubernetes manifests.
+func Generator() *KubeGenerator {
+	return &KubeGenerator{}
+}
+
+// GenerateContainer implements generate.Generator. Containers of a stack
+// pod are rendered by GeneratePod and produce no manifest of their own.
+func (g *KubeGenerator) GenerateContainer(stack *definition.Stack, containerName string) ([]byte, error) {
+	c, exists := stack.Containers[containerName]
+	if !exists {
+		return nil, fmt.Errorf("container '%s' not found in stack", containerName)
+	}
+	if c.Pod != "" {
+		return nil, nil
+	}
+
+	workload := stack.ResourceName(containerName)
+	spec, err := g.podSpec(stack, nil, []*definition.Container{c})
+	if err != nil {
+		return nil, err
+	}
+
+	var docs []any
+	if cm := environmentConfigMap(stack, c); cm != nil {
+		docs = append(docs, cm)
+	}
+	docs = append(docs, workloadObject(stack, workload, restartPolicy(c), spec))
+	return marshalDocuments(docs...)
+}
+
+// GeneratePod implements generate.Generator.
+func (g *KubeGenerator) GeneratePod(stack *definition.Stack, podName string) ([]byte, error) {
+	p, exists := stack.Pods[podName]
+	if !exists {
+		return nil, fmt.Errorf("pod '%s' not found in stack", podName)
+	}
+
+	members := PodContainers(stack, podName)
+	if len(members) == 0 {
+		// podman would not create an empty pod from a manifest
+		return nil, nil
+	}
+	spec, err := g.podSpec(stack, p, members)
+	if err != nil {
+		return nil, err
+	}
+
+	var docs []any
+	for _, c := range members {
+		if cm := environmentConfigMap(stack, c); cm != nil {
+			docs = append(docs, cm)
+		}
+	}
+
+	docs = append(docs, workloadObject(stack, stack.ResourceName(podName), podRestartPolicy(members), spec))
+	return marshalDocuments(docs...)
+}
+
+// GenerateVolume implements generate.Generator.
+func (g *KubeGenerator) GenerateVolume(stack *definition.Stack, volumeName string) ([]byte, error) {
+	if _, exists := stack.Volumes[volumeName]; !exists {
+		return nil, fmt.Errorf("volume '%s' not found in stack", volumeName)
+	}
+	return marshalDocuments(&persistentVolumeClaim{
+		typeMeta: typeMeta{APIVersion: "v1", Kind: "PersistentVolumeClaim"},
+		Metadata: stackMeta(stack, stack.ResourceName(volumeName)),
+		Spec: persistentVolumeClaimSpec{
+			AccessModes: []string{"ReadWriteOnce"},
+			Resources: resourceRequirements{
+				Requests: map[string]string{"storage": defaultVolumeSize},
+			},
+		},
+	})
+}
+
+// GenerateNetwork implements generate.Generator. Kubernetes has no network
+// object, the .kube quadlet attaches the pods to the stack networks.
+func (g *KubeGenerator) GenerateNetwork(stack *definition.Stack, networkName string) ([]byte, error) {
+	if _, exists := stack.Networks[networkName]; !exists {
+		return nil, fmt.Errorf("network '%s' not found in stack", networkName)
+	}
+	return nil, nil
+}
+
+// SecretData returns the value podman stores for a stack secret in kube
+// mode. Podman resolves secretKeyRef and secret volumes by reading the
+// podman secret as a Kubernetes Secret, keyed by the stack secret name.
+func SecretData(stack *definition.Stack, secretName string, value []byte) ([]byte, error) {
+	return marshalDocuments(&secret{
+		typeMeta: typeMeta{APIVersion: "v1", Kind: "Secret"},
+		Metadata: stackMeta(stack, stack.ResourceName(secretName)),
+		Data: map[string]string{
+			secretName: base64.StdEncoding.EncodeToString(value),
+		},
+	})
+}
+
+// PodContainers returns the containers of a stack pod sorted by name.
+func PodContainers(stack *definition.Stack, podName string) []*definition.Container {
+	var members []*definition.Container
+	for name, c := range stack.Containers {
+		if c.Pod == podName {
+			c.ContainerName = name
+			members = append(members, c)
+		}
+	}
+	sort.Slice(members, func(i, j int) bool {
+		return members[i].ContainerName < members[j].ContainerName
+	})
+	return members
+}
+
+// PodName returns the name of the podman pod running a container.
+func PodName(stack *definition.Stack, containerName string) string {
+	c := stack.Containers[containerName]
+	if c == nil {
+		return stack.ResourceName(containerName)
+	}
+
+	name, policy := stack.ResourceName(containerName), restartPolicy(c)
+	if c.Pod != "" {
+		name, policy = stack.ResourceName(c.Pod), podRestartPolicy(PodContainers(stack, c.Pod))
+	}
+	if policy == "Always" {
+		// podman names the pod of a deployment after it
+		return name + "-pod"
+	}
+	return name
+}
+
+// ContainerName returns the name podman kube play gives a container.
+func ContainerName(stack *definition.Stack, containerName string) string {
+	return PodName(stack, containerName) + "-" + containerName
+}
+
+func (g *KubeGenerator) podSpec(stack *definition.Stack, p *definition.Pod, members []*definition.Container) (podSpec, error) {
+	var spec podSpec
+	volumes := make(map[string]volume)
+
+	networks := members[0].Networks
+	if p != nil {
+		networks = p.Networks
+	}
+	for _, network := range networks {
+		if n, ok := stack.Networks[network]; ok && n.Driver == definition.NetworkDriverHost {
+			spec.HostNetwork = true
+		}
+	}
+
+	for i, c := range members {
+		out := container{
+			Name:  c.ContainerName,
+			Image: imageName(stack, c),
+		}
+		if c.Entrypoint != "" {
+			out.Command = strings.Fields(string(c.Entrypoint))
+		}
+		if len(c.Environment) > 0 {
+			out.EnvFrom = append(out.EnvFrom, envFromSource{
+				ConfigMapRef: &localObjectReference{Name: environmentName(stack, c)},
+			})
+		}
+
+		ports := c.Ports
+		if p != nil && i == 0 {
+			// pod ports are published once for the whole pod
+			ports = append(append([]definition.PortMap(nil), p.Ports...), ports...)
+		}
+		out.Ports = containerPorts(ports)
+
+		mounts := c.Volumes
+		if p != nil {
+			mounts = append(append([]definition.VolumeMap(nil), p.Volumes...), mounts...)
+		}
+		for _, vm := range mounts {
+			v, err := volumeSource(stack, vm)
+			if err != nil {
+				return spec, err
+			}
+			volumes[v.Name] = v
+			out.VolumeMounts = append(out.VolumeMounts, volumeMount{
+				Name:      v.Name,
+				MountPath: vm.Destination,
+				ReadOnly:  slices.Contains(vm.Options, "ro"),
+			})
+		}
+
+		for _, ref := range c.Secrets {
+			secretName := stack.ResourceName(ref.Source)
+			if ref.Type == definition.SecretTypeEnv {
+				target := ref.Target
+				if target == "" {
+					target = ref.Source
+				}
+				out.Env = append(out.Env, envVar{
+					Name: target,
+					ValueFrom: &envVarSource{
+						SecretKeyRef: &keySelector{Name: secretName, Key: ref.Source},
+					},
+				})
+				continue
+			}
+
+			v := volume{
+				Name:   volumeName("secret-" + ref.Source),
+				Secret: &secretSource{SecretName: secretName},
+			}
+			if ref.Mode != "" {
+				mode, err := strconv.ParseInt(ref.Mode, 8, 32)
+				if err != nil {
+					return spec, fmt.Errorf("invalid mode '%s' for secret '%s': %w", ref.Mode, ref.Source, err)
+				}
+				m := int(mode)
+				v.Secret.DefaultMode = &m
+			}
+			volumes[v.Name] = v
+			out.VolumeMounts = append(out.VolumeMounts, volumeMount{
+				Name:      v.Name,
+				MountPath: secretTarget(ref),
+				SubPath:   ref.Source,
+				ReadOnly:  true,
+			})
+		}
+
+		if hc := c.Healthcheck; hc != nil && !hc.IsDisabled() {
+			out.LivenessProbe = &probe{
+				Exec:                execAction{Command: []string{"/bin/sh", "-c", hc.Command()}},
+				InitialDelaySeconds: int(hc.StartPeriod.Seconds()),
+				PeriodSeconds:       int(hc.Interval.Seconds()),
+				TimeoutSeconds:      int(hc.Timeout.Seconds()),
+				FailureThreshold:    hc.Retries,
+			}
+		}
+
+		spec.Containers = append(spec.Containers, out)
+	}
+
+	names := make([]string, 0, len(volumes))
+	for name := range volumes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		spec.Volumes = append(spec.Volumes, volumes[name])
+	}
+
+	return spec, nil
+}
+
+// workloadObject wraps a pod spec into a Deployment if the pod is always
+// restarted, a Deployment cannot use any other restart policy.
+func workloadObject(stack *definition.Stack, name, policy string, spec podSpec) any {
+	meta := stackMeta(stack, name)
+	meta.Labels[appLabel] = name
+	meta.Annotations = podAnnotations(stack, name, spec)
+
+	if policy == "Always" {
+		template := objectMeta{
+			Name:        name,
+			Labels:      meta.Labels,
+			Annotations: meta.Annotations,
+		}
+		return &deployment{
+			typeMeta: typeMeta{APIVersion: "apps/v1", Kind: "Deployment"},
+			Metadata: objectMeta{Name: name, Labels: meta.Labels},
+			Spec: deploymentSpec{
+				Replicas: 1,
+				Selector: labelSelector{MatchLabels: map[string]string{appLabel: name}},
+				Template: podTemplateSpec{Metadata: template, Spec: spec},
+			},
+		}
+	}
+
+	spec.RestartPolicy = policy
+	return &pod{
+		typeMeta: typeMeta{APIVersion: "v1", Kind: "Pod"},
+		Metadata: meta,
+		Spec:     spec,
+	}
+}
+
+// podAnnotations returns the podman annotations of the pod named name.
+func podAnnotations(stack *definition.Stack, name string, spec podSpec) map[string]string {
+	annotations := make(map[string]string)
+	for _, out := range spec.Containers {
+		c := stack.Containers[out.Name]
+		if c == nil {
+			continue
+		}
+		if c.Init {
+			annotations[initAnnotation+out.Name] = "true"
+		}
+		for key, value := range c.Labels {
+			annotations[key] = value
+		}
+	}
+	for podName, p := range stack.Pods {
+		if stack.ResourceName(podName) == name && p.UserNS != "" {
+			annotations[userNSAnnotation] = p.UserNS
+		}
+	}
+	if len(annotations) == 0 {
+		return nil
+	}
+	return annotations
+}
+
+func environmentConfigMap(stack *definition.Stack, c *definition.Container) *configMap {
+	if len(c.Environment) == 0 {
+		return nil
+	}
+	data := make(map[string]string, len(c.Environment))
+	for key, value := range c.Environment {
+		data[key] = value
+	}
+	return &configMap{
+		typeMeta: typeMeta{APIVersion: "v1", Kind: "ConfigMap"},
+		Metadata: stackMeta(stack, environmentName(stack, c)),
+		Data:     data,
+	}
+}
+
+func environmentName(stack *definition.Stack, c *definition.Container) string {
+	return stack.ResourceName(c.ContainerName) + "-env"
+}
+
+func stackMeta(stack *definition.Stack, name string) objectMeta {
+	return objectMeta{
+		Name:   name,
+		Labels: map[string]string{definition.StackLabel: stack.StackName},
+	}
+}
+
+// restartPolicy maps the restart policy of a container to a pod restart
+// policy.
+func restartPolicy(c *definition.Container) string {
+	switch {
+	case c.RestartPolicy.IsNo():
+		return "Never"
+	case c.RestartPolicy.IsOnFailure():
+		return "OnFailure"
+	}
+	return "Always"
+}
+
+// podRestartPolicy returns the restart policy of a stack pod. The pod is
+// restarted as a whole, so the most permissive policy of its containers
+// wins.
+func podRestartPolicy(members []*definition.Container) string {
+	policy := "Never"
+	for _, c := range members {
+		switch restartPolicy(c) {
+		case "Always":
+			return "Always"
+		case "OnFailure":
+			policy = "OnFailure"
+		}
+	}
+	return policy
+}
+
+// imageName returns the image of a container, build containers use the
+// image otari tags their build with.
+func imageName(stack *definition.Stack, c *definition.Container) string {
+	if c.Build != nil {
+		return fmt.Sprintf("%s_%s", stack.StackName, c.ContainerName)
+	}
+	return c.ImageRef()
+}
+
+// containerPorts expands port ranges, Kubernetes only maps single ports.
+func containerPorts(ports []definition.PortMap) []containerPort {
+	var out []containerPort
+	for _, port := range ports {
+		protocol := strings.ToUpper(port.Protocol)
+		if protocol == "TCP" {
+			protocol = ""
+		}
+		hostIP := port.IP
+		if hostIP == "0.0.0.0" {
+			hostIP = ""
+		}
+		for offset := 0; offset <= port.ContainerPort.End-port.ContainerPort.Start; offset++ {
+			out = append(out, containerPort{
+				ContainerPort: port.ContainerPort.Start + offset,
+				HostPort:      port.HostPort.Start + offset,
+				HostIP:        hostIP,
+				Protocol:      protocol,
+			})
+		}
+	}
+	return out
+}
+
+// volumeSource returns the pod volume backing a volume mapping. Named
+// volumes are claimed by name, which podman resolves to the podman volume.
+func volumeSource(stack *definition.Stack, vm definition.VolumeMap) (volume, error) {
+	if vm.Type == definition.VolumeMountTypeBind {
+		absPath, err := utils.ResolvePath(stack.Dir, vm.Source)
+		if err != nil {
+			return volume{}, fmt.Errorf("failed to get absolute path for bind mount '%s': %v", vm.Source, err)
+		}
+		return volume{
+			Name:     volumeName("host" + absPath),
+			HostPath: &hostPathSource{Path: absPath},
+		}, nil
+	}
+	claim := stack.ResourceName(vm.Source)
+	return volume{
+		Name:                  volumeName(claim),
+		PersistentVolumeClaim: &persistentVolumeClaimRef{ClaimName: claim},
+	}, nil
+}
+
+// volumeName turns s into a valid pod volume name, a DNS label.
+func volumeName(s string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(s) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			b.WriteRune(r)
+			dash = false
+		} else if !dash && b.Len() > 0 {
+			b.WriteByte('-')
+			dash = true
+		}
+	}
+	name := strings.TrimSuffix(b.String(), "-")
+	if len(name) > 63 {
+		name = strings.TrimSuffix(name[:63], "-")
+	}
+	return name
+}
+
+// secretTarget returns the path a secret is mounted at, relative targets
+// are placed in /run/secrets like podman does.
+func secretTarget(ref definition.ContainerSecret) string {
+	switch {
+	case ref.Target == "":
+		return "/run/secrets/" + ref.Source
+	case path.IsAbs(ref.Target):
+		return ref.Target
+	}
+	return "/run/secrets/" + ref.Target
+}
+
+// marshalDocuments encodes objects as a multi document YAML stream.
+func marshalDocuments(objects ...any) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	for _, obj := range objects {
+		if err := enc.Encode(obj); err != nil {
+			return nil, err
+		}
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
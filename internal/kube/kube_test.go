@@ -0,0 +1,204 @@
+package kube
+
+// Tests the Kubernetes manifests and .kube quadlet generated for a stack.
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/danecwalker/otari/internal/definition"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+const stackFile = `
+mode: kube
+containers:
+  web:
+    image: nginx:1.27
+    ports: ["8080:80", "127.0.0.1:9000-9001:9000-9001/udp"]
+    environment:
+      MODE: prod
+    volumes:
+      - data:/data:ro
+    networks: [backend]
+    secrets:
+      - token
+      - source: token
+        type: env
+        target: API_TOKEN
+    healthcheck:
+      test: ["CMD", "curl", "-f", "http://localhost"]
+      interval: 10s
+      retries: 3
+  job:
+    image: busybox
+    restart: "no"
+  api:
+    image: api:1
+    pod: app
+    restart: on-failure
+    init: true
+  cache:
+    build:
+      context: ./cache
+    pod: app
+    restart: "no"
+volumes:
+  data:
+networks:
+  backend:
+  host:
+    driver: host
+pods:
+  app:
+    ports: ["3000:3000"]
+    networks: [host]
+    userns: keep-id
+secrets:
+  token:
+    environment: TOKEN
+`
+
+func parseStack(t *testing.T) *definition.Stack {
+	t.Helper()
+	stack, err := definition.Parse([]byte(stackFile))
+	require.NoError(t, err)
+	stack.StackName = "demo"
+	return stack
+}
+
+// documents splits a multi document manifest.
+func documents(t *testing.T, data []byte) []*yaml.Node {
+	t.Helper()
+	var docs []*yaml.Node
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	for {
+		var doc yaml.Node
+		err := dec.Decode(&doc)
+		if errors.Is(err, io.EOF) {
+			return docs
+		}
+		require.NoError(t, err)
+		docs = append(docs, &doc)
+	}
+}
+
+func TestGenerateContainer(t *testing.T) {
+	stack := parseStack(t)
+
+	out, err := Generator().GenerateContainer(stack, "web")
+	require.NoError(t, err)
+	docs := documents(t, out)
+	require.Len(t, docs, 2)
+
+	var cm configMap
+	require.NoError(t, docs[0].Decode(&cm))
+	assert.Equal(t, "ConfigMap", cm.Kind)
+	assert.Equal(t, map[string]string{"MODE": "prod"}, cm.Data)
+
+	var d deployment
+	require.NoError(t, docs[1].Decode(&d))
+	assert.Equal(t, "Deployment", d.Kind)
+	assert.Equal(t, "demo-web", d.Metadata.Name)
+	assert.Equal(t, "demo", d.Metadata.Labels[definition.StackLabel])
+	assert.Equal(t, map[string]string{appLabel: "demo-web"}, d.Spec.Selector.MatchLabels)
+
+	spec := d.Spec.Template.Spec
+	require.Len(t, spec.Containers, 1)
+	c := spec.Containers[0]
+	assert.Equal(t, "nginx:1.27", c.Image)
+	assert.Equal(t, "demo-web-env", c.EnvFrom[0].ConfigMapRef.Name)
+	assert.Equal(t, []containerPort{
+		{ContainerPort: 80, HostPort: 8080},
+		{ContainerPort: 9000, HostPort: 9000, HostIP: "127.0.0.1", Protocol: "UDP"},
+		{ContainerPort: 9001, HostPort: 9001, HostIP: "127.0.0.1", Protocol: "UDP"},
+	}, c.Ports)
+	assert.Equal(t, []envVar{{
+		Name:      "API_TOKEN",
+		ValueFrom: &envVarSource{SecretKeyRef: &keySelector{Name: "demo-token", Key: "token"}},
+	}}, c.Env)
+	assert.Equal(t, []volumeMount{
+		{Name: "demo-data", MountPath: "/data", ReadOnly: true},
+		{Name: "secret-token", MountPath: "/run/secrets/token", SubPath: "token", ReadOnly: true},
+	}, c.VolumeMounts)
+	require.NotNil(t, c.LivenessProbe)
+	assert.Equal(t, []string{"/bin/sh", "-c", "curl -f http://localhost"}, c.LivenessProbe.Exec.Command)
+	assert.Equal(t, 10, c.LivenessProbe.PeriodSeconds)
+	assert.Equal(t, 3, c.LivenessProbe.FailureThreshold)
+
+	require.Len(t, spec.Volumes, 2)
+	assert.Equal(t, "demo-data", spec.Volumes[0].PersistentVolumeClaim.ClaimName)
+	assert.Equal(t, "demo-token", spec.Volumes[1].Secret.SecretName)
+
+	// containers that are not always restarted run as plain pods
+	out, err = Generator().GenerateContainer(stack, "job")
+	require.NoError(t, err)
+	var p pod
+	require.NoError(t, yaml.Unmarshal(out, &p))
+	assert.Equal(t, "Pod", p.Kind)
+	assert.Equal(t, "Never", p.Spec.RestartPolicy)
+
+	// pod members are rendered with their pod
+	out, err = Generator().GenerateContainer(stack, "api")
+	require.NoError(t, err)
+	assert.Empty(t, out)
+}
+
+func TestGeneratePod(t *testing.T) {
+	stack := parseStack(t)
+
+	out, err := Generator().GeneratePod(stack, "app")
+	require.NoError(t, err)
+
+	var p pod
+	require.NoError(t, yaml.Unmarshal(out, &p))
+	assert.Equal(t, "Pod", p.Kind)
+	assert.Equal(t, "demo-app", p.Metadata.Name)
+	assert.Equal(t, "OnFailure", p.Spec.RestartPolicy)
+	assert.True(t, p.Spec.HostNetwork)
+	assert.Equal(t, "true", p.Metadata.Annotations[initAnnotation+"api"])
+	assert.Equal(t, "keep-id", p.Metadata.Annotations[userNSAnnotation])
+
+	require.Len(t, p.Spec.Containers, 2)
+	assert.Equal(t, "api", p.Spec.Containers[0].Name)
+	assert.Equal(t, []containerPort{{ContainerPort: 3000, HostPort: 3000}}, p.Spec.Containers[0].Ports)
+	assert.Equal(t, "cache", p.Spec.Containers[1].Name)
+	assert.Equal(t, "demo_cache", p.Spec.Containers[1].Image)
+}
+
+func TestNames(t *testing.T) {
+	stack := parseStack(t)
+
+	assert.Equal(t, "demo-web-pod-web", ContainerName(stack, "web"))
+	assert.Equal(t, "demo-job-job", ContainerName(stack, "job"))
+	assert.Equal(t, "demo-app-api", ContainerName(stack, "api"))
+	assert.Equal(t, []string{"demo.kube", "demo-kube.yaml"}, FileNames(stack))
+	assert.Equal(t, "demo.service", ServiceName(stack))
+}
+
+func TestQuadlet(t *testing.T) {
+	stack := parseStack(t)
+
+	out, err := Quadlet(stack)
+	require.NoError(t, err)
+	assert.Contains(t, string(out), "[Kube]\nYaml=demo-kube.yaml\nNetwork=demo-backend.network\n")
+	// podman provides the host network
+	assert.NotContains(t, string(out), "demo-host")
+}
+
+func TestSecretData(t *testing.T) {
+	stack := parseStack(t)
+
+	out, err := SecretData(stack, "token", []byte("s3cret"))
+	require.NoError(t, err)
+
+	var s secret
+	require.NoError(t, yaml.Unmarshal(out, &s))
+	assert.Equal(t, "Secret", s.Kind)
+	assert.Equal(t, "demo-token", s.Metadata.Name)
+	assert.Equal(t, map[string]string{"token": "czNjcmV0"}, s.Data)
+}
@@ -0,0 +1,119 @@
+package kube
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+
+	"github.com/danecwalker/otari/internal/definition"
+	"github.com/danecwalker/otari/internal/utils"
+)
+
+// QuadletFileName returns the file name of the .kube quadlet of a stack.
+func QuadletFileName(stack *definition.Stack) string {
+	return stack.StackName + ".kube"
+}
+
+// ManifestFileName returns the file name of the manifest the .kube quadlet
+// of a stack plays.
+func ManifestFileName(stack *definition.Stack) string {
+	return stack.StackName + "-kube.yaml"
+}
+
+// ServiceName returns the systemd service quadlet generates for the .kube
+// quadlet of a stack.
+func ServiceName(stack *definition.Stack) string {
+	return stack.StackName + ".service"
+}
+
+// FileNames returns the files written for a stack in kube mode.
+func FileNames(stack *definition.Stack) []string {
+	return []string{QuadletFileName(stack), ManifestFileName(stack)}
+}
+
+// Quadlet renders the .kube quadlet running the manifest of a stack. The
+// pods are attached to every network used by the stack, quadlet adds the
+// dependencies on their units.
+func Quadlet(stack *definition.Stack) ([]byte, error) {
+	var buf bytes.Buffer
+	err := utils.WriteSection(&buf, "Unit", [][2]string{
+		{"Description", fmt.Sprintf("%s stack", stack.StackName)},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := utils.WriteEmptyLine(&buf); err != nil {
+		return nil, err
+	}
+
+	kubeProperties := [][2]string{
+		{"Yaml", ManifestFileName(stack)},
+	}
+	for _, network := range networks(stack) {
+		kubeProperties = append(kubeProperties, [2]string{
+			"Network", stack.ResourceName(network) + ".network",
+		})
+	}
+
+	err = utils.WriteSection(&buf, "Kube", kubeProperties)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := utils.WriteEmptyLine(&buf); err != nil {
+		return nil, err
+	}
+
+	serviceProperties := [][2]string{
+		{"TimeoutStartSec", "900"},
+	}
+
+	err = utils.WriteSection(&buf, "Service", serviceProperties)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := utils.WriteEmptyLine(&buf); err != nil {
+		return nil, err
+	}
+
+	installProperties := [][2]string{
+		{"WantedBy", "multi-user.target default.target"},
+	}
+
+	err = utils.WriteSection(&buf, "Install", installProperties)
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// networks returns the sorted names of the non-host networks used by the
+// containers and pods of a stack.
+func networks(stack *definition.Stack) []string {
+	used := make(map[string]struct{})
+	add := func(names []string) {
+		for _, name := range names {
+			if n, ok := stack.Networks[name]; ok && n.Driver != definition.NetworkDriverHost {
+				used[name] = struct{}{}
+			}
+		}
+	}
+	for _, c := range stack.Containers {
+		if c.Pod == "" {
+			add(c.Networks)
+		}
+	}
+	for _, p := range stack.Pods {
+		add(p.Networks)
+	}
+
+	out := make([]string, 0, len(used))
+	for name := range used {
+		out = append(out, name)
+	}
+	sort.Strings(out)
+	return out
+}
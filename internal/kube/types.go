@@ -0,0 +1,156 @@
+package kube
+
+// The subset of the Kubernetes API that otari generates. Only fields podman
+// kube play understands are included.
+
+type objectMeta struct {
+	Name        string            `yaml:"name"`
+	Labels      map[string]string `yaml:"labels,omitempty"`
+	Annotations map[string]string `yaml:"annotations,omitempty"`
+}
+
+type typeMeta struct {
+	APIVersion string `yaml:"apiVersion"`
+	Kind       string `yaml:"kind"`
+}
+
+type deployment struct {
+	typeMeta `yaml:",inline"`
+	Metadata objectMeta     `yaml:"metadata"`
+	Spec     deploymentSpec `yaml:"spec"`
+}
+
+type deploymentSpec struct {
+	Replicas int             `yaml:"replicas"`
+	Selector labelSelector   `yaml:"selector"`
+	Template podTemplateSpec `yaml:"template"`
+}
+
+type labelSelector struct {
+	MatchLabels map[string]string `yaml:"matchLabels"`
+}
+
+type podTemplateSpec struct {
+	Metadata objectMeta `yaml:"metadata"`
+	Spec     podSpec    `yaml:"spec"`
+}
+
+type pod struct {
+	typeMeta `yaml:",inline"`
+	Metadata objectMeta `yaml:"metadata"`
+	Spec     podSpec    `yaml:"spec"`
+}
+
+type podSpec struct {
+	HostNetwork   bool        `yaml:"hostNetwork,omitempty"`
+	RestartPolicy string      `yaml:"restartPolicy,omitempty"`
+	Containers    []container `yaml:"containers"`
+	Volumes       []volume    `yaml:"volumes,omitempty"`
+}
+
+type container struct {
+	Name          string          `yaml:"name"`
+	Image         string          `yaml:"image"`
+	Command       []string        `yaml:"command,omitempty"`
+	EnvFrom       []envFromSource `yaml:"envFrom,omitempty"`
+	Env           []envVar        `yaml:"env,omitempty"`
+	Ports         []containerPort `yaml:"ports,omitempty"`
+	VolumeMounts  []volumeMount   `yaml:"volumeMounts,omitempty"`
+	LivenessProbe *probe          `yaml:"livenessProbe,omitempty"`
+}
+
+type envFromSource struct {
+	ConfigMapRef *localObjectReference `yaml:"configMapRef,omitempty"`
+}
+
+type localObjectReference struct {
+	Name string `yaml:"name"`
+}
+
+type envVar struct {
+	Name      string        `yaml:"name"`
+	Value     string        `yaml:"value,omitempty"`
+	ValueFrom *envVarSource `yaml:"valueFrom,omitempty"`
+}
+
+type envVarSource struct {
+	SecretKeyRef *keySelector `yaml:"secretKeyRef,omitempty"`
+}
+
+type keySelector struct {
+	Name string `yaml:"name"`
+	Key  string `yaml:"key"`
+}
+
+type containerPort struct {
+	ContainerPort int    `yaml:"containerPort"`
+	HostPort      int    `yaml:"hostPort,omitempty"`
+	HostIP        string `yaml:"hostIP,omitempty"`
+	Protocol      string `yaml:"protocol,omitempty"`
+}
+
+type volumeMount struct {
+	Name      string `yaml:"name"`
+	MountPath string `yaml:"mountPath"`
+	SubPath   string `yaml:"subPath,omitempty"`
+	ReadOnly  bool   `yaml:"readOnly,omitempty"`
+}
+
+type volume struct {
+	Name                  string                    `yaml:"name"`
+	PersistentVolumeClaim *persistentVolumeClaimRef `yaml:"persistentVolumeClaim,omitempty"`
+	HostPath              *hostPathSource           `yaml:"hostPath,omitempty"`
+	Secret                *secretSource             `yaml:"secret,omitempty"`
+}
+
+type persistentVolumeClaimRef struct {
+	ClaimName string `yaml:"claimName"`
+}
+
+type hostPathSource struct {
+	Path string `yaml:"path"`
+}
+
+type secretSource struct {
+	SecretName  string `yaml:"secretName"`
+	DefaultMode *int   `yaml:"defaultMode,omitempty"`
+}
+
+type probe struct {
+	Exec                execAction `yaml:"exec"`
+	InitialDelaySeconds int        `yaml:"initialDelaySeconds,omitempty"`
+	PeriodSeconds       int        `yaml:"periodSeconds,omitempty"`
+	TimeoutSeconds      int        `yaml:"timeoutSeconds,omitempty"`
+	FailureThreshold    int        `yaml:"failureThreshold,omitempty"`
+}
+
+type execAction struct {
+	Command []string `yaml:"command"`
+}
+
+type configMap struct {
+	typeMeta `yaml:",inline"`
+	Metadata objectMeta        `yaml:"metadata"`
+	Data     map[string]string `yaml:"data"`
+}
+
+type persistentVolumeClaim struct {
+	typeMeta `yaml:",inline"`
+	Metadata objectMeta                `yaml:"metadata"`
+	Spec     persistentVolumeClaimSpec `yaml:"spec"`
+}
+
+type persistentVolumeClaimSpec struct {
+	AccessModes []string             `yaml:"accessModes"`
+	Resources   resourceRequirements `yaml:"resources"`
+}
+
+type resourceRequirements struct {
+	Requests map[string]string `yaml:"requests"`
+}
+
+type secret struct {
+	typeMeta `yaml:",inline"`
+	Metadata objectMeta        `yaml:"metadata"`
+	Data     map[string]string `yaml:"data"`
+}
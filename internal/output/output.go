@@ -0,0 +1,159 @@
+// Package output selects how otari reports what it does: animated for
+// people at a terminal, plain lines for logs, or JSON events for scripts.
+package output
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/danecwalker/otari/internal/utils"
+	"github.com/fatih/color"
+)
+
+// Mode is how output is written.
+type Mode string
+
+const (
+	// Human animates spinners and colours the output.
+	Human Mode = "human"
+	// Plain prints lines without colours, spinners or escape sequences.
+	Plain Mode = "plain"
+	// JSON prints an event per line. Text meant for people, like tables
+	// and error details, is written to stderr instead.
+	JSON Mode = "json"
+)
+
+var (
+	mu     sync.Mutex
+	mode   = Human
+	quiet  bool
+	events io.Writer = os.Stdout
+)
+
+// ParseMode parses the value of the --output flag. An empty value selects
+// Human if stdout is a terminal and Plain if it is not.
+func ParseMode(s string, terminal bool) (Mode, error) {
+	switch Mode(s) {
+	case "":
+		if terminal {
+			return Human, nil
+		}
+		return Plain, nil
+	case Human, Plain, JSON:
+		return Mode(s), nil
+	}
+	return "", fmt.Errorf("unknown output mode '%s', expected human, plain or json", s)
+}
+
+// Set selects the output mode. With quiet only failures and the data a
+// command was asked for are printed.
+func Set(m Mode, q bool) {
+	mu.Lock()
+	defer mu.Unlock()
+	mode, quiet = m, q
+	color.NoColor = m != Human
+}
+
+// Current returns the output mode.
+func Current() Mode {
+	mu.Lock()
+	defer mu.Unlock()
+	return mode
+}
+
+// Quiet reports whether only failures are printed.
+func Quiet() bool {
+	mu.Lock()
+	defer mu.Unlock()
+	return quiet
+}
+
+// Stdout returns where the data a command was asked for is written, stdout
+// even in JSON mode.
+func Stdout() io.Writer {
+	mu.Lock()
+	defer mu.Unlock()
+	return events
+}
+
+// Text returns where text meant for people is written, like messages,
+// tables and error details. In JSON mode stdout is reserved for events and
+// text is written to stderr.
+func Text() io.Writer {
+	if Current() == JSON {
+		return os.Stderr
+	}
+	return os.Stdout
+}
+
+// Event is a line of JSON output. Steps of an operation report the action
+// they perform on a resource, messages of a command only a status.
+type Event struct {
+	Time     time.Time `json:"time"`
+	Action   string    `json:"action,omitempty"`
+	Kind     string    `json:"kind,omitempty"`
+	Resource string    `json:"resource,omitempty"`
+	// Status is started, progress, succeeded, skipped, failed or info.
+	Status  string `json:"status"`
+	Message string `json:"message,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// Status values of events that are not steps.
+const (
+	StatusSucceeded = "succeeded"
+	StatusFailed    = "failed"
+	StatusInfo      = "info"
+)
+
+// Emit writes e as a line of JSON.
+func Emit(e Event) {
+	mu.Lock()
+	defer mu.Unlock()
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+	json.NewEncoder(events).Encode(e)
+}
+
+// Success reports that a command succeeded.
+func Success(msg string) {
+	if Quiet() {
+		return
+	}
+	if Current() == JSON {
+		Emit(Event{Status: StatusSucceeded, Message: msg})
+		return
+	}
+	fmt.Fprintln(Text(), utils.Success(msg))
+}
+
+// Info prints a message of a command.
+func Info(msg string) {
+	if Quiet() {
+		return
+	}
+	if Current() == JSON {
+		Emit(Event{Status: StatusInfo, Message: msg})
+		return
+	}
+	fmt.Fprintln(Text(), utils.Info(msg))
+}
+
+// Error reports why a command failed, followed by indented details such as
+// the error that caused it. It is printed even when quiet.
+func Error(msg string, details ...string) {
+	if Current() == JSON {
+		Emit(Event{Status: StatusFailed, Message: msg, Error: strings.Join(details, "\n")})
+		return
+	}
+	fmt.Fprintln(Text(), utils.Error(msg))
+	for _, detail := range details {
+		color.New(color.FgWhite).Fprintln(Text(), "    "+detail)
+	}
+}
@@ -0,0 +1,65 @@
+package output
+
+// Tests selecting the output mode and the shape of JSON events.
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseMode(t *testing.T) {
+	mode, err := ParseMode("", true)
+	require.NoError(t, err)
+	assert.Equal(t, Human, mode)
+	mode, err = ParseMode("", false)
+	require.NoError(t, err)
+	assert.Equal(t, Plain, mode)
+	mode, err = ParseMode("json", true)
+	require.NoError(t, err)
+	assert.Equal(t, JSON, mode)
+	_, err = ParseMode("xml", true)
+	assert.Error(t, err)
+}
+
+func TestEmit(t *testing.T) {
+	var out bytes.Buffer
+	events, mode = &out, JSON
+	t.Cleanup(func() { events, mode = os.Stdout, Human })
+
+	Emit(Event{Action: "pull", Kind: "image", Resource: "nginx:1.27", Status: "started"})
+	Error("Failed to pull images", "boom")
+	Success("done")
+
+	var lines []map[string]any
+	decoder := json.NewDecoder(&out)
+	for decoder.More() {
+		var line map[string]any
+		require.NoError(t, decoder.Decode(&line))
+		assert.NotEmpty(t, line["time"])
+		delete(line, "time")
+		lines = append(lines, line)
+	}
+	assert.Equal(t, []map[string]any{
+		{"action": "pull", "kind": "image", "resource": "nginx:1.27", "status": "started"},
+		{"status": "failed", "message": "Failed to pull images", "error": "boom"},
+		{"status": "succeeded", "message": "done"},
+	}, lines)
+}
+
+func TestTextInJSONMode(t *testing.T) {
+	stdout := os.Stdout
+	t.Cleanup(func() { Set(Human, false) })
+
+	Set(JSON, false)
+	assert.Same(t, stdout, os.Stdout)
+	assert.Equal(t, os.Stderr, Text())
+	assert.Equal(t, os.Stdout, Stdout())
+
+	Set(Plain, false)
+	assert.Equal(t, os.Stdout, Text())
+}
@@ -0,0 +1,283 @@
+package plan
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/danecwalker/otari/internal/changes"
+	"github.com/danecwalker/otari/internal/definition"
+	"github.com/danecwalker/otari/internal/diff"
+	"github.com/danecwalker/otari/internal/generate"
+	"github.com/danecwalker/otari/internal/hasher"
+	"github.com/danecwalker/otari/internal/kube"
+)
+
+type Action string
+
+// KindSecret is the kind of podman secrets, which have no quadlet.
+const KindSecret = changes.KindSecret
+
+// Kinds lists every resource kind tracked in the lock file.
+var Kinds = append(append([]string(nil), generate.Kinds...), KindSecret)
+
+const (
+	ActionAdd       Action = "add"
+	ActionModify    Action = "modify"
+	ActionDelete    Action = "delete"
+	ActionUnchanged Action = "unchanged"
+)
+
+// Change describes what applying the stack would do to a single resource.
+type Change struct {
+	Kind     string
+	Name     string
+	Action   Action
+	FileName string
+	// Diff is a unified diff between the quadlet on disk and the one that
+	// would be written. It is empty if the quadlet would not change.
+	Diff string
+}
+
+type Plan struct {
+	StackName string
+	// Deployed is false if no lock file exists for the stack yet.
+	Deployed bool
+	Changes  []*Change
+}
+
+// Count returns the number of resources with the given action.
+func (p *Plan) Count(action Action) int {
+	n := 0
+	for _, c := range p.Changes {
+		if c.Action == action {
+			n++
+		}
+	}
+	return n
+}
+
+// Pending reports whether applying the stack would change anything.
+func (p *Plan) Pending() bool {
+	for _, c := range p.Changes {
+		if c.Action != ActionUnchanged || c.Diff != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// Compute works out what applying the stack would change without touching
+// podman or systemd. Quadlets are rendered into memory and compared to the
+// ones in outputDir.
+func Compute(ctx context.Context, stack *definition.Stack, generator generate.Generator, outputDir string) (*Plan, error) {
+	stackData, err := changes.LoadStackData(stack)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &Plan{
+		StackName: stack.StackName,
+		Deployed:  stackData != nil,
+	}
+
+	// hash before rendering, rendering fills in the image of build containers
+	hashes := make(map[string]map[string]string, len(Kinds))
+	for _, kind := range Kinds {
+		hashes[kind] = make(map[string]string)
+		for _, name := range resourceNames(stack, kind) {
+			h, err := hashResource(stack, kind, name)
+			if err != nil {
+				return nil, err
+			}
+			hashes[kind][name] = h
+		}
+	}
+
+	rendered, err := generate.Render(stack, generator)
+	if err != nil {
+		return nil, err
+	}
+	contents := make(map[string][]byte, len(rendered))
+	for _, q := range rendered {
+		contents[q.FileName] = q.Content
+	}
+
+	// resources of a renamed stack are recreated under their new names, the
+	// deployed ones are known by the names of the old template. The same
+	// goes for stacks that changed their deploy mode.
+	renamed := changes.Renamed(stack, stackData) || changes.ModeChanged(stack, stackData)
+	deployed := stack
+	if renamed {
+		deployed = &definition.Stack{
+			StackName:    stack.StackName,
+			NameTemplate: stackData.NameTemplate,
+			Mode:         stackData.DeployMode(),
+		}
+	}
+
+	for _, kind := range Kinds {
+		existing := stackData.Hashes(kind)
+
+		names := resourceNames(stack, kind)
+		sort.Strings(names)
+		for _, name := range names {
+			change := &Change{
+				Kind:     kind,
+				Name:     name,
+				FileName: stack.ResourceName(name) + "." + kind,
+			}
+
+			oldHash, locked := existing[name]
+			switch {
+			case renamed || !locked:
+				change.Action = ActionAdd
+			case oldHash != hashes[kind][name]:
+				change.Action = ActionModify
+			case kind == generate.KindContainer && changes.BuildChanged(name, stack.Containers[name], stackData):
+				// the image is rebuilt, the quadlet stays the same
+				change.Action = ActionModify
+			default:
+				change.Action = ActionUnchanged
+			}
+
+			newContent, generated := contents[change.FileName]
+			if kind == KindSecret || !generated {
+				// resource without a quadlet, e.g. a host network
+				change.FileName = ""
+			} else if change.Diff, err = fileDiff(outputDir, change.FileName, newContent); err != nil {
+				return nil, err
+			}
+
+			p.Changes = append(p.Changes, change)
+		}
+
+		var removed []string
+		for name := range existing {
+			if _, ok := hashes[kind][name]; !ok || changes.Replaced(kind, stack, stackData) {
+				removed = append(removed, name)
+			}
+		}
+		sort.Strings(removed)
+		for _, name := range removed {
+			change := &Change{
+				Kind:     kind,
+				Name:     name,
+				Action:   ActionDelete,
+				FileName: deployed.ResourceName(name) + "." + kind,
+			}
+
+			if kind == KindSecret || (deployed.IsKube() && kind != generate.KindNetwork) {
+				// resource without a quadlet of its own
+				change.FileName = ""
+			} else if change.Diff, err = fileDiff(outputDir, change.FileName, nil); err != nil {
+				return nil, err
+			}
+
+			p.Changes = append(p.Changes, change)
+		}
+	}
+
+	// the .kube quadlet and manifest of a stack in kube mode, which are
+	// named by their file names
+	for _, q := range rendered {
+		if q.Kind != generate.KindKube {
+			continue
+		}
+		change := &Change{
+			Kind:     q.Kind,
+			Name:     q.FileName,
+			Action:   ActionUnchanged,
+			FileName: q.FileName,
+		}
+		if change.Diff, err = fileDiff(outputDir, q.FileName, q.Content); err != nil {
+			return nil, err
+		}
+		switch {
+		case renamed || stackData == nil:
+			change.Action = ActionAdd
+		case change.Diff != "":
+			change.Action = ActionModify
+		}
+		p.Changes = append(p.Changes, change)
+	}
+	if stackData.DeployMode() == definition.DeployModeKube && !stack.IsKube() {
+		for _, fileName := range kube.FileNames(stack) {
+			change := &Change{
+				Kind:     generate.KindKube,
+				Name:     fileName,
+				Action:   ActionDelete,
+				FileName: fileName,
+			}
+			if change.Diff, err = fileDiff(outputDir, fileName, nil); err != nil {
+				return nil, err
+			}
+			p.Changes = append(p.Changes, change)
+		}
+	}
+
+	return p, nil
+}
+
+func fileDiff(outputDir, fileName string, newContent []byte) (string, error) {
+	path := filepath.Join(outputDir, fileName)
+	oldName, newName := path, path
+
+	oldContent, err := os.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return "", err
+		}
+		oldName = "/dev/null"
+	}
+	if newContent == nil {
+		newName = "/dev/null"
+	}
+
+	return diff.Unified(oldName, newName, string(oldContent), string(newContent), 3), nil
+}
+
+func resourceNames(stack *definition.Stack, kind string) []string {
+	var names []string
+	switch kind {
+	case generate.KindNetwork:
+		for name := range stack.Networks {
+			names = append(names, name)
+		}
+	case generate.KindVolume:
+		for name := range stack.Volumes {
+			names = append(names, name)
+		}
+	case generate.KindPod:
+		for name := range stack.Pods {
+			names = append(names, name)
+		}
+	case generate.KindContainer:
+		for name := range stack.Containers {
+			names = append(names, name)
+		}
+	case KindSecret:
+		for name := range stack.Secrets {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func hashResource(stack *definition.Stack, kind, name string) (string, error) {
+	switch kind {
+	case generate.KindNetwork:
+		return hasher.MarshalHashableB58(stack.Networks[name])
+	case generate.KindVolume:
+		return hasher.MarshalHashableB58(stack.Volumes[name])
+	case generate.KindPod:
+		return hasher.MarshalHashableB58(stack.Pods[name])
+	case generate.KindContainer:
+		return hasher.MarshalHashableB58(stack.Containers[name])
+	case KindSecret:
+		return hasher.MarshalHashableB58(stack.Secrets[name])
+	}
+	return "", fmt.Errorf("unknown resource kind '%s'", kind)
+}
@@ -0,0 +1,418 @@
+package podman
+
+import (
+	"archive/tar"
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/danecwalker/otari/internal/definition"
+	"github.com/danecwalker/otari/internal/utils"
+)
+
+// apiPrefix is the versioned path of the libpod API, podman serves every
+// version since 4.0 under it.
+const apiPrefix = "http://podman/v4.0.0/libpod"
+
+// ErrNotFound is matched by the APIError of requests for missing images,
+// containers, volumes and networks.
+var ErrNotFound = errors.New("no such object")
+
+// APIError is an error reported by the podman API.
+type APIError struct {
+	StatusCode int
+	Message    string
+	Cause      string
+}
+
+func (e *APIError) Error() string {
+	return e.Message
+}
+
+func (e *APIError) Is(target error) bool {
+	return target == ErrNotFound && e.StatusCode == http.StatusNotFound
+}
+
+// StreamReport is one message of the progress a pull or build streams.
+type StreamReport struct {
+	// Stream is a line of progress output
+	Stream string `json:"stream"`
+	// Error is set if the operation failed
+	Error string `json:"error"`
+	// ID is the id of the image once it was pulled
+	ID     string   `json:"id"`
+	Images []string `json:"images"`
+}
+
+// Event is an event from the podman event stream.
+type Event struct {
+	Type   string `json:"Type"`
+	Action string `json:"Action"`
+	Actor  struct {
+		ID         string            `json:"ID"`
+		Attributes map[string]string `json:"Attributes"`
+	} `json:"Actor"`
+	TimeNano int64 `json:"timeNano"`
+}
+
+// Name returns the name of the object the event is about.
+func (e *Event) Name() string {
+	return e.Actor.Attributes["name"]
+}
+
+// Time returns when the event happened.
+func (e *Event) Time() time.Time {
+	return time.Unix(0, e.TimeNano)
+}
+
+// APIClient is a Runtime that talks to the libpod REST API over the podman
+// socket. Secrets are still managed with the podman binary.
+type APIClient struct {
+	execRuntime
+	http *http.Client
+}
+
+var _ Runtime = (*APIClient)(nil)
+
+// NewAPIClient returns a client for the podman socket at socketPath.
+func NewAPIClient(socketPath string) *APIClient {
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", socketPath)
+		},
+	}
+	return &APIClient{http: &http.Client{Transport: transport}}
+}
+
+// SocketPath returns the path of the podman socket of the current user,
+// CONTAINER_HOST overrides it.
+func SocketPath() string {
+	if host, ok := strings.CutPrefix(os.Getenv("CONTAINER_HOST"), "unix://"); ok {
+		return host
+	}
+	if utils.Rootful() {
+		return "/run/podman/podman.sock"
+	}
+	runtimeDir := os.Getenv("XDG_RUNTIME_DIR")
+	if runtimeDir == "" {
+		runtimeDir = fmt.Sprintf("/run/user/%d", os.Getuid())
+	}
+	return filepath.Join(runtimeDir, "podman", "podman.sock")
+}
+
+// DefaultRuntime returns the Runtime otari uses unless told otherwise. It
+// talks to the podman socket if it answers and runs the podman binary
+// otherwise.
+func DefaultRuntime() Runtime {
+	client := NewAPIClient(SocketPath())
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if client.Ping(ctx) != nil {
+		return ExecRuntime()
+	}
+	return client
+}
+
+// do sends a request to the API and returns the response if it succeeded.
+// Otherwise the error in the response body is returned as an APIError.
+func (c *APIClient) do(ctx context.Context, method, path string, query url.Values, body io.Reader, contentType string) (*http.Response, error) {
+	u := apiPrefix + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, body)
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 300 {
+		return resp, nil
+	}
+
+	defer resp.Body.Close()
+	apiErr := &APIError{StatusCode: resp.StatusCode}
+	var payload struct {
+		Cause   string `json:"cause"`
+		Message string `json:"message"`
+	}
+	if json.NewDecoder(resp.Body).Decode(&payload) == nil && payload.Message != "" {
+		apiErr.Message, apiErr.Cause = payload.Message, payload.Cause
+	} else {
+		apiErr.Message = fmt.Sprintf("%s %s: %s", method, path, resp.Status)
+	}
+	return nil, apiErr
+}
+
+// call sends a request and discards the response body.
+func (c *APIClient) call(ctx context.Context, method, path string, query url.Values) error {
+	resp, err := c.do(ctx, method, path, query, nil, "")
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// getJSON decodes the response of a GET request into out.
+func (c *APIClient) getJSON(ctx context.Context, path string, query url.Values, out any) error {
+	resp, err := c.do(ctx, http.MethodGet, path, query, nil, "")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// Ping checks that the API is reachable.
+func (c *APIClient) Ping(ctx context.Context) error {
+	return c.call(ctx, http.MethodGet, "/_ping", nil)
+}
+
+func (c *APIClient) exists(ctx context.Context, path string) bool {
+	return c.call(ctx, http.MethodGet, path+"/exists", nil) == nil
+}
+
+func (c *APIClient) ImageExists(ctx context.Context, image string) bool {
+	return c.exists(ctx, "/images/"+url.PathEscape(image))
+}
+
+func (c *APIClient) ImageDigest(ctx context.Context, image string) (string, error) {
+	var inspected struct {
+		Digest string `json:"Digest"`
+	}
+	if err := c.getJSON(ctx, "/images/"+url.PathEscape(image)+"/json", nil, &inspected); err != nil {
+		return "", err
+	}
+	return inspected.Digest, nil
+}
+
+// streamReports passes every report in body to fn and returns the error
+// reported in the stream, if any.
+func streamReports(body io.Reader, fn func(report *StreamReport)) error {
+	decoder := json.NewDecoder(body)
+	for {
+		var report StreamReport
+		if err := decoder.Decode(&report); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if report.Error != "" {
+			return &APIError{StatusCode: http.StatusInternalServerError, Message: strings.TrimSpace(report.Error)}
+		}
+		fn(&report)
+	}
+}
+
+// progressLines passes the lines of output in reports to progress.
+func progressLines(progress func(line string)) func(report *StreamReport) {
+	return func(report *StreamReport) {
+		for _, line := range strings.Split(strings.TrimSpace(report.Stream), "\n") {
+			if line != "" {
+				progress(line)
+			}
+		}
+	}
+}
+
+// Pull pulls image and passes the progress reports to fn.
+func (c *APIClient) Pull(ctx context.Context, image string, policy definition.PullPolicy, fn func(report *StreamReport)) error {
+	query := url.Values{"reference": {image}}
+	if policy == definition.PullNewer {
+		query.Set("policy", string(policy))
+	}
+	resp, err := c.do(ctx, http.MethodPost, "/images/pull", query, nil, "")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return streamReports(resp.Body, fn)
+}
+
+func (c *APIClient) PullImage(ctx context.Context, image string, policy definition.PullPolicy, progress func(line string)) error {
+	return c.Pull(ctx, image, policy, progressLines(progress))
+}
+
+// Build builds image from build and passes the progress reports to fn.
+func (c *APIClient) Build(ctx context.Context, build *definition.Build, image string, noCache bool, fn func(report *StreamReport)) error {
+	contextDir, containerFile, err := resolveBuild(build)
+	if err != nil {
+		return err
+	}
+	relFile, err := filepath.Rel(contextDir, containerFile)
+	if err != nil || !filepath.IsLocal(relFile) {
+		return fmt.Errorf("containerfile %s is outside of the build context %s", containerFile, contextDir)
+	}
+
+	query := url.Values{"dockerfile": {filepath.ToSlash(relFile)}, "t": {image}}
+	query["t"] = append(query["t"], build.Tags...)
+	if len(build.Args) > 0 {
+		args, err := json.Marshal(build.Args)
+		if err != nil {
+			return err
+		}
+		query.Set("buildargs", string(args))
+	}
+	if build.Target != "" {
+		query.Set("target", build.Target)
+	}
+	if noCache {
+		query.Set("nocache", "true")
+	}
+
+	// the build context is sent as a tar archive
+	reader, writer := io.Pipe()
+	go func() {
+		writer.CloseWithError(writeTar(writer, contextDir))
+	}()
+	defer reader.Close()
+
+	resp, err := c.do(ctx, http.MethodPost, "/build", query, reader, "application/x-tar")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return streamReports(resp.Body, fn)
+}
+
+func (c *APIClient) BuildImage(ctx context.Context, build *definition.Build, image string, noCache bool, progress func(line string)) error {
+	return c.Build(ctx, build, image, noCache, progressLines(progress))
+}
+
+// writeTar writes the files below dir to w as a tar archive.
+func writeTar(w io.Writer, dir string) error {
+	tw := tar.NewWriter(w)
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil || rel == "." {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		link := ""
+		if info.Mode()&fs.ModeSymlink != 0 {
+			if link, err = os.Readlink(path); err != nil {
+				return err
+			}
+		}
+		header, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(rel)
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(tw, f)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+// listedContainer is an entry of the container list.
+type listedContainer struct {
+	Names []string `json:"Names"`
+	State string   `json:"State"`
+}
+
+func (c *APIClient) ActiveContainers(ctx context.Context) ([]string, error) {
+	var listed []listedContainer
+	if err := c.getJSON(ctx, "/containers/json", nil, &listed); err != nil {
+		return nil, err
+	}
+	var containers []string
+	for _, container := range listed {
+		if container.State == "running" {
+			containers = append(containers, container.Names...)
+		}
+	}
+	slices.Sort(containers)
+	return containers, nil
+}
+
+func (c *APIClient) InspectContainer(ctx context.Context, containerName string) (*ContainerState, error) {
+	var inspected inspectOutput
+	if err := c.getJSON(ctx, "/containers/"+url.PathEscape(containerName)+"/json", nil, &inspected); err != nil {
+		return nil, err
+	}
+	return inspected.state(), nil
+}
+
+func (c *APIClient) ResourceExists(ctx context.Context, resourceType, name string) bool {
+	return c.exists(ctx, "/"+resourceType+"s/"+url.PathEscape(name))
+}
+
+func (c *APIClient) RemoveVolume(ctx context.Context, volumeName string) error {
+	return c.call(ctx, http.MethodDelete, "/volumes/"+url.PathEscape(volumeName), url.Values{"force": {"true"}})
+}
+
+func (c *APIClient) RemoveNetwork(ctx context.Context, networkName string) error {
+	return c.call(ctx, http.MethodDelete, "/networks/"+url.PathEscape(networkName), url.Values{"force": {"true"}})
+}
+
+// Events streams the podman events matching filters to fn until ctx is
+// done. Filters are keyed by field, e.g. "type" or "container".
+func (c *APIClient) Events(ctx context.Context, filters map[string][]string, fn func(event *Event)) error {
+	query := url.Values{"stream": {"true"}}
+	if len(filters) > 0 {
+		encoded, err := json.Marshal(filters)
+		if err != nil {
+			return err
+		}
+		query.Set("filters", string(encoded))
+	}
+	resp, err := c.do(ctx, http.MethodGet, "/events", query, nil, "")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		var event Event
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			return err
+		}
+		fn(&event)
+	}
+	if ctx.Err() != nil {
+		return nil
+	}
+	return scanner.Err()
+}
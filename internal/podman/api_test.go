@@ -0,0 +1,189 @@
+package podman
+
+// Tests the podman API client against a fake server on a unix socket.
+
+import (
+	"archive/tar"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/danecwalker/otari/internal/definition"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// serve runs handler on a unix socket and returns a client for it.
+func serve(t *testing.T, handler http.Handler) *APIClient {
+	t.Helper()
+	socketPath := filepath.Join(t.TempDir(), "podman.sock")
+	listener, err := net.Listen("unix", socketPath)
+	require.NoError(t, err)
+
+	server := httptest.NewUnstartedServer(handler)
+	server.Listener = listener
+	server.Start()
+	t.Cleanup(server.Close)
+	return NewAPIClient(socketPath)
+}
+
+func notFound(w http.ResponseWriter, message string) {
+	w.WriteHeader(http.StatusNotFound)
+	fmt.Fprintf(w, `{"cause":"no such object","message":%q,"response":404}`, message)
+}
+
+func TestAPIClientContainers(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v4.0.0/libpod/containers/json", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `[{"Names":["demo-web"],"State":"running"},{"Names":["demo-db"],"State":"running"},{"Names":["demo-old"],"State":"exited"}]`)
+	})
+	mux.HandleFunc("GET /v4.0.0/libpod/containers/{name}/json", func(w http.ResponseWriter, r *http.Request) {
+		if r.PathValue("name") != "demo-web" {
+			notFound(w, "no container with name or ID \""+r.PathValue("name")+"\" found: no such container")
+			return
+		}
+		fmt.Fprint(w, `{"RestartCount":2,"ImageDigest":"sha256:abc","State":{"Status":"running","Running":true,"Health":{"Status":"healthy"}},
+			"NetworkSettings":{"Ports":{"80/tcp":[{"HostIp":"","HostPort":"8080"}]}}}`)
+	})
+	mux.HandleFunc("GET /v4.0.0/libpod/networks/{name}/exists", func(w http.ResponseWriter, r *http.Request) {
+		if r.PathValue("name") != "demo-backend" {
+			notFound(w, "network not found")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("DELETE /v4.0.0/libpod/volumes/{name}", func(w http.ResponseWriter, r *http.Request) {
+		notFound(w, "no volume with name \""+r.PathValue("name")+"\" found: no such volume")
+	})
+	client := serve(t, mux)
+	ctx := context.Background()
+
+	active, err := client.ActiveContainers(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"demo-db", "demo-web"}, active)
+
+	state, err := client.InspectContainer(ctx, "demo-web")
+	require.NoError(t, err)
+	assert.True(t, state.Running)
+	assert.Equal(t, "healthy", state.Health)
+	assert.Equal(t, 2, state.RestartCount)
+	assert.Equal(t, []string{"0.0.0.0:8080->80/tcp"}, state.Ports)
+
+	_, err = client.InspectContainer(ctx, "demo-missing")
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.EqualError(t, err, `no container with name or ID "demo-missing" found: no such container`)
+
+	assert.True(t, client.ResourceExists(ctx, "network", "demo-backend"))
+	assert.False(t, client.ResourceExists(ctx, "network", "demo-frontend"))
+
+	err = client.RemoveVolume(ctx, "demo-data")
+	var apiErr *APIError
+	require.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, http.StatusNotFound, apiErr.StatusCode)
+	assert.Equal(t, "no such object", apiErr.Cause)
+}
+
+func TestAPIClientImages(t *testing.T) {
+	var built map[string][]byte
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v4.0.0/libpod/images/{name}/exists", func(w http.ResponseWriter, r *http.Request) {
+		if r.PathValue("name") != "nginx:1.27" {
+			notFound(w, "failed to find image")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("GET /v4.0.0/libpod/images/{name}/json", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"Id":"abc","Digest":"sha256:0123abcd"}`)
+	})
+	mux.HandleFunc("POST /v4.0.0/libpod/images/pull", func(w http.ResponseWriter, r *http.Request) {
+		reference := r.URL.Query().Get("reference")
+		if reference == "nginx:1.27" {
+			assert.Equal(t, "newer", r.URL.Query().Get("policy"))
+		}
+		fmt.Fprintf(w, "{\"stream\":\"Trying to pull %s...\\n\"}\n", reference)
+		if reference == "missing:1" {
+			fmt.Fprint(w, `{"error":"initializing source: manifest unknown"}`+"\n")
+			return
+		}
+		fmt.Fprint(w, `{"images":["sha256:abc"],"id":"sha256:abc"}`+"\n")
+	})
+	mux.HandleFunc("POST /v4.0.0/libpod/build", func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		assert.Equal(t, []string{"web:local", "web:latest"}, query["t"])
+		assert.Equal(t, "Containerfile", query.Get("dockerfile"))
+		var args map[string]string
+		require.NoError(t, json.Unmarshal([]byte(query.Get("buildargs")), &args))
+		assert.Equal(t, map[string]string{"VERSION": "1"}, args)
+
+		built = make(map[string][]byte)
+		tr := tar.NewReader(r.Body)
+		for {
+			header, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			require.NoError(t, err)
+			content, _ := io.ReadAll(tr)
+			built[header.Name] = content
+		}
+		fmt.Fprint(w, `{"stream":"STEP 1/1: FROM alpine\n"}`+"\n")
+	})
+	client := serve(t, mux)
+	ctx := context.Background()
+
+	assert.True(t, client.ImageExists(ctx, "nginx:1.27"))
+	assert.False(t, client.ImageExists(ctx, "nginx:1.28"))
+	digest, err := client.ImageDigest(ctx, "nginx:1.27")
+	require.NoError(t, err)
+	assert.Equal(t, "sha256:0123abcd", digest)
+
+	var lines []string
+	progress := func(line string) { lines = append(lines, line) }
+	require.NoError(t, client.PullImage(ctx, "nginx:1.27", definition.PullNewer, progress))
+	assert.Equal(t, []string{"Trying to pull nginx:1.27..."}, lines)
+
+	err = client.PullImage(ctx, "missing:1", definition.PullAlways, progress)
+	var apiErr *APIError
+	require.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, "initializing source: manifest unknown", apiErr.Message)
+
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "Containerfile"), []byte("FROM alpine\n"), 0o644))
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "src"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "src", "main.go"), []byte("package main\n"), 0o644))
+	build := &definition.Build{Context: dir, Tags: []string{"web:latest"}, Args: definition.MapArray{"VERSION": "1"}}
+
+	lines = nil
+	require.NoError(t, client.BuildImage(ctx, build, "web:local", false, progress))
+	assert.Equal(t, []string{"STEP 1/1: FROM alpine"}, lines)
+	assert.Equal(t, []byte("FROM alpine\n"), built["Containerfile"])
+	assert.Equal(t, []byte("package main\n"), built["src/main.go"])
+}
+
+func TestAPIClientEvents(t *testing.T) {
+	client := serve(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, `{"type":["container"]}`, r.URL.Query().Get("filters"))
+		fmt.Fprint(w, `{"Type":"container","Action":"start","Actor":{"ID":"1","Attributes":{"name":"demo-web"}},"timeNano":1700000000000000000}`+"\n")
+		fmt.Fprint(w, `{"Type":"container","Action":"died","Actor":{"ID":"2","Attributes":{"name":"demo-migrate"}},"timeNano":1700000001000000000}`+"\n")
+	}))
+
+	var events []string
+	err := client.Events(context.Background(), map[string][]string{"type": {"container"}}, func(event *Event) {
+		events = append(events, event.Action+" "+event.Name())
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"start demo-web", "died demo-migrate"}, events)
+
+	_, err = NewAPIClient(filepath.Join(t.TempDir(), "missing.sock")).ActiveContainers(context.Background())
+	assert.Error(t, err)
+	assert.False(t, errors.Is(err, ErrNotFound))
+}
@@ -48,3 +48,10 @@ func RemoveVolume(ctx context.Context, volumeName string) error {
 	cmd := exec.CommandContext(ctx, "podman", "volume", "rm", "-f", volumeName)
 	return cmd.Run()
 }
+
+// ResourceExists reports whether podman has a resource of the given type,
+// one of container, network, volume or pod.
+func ResourceExists(ctx context.Context, resourceType, name string) bool {
+	cmd := exec.CommandContext(ctx, "podman", resourceType, "exists", name)
+	return cmd.Run() == nil
+}
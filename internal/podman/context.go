@@ -0,0 +1,194 @@
+package podman
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/danecwalker/otari/internal/definition"
+)
+
+// ignoreFiles are the files that exclude paths from a build context, the
+// first one that exists is used.
+var ignoreFiles = []string{".containerignore", ".dockerignore"}
+
+// ignoreRule is a pattern of an ignore file. Rules are applied in order and
+// the last one that matches a path decides whether it is excluded.
+type ignoreRule struct {
+	pattern *regexp.Regexp
+	negate  bool
+}
+
+// readIgnoreRules reads the ignore file of a build context.
+func readIgnoreRules(contextDir string) ([]ignoreRule, error) {
+	for _, name := range ignoreFiles {
+		f, err := os.Open(filepath.Join(contextDir, name))
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+
+		var rules []ignoreRule
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			rule := ignoreRule{}
+			if line, rule.negate = strings.CutPrefix(line, "!"); rule.negate {
+				line = strings.TrimSpace(line)
+			}
+			line = strings.Trim(filepath.ToSlash(filepath.Clean(line)), "/")
+			rule.pattern = compileIgnorePattern(line)
+			rules = append(rules, rule)
+		}
+		return rules, scanner.Err()
+	}
+	return nil, nil
+}
+
+// compileIgnorePattern turns a pattern of an ignore file into a regular
+// expression. "**" matches any number of directories, "*" and "?" do not
+// match across a "/", and a pattern matching a directory matches everything
+// below it.
+func compileIgnorePattern(pattern string) *regexp.Regexp {
+	var sb strings.Builder
+	sb.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; c {
+		case '*':
+			if strings.HasPrefix(pattern[i:], "**/") {
+				// any number of directories, including none
+				sb.WriteString("(.*/)?")
+				i += 2
+			} else if strings.HasPrefix(pattern[i:], "**") {
+				sb.WriteString(".*")
+				i++
+			} else {
+				sb.WriteString("[^/]*")
+			}
+		case '?':
+			sb.WriteString("[^/]")
+		case '[':
+			if end := strings.IndexByte(pattern[i:], ']'); end > 0 {
+				class := pattern[i+1 : i+end]
+				if negated, ok := strings.CutPrefix(class, "!"); ok {
+					class = "^" + negated
+				}
+				sb.WriteString("[" + class + "]")
+				i += end
+			} else {
+				sb.WriteString(regexp.QuoteMeta("["))
+			}
+		case '\\':
+			if i+1 < len(pattern) {
+				i++
+				sb.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+			}
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	sb.WriteString("(/.*)?$")
+	re, err := regexp.Compile(sb.String())
+	if err != nil {
+		// a broken character class matches literally
+		return regexp.MustCompile("^" + regexp.QuoteMeta(pattern) + "(/.*)?$")
+	}
+	return re
+}
+
+// ignored reports whether rules exclude the path, relative to the build
+// context and separated by slashes.
+func ignored(rules []ignoreRule, rel string) bool {
+	excluded := false
+	for _, rule := range rules {
+		if rule.pattern.MatchString(rel) {
+			excluded = !rule.negate
+		}
+	}
+	return excluded
+}
+
+// ContextHash returns a hash of the containerfile and the files of the
+// build context of build, leaving out the files its ignore file excludes.
+// It changes whenever building the context could produce a different
+// image.
+func ContextHash(build *definition.Build) (string, error) {
+	contextDir, containerFile, err := resolveBuild(build)
+	if err != nil {
+		return "", err
+	}
+	rules, err := readIgnoreRules(contextDir)
+	if err != nil {
+		return "", err
+	}
+
+	h := sha256.New()
+	if err := hashFile(h, containerFile); err != nil {
+		return "", err
+	}
+	err = filepath.WalkDir(contextDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(contextDir, path)
+		if err != nil || rel == "." {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if ignored(rules, rel) {
+			if d.IsDir() {
+				// negated rules may still include files below it
+				for _, rule := range rules {
+					if rule.negate {
+						return nil
+					}
+				}
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(h, "%s\x00%o\x00", rel, info.Mode())
+		switch {
+		case info.Mode()&fs.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(h, "%s\x00", link)
+		case info.Mode().IsRegular():
+			fmt.Fprintf(h, "%d\x00", info.Size())
+			return hashFile(h, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("sha256:%x", h.Sum(nil)), nil
+}
+
+func hashFile(w io.Writer, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(w, f)
+	return err
+}
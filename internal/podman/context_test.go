@@ -0,0 +1,71 @@
+package podman
+
+// Tests hashing build contexts and matching ignore file patterns.
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/danecwalker/otari/internal/definition"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIgnorePatterns(t *testing.T) {
+	tests := []struct {
+		pattern string
+		path    string
+		want    bool
+	}{
+		{"*.log", "debug.log", true},
+		{"*.log", "logs/debug.log", false},
+		{"**/*.log", "logs/debug.log", true},
+		{"**/*.log", "debug.log", true},
+		{"node_modules", "node_modules/react/index.js", true},
+		{"node_modules", "src/node_modules", false},
+		{"src/*/test", "src/app/test/main_test.go", true},
+		{"file?.txt", "file1.txt", true},
+		{"file?.txt", "file10.txt", false},
+		{"[a-c].txt", "b.txt", true},
+		{"[!a-c].txt", "b.txt", false},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, compileIgnorePattern(tt.pattern).MatchString(tt.path), "%s %s", tt.pattern, tt.path)
+	}
+
+	rules := []ignoreRule{
+		{pattern: compileIgnorePattern("*.md")},
+		{pattern: compileIgnorePattern("README.md"), negate: true},
+	}
+	assert.True(t, ignored(rules, "CHANGELOG.md"))
+	assert.False(t, ignored(rules, "README.md"))
+}
+
+func TestContextHash(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) {
+		require.NoError(t, os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0o755))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644))
+	}
+	write("Containerfile", "FROM alpine\n")
+	write(".dockerignore", "# build output\ndist\n")
+	write("src/main.go", "package main\n")
+	build := &definition.Build{Context: dir}
+
+	hash, err := ContextHash(build)
+	require.NoError(t, err)
+
+	write("dist/app", "binary")
+	unchanged, err := ContextHash(build)
+	require.NoError(t, err)
+	assert.Equal(t, hash, unchanged)
+
+	write("src/main.go", "package main\n\nfunc main() {}\n")
+	changed, err := ContextHash(build)
+	require.NoError(t, err)
+	assert.NotEqual(t, hash, changed)
+
+	_, err = ContextHash(&definition.Build{Context: filepath.Join(dir, "missing")})
+	assert.Error(t, err)
+}
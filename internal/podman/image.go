@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"os/exec"
 	"path/filepath"
+	"strings"
 
 	"github.com/danecwalker/otari/internal/definition"
 	"github.com/danecwalker/otari/internal/utils"
@@ -25,20 +26,37 @@ func ImageExists(ctx context.Context, image string) bool {
 	return true
 }
 
-func ImagePull(ctx context.Context, image string) *exec.Cmd {
+// ImageDigest returns the digest of a local image.
+func ImageDigest(ctx context.Context, image string) (string, error) {
+	out, err := exec.CommandContext(ctx, "podman", "image", "inspect", "--format", "{{.Digest}}", image).Output()
+	if err != nil {
+		if exitError, ok := err.(*exec.ExitError); ok && len(exitError.Stderr) > 0 {
+			return "", fmt.Errorf("%s", strings.TrimSpace(string(exitError.Stderr)))
+		}
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+func ImagePull(ctx context.Context, image string, policy definition.PullPolicy) *exec.Cmd {
+	if policy == definition.PullNewer {
+		return exec.CommandContext(ctx, "podman", "pull", "--policy", string(policy), image)
+	}
 	cmd := exec.CommandContext(ctx, "podman", "pull", image)
 	return cmd
 }
 
-func ImageBuild(ctx context.Context, build *definition.Build, image string) (*exec.Cmd, error) {
+// resolveBuild returns the absolute path of the build context, relative to
+// the stack file, and of the containerfile of build.
+func resolveBuild(build *definition.Build) (string, string, error) {
 	// check if build context path exists
-	absPath, err := utils.GetAbsolutePath(build.Context)
+	absPath, err := utils.ResolvePath(build.Dir, build.Context)
 	if err != nil {
-		return nil, err
+		return "", "", err
 	}
 
 	if !utils.PathExists(absPath) {
-		return nil, fmt.Errorf("build context path does not exist: %s", absPath)
+		return "", "", fmt.Errorf("build context path does not exist: %s", absPath)
 	}
 
 	// check if containerfile / dockerfile exists
@@ -56,7 +74,15 @@ func ImageBuild(ctx context.Context, build *definition.Build, image string) (*ex
 		containerFile = filepath.Join(absPath, containerFile)
 	}
 	if !utils.PathExists(containerFile) {
-		return nil, fmt.Errorf("containerfile does not exist: %s", containerFile)
+		return "", "", fmt.Errorf("containerfile does not exist: %s", containerFile)
+	}
+	return absPath, containerFile, nil
+}
+
+func ImageBuild(ctx context.Context, build *definition.Build, image string, noCache bool) (*exec.Cmd, error) {
+	absPath, containerFile, err := resolveBuild(build)
+	if err != nil {
+		return nil, err
 	}
 
 	cmdSlice := []string{
@@ -79,6 +105,10 @@ func ImageBuild(ctx context.Context, build *definition.Build, image string) (*ex
 		cmdSlice = append(cmdSlice, "--target", build.Target)
 	}
 
+	if noCache {
+		cmdSlice = append(cmdSlice, "--no-cache")
+	}
+
 	cmd := exec.CommandContext(ctx, "podman", append(cmdSlice, absPath)...)
 	return cmd, nil
 }
@@ -0,0 +1,96 @@
+package podman
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"sort"
+	"time"
+)
+
+type ContainerState struct {
+	Status   string
+	Running  bool
+	ExitCode int
+	// Health is empty if the container has no healthcheck
+	Health       string
+	StartedAt    time.Time
+	RestartCount int
+	ImageDigest  string
+	// Ports lists the published ports as hostIP:hostPort->containerPort/proto
+	Ports []string
+}
+
+type inspectHealth struct {
+	Status string `json:"Status"`
+}
+
+type inspectPortBinding struct {
+	HostIP   string `json:"HostIp"`
+	HostPort string `json:"HostPort"`
+}
+
+type inspectOutput struct {
+	RestartCount    int    `json:"RestartCount"`
+	ImageDigest     string `json:"ImageDigest"`
+	NetworkSettings struct {
+		Ports map[string][]inspectPortBinding `json:"Ports"`
+	} `json:"NetworkSettings"`
+	State struct {
+		StartedAt time.Time `json:"StartedAt"`
+		Status    string    `json:"Status"`
+		Running   bool      `json:"Running"`
+		ExitCode  int       `json:"ExitCode"`
+		// podman renamed Healthcheck to Health, accept both
+		Health      *inspectHealth `json:"Health"`
+		Healthcheck *inspectHealth `json:"Healthcheck"`
+	} `json:"State"`
+}
+
+func InspectContainer(ctx context.Context, containerName string) (*ContainerState, error) {
+	cmd := exec.CommandContext(ctx, "podman", "container", "inspect", "--format", "json", containerName)
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, err
+	}
+
+	var inspected []inspectOutput
+	if err := json.Unmarshal(out, &inspected); err != nil {
+		return nil, err
+	}
+	if len(inspected) == 0 {
+		return nil, fmt.Errorf("container '%s' not found", containerName)
+	}
+
+	return inspected[0].state(), nil
+}
+
+// state returns the parts of the inspect output otari uses.
+func (inspected *inspectOutput) state() *ContainerState {
+	st := inspected.State
+	state := &ContainerState{
+		Status:       st.Status,
+		Running:      st.Running,
+		ExitCode:     st.ExitCode,
+		StartedAt:    st.StartedAt,
+		RestartCount: inspected.RestartCount,
+		ImageDigest:  inspected.ImageDigest,
+	}
+	for containerPort, bindings := range inspected.NetworkSettings.Ports {
+		for _, b := range bindings {
+			hostIP := b.HostIP
+			if hostIP == "" {
+				hostIP = "0.0.0.0"
+			}
+			state.Ports = append(state.Ports, fmt.Sprintf("%s:%s->%s", hostIP, b.HostPort, containerPort))
+		}
+	}
+	sort.Strings(state.Ports)
+	if st.Health != nil {
+		state.Health = st.Health.Status
+	} else if st.Healthcheck != nil {
+		state.Health = st.Healthcheck.Status
+	}
+	return state
+}
@@ -0,0 +1,117 @@
+package podman
+
+import (
+	"bufio"
+	"context"
+	"os/exec"
+
+	"github.com/danecwalker/otari/internal/definition"
+)
+
+// Runtime manages the images, containers, volumes, networks and secrets of
+// a stack.
+type Runtime interface {
+	ImageExists(ctx context.Context, image string) bool
+	// PullImage pulls image, passing every line of progress to progress.
+	// With PullNewer it is only pulled if the registry has a different
+	// image for its tag, any other policy pulls it unconditionally.
+	PullImage(ctx context.Context, image string, policy definition.PullPolicy, progress func(line string)) error
+	// ImageDigest returns the registry digest of a local image, e.g.
+	// "sha256:...".
+	ImageDigest(ctx context.Context, image string) (string, error)
+	// BuildImage builds image from build, passing every line of progress to
+	// progress. With noCache no cached layers are used.
+	BuildImage(ctx context.Context, build *definition.Build, image string, noCache bool, progress func(line string)) error
+	// ActiveContainers returns the names of the running containers.
+	ActiveContainers(ctx context.Context) ([]string, error)
+	InspectContainer(ctx context.Context, containerName string) (*ContainerState, error)
+	// ResourceExists reports whether podman has a resource of the given
+	// type, one of container, network, volume or pod.
+	ResourceExists(ctx context.Context, resourceType, name string) bool
+	RemoveVolume(ctx context.Context, volumeName string) error
+	RemoveNetwork(ctx context.Context, networkName string) error
+	CreateSecret(ctx context.Context, secretName string, value []byte, labels ...string) error
+	RemoveSecret(ctx context.Context, secretName string) error
+	SecretExists(ctx context.Context, secretName string) bool
+}
+
+type execRuntime struct{}
+
+// ExecRuntime returns a Runtime that runs the podman binary.
+func ExecRuntime() Runtime {
+	return execRuntime{}
+}
+
+func (execRuntime) ImageExists(ctx context.Context, image string) bool {
+	return ImageExists(ctx, image)
+}
+
+func (execRuntime) PullImage(ctx context.Context, image string, policy definition.PullPolicy, progress func(line string)) error {
+	return runWithProgress(ImagePull(ctx, image, policy), progress)
+}
+
+func (execRuntime) ImageDigest(ctx context.Context, image string) (string, error) {
+	return ImageDigest(ctx, image)
+}
+
+func (execRuntime) BuildImage(ctx context.Context, build *definition.Build, image string, noCache bool, progress func(line string)) error {
+	cmd, err := ImageBuild(ctx, build, image, noCache)
+	if err != nil {
+		return err
+	}
+	return runWithProgress(cmd, progress)
+}
+
+func (execRuntime) ActiveContainers(ctx context.Context) ([]string, error) {
+	return ActiveContainers(ctx)
+}
+
+func (execRuntime) InspectContainer(ctx context.Context, containerName string) (*ContainerState, error) {
+	return InspectContainer(ctx, containerName)
+}
+
+func (execRuntime) ResourceExists(ctx context.Context, resourceType, name string) bool {
+	return ResourceExists(ctx, resourceType, name)
+}
+
+func (execRuntime) RemoveVolume(ctx context.Context, volumeName string) error {
+	return RemoveVolume(ctx, volumeName)
+}
+
+func (execRuntime) RemoveNetwork(ctx context.Context, networkName string) error {
+	return RemoveNetwork(ctx, networkName)
+}
+
+func (execRuntime) CreateSecret(ctx context.Context, secretName string, value []byte, labels ...string) error {
+	return CreateSecret(ctx, secretName, value, labels...)
+}
+
+func (execRuntime) RemoveSecret(ctx context.Context, secretName string) error {
+	return RemoveSecret(ctx, secretName)
+}
+
+func (execRuntime) SecretExists(ctx context.Context, secretName string) bool {
+	return SecretExists(ctx, secretName)
+}
+
+// runWithProgress runs cmd and passes every line it writes to stderr to
+// progress.
+func runWithProgress(cmd *exec.Cmd, progress func(line string)) error {
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		stderr = nil
+	}
+
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	if stderr != nil {
+		scanner := bufio.NewScanner(stderr)
+		for scanner.Scan() {
+			progress(scanner.Text())
+		}
+	}
+
+	return cmd.Wait()
+}
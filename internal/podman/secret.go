@@ -0,0 +1,42 @@
+package podman
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// CreateSecret creates or replaces a podman secret. The value is passed on
+// stdin so it never shows up in the process list.
+func CreateSecret(ctx context.Context, secretName string, value []byte, labels ...string) error {
+	// podman < 4.7 has no --replace, so remove any previous version first
+	_ = RemoveSecret(ctx, secretName)
+
+	args := []string{"secret", "create"}
+	for _, label := range labels {
+		args = append(args, "--label", label)
+	}
+	args = append(args, secretName, "-")
+
+	cmd := exec.CommandContext(ctx, "podman", args...)
+	cmd.Stdin = bytes.NewReader(value)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+func RemoveSecret(ctx context.Context, secretName string) error {
+	cmd := exec.CommandContext(ctx, "podman", "secret", "rm", secretName)
+	return cmd.Run()
+}
+
+// SecretExists reports whether podman has a secret with the given name.
+func SecretExists(ctx context.Context, secretName string) bool {
+	cmd := exec.CommandContext(ctx, "podman", "secret", "inspect", secretName)
+	return cmd.Run() == nil
+}
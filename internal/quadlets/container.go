@@ -3,6 +3,9 @@ package quadlets
 import (
 	"bytes"
 	"fmt"
+	"maps"
+	"slices"
+	"strconv"
 	"strings"
 
 	"github.com/danecwalker/otari/internal/definition"
@@ -21,14 +24,17 @@ func (q *QuadletGenerator) GenerateContainer(stack *definition.Stack, containerN
 		{"Description", container.ContainerName + " container"},
 	}
 
-	if len(container.Depends) > 0 {
-		unitDefinition = append(unitDefinition, [2]string{
-			"Requires", strings.Join(container.Depends, " "),
-		})
-		unitDefinition = append(unitDefinition, [2]string{
-			"After", strings.Join(container.Depends, " "),
-		})
+	var deps unitDependencies
+	for _, dep := range container.Depends {
+		deps.add(ContainerServiceName(stack.ResourceName(dep.Name)))
 	}
+	if container.Pod != "" {
+		deps.add(PodServiceName(stack.ResourceName(container.Pod)))
+	} else {
+		deps.addNetworks(stack, container.Networks)
+	}
+	deps.addVolumes(stack, container.Volumes)
+	unitDefinition = append(unitDefinition, deps.unitProperties()...)
 
 	if err := utils.WriteSection(&buf, "Unit", unitDefinition); err != nil {
 		return nil, err
@@ -40,8 +46,9 @@ func (q *QuadletGenerator) GenerateContainer(stack *definition.Stack, containerN
 
 	// Container section
 	containerProperties := [][2]string{
-		{"ContainerName", container.ContainerName},
-		{"Image", container.Image.String()},
+		{"ContainerName", stack.ResourceName(container.ContainerName)},
+		{"Image", container.ImageRef()},
+		{"Label", stack.StackLabelValue()},
 	}
 
 	if container.Entrypoint != "" {
@@ -56,14 +63,11 @@ func (q *QuadletGenerator) GenerateContainer(stack *definition.Stack, containerN
 		})
 	}
 
-	// Environment variables
-	if len(container.Environment) > 0 {
-		for key, value := range container.Environment {
-			containerProperties = append(containerProperties, [2]string{
-				"Environment", key + "=" + value,
-			})
-		}
-
+	// Environment variables, sorted so the quadlet is the same every time
+	for _, key := range slices.Sorted(maps.Keys(container.Environment)) {
+		containerProperties = append(containerProperties, [2]string{
+			"Environment", key + "=" + container.Environment[key],
+		})
 	}
 
 	if len(container.Ports) > 0 {
@@ -76,55 +80,82 @@ func (q *QuadletGenerator) GenerateContainer(stack *definition.Stack, containerN
 		})
 	}
 
-	if len(container.Labels) > 0 {
-		for key, value := range container.Labels {
+	for _, key := range slices.Sorted(maps.Keys(container.Labels)) {
+		containerProperties = append(containerProperties, [2]string{
+			"Label", key + "=" + container.Labels[key],
+		})
+	}
+
+	// containers in a pod share the pod's network namespace
+	if container.Pod != "" {
+		containerProperties = append(containerProperties, [2]string{
+			"Pod", stack.ResourceName(container.Pod) + ".pod",
+		})
+	} else {
+		for _, network := range networkValues(stack, container.Networks) {
 			containerProperties = append(containerProperties, [2]string{
-				"Label", key + "=" + value,
+				"Network", network,
 			})
 		}
 	}
 
-	// check if contains any host networks
-	if len(container.Networks) > 0 {
-		hasHostNetwork := false
-		for _, network := range container.Networks {
-			if stack.Networks[network].Driver == definition.NetworkDriverHost {
-				hasHostNetwork = true
-				break
+	if len(container.Volumes) > 0 {
+		for _, volumeMap := range container.Volumes {
+			volumeDef, err := volumeValue(stack, volumeMap)
+			if err != nil {
+				return nil, err
 			}
+			containerProperties = append(containerProperties, [2]string{
+				"Volume", volumeDef,
+			})
+		}
+	}
+
+	for _, secret := range container.Secrets {
+		secretDef := stack.ResourceName(secret.Source) + ",type=" + string(secret.Type)
+		if secret.Target != "" {
+			secretDef += ",target=" + secret.Target
+		}
+		if secret.Mode != "" {
+			secretDef += ",mode=" + secret.Mode
 		}
+		containerProperties = append(containerProperties, [2]string{
+			"Secret", secretDef,
+		})
+	}
 
-		if hasHostNetwork {
+	if hc := container.Healthcheck; hc != nil {
+		if hc.IsDisabled() {
 			containerProperties = append(containerProperties, [2]string{
-				"Network", "host",
+				"HealthCmd", "none",
 			})
 		} else {
-			for _, network := range container.Networks {
+			containerProperties = append(containerProperties, [2]string{
+				"HealthCmd", hc.Command(),
+			})
+			if hc.Interval > 0 {
 				containerProperties = append(containerProperties, [2]string{
-					"Network", network + ".network",
+					"HealthInterval", hc.Interval.String(),
 				})
 			}
-		}
-	}
-
-	if len(container.Volumes) > 0 {
-		for _, volumeMap := range container.Volumes {
-			volumeDef := volumeMap.Destination
-			if len(volumeMap.Options) > 0 {
-				volumeDef += ":" + strings.Join(volumeMap.Options, ",")
+			if hc.Timeout > 0 {
+				containerProperties = append(containerProperties, [2]string{
+					"HealthTimeout", hc.Timeout.String(),
+				})
 			}
-			if volumeMap.Type == definition.VolumeMountTypeBind {
-				// get absolute path for host bind mounts
-				absPath, err := utils.GetAbsolutePath(volumeMap.Source)
-				if err != nil {
-					return nil, fmt.Errorf("failed to get absolute path for bind mount '%s': %v", volumeMap.Source, err)
-				}
-				volumeDef = absPath + ":" + volumeDef
-			} else {
-				volumeDef = volumeMap.Source + ".volume:" + volumeDef
+			if hc.Retries > 0 {
+				containerProperties = append(containerProperties, [2]string{
+					"HealthRetries", strconv.Itoa(hc.Retries),
+				})
 			}
+			if hc.StartPeriod > 0 {
+				containerProperties = append(containerProperties, [2]string{
+					"HealthStartPeriod", hc.StartPeriod.String(),
+				})
+			}
+			// only report the unit as started once the container is healthy
 			containerProperties = append(containerProperties, [2]string{
-				"Volume", volumeDef,
+				"Notify", "healthy",
 			})
 		}
 	}
@@ -185,3 +216,35 @@ func (q *QuadletGenerator) GenerateContainer(stack *definition.Stack, containerN
 
 	return buf.Bytes(), nil
 }
+
+// networkValues returns the Network= values for a list of stack networks.
+// If any of them uses the host driver the resource is attached to the host
+// network only.
+func networkValues(stack *definition.Stack, networks []string) []string {
+	var values []string
+	for _, network := range networks {
+		if n, ok := stack.Networks[network]; ok && n.Driver == definition.NetworkDriverHost {
+			return []string{"host"}
+		}
+		values = append(values, stack.ResourceName(network)+".network")
+	}
+	return values
+}
+
+// volumeValue returns the Volume= value for a volume mapping, resolving host
+// bind mounts to absolute paths relative to the stack file.
+func volumeValue(stack *definition.Stack, volumeMap definition.VolumeMap) (string, error) {
+	volumeDef := volumeMap.Destination
+	if len(volumeMap.Options) > 0 {
+		volumeDef += ":" + strings.Join(volumeMap.Options, ",")
+	}
+	if volumeMap.Type == definition.VolumeMountTypeBind {
+		// get absolute path for host bind mounts
+		absPath, err := utils.ResolvePath(stack.Dir, volumeMap.Source)
+		if err != nil {
+			return "", fmt.Errorf("failed to get absolute path for bind mount '%s': %v", volumeMap.Source, err)
+		}
+		return absPath + ":" + volumeDef, nil
+	}
+	return stack.ResourceName(volumeMap.Source) + ".volume:" + volumeDef, nil
+}
@@ -0,0 +1,310 @@
+package quadlets
+
+// Tests the dependency graph systemd sees in the generated quadlets.
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/danecwalker/otari/internal/definition"
+	"github.com/danecwalker/otari/internal/rules"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// unitFile maps section -> key -> values of a parsed quadlet.
+type unitFile map[string]map[string][]string
+
+func parseUnit(t *testing.T, data []byte) unitFile {
+	t.Helper()
+	unit := make(unitFile)
+	section := ""
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = line[1 : len(line)-1]
+			unit[section] = make(map[string][]string)
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		require.True(t, ok, "invalid line %q", line)
+		require.NotEmpty(t, section, "key %q outside of a section", key)
+		unit[section][key] = append(unit[section][key], value)
+	}
+	require.NoError(t, scanner.Err())
+	return unit
+}
+
+// units returns the space separated unit names of a key.
+func (u unitFile) units(section, key string) []string {
+	var units []string
+	for _, value := range u[section][key] {
+		units = append(units, strings.Fields(value)...)
+	}
+	return units
+}
+
+func parseStack(t *testing.T, data string) *definition.Stack {
+	t.Helper()
+	stack, err := definition.Parse([]byte(data))
+	require.NoError(t, err)
+	stack.StackName = "test"
+	require.Empty(t, rules.Validate(stack))
+	return stack
+}
+
+func TestGenerateContainerDependencies(t *testing.T) {
+	stack := parseStack(t, `
+containers:
+  redis:
+    image: redis:7
+    networks:
+      - backend
+    volumes:
+      - redis-data:/data
+  web:
+    image: nginx:latest
+    depends:
+      - redis
+    networks:
+      - backend
+      - frontend
+    volumes:
+      - redis-data:/cache
+      - static:/static:ro
+  standalone:
+    image: alpine:latest
+networks:
+  backend:
+  frontend:
+volumes:
+  redis-data:
+  static:
+`)
+
+	tests := []struct {
+		container string
+		expected  []string
+	}{
+		{
+			container: "redis",
+			expected:  []string{"test-backend-network.service", "test-redis-data-volume.service"},
+		},
+		{
+			container: "web",
+			expected: []string{
+				"test-redis.service",
+				"test-backend-network.service",
+				"test-frontend-network.service",
+				"test-redis-data-volume.service",
+				"test-static-volume.service",
+			},
+		},
+		{
+			container: "standalone",
+			expected:  nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.container, func(t *testing.T) {
+			out, err := Generator().GenerateContainer(stack, tt.container)
+			require.NoError(t, err)
+			unit := parseUnit(t, out)
+
+			assert.Equal(t, tt.expected, unit.units("Unit", "Requires"))
+			assert.Equal(t, tt.expected, unit.units("Unit", "After"))
+		})
+	}
+}
+
+func TestGenerateContainerHostNetworkAndBindMount(t *testing.T) {
+	stack := parseStack(t, `
+containers:
+  monitor:
+    image: alpine:latest
+    networks:
+      - host-net
+    volumes:
+      - ./:/workspace
+networks:
+  host-net:
+    driver: host
+`)
+
+	out, err := Generator().GenerateContainer(stack, "monitor")
+	require.NoError(t, err)
+	unit := parseUnit(t, out)
+
+	assert.Empty(t, unit.units("Unit", "Requires"))
+	assert.Empty(t, unit.units("Unit", "After"))
+	assert.Equal(t, []string{"host"}, unit["Container"]["Network"])
+}
+
+func TestGeneratePodDependencies(t *testing.T) {
+	stack := parseStack(t, `
+containers:
+  db:
+    image: postgres:16
+    healthcheck:
+      test: pg_isready
+  app:
+    image: nginx:latest
+    pod: web
+    depends:
+      db:
+        condition: healthy
+    volumes:
+      - uploads:/uploads
+pods:
+  web:
+    ports:
+      - '8080:80'
+    networks:
+      - backend
+networks:
+  backend:
+volumes:
+  uploads:
+`)
+
+	out, err := Generator().GenerateContainer(stack, "app")
+	require.NoError(t, err)
+	unit := parseUnit(t, out)
+
+	expected := []string{"test-db.service", "test-web-pod.service", "test-uploads-volume.service"}
+	assert.Equal(t, expected, unit.units("Unit", "Requires"))
+	assert.Equal(t, expected, unit.units("Unit", "After"))
+	assert.Equal(t, []string{"test-web.pod"}, unit["Container"]["Pod"])
+	assert.Empty(t, unit["Container"]["Network"])
+
+	out, err = Generator().GeneratePod(stack, "web")
+	require.NoError(t, err)
+	unit = parseUnit(t, out)
+
+	assert.Equal(t, []string{"test-backend-network.service"}, unit.units("Unit", "Requires"))
+	assert.Equal(t, []string{"test-backend-network.service"}, unit.units("Unit", "After"))
+	assert.Equal(t, []string{"test-backend.network"}, unit["Pod"]["Network"])
+	assert.Equal(t, []string{"0.0.0.0:8080:80/tcp"}, unit["Pod"]["PublishPort"])
+}
+
+func TestGenerateContainerHealthcheck(t *testing.T) {
+	stack := parseStack(t, `
+containers:
+  redis:
+    image: redis:7
+    healthcheck:
+      test: ["CMD-SHELL", "redis-cli ping || exit 1"]
+      interval: 10s
+      retries: 3
+`)
+
+	out, err := Generator().GenerateContainer(stack, "redis")
+	require.NoError(t, err)
+	unit := parseUnit(t, out)
+
+	assert.Equal(t, []string{"redis-cli ping || exit 1"}, unit["Container"]["HealthCmd"])
+	assert.Equal(t, []string{"10s"}, unit["Container"]["HealthInterval"])
+	assert.Equal(t, []string{"3"}, unit["Container"]["HealthRetries"])
+	assert.Equal(t, []string{"healthy"}, unit["Container"]["Notify"])
+
+	// arguments of the CMD form keep their boundaries
+	stack = parseStack(t, `
+containers:
+  web:
+    image: nginx:1.27
+    healthcheck:
+      test: ["CMD", "sh", "-c", "echo a b", "it's"]
+`)
+	out, err = Generator().GenerateContainer(stack, "web")
+	require.NoError(t, err)
+	unit = parseUnit(t, out)
+	assert.Equal(t, []string{`sh -c 'echo a b' 'it'\''s'`}, unit["Container"]["HealthCmd"])
+}
+
+func TestGenerateContainerIsStable(t *testing.T) {
+	stack := parseStack(t, `
+containers:
+  web:
+    image: nginx:1.27
+    environment:
+      E: "5"
+      A: "1"
+      D: "4"
+      B: "2"
+      C: "3"
+    labels:
+      team: web
+      app: shop
+      tier: front
+      env: prod
+      owner: ops
+`)
+
+	first, err := Generator().GenerateContainer(stack, "web")
+	require.NoError(t, err)
+	for range 10 {
+		out, err := Generator().GenerateContainer(stack, "web")
+		require.NoError(t, err)
+		require.Equal(t, string(first), string(out))
+	}
+
+	unit := parseUnit(t, first)
+	assert.Equal(t, []string{"A=1", "B=2", "C=3", "D=4", "E=5"}, unit["Container"]["Environment"])
+	assert.Equal(t, []string{"otari.stack=test", "app=shop", "env=prod", "owner=ops", "team=web", "tier=front"}, unit["Container"]["Label"])
+}
+
+func TestGenerateNamespacedNames(t *testing.T) {
+	data := `
+containers:
+  redis:
+    image: redis:7
+    networks:
+      - backend
+    volumes:
+      - data:/data
+    secrets:
+      - password
+networks:
+  backend:
+volumes:
+  data:
+secrets:
+  password:
+    environment: REDIS_PASSWORD
+`
+
+	stack := parseStack(t, data)
+	out, err := Generator().GenerateContainer(stack, "redis")
+	require.NoError(t, err)
+	unit := parseUnit(t, out)
+
+	assert.Equal(t, []string{"test-redis"}, unit["Container"]["ContainerName"])
+	assert.Equal(t, []string{"test-backend.network"}, unit["Container"]["Network"])
+	assert.Equal(t, []string{"test-data.volume:/data"}, unit["Container"]["Volume"])
+	assert.Equal(t, []string{"test-password,type=mount"}, unit["Container"]["Secret"])
+	assert.Equal(t, []string{"otari.stack=test"}, unit["Container"]["Label"])
+
+	out, err = Generator().GenerateNetwork(stack, "backend")
+	require.NoError(t, err)
+	unit = parseUnit(t, out)
+	assert.Equal(t, []string{"test-backend"}, unit["Network"]["NetworkName"])
+	assert.Equal(t, []string{"otari.stack=test"}, unit["Network"]["Label"])
+
+	// stacks deployed before namespacing keep their names
+	stack = parseStack(t, data)
+	stack.NameTemplate = definition.LegacyNameTemplate
+	out, err = Generator().GenerateContainer(stack, "redis")
+	require.NoError(t, err)
+	unit = parseUnit(t, out)
+
+	assert.Equal(t, []string{"redis"}, unit["Container"]["ContainerName"])
+	assert.Equal(t, []string{"backend-network.service", "data-volume.service"}, unit.units("Unit", "Requires"))
+	assert.Equal(t, []string{"backend.network"}, unit["Container"]["Network"])
+}
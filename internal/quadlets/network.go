@@ -26,7 +26,8 @@ func (q *QuadletGenerator) GenerateNetwork(stack *definition.Stack, networkName
 	}
 
 	networkProperties := [][2]string{
-		{"NetworkName", network.NetworkName},
+		{"NetworkName", stack.ResourceName(network.NetworkName)},
+		{"Label", stack.StackLabelValue()},
 	}
 
 	if network.Driver != definition.NetworkDriverHost && network.Driver != "" {
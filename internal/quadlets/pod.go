@@ -0,0 +1,90 @@
+package quadlets
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/danecwalker/otari/internal/definition"
+	"github.com/danecwalker/otari/internal/utils"
+)
+
+// GeneratePod implements generate.Generator.
+func (q *QuadletGenerator) GeneratePod(stack *definition.Stack, podName string) ([]byte, error) {
+	pod, exists := stack.Pods[podName]
+	if !exists {
+		return nil, fmt.Errorf("pod '%s' not found in stack", podName)
+	}
+
+	unitDefinition := [][2]string{
+		{"Description", fmt.Sprintf("%s pod", pod.PodName)},
+	}
+
+	var deps unitDependencies
+	deps.addNetworks(stack, pod.Networks)
+	deps.addVolumes(stack, pod.Volumes)
+	unitDefinition = append(unitDefinition, deps.unitProperties()...)
+
+	var buf bytes.Buffer
+	err := utils.WriteSection(&buf, "Unit", unitDefinition)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := utils.WriteEmptyLine(&buf); err != nil {
+		return nil, err
+	}
+
+	podProperties := [][2]string{
+		{"PodName", stack.ResourceName(pod.PodName)},
+		// pod quadlets have no Label key
+		{"PodmanArgs", "--label " + stack.StackLabelValue()},
+	}
+
+	for _, port := range pod.Ports {
+		podProperties = append(podProperties, [2]string{
+			"PublishPort", port.String(),
+		})
+	}
+
+	for _, network := range networkValues(stack, pod.Networks) {
+		podProperties = append(podProperties, [2]string{
+			"Network", network,
+		})
+	}
+
+	for _, volumeMap := range pod.Volumes {
+		volumeDef, err := volumeValue(stack, volumeMap)
+		if err != nil {
+			return nil, err
+		}
+		podProperties = append(podProperties, [2]string{
+			"Volume", volumeDef,
+		})
+	}
+
+	if pod.UserNS != "" {
+		podProperties = append(podProperties, [2]string{
+			"UserNS", pod.UserNS,
+		})
+	}
+
+	err = utils.WriteSection(&buf, "Pod", podProperties)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := utils.WriteEmptyLine(&buf); err != nil {
+		return nil, err
+	}
+
+	installProperties := [][2]string{
+		{"WantedBy", "multi-user.target default.target"},
+	}
+
+	err = utils.WriteSection(&buf, "Install", installProperties)
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,74 @@
+package quadlets
+
+import (
+	"strings"
+
+	"github.com/danecwalker/otari/internal/definition"
+)
+
+// Service names of the units quadlet generates for each resource kind.
+
+func ContainerServiceName(name string) string {
+	return name + ".service"
+}
+
+func NetworkServiceName(name string) string {
+	return name + "-network.service"
+}
+
+func VolumeServiceName(name string) string {
+	return name + "-volume.service"
+}
+
+func PodServiceName(name string) string {
+	return name + "-pod.service"
+}
+
+// unitDependencies collects unit names in order without duplicates.
+type unitDependencies struct {
+	units []string
+	seen  map[string]struct{}
+}
+
+func (d *unitDependencies) add(unit string) {
+	if d.seen == nil {
+		d.seen = make(map[string]struct{})
+	}
+	if _, ok := d.seen[unit]; ok {
+		return
+	}
+	d.seen[unit] = struct{}{}
+	d.units = append(d.units, unit)
+}
+
+func (d *unitDependencies) addNetworks(stack *definition.Stack, networks []string) {
+	for _, network := range networks {
+		n, ok := stack.Networks[network]
+		if !ok || n.Driver == definition.NetworkDriverHost {
+			// podman provides the host network, there is no unit for it
+			continue
+		}
+		d.add(NetworkServiceName(stack.ResourceName(network)))
+	}
+}
+
+func (d *unitDependencies) addVolumes(stack *definition.Stack, volumes []definition.VolumeMap) {
+	for _, volumeMap := range volumes {
+		if volumeMap.Type == definition.VolumeMountTypeBind {
+			continue
+		}
+		d.add(VolumeServiceName(stack.ResourceName(volumeMap.Source)))
+	}
+}
+
+// unitProperties returns the Requires/After entries for the [Unit] section.
+func (d *unitDependencies) unitProperties() [][2]string {
+	if len(d.units) == 0 {
+		return nil
+	}
+	units := strings.Join(d.units, " ")
+	return [][2]string{
+		{"Requires", units},
+		{"After", units},
+	}
+}
@@ -27,7 +27,8 @@ func (q *QuadletGenerator) GenerateVolume(stack *definition.Stack, volumeName st
 	}
 
 	networkProperties := [][2]string{
-		{"VolumeName", volume.VolumeName},
+		{"VolumeName", stack.ResourceName(volume.VolumeName)},
+		{"Label", stack.StackLabelValue()},
 	}
 
 	err = utils.WriteSection(&buf, "Volume", networkProperties)
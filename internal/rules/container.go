@@ -3,6 +3,7 @@ package rules
 import (
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/danecwalker/otari/internal/definition"
 )
@@ -46,10 +47,10 @@ func ValidateDuplicateEnvironmentVariables(s *definition.Stack) []*RuleError {
 func ValidateDependencyExistence(s *definition.Stack) []*RuleError {
 	var errors []*RuleError
 	for _, container := range s.Containers {
-		for _, depName := range container.Depends {
-			if _, exists := s.Containers[depName]; !exists {
+		for _, dep := range container.Depends {
+			if _, exists := s.Containers[dep.Name]; !exists {
 				errors = append(errors, &RuleError{
-					Message: "Container '" + container.ContainerName + "' has undefined dependency '" + depName + "'.",
+					Message: "Container '" + container.ContainerName + "' has undefined dependency '" + dep.Name + "'.",
 				})
 			}
 		}
@@ -60,11 +61,7 @@ func ValidateDependencyExistence(s *definition.Stack) []*RuleError {
 func ValidateCircularDependencies(s *definition.Stack) []*RuleError {
 	var errors []*RuleError
 
-	// Build adjacency: containerName -> []dependsOnNames
-	deps := make(map[string][]string, len(s.Containers))
-	for cname, c := range s.Containers {
-		deps[cname] = append([]string(nil), c.Depends...)
-	}
+	deps := DependencyGraph(s)
 
 	// 0 = unvisited, 1 = visiting, 2 = done
 	const (
@@ -128,3 +125,105 @@ func ValidateCircularDependencies(s *definition.Stack) []*RuleError {
 
 	return errors
 }
+
+func ValidateDependencyConditions(s *definition.Stack) []*RuleError {
+	var errors []*RuleError
+	for _, container := range s.Containers {
+		for _, dep := range container.Depends {
+			switch dep.Condition {
+			case definition.DependencyConditionStarted, definition.DependencyConditionCompletedSuccessfully:
+			case definition.DependencyConditionHealthy:
+				target, exists := s.Containers[dep.Name]
+				if exists && (target.Healthcheck == nil || target.Healthcheck.IsDisabled()) {
+					errors = append(errors, &RuleError{
+						Message: "Container '" + container.ContainerName + "' waits for '" + dep.Name + "' to be healthy, but '" + dep.Name + "' has no healthcheck.",
+					})
+				}
+			default:
+				errors = append(errors, &RuleError{
+					Message: "Container '" + container.ContainerName + "' uses unknown condition '" + string(dep.Condition) + "' for dependency '" + dep.Name + "'.",
+				})
+			}
+		}
+	}
+	return errors
+}
+
+func ValidateHealthchecks(s *definition.Stack) []*RuleError {
+	var errors []*RuleError
+
+	for _, container := range s.Containers {
+		hc := container.Healthcheck
+		if hc == nil || hc.IsDisabled() {
+			continue
+		}
+
+		if hc.Command() == "" {
+			errors = append(errors, &RuleError{
+				Message: "Container '" + container.ContainerName + "' defines a healthcheck without a test command.",
+			})
+		}
+
+		durations := []struct {
+			key   string
+			value time.Duration
+		}{
+			{"interval", hc.Interval},
+			{"timeout", hc.Timeout},
+			{"start_period", hc.StartPeriod},
+		}
+		for _, d := range durations {
+			if d.value < 0 {
+				errors = append(errors, &RuleError{
+					Message: "Container '" + container.ContainerName + "' has a negative healthcheck " + d.key + " '" + d.value.String() + "'.",
+				})
+			} else if d.value > 0 && d.value < time.Second {
+				errors = append(errors, &RuleError{
+					Message: "Container '" + container.ContainerName + "' has a healthcheck " + d.key + " '" + d.value.String() + "' shorter than 1s.",
+				})
+			}
+		}
+
+		if hc.Interval > 0 && hc.Timeout > hc.Interval {
+			errors = append(errors, &RuleError{
+				Message: "Container '" + container.ContainerName + "' has a healthcheck timeout longer than its interval.",
+			})
+		}
+
+		// podman only has a separate startup healthcheck, which runs a
+		// command of its own and replaces the regular one until it passes
+		if hc.StartInterval != 0 {
+			errors = append(errors, &RuleError{
+				Message: "Container '" + container.ContainerName + "' sets a healthcheck start_interval, which podman does not support. Its startup healthcheck is a separate check with a command of its own, remove start_interval and use start_period instead.",
+			})
+		}
+
+		if hc.Retries < 0 {
+			errors = append(errors, &RuleError{
+				Message: "Container '" + container.ContainerName + "' has a negative healthcheck retries value.",
+			})
+		}
+	}
+
+	return errors
+}
+
+func ValidatePullPolicies(s *definition.Stack) []*RuleError {
+	var errors []*RuleError
+	for _, container := range s.Containers {
+		switch container.EffectivePullPolicy() {
+		case definition.PullMissing, definition.PullAlways, definition.PullNewer, definition.PullNever:
+		default:
+			errors = append(errors, &RuleError{
+				Message: "Container '" + container.ContainerName + "' has unknown pull policy '" + string(container.PullPolicy) + "', expected 'always', 'missing', 'newer' or 'never'.",
+			})
+			continue
+		}
+		if container.PullPolicy != "" && container.Build != nil {
+			errors = append(errors, &RuleError{
+				Message: "Container '" + container.ContainerName + "' sets a pull policy but builds its image.",
+			})
+		}
+	}
+	return errors
+}
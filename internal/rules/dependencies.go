@@ -0,0 +1,106 @@
+package rules
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/danecwalker/otari/internal/definition"
+)
+
+// DependencyGraph returns the adjacency list of the stack's containers,
+// mapping each container name to the names of the containers it depends on.
+func DependencyGraph(s *definition.Stack) map[string][]string {
+	deps := make(map[string][]string, len(s.Containers))
+	for cname, c := range s.Containers {
+		deps[cname] = c.Depends.Names()
+	}
+	return deps
+}
+
+// DependencyLayers orders the stack's containers topologically. Every
+// container in a layer only depends on containers in earlier layers, so
+// layers can be started in order and stopped in reverse. Names within a
+// layer are sorted.
+func DependencyLayers(s *definition.Stack) ([][]string, error) {
+	deps := DependencyGraph(s)
+
+	remaining := make(map[string]int, len(deps))
+	dependents := make(map[string][]string, len(deps))
+	for name, ds := range deps {
+		for _, dep := range ds {
+			// unknown dependencies are reported by ValidateDependencyExistence
+			if _, ok := deps[dep]; !ok {
+				continue
+			}
+			remaining[name]++
+			dependents[dep] = append(dependents[dep], name)
+		}
+	}
+
+	var layer []string
+	for name := range deps {
+		if remaining[name] == 0 {
+			layer = append(layer, name)
+		}
+	}
+
+	var layers [][]string
+	visited := 0
+	for len(layer) > 0 {
+		sort.Strings(layer)
+		layers = append(layers, layer)
+		visited += len(layer)
+
+		var next []string
+		for _, name := range layer {
+			for _, dependent := range dependents[name] {
+				remaining[dependent]--
+				if remaining[dependent] == 0 {
+					next = append(next, dependent)
+				}
+			}
+		}
+		layer = next
+	}
+
+	if visited != len(deps) {
+		var cyclic []string
+		for name, n := range remaining {
+			if n > 0 {
+				cyclic = append(cyclic, name)
+			}
+		}
+		sort.Strings(cyclic)
+		return nil, fmt.Errorf("circular dependency between containers: %s", strings.Join(cyclic, ", "))
+	}
+
+	return layers, nil
+}
+
+// StartOrder returns the stack's containers in an order that respects their
+// dependencies.
+func StartOrder(s *definition.Stack) ([]string, error) {
+	layers, err := DependencyLayers(s)
+	if err != nil {
+		return nil, err
+	}
+	var order []string
+	for _, layer := range layers {
+		order = append(order, layer...)
+	}
+	return order, nil
+}
+
+// StopOrder returns the stack's containers with dependents before the
+// containers they depend on.
+func StopOrder(s *definition.Stack) ([]string, error) {
+	order, err := StartOrder(s)
+	if err != nil {
+		return nil, err
+	}
+	for i, j := 0, len(order)-1; i < j; i, j = i+1, j-1 {
+		order[i], order[j] = order[j], order[i]
+	}
+	return order, nil
+}
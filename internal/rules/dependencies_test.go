@@ -0,0 +1,67 @@
+package rules
+
+import (
+	"testing"
+
+	"github.com/danecwalker/otari/internal/definition"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDependencyLayers(t *testing.T) {
+	data := `
+containers:
+  web:
+    image: nginx
+    depends:
+      - api
+  api:
+    image: api
+    depends:
+      db:
+        condition: healthy
+      migrate:
+        condition: completed_successfully
+  migrate:
+    image: api
+    depends:
+      - db
+  db:
+    image: postgres
+  cache:
+    image: redis
+`
+	stack, err := definition.Parse([]byte(data))
+	assert.NoError(t, err)
+
+	layers, err := DependencyLayers(stack)
+	assert.NoError(t, err)
+	assert.Equal(t, [][]string{
+		{"cache", "db"},
+		{"migrate"},
+		{"api"},
+		{"web"},
+	}, layers)
+
+	stop, err := StopOrder(stack)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"web", "api", "migrate", "db", "cache"}, stop)
+}
+
+func TestDependencyLayersCycle(t *testing.T) {
+	data := `
+containers:
+  a:
+    image: a
+    depends: [b]
+  b:
+    image: b
+    depends: [a]
+  c:
+    image: c
+`
+	stack, err := definition.Parse([]byte(data))
+	assert.NoError(t, err)
+
+	_, err = DependencyLayers(stack)
+	assert.EqualError(t, err, "circular dependency between containers: a, b")
+}
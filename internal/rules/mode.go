@@ -0,0 +1,32 @@
+package rules
+
+import "github.com/danecwalker/otari/internal/definition"
+
+func ValidateDeployMode(s *definition.Stack) []*RuleError {
+	var errors []*RuleError
+
+	switch s.EffectiveMode() {
+	case definition.DeployModeQuadlet:
+		return nil
+	case definition.DeployModeKube:
+	default:
+		errors = append(errors, &RuleError{
+			Message: "Unknown deploy mode '" + string(s.Mode) + "', expected 'quadlet' or 'kube'.",
+		})
+		return errors
+	}
+
+	// podman kube play starts every pod at once, it cannot wait for a
+	// dependency to become healthy or to complete
+	for _, container := range s.Containers {
+		for _, dep := range container.Depends {
+			if dep.Condition != definition.DependencyConditionStarted {
+				errors = append(errors, &RuleError{
+					Message: "Container '" + container.ContainerName + "' waits for '" + dep.Name + "' to be " + string(dep.Condition) + ", which kube mode does not support.",
+				})
+			}
+		}
+	}
+
+	return errors
+}
@@ -0,0 +1,61 @@
+package rules
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/danecwalker/otari/internal/definition"
+)
+
+// resourceNamePattern matches the names podman accepts for containers,
+// networks, volumes, pods and secrets.
+var resourceNamePattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+// ValidateStackName checks that the stack name can prefix resource names
+// and name the lock file of the stack.
+func ValidateStackName(s *definition.Stack) []*RuleError {
+	if resourceNamePattern.MatchString(s.StackName) {
+		return nil
+	}
+	return []*RuleError{{
+		Message: "Stack name '" + s.StackName + "' is invalid, it may only contain letters, digits, '_', '.' and '-'. Set 'name' in the stack file.",
+	}}
+}
+
+func ValidateNameTemplate(s *definition.Stack) []*RuleError {
+	var errors []*RuleError
+	template := s.EffectiveNameTemplate()
+
+	if !strings.Contains(template, "{name}") {
+		errors = append(errors, &RuleError{
+			Message: "Name template '" + template + "' must contain '{name}'.",
+		})
+		return errors
+	}
+
+	check := func(kind, name string) {
+		resourceName := s.ResourceName(name)
+		if !resourceNamePattern.MatchString(resourceName) {
+			errors = append(errors, &RuleError{
+				Message: "Name template '" + template + "' produces invalid " + kind + " name '" + resourceName + "'.",
+			})
+		}
+	}
+	for name := range s.Containers {
+		check("container", name)
+	}
+	for name := range s.Networks {
+		check("network", name)
+	}
+	for name := range s.Volumes {
+		check("volume", name)
+	}
+	for name := range s.Pods {
+		check("pod", name)
+	}
+	for name := range s.Secrets {
+		check("secret", name)
+	}
+
+	return errors
+}
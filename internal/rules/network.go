@@ -41,32 +41,28 @@ func ValidatePortConflicts(s *definition.Stack) []*RuleError {
 	var errors []*RuleError
 	portSet := make(map[int]string)
 
-	for _, container := range s.Containers {
-		for _, port := range container.Ports {
+	checkPorts := func(owner string, ports []definition.PortMap) {
+		for _, port := range ports {
 			hostPort := port.HostPort
-			if hostPort.Range {
-				for p := hostPort.Start; p <= hostPort.End; p++ {
-					if existingContainer, exists := portSet[p]; exists {
-						errors = append(errors, &RuleError{
-							Message: "Port conflict on port " + fmt.Sprint(p) + " between containers '" + existingContainer + "' and '" + container.ContainerName + "'.",
-						})
-					} else {
-						portSet[p] = container.ContainerName
-					}
-				}
-			} else {
-				p := hostPort.Start
-				if existingContainer, exists := portSet[p]; exists {
+			for p := hostPort.Start; p <= hostPort.End; p++ {
+				if existing, exists := portSet[p]; exists {
 					errors = append(errors, &RuleError{
-						Message: "Port conflict on port " + fmt.Sprint(p) + " between containers '" + existingContainer + "' and '" + container.ContainerName + "'.",
+						Message: "Port conflict on port " + fmt.Sprint(p) + " between " + existing + " and " + owner + ".",
 					})
 				} else {
-					portSet[p] = container.ContainerName
+					portSet[p] = owner
 				}
 			}
 		}
 	}
 
+	for _, container := range s.Containers {
+		checkPorts("container '"+container.ContainerName+"'", container.Ports)
+	}
+	for _, pod := range s.Pods {
+		checkPorts("pod '"+pod.PodName+"'", pod.Ports)
+	}
+
 	return errors
 }
 
@@ -0,0 +1,76 @@
+package rules
+
+import (
+	"github.com/danecwalker/otari/internal/definition"
+)
+
+func ValidateContainerPodExistence(s *definition.Stack) []*RuleError {
+	var errors []*RuleError
+	for _, container := range s.Containers {
+		if container.Pod == "" {
+			continue
+		}
+		if _, exists := s.Pods[container.Pod]; !exists {
+			errors = append(errors, &RuleError{
+				Message: "Container '" + container.ContainerName + "' references undefined pod '" + container.Pod + "'.",
+			})
+		}
+	}
+	return errors
+}
+
+func ValidatePodContainerPorts(s *definition.Stack) []*RuleError {
+	var errors []*RuleError
+	for _, container := range s.Containers {
+		if container.Pod == "" {
+			continue
+		}
+		if len(container.Ports) > 0 {
+			errors = append(errors, &RuleError{
+				Message: "Container '" + container.ContainerName + "' is part of pod '" + container.Pod + "' and cannot define its own ports; publish them on the pod instead.",
+			})
+		}
+		if len(container.Networks) > 0 {
+			errors = append(errors, &RuleError{
+				Message: "Container '" + container.ContainerName + "' is part of pod '" + container.Pod + "' and cannot join its own networks; attach them to the pod instead.",
+			})
+		}
+	}
+	return errors
+}
+
+func ValidatePodNetworkExistence(s *definition.Stack) []*RuleError {
+	var errors []*RuleError
+	for _, pod := range s.Pods {
+		for _, networkName := range pod.Networks {
+			if _, exists := s.Networks[networkName]; !exists {
+				errors = append(errors, &RuleError{
+					Message: "Pod '" + pod.PodName + "' references undefined network '" + networkName + "'.",
+				})
+			}
+		}
+	}
+	return errors
+}
+
+func ValidatePodVolumeExistence(s *definition.Stack) []*RuleError {
+	var errors []*RuleError
+	for pname, pod := range s.Pods {
+		for i, volumeMap := range pod.Volumes {
+			volumeName := volumeMap.Source
+			if _, exists := s.Volumes[volumeName]; !exists {
+				if isHostPath(volumeName) {
+					if hostPathExists(s, volumeName) {
+						// change volume mount type to bind mount
+						s.Pods[pname].Volumes[i].Type = definition.VolumeMountTypeBind
+						continue
+					}
+				}
+				errors = append(errors, &RuleError{
+					Message: "Pod '" + pod.PodName + "' references undefined volume '" + volumeName + "'.",
+				})
+			}
+		}
+	}
+	return errors
+}
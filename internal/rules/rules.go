@@ -33,6 +33,18 @@ func GetDefaultRules() []Rule {
 		RuleFunc(ValidateDuplicateVolumeMountsPerContainer),
 		RuleFunc(ValidateDependencyExistence),
 		RuleFunc(ValidateCircularDependencies),
+		RuleFunc(ValidateDependencyConditions),
+		RuleFunc(ValidateHealthchecks),
+		RuleFunc(ValidatePullPolicies),
+		RuleFunc(ValidateContainerPodExistence),
+		RuleFunc(ValidatePodContainerPorts),
+		RuleFunc(ValidatePodNetworkExistence),
+		RuleFunc(ValidatePodVolumeExistence),
+		RuleFunc(ValidateSecretSources),
+		RuleFunc(ValidateContainerSecrets),
+		RuleFunc(ValidateStackName),
+		RuleFunc(ValidateNameTemplate),
+		RuleFunc(ValidateDeployMode),
 	}
 }
 
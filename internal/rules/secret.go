@@ -0,0 +1,73 @@
+package rules
+
+import (
+	"strings"
+
+	"github.com/danecwalker/otari/internal/definition"
+)
+
+func ValidateSecretSources(s *definition.Stack) []*RuleError {
+	var errors []*RuleError
+	var stdinSecrets []string
+
+	for _, secret := range s.Secrets {
+		sources := 0
+		if secret.File != "" {
+			sources++
+		}
+		if secret.Environment != "" {
+			sources++
+		}
+		if secret.Stdin {
+			sources++
+			stdinSecrets = append(stdinSecrets, secret.SecretName)
+		}
+
+		if sources != 1 {
+			errors = append(errors, &RuleError{
+				Message: "Secret '" + secret.SecretName + "' must define exactly one of 'file', 'environment' or 'stdin'.",
+			})
+		}
+	}
+
+	if len(stdinSecrets) > 1 {
+		errors = append(errors, &RuleError{
+			Message: "Only one secret can be read from stdin, found: " + strings.Join(stdinSecrets, ", ") + ".",
+		})
+	}
+
+	return errors
+}
+
+func ValidateContainerSecrets(s *definition.Stack) []*RuleError {
+	var errors []*RuleError
+	for _, container := range s.Containers {
+		for _, ref := range container.Secrets {
+			if _, exists := s.Secrets[ref.Source]; !exists {
+				errors = append(errors, &RuleError{
+					Message: "Container '" + container.ContainerName + "' references undefined secret '" + ref.Source + "'.",
+				})
+			}
+
+			switch ref.Type {
+			case definition.SecretTypeMount:
+			case definition.SecretTypeEnv:
+				if ref.Target == "" {
+					errors = append(errors, &RuleError{
+						Message: "Container '" + container.ContainerName + "' exposes secret '" + ref.Source + "' as an environment variable without a target.",
+					})
+				}
+				if _, exists := container.Environment[ref.Target]; exists {
+					errors = append(errors, &RuleError{
+						Message: "Container '" + container.ContainerName + "' sets environment variable '" + ref.Target + "' both directly and from secret '" + ref.Source + "'.",
+					})
+				}
+			default:
+				errors = append(errors, &RuleError{
+					Message: "Container '" + container.ContainerName + "' uses unknown secret type '" + string(ref.Type) + "' for secret '" + ref.Source + "'.",
+				})
+			}
+		}
+	}
+	return errors
+}
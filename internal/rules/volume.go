@@ -6,6 +6,7 @@ import (
 	"strings"
 
 	"github.com/danecwalker/otari/internal/definition"
+	"github.com/danecwalker/otari/internal/utils"
 )
 
 func ValidateVolumeNames(s *definition.Stack) []*RuleError {
@@ -49,6 +50,13 @@ func isHostPath(p string) bool {
 	return false
 }
 
+// hostPathExists reports whether a host path exists, relative to the stack
+// file.
+func hostPathExists(s *definition.Stack, p string) bool {
+	absPath, err := utils.ResolvePath(s.Dir, p)
+	return err == nil && utils.PathExists(absPath)
+}
+
 func ValidateContainerVolumeExistence(s *definition.Stack) []*RuleError {
 	var errors []*RuleError
 	for cname, container := range s.Containers {
@@ -58,7 +66,7 @@ func ValidateContainerVolumeExistence(s *definition.Stack) []*RuleError {
 				// Also check for the case where the volume is specified as a host path
 				if isHostPath(volumeName) {
 					// check if the path exists on the host
-					if _, err := os.Stat(volumeName); err == nil {
+					if hostPathExists(s, volumeName) {
 						// change volume mount type to bind mount
 						s.Containers[cname].Volumes[i].Type = definition.VolumeMountTypeBind
 						continue
@@ -1,8 +1,10 @@
 package spinners
 
 import (
+	"io"
 	"time"
 
+	"github.com/danecwalker/otari/internal/output"
 	"github.com/danecwalker/otari/pkg/spinner"
 	"github.com/fatih/color"
 )
@@ -15,7 +17,34 @@ func DefaultSpinner() *spinner.Spinner {
 		spinner.WithSuccessSymbol(color.New(color.FgGreen, color.Bold).Sprint("[+]")),
 		spinner.WithErrorSymbol(color.New(color.FgRed, color.Bold).Sprint("[x]")),
 		spinner.WithInfoSymbol(color.New(color.FgCyan, color.Bold).Sprint("[i]")),
+		spinner.WithPlain(output.Current() != output.Human),
+		spinner.WithOutput(writer()),
 	)
 	sp.Enable(100 * time.Millisecond)
 	return sp
 }
+
+// DefaultGroup returns a group of spinners styled like DefaultSpinner, for
+// steps that run at the same time.
+func DefaultGroup() *spinner.Group {
+	g := spinner.NewGroup(
+		[]string{
+			"[|]", "[/]", "[-]", "[\\]",
+		},
+		spinner.WithSuccessSymbol(color.New(color.FgGreen, color.Bold).Sprint("[+]")),
+		spinner.WithErrorSymbol(color.New(color.FgRed, color.Bold).Sprint("[x]")),
+		spinner.WithInfoSymbol(color.New(color.FgCyan, color.Bold).Sprint("[i]")),
+		spinner.WithPlain(output.Current() != output.Human),
+		spinner.WithOutput(writer()),
+	)
+	g.Enable(100 * time.Millisecond)
+	return g
+}
+
+// writer returns where spinners write to, nowhere when output is quiet.
+func writer() io.Writer {
+	if output.Quiet() {
+		return io.Discard
+	}
+	return output.Text()
+}
@@ -0,0 +1,174 @@
+// Package stacklock keeps two otari runs from changing the same stack at
+// once. A run holds an advisory lock on a file per stack, which also names
+// the process holding it. The lock is separate from the lock file that
+// pins the state of a stack.
+package stacklock
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/BurntSushi/toml"
+)
+
+// ErrLocked is returned by Acquire if another process holds the lock.
+var ErrLocked = errors.New("stack is locked")
+
+// pollInterval is how often a waiting Acquire tries the lock again.
+var pollInterval = 100 * time.Millisecond
+
+// Holder is the process holding the lock of a stack.
+type Holder struct {
+	PID     int       `toml:"pid"`
+	Command string    `toml:"command"`
+	Since   time.Time `toml:"since"`
+}
+
+func (h *Holder) String() string {
+	return fmt.Sprintf("PID %d (%s) since %s", h.PID, h.Command, h.Since.Local().Format(time.DateTime))
+}
+
+// LockedError is returned by Acquire if another process holds the lock,
+// Holder is nil if it could not be read.
+type LockedError struct {
+	Stack  string
+	Holder *Holder
+	// Stale is set if the holder is no longer running, a process it started
+	// may have inherited the lock.
+	Stale bool
+}
+
+func (e *LockedError) Error() string {
+	if e.Holder == nil {
+		return fmt.Sprintf("stack '%s' is locked by another otari process", e.Stack)
+	}
+	msg := fmt.Sprintf("stack '%s' is locked by %s", e.Stack, e.Holder)
+	if e.Stale {
+		msg += ", which is no longer running"
+	}
+	return msg
+}
+
+func (e *LockedError) Unwrap() error {
+	return ErrLocked
+}
+
+// Lock is a held stack lock.
+type Lock struct {
+	f *os.File
+	// Stale is the holder of a lock that was not released, because the
+	// process holding it ended without releasing it.
+	Stale *Holder
+}
+
+// Path returns the file locked for a stack, inside the state directory of
+// the stack.
+func Path(stateDir, stackName string) string {
+	return filepath.Join(stateDir, ".otari", "locks", stackName+".lock")
+}
+
+// Acquire locks the stack for command. If another process holds the lock
+// it returns a LockedError, unless wait is set: then it waits until the
+// lock is released, timeout passed or ctx ends. A timeout of 0 waits
+// without limit. waiting is called once with the holder when it starts to
+// wait.
+func Acquire(ctx context.Context, stateDir, stackName, command string, wait bool, timeout time.Duration, waiting func(err *LockedError)) (*Lock, error) {
+	path := Path(stateDir, stackName)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	for notified := false; ; {
+		locked, err := tryLock(f)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		if locked {
+			break
+		}
+
+		lockedErr := &LockedError{Stack: stackName, Holder: readHolder(f)}
+		lockedErr.Stale = lockedErr.Holder != nil && !running(lockedErr.Holder.PID)
+		if !wait {
+			f.Close()
+			return nil, lockedErr
+		}
+		if !notified && waiting != nil {
+			waiting(lockedErr)
+			notified = true
+		}
+		select {
+		case <-time.After(pollInterval):
+		case <-ctx.Done():
+			f.Close()
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return nil, fmt.Errorf("timed out after %s waiting for the lock: %w", timeout, lockedErr)
+			}
+			return nil, ctx.Err()
+		}
+	}
+
+	// a holder left behind was not released by its process
+	l := &Lock{f: f, Stale: readHolder(f)}
+	holder := Holder{PID: os.Getpid(), Command: command, Since: time.Now().UTC().Truncate(time.Second)}
+	var buf bytes.Buffer
+	if err := toml.NewEncoder(&buf).Encode(holder); err != nil {
+		l.Release()
+		return nil, err
+	}
+	if err := f.Truncate(0); err != nil {
+		l.Release()
+		return nil, err
+	}
+	if _, err := f.WriteAt(buf.Bytes(), 0); err != nil {
+		l.Release()
+		return nil, err
+	}
+	return l, nil
+}
+
+// Release clears the holder and releases the lock.
+func (l *Lock) Release() error {
+	if l == nil || l.f == nil {
+		return nil
+	}
+	// the holder is cleared first, another process could lock it right
+	// after it is released
+	err := l.f.Truncate(0)
+	err = errors.Join(err, unlock(l.f), l.f.Close())
+	l.f = nil
+	return err
+}
+
+// readHolder reads the holder written to the lock file, nil if there is
+// none.
+func readHolder(f *os.File) *Holder {
+	info, err := f.Stat()
+	if err != nil || info.Size() == 0 {
+		return nil
+	}
+	data := make([]byte, info.Size())
+	if _, err := f.ReadAt(data, 0); err != nil {
+		return nil
+	}
+	var h Holder
+	if err := toml.Unmarshal(data, &h); err != nil || h.PID == 0 {
+		return nil
+	}
+	return &h
+}
@@ -0,0 +1,67 @@
+package stacklock
+
+// Tests taking, waiting for and taking over the lock of a stack.
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAcquire(t *testing.T) {
+	stateDir := t.TempDir()
+	ctx := context.Background()
+
+	lock, err := Acquire(ctx, stateDir, "demo", "otari start", false, 0, nil)
+	require.NoError(t, err)
+	assert.Nil(t, lock.Stale)
+
+	// a second open file is locked out like another process would be
+	_, err = Acquire(ctx, stateDir, "demo", "otari stop", false, 0, nil)
+	var lockedErr *LockedError
+	require.ErrorAs(t, err, &lockedErr)
+	assert.ErrorIs(t, err, ErrLocked)
+	require.NotNil(t, lockedErr.Holder)
+	assert.Equal(t, os.Getpid(), lockedErr.Holder.PID)
+	assert.Equal(t, "otari start", lockedErr.Holder.Command)
+	assert.False(t, lockedErr.Stale)
+
+	// other stacks are not affected
+	other, err := Acquire(ctx, stateDir, "other", "otari start", false, 0, nil)
+	require.NoError(t, err)
+	require.NoError(t, other.Release())
+
+	waited := 0
+	_, err = Acquire(ctx, stateDir, "demo", "otari stop", true, 50*time.Millisecond, func(*LockedError) { waited++ })
+	assert.ErrorIs(t, err, ErrLocked)
+	assert.Contains(t, err.Error(), "timed out")
+	assert.Equal(t, 1, waited)
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		lock.Release()
+	}()
+	next, err := Acquire(ctx, stateDir, "demo", "otari stop", true, 0, nil)
+	require.NoError(t, err)
+	assert.Nil(t, next.Stale)
+	require.NoError(t, next.Release())
+}
+
+func TestAcquireStale(t *testing.T) {
+	stateDir := t.TempDir()
+
+	// a holder that ended without releasing the lock
+	require.NoError(t, os.MkdirAll(filepath.Dir(Path(stateDir, "demo")), 0o755))
+	require.NoError(t, os.WriteFile(Path(stateDir, "demo"), []byte("pid = 999999999\ncommand = \"otari start\"\n"), 0o644))
+
+	lock, err := Acquire(context.Background(), stateDir, "demo", "otari stop", false, 0, nil)
+	require.NoError(t, err)
+	require.NotNil(t, lock.Stale)
+	assert.Equal(t, 999999999, lock.Stale.PID)
+	require.NoError(t, lock.Release())
+}
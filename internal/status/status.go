@@ -0,0 +1,317 @@
+package status
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/danecwalker/otari/internal/changes"
+	"github.com/danecwalker/otari/internal/definition"
+	"github.com/danecwalker/otari/internal/generate"
+	"github.com/danecwalker/otari/internal/kube"
+	"github.com/danecwalker/otari/internal/plan"
+	"github.com/danecwalker/otari/internal/podman"
+	"github.com/danecwalker/otari/internal/quadlets"
+	"github.com/danecwalker/otari/internal/systemd"
+)
+
+type Problem string
+
+const (
+	ProblemNone Problem = ""
+	// ProblemMissing marks a resource that is defined in the stack file but
+	// not deployed.
+	ProblemMissing Problem = "missing"
+	// ProblemUndefined marks a deployed resource that is no longer defined
+	// in the stack file.
+	ProblemUndefined Problem = "undefined"
+)
+
+// Container is the live state of a container as reported by podman.
+type Container struct {
+	Status        string    `json:"status"`
+	Running       bool      `json:"running"`
+	Health        string    `json:"health,omitempty"`
+	StartedAt     time.Time `json:"started_at"`
+	UptimeSeconds int64     `json:"uptime_seconds"`
+	RestartCount  int       `json:"restart_count"`
+	Ports         []string  `json:"ports,omitempty"`
+	ImageDigest   string    `json:"image_digest,omitempty"`
+}
+
+// Resource is the combined state of a single stack resource.
+type Resource struct {
+	Kind string `json:"kind"`
+	// Name is the name in the stack file, ResourceName the name podman
+	// knows the resource by.
+	Name         string `json:"name"`
+	ResourceName string `json:"resource_name"`
+	// Quadlet is the file name of the quadlet, empty for secrets.
+	Quadlet     string     `json:"quadlet,omitempty"`
+	Unit        string     `json:"unit,omitempty"`
+	Defined     bool       `json:"defined"`
+	Locked      bool       `json:"locked"`
+	Present     bool       `json:"present"`
+	ActiveState string     `json:"active_state,omitempty"`
+	SubState    string     `json:"sub_state,omitempty"`
+	Container   *Container `json:"container,omitempty"`
+	Problem     Problem    `json:"problem,omitempty"`
+}
+
+type Status struct {
+	Stack string `json:"stack"`
+	// Deployed is false if no lock file exists for the stack yet.
+	Deployed  bool        `json:"deployed"`
+	Resources []*Resource `json:"resources"`
+}
+
+// Problems returns the resources that are missing or undefined.
+func (s *Status) Problems() []*Resource {
+	var out []*Resource
+	for _, r := range s.Resources {
+		if r.Problem != ProblemNone {
+			out = append(out, r)
+		}
+	}
+	return out
+}
+
+// Probe queries systemd and podman for the live state of resources.
+type Probe interface {
+	UnitState(unitName string) (*systemd.UnitState, error)
+	InspectContainer(ctx context.Context, containerName string) (*podman.ContainerState, error)
+	SecretExists(ctx context.Context, secretName string) bool
+}
+
+type hostProbe struct {
+	runtime  podman.Runtime
+	services systemd.ServiceManager
+}
+
+// NewProbe returns a Probe backed by runtime and services, the podman and
+// systemd backends of the engine.
+func NewProbe(runtime podman.Runtime, services systemd.ServiceManager) Probe {
+	return &hostProbe{runtime: runtime, services: services}
+}
+
+func (p *hostProbe) UnitState(unitName string) (*systemd.UnitState, error) {
+	return p.services.GetUnitState(unitName)
+}
+
+func (p *hostProbe) InspectContainer(ctx context.Context, containerName string) (*podman.ContainerState, error) {
+	return p.runtime.InspectContainer(ctx, containerName)
+}
+
+func (p *hostProbe) SecretExists(ctx context.Context, secretName string) bool {
+	return p.runtime.SecretExists(ctx, secretName)
+}
+
+// Collect combines the stack definition, its lock file, the quadlets in
+// outputDir and the state reported by probe into a Status.
+func Collect(ctx context.Context, stack *definition.Stack, stackData *changes.StackData, outputDir string, probe Probe) (*Status, error) {
+	st := &Status{
+		Stack:    stack.StackName,
+		Deployed: stackData != nil,
+	}
+
+	labelled, err := labelledQuadlets(outputDir, stack)
+	if err != nil {
+		return nil, err
+	}
+
+	// resources no longer defined are known by the names they were deployed with
+	deployed := stack
+	if changes.Renamed(stack, stackData) {
+		deployed = &definition.Stack{
+			StackName:    stack.StackName,
+			NameTemplate: stackData.NameTemplate,
+		}
+	}
+
+	seen := make(map[string]struct{})
+	for _, kind := range plan.Kinds {
+		locked := stackData.Hashes(kind)
+
+		// defined resources
+		var names []string
+		if kind == plan.KindSecret {
+			for name := range stack.Secrets {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+		} else {
+			names = generate.Names(stack, kind)
+		}
+		for _, name := range names {
+			r := newResource(kind, name, stack.ResourceName(name))
+			seen[r.ResourceName+"."+kind] = struct{}{}
+			if stack.IsKube() {
+				kubeResource(stack, r)
+			}
+			r.Defined = true
+			_, r.Locked = locked[name]
+			probeResource(ctx, r, outputDir, probe)
+			if !r.Present || !r.Locked {
+				r.Problem = ProblemMissing
+			}
+			st.Resources = append(st.Resources, r)
+		}
+
+		// deployed resources that are no longer defined
+		var undefined []*Resource
+		for name := range locked {
+			resourceName := deployed.ResourceName(name)
+			if _, ok := seen[resourceName+"."+kind]; ok {
+				continue
+			}
+			if kind == generate.KindNetwork && generate.Has(stack, kind, name) {
+				// host networks have no quadlet
+				continue
+			}
+			r := newResource(kind, name, resourceName)
+			r.Locked = true
+			probeResource(ctx, r, outputDir, probe)
+			seen[resourceName+"."+kind] = struct{}{}
+			if r.Present {
+				r.Problem = ProblemUndefined
+				undefined = append(undefined, r)
+			}
+		}
+		for _, fileName := range labelled {
+			if filepath.Ext(fileName) != "."+kind {
+				continue
+			}
+			if _, ok := seen[fileName]; ok {
+				continue
+			}
+			resourceName := strings.TrimSuffix(fileName, "."+kind)
+			r := newResource(kind, resourceName, resourceName)
+			probeResource(ctx, r, outputDir, probe)
+			r.Problem = ProblemUndefined
+			undefined = append(undefined, r)
+		}
+		sort.Slice(undefined, func(i, j int) bool {
+			return undefined[i].Name < undefined[j].Name
+		})
+		st.Resources = append(st.Resources, undefined...)
+	}
+
+	return st, nil
+}
+
+func newResource(kind, name, resourceName string) *Resource {
+	r := &Resource{
+		Kind:         kind,
+		Name:         name,
+		ResourceName: resourceName,
+	}
+	switch kind {
+	case generate.KindNetwork:
+		r.Unit = quadlets.NetworkServiceName(resourceName)
+	case generate.KindVolume:
+		r.Unit = quadlets.VolumeServiceName(resourceName)
+	case generate.KindPod:
+		r.Unit = quadlets.PodServiceName(resourceName)
+	case generate.KindContainer:
+		r.Unit = quadlets.ContainerServiceName(resourceName)
+	}
+	if kind != plan.KindSecret {
+		r.Quadlet = resourceName + "." + kind
+	}
+	return r
+}
+
+// kubeResource points a resource that is part of the manifest of a kube
+// stack at the .kube unit running it and the name podman gives it.
+func kubeResource(stack *definition.Stack, r *Resource) {
+	switch r.Kind {
+	case generate.KindContainer:
+		r.ResourceName = kube.ContainerName(stack, r.Name)
+	case generate.KindPod, generate.KindVolume:
+	default:
+		return
+	}
+	r.Unit = kube.ServiceName(stack)
+	r.Quadlet = kube.ManifestFileName(stack)
+}
+
+func probeResource(ctx context.Context, r *Resource, outputDir string, probe Probe) {
+	if r.Kind == plan.KindSecret {
+		r.Present = probe.SecretExists(ctx, r.ResourceName)
+		return
+	}
+
+	if _, err := os.Stat(filepath.Join(outputDir, r.Quadlet)); err == nil {
+		r.Present = true
+	}
+
+	if state, err := probe.UnitState(r.Unit); err == nil && state.Exists() {
+		r.ActiveState = state.ActiveState
+		r.SubState = state.SubState
+	}
+
+	if r.Kind != generate.KindContainer {
+		return
+	}
+	state, err := probe.InspectContainer(ctx, r.ResourceName)
+	if err != nil {
+		return
+	}
+	r.Container = &Container{
+		Status:       state.Status,
+		Running:      state.Running,
+		Health:       state.Health,
+		StartedAt:    state.StartedAt,
+		RestartCount: state.RestartCount,
+		Ports:        state.Ports,
+		ImageDigest:  state.ImageDigest,
+	}
+	if state.Running && !state.StartedAt.IsZero() {
+		r.Container.UptimeSeconds = int64(time.Since(state.StartedAt).Seconds())
+	}
+}
+
+// labelledQuadlets returns the sorted file names of the quadlets in
+// outputDir that carry the stack label of the given stack.
+func labelledQuadlets(outputDir string, stack *definition.Stack) ([]string, error) {
+	entries, err := os.ReadDir(outputDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	label := stack.StackLabelValue()
+	var out []string
+	for _, entry := range entries {
+		if entry.IsDir() || !isQuadlet(entry.Name()) {
+			continue
+		}
+		content, err := os.ReadFile(filepath.Join(outputDir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		scanner := bufio.NewScanner(bytes.NewReader(content))
+		for scanner.Scan() {
+			// Label=otari.stack=x or PodmanArgs=--label otari.stack=x
+			line := strings.TrimSpace(scanner.Text())
+			if strings.HasSuffix(line, "="+label) || strings.HasSuffix(line, " "+label) {
+				out = append(out, entry.Name())
+				break
+			}
+		}
+	}
+	sort.Strings(out)
+	return out, nil
+}
+
+func isQuadlet(fileName string) bool {
+	return slices.Contains(generate.Kinds, strings.TrimPrefix(filepath.Ext(fileName), "."))
+}
@@ -0,0 +1,165 @@
+package status
+
+// Tests how the stack file, lock file and deployed quadlets are combined.
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/danecwalker/otari/internal/changes"
+	"github.com/danecwalker/otari/internal/definition"
+	"github.com/danecwalker/otari/internal/podman"
+	"github.com/danecwalker/otari/internal/systemd"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeProbe struct {
+	units      map[string]*systemd.UnitState
+	containers map[string]*podman.ContainerState
+	secrets    map[string]bool
+}
+
+func (p *fakeProbe) UnitState(unitName string) (*systemd.UnitState, error) {
+	if state, ok := p.units[unitName]; ok {
+		return state, nil
+	}
+	return &systemd.UnitState{LoadState: "not-found", ActiveState: "inactive"}, nil
+}
+
+func (p *fakeProbe) InspectContainer(ctx context.Context, containerName string) (*podman.ContainerState, error) {
+	if state, ok := p.containers[containerName]; ok {
+		return state, nil
+	}
+	return nil, errors.New("no such container")
+}
+
+func (p *fakeProbe) SecretExists(ctx context.Context, secretName string) bool {
+	return p.secrets[secretName]
+}
+
+func writeQuadlet(t *testing.T, dir, fileName, content string) {
+	t.Helper()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, fileName), []byte(content), 0o644))
+}
+
+func find(st *Status, kind, name string) *Resource {
+	for _, r := range st.Resources {
+		if r.Kind == kind && r.Name == name {
+			return r
+		}
+	}
+	return nil
+}
+
+func TestCollect(t *testing.T) {
+	stack, err := definition.Parse([]byte(`
+containers:
+  web:
+    image: nginx:latest
+  worker:
+    image: alpine:latest
+networks:
+  backend:
+secrets:
+  token:
+    environment: TOKEN
+`))
+	require.NoError(t, err)
+	stack.StackName = "test"
+	stack.NameTemplate = definition.DefaultNameTemplate
+
+	stackData := &changes.StackData{
+		Version:      changes.StackDataVersion,
+		NameTemplate: definition.DefaultNameTemplate,
+		Containers:   map[string]string{"web": "a", "old": "b"},
+		Networks:     map[string]string{"backend": "c"},
+		Secrets:      map[string]string{"token": "d"},
+	}
+
+	dir := t.TempDir()
+	writeQuadlet(t, dir, "test-web.container", "[Container]\nLabel=otari.stack=test\n")
+	writeQuadlet(t, dir, "test-old.container", "[Container]\nLabel=otari.stack=test\n")
+	writeQuadlet(t, dir, "test-backend.network", "[Network]\nLabel=otari.stack=test\n")
+	// created by hand with the stack label
+	writeQuadlet(t, dir, "stray.container", "[Container]\nLabel=otari.stack=test\n")
+	// belongs to another stack
+	writeQuadlet(t, dir, "other-web.container", "[Container]\nLabel=otari.stack=other\n")
+
+	startedAt := time.Now().Add(-time.Hour)
+	probe := &fakeProbe{
+		units: map[string]*systemd.UnitState{
+			"test-web.service": {LoadState: "loaded", ActiveState: "active", SubState: "running"},
+		},
+		containers: map[string]*podman.ContainerState{
+			"test-web": {
+				Status:       "running",
+				Running:      true,
+				Health:       "healthy",
+				StartedAt:    startedAt,
+				RestartCount: 2,
+				Ports:        []string{"0.0.0.0:8080->80/tcp"},
+				ImageDigest:  "sha256:abc",
+			},
+		},
+		secrets: map[string]bool{"test-token": true},
+	}
+
+	st, err := Collect(context.Background(), stack, stackData, dir, probe)
+	require.NoError(t, err)
+	assert.True(t, st.Deployed)
+
+	web := find(st, "container", "web")
+	require.NotNil(t, web)
+	assert.Equal(t, ProblemNone, web.Problem)
+	assert.Equal(t, "test-web.service", web.Unit)
+	assert.Equal(t, "active", web.ActiveState)
+	require.NotNil(t, web.Container)
+	assert.Equal(t, 2, web.Container.RestartCount)
+	assert.Equal(t, "healthy", web.Container.Health)
+	assert.GreaterOrEqual(t, web.Container.UptimeSeconds, int64(3600))
+
+	worker := find(st, "container", "worker")
+	require.NotNil(t, worker)
+	assert.Equal(t, ProblemMissing, worker.Problem)
+	assert.Empty(t, worker.ActiveState)
+	assert.Nil(t, worker.Container)
+
+	old := find(st, "container", "old")
+	require.NotNil(t, old)
+	assert.Equal(t, ProblemUndefined, old.Problem)
+	assert.Equal(t, "test-old", old.ResourceName)
+
+	stray := find(st, "container", "stray")
+	require.NotNil(t, stray)
+	assert.Equal(t, ProblemUndefined, stray.Problem)
+
+	assert.Nil(t, find(st, "container", "other-web"))
+
+	token := find(st, "secret", "token")
+	require.NotNil(t, token)
+	assert.True(t, token.Present)
+	assert.Equal(t, ProblemNone, token.Problem)
+
+	assert.Len(t, st.Problems(), 3)
+}
+
+func TestCollectNotDeployed(t *testing.T) {
+	stack, err := definition.Parse([]byte(`
+containers:
+  web:
+    image: nginx:latest
+`))
+	require.NoError(t, err)
+	stack.StackName = "test"
+
+	st, err := Collect(context.Background(), stack, nil, filepath.Join(t.TempDir(), "missing"), &fakeProbe{})
+	require.NoError(t, err)
+	assert.False(t, st.Deployed)
+	require.Len(t, st.Resources, 1)
+	assert.Equal(t, ProblemMissing, st.Resources[0].Problem)
+}
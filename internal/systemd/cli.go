@@ -6,6 +6,8 @@ import (
 	"fmt"
 	"os"
 	"os/user"
+
+	"github.com/danecwalker/otari/internal/utils"
 )
 
 func IsSystemdRunning() bool {
@@ -18,6 +20,10 @@ func IsSystemdRunning() bool {
 }
 
 func IsUserLingeringEnabled() (bool, error) {
+	// rootful units run in the system instance, which needs no lingering
+	if utils.Rootful() {
+		return true, nil
+	}
 	// Check for the existence of the lingering file for the current user
 	// username $USER
 	user, err := user.Current()
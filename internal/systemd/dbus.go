@@ -0,0 +1,357 @@
+package systemd
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/danecwalker/otari/internal/utils"
+	"github.com/godbus/dbus/v5"
+)
+
+const (
+	systemdDest      = "org.freedesktop.systemd1"
+	systemdPath      = dbus.ObjectPath("/org/freedesktop/systemd1")
+	managerInterface = "org.freedesktop.systemd1.Manager"
+	unitInterface    = "org.freedesktop.systemd1.Unit"
+	serviceInterface = "org.freedesktop.systemd1.Service"
+	propsInterface   = "org.freedesktop.DBus.Properties"
+)
+
+// Watcher is implemented by service managers that can report the state
+// transitions of a unit as they happen.
+type Watcher interface {
+	// WatchUnit calls fn every time the state of the unit changes, until
+	// stop is called. No calls are made once stop returns.
+	WatchUnit(unitName string, fn func(state *UnitState)) (stop func(), err error)
+}
+
+// UnitError is returned when a job for a unit does not complete. It carries
+// the state the unit was left in, which holds the reason it failed.
+type UnitError struct {
+	Unit string
+	// JobResult is the result of the job, e.g. "failed", "timeout" or
+	// "dependency".
+	JobResult string
+	State     *UnitState
+}
+
+func (e *UnitError) Error() string {
+	msg := fmt.Sprintf("job for %s %s", e.Unit, e.JobResult)
+	if e.State != nil {
+		msg += fmt.Sprintf(": unit is %s (%s)", e.State.ActiveState, e.State.SubState)
+		if e.State.Result != "" && e.State.Result != "success" {
+			msg += ", result " + e.State.Result
+		}
+		if e.State.ExecMainStatus != 0 {
+			msg += fmt.Sprintf(", exit status %d", e.State.ExecMainStatus)
+		}
+	}
+	return msg
+}
+
+// DBusManager is a ServiceManager that talks to systemd over D-Bus. Jobs
+// are tracked until systemd reports their result, so a failed start returns
+// the state of the unit instead of an exit code.
+type DBusManager struct {
+	conn    *dbus.Conn
+	manager dbus.BusObject
+	signals chan *dbus.Signal
+
+	// mu guards the maps below, the signal loop holds it while it
+	// delivers a signal
+	mu       sync.Mutex
+	jobs     map[dbus.ObjectPath]chan string
+	closed   bool
+	watchers map[dbus.ObjectPath]map[*unitWatch]bool
+}
+
+type unitWatch struct {
+	state *UnitState
+	fn    func(state *UnitState)
+}
+
+var _ ServiceManager = (*DBusManager)(nil)
+var _ Watcher = (*DBusManager)(nil)
+
+// ConnectDBus connects to the systemd instance that loads the quadlets otari
+// writes, the system instance when running as root and the user instance
+// otherwise.
+func ConnectDBus() (*DBusManager, error) {
+	var conn *dbus.Conn
+	var err error
+	if utils.Rootful() {
+		conn, err = dbus.ConnectSystemBus()
+	} else {
+		conn, err = dbus.ConnectSessionBus()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return NewDBusManager(conn)
+}
+
+// ConnectDBusAddress connects to systemd on the bus at address.
+func ConnectDBusAddress(address string) (*DBusManager, error) {
+	conn, err := dbus.Connect(address)
+	if err != nil {
+		return nil, err
+	}
+	return NewDBusManager(conn)
+}
+
+// NewDBusManager subscribes to the signals of systemd on conn. The manager
+// owns conn from then on and closes it on Close.
+func NewDBusManager(conn *dbus.Conn) (*DBusManager, error) {
+	m := &DBusManager{
+		conn:     conn,
+		manager:  conn.Object(systemdDest, systemdPath),
+		signals:  make(chan *dbus.Signal, 64),
+		jobs:     make(map[dbus.ObjectPath]chan string),
+		watchers: make(map[dbus.ObjectPath]map[*unitWatch]bool),
+	}
+
+	err := conn.AddMatchSignal(
+		dbus.WithMatchObjectPath(systemdPath),
+		dbus.WithMatchInterface(managerInterface),
+		dbus.WithMatchMember("JobRemoved"),
+	)
+	if err == nil {
+		// systemd only emits signals to subscribed clients
+		err = m.manager.Call(managerInterface+".Subscribe", 0).Err
+	}
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to subscribe to systemd: %w", err)
+	}
+
+	conn.Signal(m.signals)
+	go m.dispatch()
+	return m, nil
+}
+
+// Close disconnects from the bus.
+func (m *DBusManager) Close() error {
+	return m.conn.Close()
+}
+
+// dispatch hands job results to the calls waiting for them and state
+// changes to the watchers of the unit.
+func (m *DBusManager) dispatch() {
+	for signal := range m.signals {
+		switch signal.Name {
+		case managerInterface + ".JobRemoved":
+			var id uint32
+			var job dbus.ObjectPath
+			var unit, result string
+			if dbus.Store(signal.Body, &id, &job, &unit, &result) != nil {
+				continue
+			}
+			m.mu.Lock()
+			if ch, ok := m.jobs[job]; ok {
+				ch <- result
+				delete(m.jobs, job)
+			}
+			m.mu.Unlock()
+		case propsInterface + ".PropertiesChanged":
+			var iface string
+			var changed map[string]dbus.Variant
+			var invalidated []string
+			if dbus.Store(signal.Body, &iface, &changed, &invalidated) != nil {
+				continue
+			}
+			m.mu.Lock()
+			for w := range m.watchers[signal.Path] {
+				if applyChanges(w.state, iface, changed) {
+					copied := *w.state
+					w.fn(&copied)
+				}
+			}
+			m.mu.Unlock()
+		}
+	}
+
+	// the connection is closed, no more job results will arrive
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.closed = true
+	for job, ch := range m.jobs {
+		close(ch)
+		delete(m.jobs, job)
+	}
+}
+
+// applyChanges updates state with the changed properties and reports
+// whether the active or sub state changed.
+func applyChanges(state *UnitState, iface string, changed map[string]dbus.Variant) bool {
+	transition := false
+	for name, value := range changed {
+		switch iface + "." + name {
+		case unitInterface + ".LoadState":
+			state.LoadState, _ = value.Value().(string)
+		case unitInterface + ".ActiveState":
+			if s, _ := value.Value().(string); s != state.ActiveState {
+				state.ActiveState, transition = s, true
+			}
+		case unitInterface + ".SubState":
+			if s, _ := value.Value().(string); s != state.SubState {
+				state.SubState, transition = s, true
+			}
+		case serviceInterface + ".Result":
+			state.Result, _ = value.Value().(string)
+		case serviceInterface + ".ExecMainStatus":
+			status, _ := value.Value().(int32)
+			state.ExecMainStatus = int(status)
+		}
+	}
+	return transition
+}
+
+// runJob calls a method of the manager that queues a job for the unit and
+// waits for the result of the job.
+func (m *DBusManager) runJob(method, unitName string) error {
+	unitName = fullUnitName(unitName)
+
+	// the signal loop cannot deliver the result before the job is
+	// registered, as it needs the lock to do so
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return errDisconnected
+	}
+	var job dbus.ObjectPath
+	err := m.manager.Call(managerInterface+"."+method, 0, unitName, "replace").Store(&job)
+	if err != nil {
+		m.mu.Unlock()
+		return err
+	}
+	done := make(chan string, 1)
+	m.jobs[job] = done
+	m.mu.Unlock()
+
+	result, ok := <-done
+	if !ok {
+		return errDisconnected
+	}
+	if result == "done" {
+		return nil
+	}
+
+	state, err := m.GetUnitState(unitName)
+	if err != nil {
+		state = nil
+	}
+	return &UnitError{Unit: unitName, JobResult: result, State: state}
+}
+
+func (m *DBusManager) ReloadDaemon() error {
+	return m.manager.Call(managerInterface+".Reload", 0).Err
+}
+
+func (m *DBusManager) StartUnit(unitName string) error {
+	return m.runJob("StartUnit", unitName)
+}
+
+func (m *DBusManager) StopUnit(unitName string) error {
+	return m.runJob("StopUnit", unitName)
+}
+
+func (m *DBusManager) RestartUnit(unitName string) error {
+	return m.runJob("RestartUnit", unitName)
+}
+
+func (m *DBusManager) DeleteUnitFile(fileName string) error {
+	return DeleteUnitFile(fileName)
+}
+
+// unitObject loads the unit and returns its object.
+func (m *DBusManager) unitObject(unitName string) (dbus.BusObject, error) {
+	var path dbus.ObjectPath
+	err := m.manager.Call(managerInterface+".LoadUnit", 0, fullUnitName(unitName)).Store(&path)
+	if err != nil {
+		return nil, err
+	}
+	return m.conn.Object(systemdDest, path), nil
+}
+
+func (m *DBusManager) GetUnitState(unitName string) (*UnitState, error) {
+	unit, err := m.unitObject(unitName)
+	if err != nil {
+		return nil, err
+	}
+	return readUnitState(unit)
+}
+
+func readUnitState(unit dbus.BusObject) (*UnitState, error) {
+	var props map[string]dbus.Variant
+	if err := unit.Call(propsInterface+".GetAll", 0, unitInterface).Store(&props); err != nil {
+		return nil, err
+	}
+	state := &UnitState{}
+	applyChanges(state, unitInterface, props)
+
+	// only services have a result and a main process
+	var serviceProps map[string]dbus.Variant
+	if unit.Call(propsInterface+".GetAll", 0, serviceInterface).Store(&serviceProps) == nil {
+		applyChanges(state, serviceInterface, serviceProps)
+	}
+	return state, nil
+}
+
+func (m *DBusManager) GetLogs(unitName string) ([]byte, error) {
+	return GetLogs(unitName)
+}
+
+func (m *DBusManager) WatchUnit(unitName string, fn func(state *UnitState)) (func(), error) {
+	unit, err := m.unitObject(unitName)
+	if err != nil {
+		return nil, err
+	}
+	state, err := readUnitState(unit)
+	if err != nil {
+		return nil, err
+	}
+
+	match := []dbus.MatchOption{
+		dbus.WithMatchObjectPath(unit.Path()),
+		dbus.WithMatchInterface(propsInterface),
+		dbus.WithMatchMember("PropertiesChanged"),
+	}
+	if err := m.conn.AddMatchSignal(match...); err != nil {
+		return nil, err
+	}
+
+	w := &unitWatch{state: state, fn: fn}
+	m.mu.Lock()
+	if m.watchers[unit.Path()] == nil {
+		m.watchers[unit.Path()] = make(map[*unitWatch]bool)
+	}
+	m.watchers[unit.Path()][w] = true
+	m.mu.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			m.mu.Lock()
+			delete(m.watchers[unit.Path()], w)
+			if len(m.watchers[unit.Path()]) == 0 {
+				delete(m.watchers, unit.Path())
+			}
+			m.mu.Unlock()
+			m.conn.RemoveMatchSignal(match...)
+		})
+	}, nil
+}
+
+// fullUnitName adds the .service suffix systemctl assumes for bare names.
+func fullUnitName(unitName string) string {
+	if !strings.Contains(unitName, ".") {
+		return unitName + ".service"
+	}
+	return unitName
+}
+
+// errDisconnected is returned for jobs still running when the connection to
+// the bus is lost.
+var errDisconnected = errors.New("lost the connection to systemd")
@@ -0,0 +1,240 @@
+package systemd
+
+// Tests the D-Bus service manager against a private bus with a stand-in for
+// systemd.
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/godbus/dbus/v5/prop"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const busConfig = `<!DOCTYPE busconfig PUBLIC "-//freedesktop//DTD D-Bus Bus Configuration 1.0//EN"
+ "http://www.freedesktop.org/standards/dbus/1.0/busconfig.dtd">
+<busconfig>
+  <type>session</type>
+  <listen>unix:dir=%s</listen>
+  <auth>EXTERNAL</auth>
+  <policy context="default">
+    <allow send_destination="*" eavesdrop="true"/>
+    <allow eavesdrop="true"/>
+    <allow own="*"/>
+  </policy>
+</busconfig>
+`
+
+// startBus runs a private dbus-daemon and returns its address.
+func startBus(t *testing.T) string {
+	t.Helper()
+	daemon, err := exec.LookPath("dbus-daemon")
+	if err != nil {
+		t.Skip("dbus-daemon is not installed")
+	}
+
+	dir := t.TempDir()
+	config := filepath.Join(dir, "bus.conf")
+	require.NoError(t, os.WriteFile(config, []byte(fmt.Sprintf(busConfig, dir)), 0o644))
+
+	cmd := exec.Command(daemon, "--config-file="+config, "--print-address", "--nofork", "--nopidfile")
+	stdout, err := cmd.StdoutPipe()
+	require.NoError(t, err)
+	require.NoError(t, cmd.Start())
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	})
+
+	address, err := bufio.NewReader(stdout).ReadString('\n')
+	require.NoError(t, err)
+	return strings.TrimSpace(address)
+}
+
+// fakeSystemd serves the parts of the systemd manager API otari uses.
+// Units whose name starts with "fail" fail to start.
+type fakeSystemd struct {
+	conn *dbus.Conn
+
+	mu      sync.Mutex
+	jobs    uint32
+	units   map[string]*prop.Properties
+	reloads int
+}
+
+func serveSystemd(t *testing.T, address string) *fakeSystemd {
+	t.Helper()
+	conn, err := dbus.Connect(address)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	f := &fakeSystemd{conn: conn, units: make(map[string]*prop.Properties)}
+	require.NoError(t, conn.Export(f, systemdPath, managerInterface))
+	reply, err := conn.RequestName(systemdDest, dbus.NameFlagDoNotQueue)
+	require.NoError(t, err)
+	require.Equal(t, dbus.RequestNameReplyPrimaryOwner, reply)
+	return f
+}
+
+func unitPath(name string) dbus.ObjectPath {
+	var escaped strings.Builder
+	for _, r := range name {
+		if r >= 'a' && r <= 'z' || r >= '0' && r <= '9' {
+			escaped.WriteRune(r)
+		} else {
+			fmt.Fprintf(&escaped, "_%02x", r)
+		}
+	}
+	return systemdPath + "/unit/" + dbus.ObjectPath(escaped.String())
+}
+
+// unit returns the properties of a unit, creating it on first use.
+func (f *fakeSystemd) unit(name string) (*prop.Properties, dbus.ObjectPath, *dbus.Error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	path := unitPath(name)
+	if props, ok := f.units[name]; ok {
+		return props, path, nil
+	}
+	str := func(v string) *prop.Prop { return &prop.Prop{Value: v, Emit: prop.EmitTrue} }
+	props, err := prop.Export(f.conn, path, prop.Map{
+		unitInterface: {
+			"LoadState":   str("loaded"),
+			"ActiveState": str("inactive"),
+			"SubState":    str("dead"),
+		},
+		serviceInterface: {
+			"Result":         str("success"),
+			"ExecMainStatus": {Value: int32(0), Emit: prop.EmitTrue},
+		},
+	})
+	if err != nil {
+		return nil, "", dbus.MakeFailedError(err)
+	}
+	f.units[name] = props
+	return props, path, nil
+}
+
+func (f *fakeSystemd) Subscribe() *dbus.Error {
+	return nil
+}
+
+func (f *fakeSystemd) Reload() *dbus.Error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.reloads++
+	return nil
+}
+
+func (f *fakeSystemd) LoadUnit(name string) (dbus.ObjectPath, *dbus.Error) {
+	_, path, err := f.unit(name)
+	return path, err
+}
+
+// job runs the transitions of a job in the background and reports its
+// result like systemd does.
+func (f *fakeSystemd) job(name string, transitions [][2]string, result string) (dbus.ObjectPath, *dbus.Error) {
+	props, _, err := f.unit(name)
+	if err != nil {
+		return "", err
+	}
+	f.mu.Lock()
+	f.jobs++
+	id := f.jobs
+	f.mu.Unlock()
+	job := systemdPath + dbus.ObjectPath(fmt.Sprintf("/job/%d", id))
+
+	go func() {
+		for _, transition := range transitions {
+			props.SetMust(unitInterface, "ActiveState", transition[0])
+			props.SetMust(unitInterface, "SubState", transition[1])
+		}
+		if result == "failed" {
+			props.SetMust(serviceInterface, "Result", "exit-code")
+			props.SetMust(serviceInterface, "ExecMainStatus", int32(1))
+		}
+		f.conn.Emit(systemdPath, managerInterface+".JobRemoved", id, job, name, result)
+	}()
+	return job, nil
+}
+
+func (f *fakeSystemd) StartUnit(name, mode string) (dbus.ObjectPath, *dbus.Error) {
+	if strings.HasPrefix(name, "fail") {
+		return f.job(name, [][2]string{{"activating", "start"}, {"failed", "failed"}}, "failed")
+	}
+	return f.job(name, [][2]string{{"activating", "start"}, {"active", "running"}}, "done")
+}
+
+func (f *fakeSystemd) RestartUnit(name, mode string) (dbus.ObjectPath, *dbus.Error) {
+	return f.StartUnit(name, mode)
+}
+
+func (f *fakeSystemd) StopUnit(name, mode string) (dbus.ObjectPath, *dbus.Error) {
+	return f.job(name, [][2]string{{"deactivating", "stop-sigterm"}, {"inactive", "dead"}}, "done")
+}
+
+func TestDBusManager(t *testing.T) {
+	address := startBus(t)
+	systemd := serveSystemd(t, address)
+
+	manager, err := ConnectDBusAddress(address)
+	require.NoError(t, err)
+	defer manager.Close()
+
+	require.NoError(t, manager.ReloadDaemon())
+	systemd.mu.Lock()
+	assert.Equal(t, 1, systemd.reloads)
+	systemd.mu.Unlock()
+
+	// state transitions are streamed while the job runs
+	var mu sync.Mutex
+	var transitions []string
+	stop, err := manager.WatchUnit("web", func(state *UnitState) {
+		mu.Lock()
+		defer mu.Unlock()
+		transitions = append(transitions, state.ActiveState+" ("+state.SubState+")")
+	})
+	require.NoError(t, err)
+	require.NoError(t, manager.StartUnit("web"))
+	stop()
+
+	mu.Lock()
+	assert.Contains(t, transitions, "activating (start)")
+	assert.Equal(t, "active (running)", transitions[len(transitions)-1])
+	mu.Unlock()
+
+	state, err := manager.GetUnitState("web.service")
+	require.NoError(t, err)
+	assert.Equal(t, &UnitState{LoadState: "loaded", ActiveState: "active", SubState: "running", Result: "success"}, state)
+
+	require.NoError(t, manager.StopUnit("web"))
+	state, err = manager.GetUnitState("web")
+	require.NoError(t, err)
+	assert.True(t, state.IsFinished())
+}
+
+func TestDBusManagerReportsFailedJobs(t *testing.T) {
+	address := startBus(t)
+	serveSystemd(t, address)
+
+	manager, err := ConnectDBusAddress(address)
+	require.NoError(t, err)
+	defer manager.Close()
+
+	err = manager.StartUnit("failing")
+	var unitErr *UnitError
+	require.ErrorAs(t, err, &unitErr)
+	assert.Equal(t, "failing.service", unitErr.Unit)
+	assert.Equal(t, "failed", unitErr.JobResult)
+	assert.Equal(t, "exit-code", unitErr.State.Result)
+	assert.Equal(t, 1, unitErr.State.ExecMainStatus)
+	assert.EqualError(t, err, "job for failing.service failed: unit is failed (failed), result exit-code, exit status 1")
+}
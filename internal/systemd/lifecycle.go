@@ -3,30 +3,51 @@
 package systemd
 
 import (
+	"bufio"
+	"bytes"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
+	"strings"
 
 	"github.com/danecwalker/otari/internal/utils"
 )
 
+// systemctl runs systemctl against the systemd instance of otari, see
+// utils.Rootful.
+func systemctl(args ...string) *exec.Cmd {
+	if !utils.Rootful() {
+		args = append([]string{"--user"}, args...)
+	}
+	return exec.Command("systemctl", args...)
+}
+
+// journalctl reads the journal of the systemd instance of otari.
+func journalctl(args ...string) *exec.Cmd {
+	if !utils.Rootful() {
+		args = append([]string{"--user"}, args...)
+	}
+	return exec.Command("journalctl", args...)
+}
+
 func ReloadDaemon() error {
-	cmd := exec.Command("systemctl", "--user", "daemon-reload")
+	cmd := systemctl("daemon-reload")
 	return cmd.Run()
 }
 
 func StartUnit(unitName string) error {
-	cmd := exec.Command("systemctl", "--user", "start", unitName)
+	cmd := systemctl("start", unitName)
 	return cmd.Run()
 }
 
 func StopUnit(unitName string) error {
-	cmd := exec.Command("systemctl", "--user", "stop", unitName)
+	cmd := systemctl("stop", unitName)
 	return cmd.Run()
 }
 
 func RestartUnit(unitName string) error {
-	cmd := exec.Command("systemctl", "--user", "restart", unitName)
+	cmd := systemctl("restart", unitName)
 	return cmd.Run()
 }
 
@@ -47,6 +68,47 @@ func DeleteUnitFile(unitName string) error {
 }
 
 func GetLogs(unitName string) ([]byte, error) {
-	cmd := exec.Command("journalctl", "--user", "-u", unitName, "-I", "-t", unitName, "-o", "cat")
+	cmd := journalctl("-u", unitName, "-I", "-t", unitName, "-o", "cat")
 	return cmd.Output()
 }
+
+func GetUnitState(unitName string) (*UnitState, error) {
+	cmd := systemctl("show", unitName,
+		"--property=LoadState,ActiveState,SubState,Result,ExecMainStatus")
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, err
+	}
+
+	state := &UnitState{}
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), "=")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "LoadState":
+			state.LoadState = value
+		case "ActiveState":
+			state.ActiveState = value
+		case "SubState":
+			state.SubState = value
+		case "Result":
+			state.Result = value
+		case "ExecMainStatus":
+			state.ExecMainStatus, _ = strconv.Atoi(value)
+		}
+	}
+	return state, scanner.Err()
+}
+
+// DefaultManager returns the ServiceManager otari uses unless told
+// otherwise. It talks to systemd over D-Bus and falls back to systemctl
+// when the bus cannot be reached.
+func DefaultManager() ServiceManager {
+	if manager, err := ConnectDBus(); err == nil {
+		return manager
+	}
+	return ExecManager()
+}
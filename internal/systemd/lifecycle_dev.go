@@ -44,3 +44,17 @@ func DeleteUnitFile(unitName string) error {
 func GetLogs(unitName string) ([]byte, error) {
 	return []byte(""), nil
 }
+
+func GetUnitState(unitName string) (*UnitState, error) {
+	return &UnitState{
+		LoadState:   "loaded",
+		ActiveState: "active",
+		SubState:    "running",
+		Result:      "success",
+	}, nil
+}
+
+// DefaultManager returns the ServiceManager otari uses unless told otherwise.
+func DefaultManager() ServiceManager {
+	return ExecManager()
+}
@@ -0,0 +1,49 @@
+package systemd
+
+// ServiceManager controls the systemd units generated from quadlets.
+type ServiceManager interface {
+	ReloadDaemon() error
+	StartUnit(unitName string) error
+	StopUnit(unitName string) error
+	RestartUnit(unitName string) error
+	// DeleteUnitFile removes a quadlet from the output location, it is not
+	// an error if it does not exist.
+	DeleteUnitFile(fileName string) error
+	GetUnitState(unitName string) (*UnitState, error)
+	GetLogs(unitName string) ([]byte, error)
+}
+
+type execManager struct{}
+
+// ExecManager returns a ServiceManager that runs systemctl and journalctl.
+func ExecManager() ServiceManager {
+	return execManager{}
+}
+
+func (execManager) ReloadDaemon() error {
+	return ReloadDaemon()
+}
+
+func (execManager) StartUnit(unitName string) error {
+	return StartUnit(unitName)
+}
+
+func (execManager) StopUnit(unitName string) error {
+	return StopUnit(unitName)
+}
+
+func (execManager) RestartUnit(unitName string) error {
+	return RestartUnit(unitName)
+}
+
+func (execManager) DeleteUnitFile(fileName string) error {
+	return DeleteUnitFile(fileName)
+}
+
+func (execManager) GetUnitState(unitName string) (*UnitState, error) {
+	return GetUnitState(unitName)
+}
+
+func (execManager) GetLogs(unitName string) ([]byte, error) {
+	return GetLogs(unitName)
+}
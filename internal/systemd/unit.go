@@ -0,0 +1,26 @@
+package systemd
+
+type UnitState struct {
+	// LoadState is "not-found" if systemd does not know the unit
+	LoadState      string
+	ActiveState    string
+	SubState       string
+	Result         string
+	ExecMainStatus int
+}
+
+// IsFinished reports whether the unit has stopped running.
+func (u *UnitState) IsFinished() bool {
+	return u.ActiveState == "inactive" || u.ActiveState == "failed"
+}
+
+// Succeeded reports whether the unit finished and its main process exited
+// cleanly.
+func (u *UnitState) Succeeded() bool {
+	return u.IsFinished() && u.Result == "success" && u.ExecMainStatus == 0
+}
+
+// Exists reports whether systemd knows the unit.
+func (u *UnitState) Exists() bool {
+	return u.LoadState != "" && u.LoadState != "not-found"
+}
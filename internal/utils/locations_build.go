@@ -7,7 +7,12 @@ import (
 	"path/filepath"
 )
 
+// OutputLocation returns the directory quadlets are written to, the
+// system quadlet directory when running as root and the user's otherwise.
 func OutputLocation() string {
+	if Rootful() {
+		return "/etc/containers/systemd"
+	}
 	homeDir, err := os.UserHomeDir()
 	if err != nil {
 		return "stack"
@@ -16,6 +16,29 @@ func GetAbsolutePath(path string) (string, error) {
 	return path, nil
 }
 
+// ResolvePath returns path made absolute relative to dir, or to the current
+// working directory if dir is empty. Relative paths in a stack are resolved
+// against the directory of the stack file.
+func ResolvePath(dir, path string) (string, error) {
+	if isAbsolutePath(path) || dir == "" {
+		return GetAbsolutePath(path)
+	}
+	return GetAbsolutePath(filepath.Join(dir, path))
+}
+
+// StateLocation returns the central state directory, $XDG_STATE_HOME/otari
+// or ~/.local/state/otari if it is not set.
+func StateLocation() (string, error) {
+	if dir := os.Getenv("XDG_STATE_HOME"); dir != "" {
+		return filepath.Join(dir, "otari"), nil
+	}
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(homeDir, ".local", "state", "otari"), nil
+}
+
 func isAbsolutePath(path string) bool {
 	return len(path) > 0 && path[0] == os.PathSeparator
 }
@@ -1,6 +1,7 @@
 package utils
 
 import (
+	"os"
 	"path/filepath"
 	"testing"
 
@@ -30,3 +31,39 @@ func TestGetAbsolutePath(t *testing.T) {
 		assert.Equal(t, tt.expected, absPath, "Absolute path does not match expected value")
 	}
 }
+
+func TestResolvePath(t *testing.T) {
+	wd, err := os.Getwd()
+	assert.NoError(t, err)
+
+	tests := []struct {
+		dir      string
+		input    string
+		expected string
+	}{
+		{"/srv/stacks", "./data", "/srv/stacks/data"},
+		{"/srv/stacks", "../shared", "/srv/shared"},
+		{"/srv/stacks", "/absolute/path", "/absolute/path"},
+		{"stacks", "data", filepath.Join(wd, "stacks", "data")},
+		{"", "data", filepath.Join(wd, "data")},
+	}
+
+	for _, tt := range tests {
+		absPath, err := ResolvePath(tt.dir, tt.input)
+		assert.NoError(t, err)
+		assert.Equal(t, tt.expected, absPath)
+	}
+}
+
+func TestStateLocation(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", "/tmp/state")
+	dir, err := StateLocation()
+	assert.NoError(t, err)
+	assert.Equal(t, "/tmp/state/otari", dir)
+
+	t.Setenv("XDG_STATE_HOME", "")
+	t.Setenv("HOME", "/home/demo")
+	dir, err = StateLocation()
+	assert.NoError(t, err)
+	assert.Equal(t, "/home/demo/.local/state/otari", dir)
+}
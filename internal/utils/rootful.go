@@ -0,0 +1,9 @@
+package utils
+
+import "os"
+
+// Rootful reports whether otari runs as root. Rootful stacks are deployed
+// to the system instance of systemd and podman instead of the user's.
+func Rootful() bool {
+	return os.Geteuid() == 0
+}
@@ -0,0 +1,647 @@
+package otari
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/danecwalker/otari/internal/changes"
+	"github.com/danecwalker/otari/internal/definition"
+	"github.com/danecwalker/otari/internal/generate"
+	"github.com/danecwalker/otari/internal/history"
+	"github.com/danecwalker/otari/internal/journal"
+	"github.com/danecwalker/otari/internal/kube"
+	"github.com/danecwalker/otari/internal/quadlets"
+	"github.com/danecwalker/otari/internal/rules"
+	"github.com/danecwalker/otari/internal/utils"
+)
+
+// Apply brings podman and systemd in line with the stack: it pulls and
+// builds images, stores secrets, writes quadlets for changed resources,
+// removes deleted ones and starts every container, restarting those that
+// changed. The lock file is updated once everything is running and the
+// deployment is recorded as a new revision in the history of the stack. If
+// the stack fails to start, it is rolled back to its last successful
+// revision and a RolledBackError is returned. Quadlets are swapped in all
+// at once, a deployment that was interrupted before is reverted first.
+func (e *Engine) Apply(ctx context.Context, stack *Stack) error {
+	unlock, err := e.lockStack(ctx, stack)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	def := stack.Definition
+	if err := e.Validate(stack); err != nil {
+		return err
+	}
+	if err := e.resume(def); err != nil {
+		return err
+	}
+	if err := def.ResolveSecrets(e.stdin); err != nil {
+		return fmt.Errorf("failed to resolve secrets: %w", err)
+	}
+	if e.frozen {
+		if err := checkFrozen(stack); err != nil {
+			return err
+		}
+	}
+	if err := e.hashBuilds(def); err != nil {
+		return err
+	}
+	if !e.frozen {
+		if err := e.refreshImages(ctx, def); err != nil {
+			return err
+		}
+	}
+
+	if changes.Renamed(def, stack.deployed) {
+		e.notice(fmt.Sprintf("Stack naming changed from '%s' to '%s', resources will be recreated under their new names. Data in existing volumes is not copied to the renamed volumes.", stack.deployed.NameTemplate, def.EffectiveNameTemplate()))
+	}
+	if changes.ModeChanged(def, stack.deployed) {
+		e.notice(fmt.Sprintf("Stack deploy mode changed from '%s' to '%s', resources will be recreated.", stack.deployed.DeployMode(), def.EffectiveMode()))
+	}
+
+	s := e.step("detect", "", "", "Detecting changes...")
+	new, deleted, totalChanges, err := changes.DetectChanges(ctx, def, e.runtime, e.services)
+	if err != nil {
+		return s.fail("Failed to detect changes.", fmt.Errorf("failed to detect changes: %w", err))
+	}
+	if totalChanges > 0 {
+		s.skip(fmt.Sprintf("Detected %d change(s).", totalChanges))
+	} else if totalChanges == -1 {
+		s.skip("No existing stack found.")
+	} else {
+		s.succeed("No changes detected.")
+	}
+
+	if totalChanges != 0 {
+		if len(def.Containers) == 0 {
+			e.notice("No containers defined in the stack.")
+			return nil
+		}
+
+		if err := e.prepareImages(ctx, def, new, stack.deployed); err != nil {
+			return err
+		}
+		if err := e.storeSecrets(ctx, def, new); err != nil {
+			return err
+		}
+	}
+
+	if err := e.deploy(ctx, def, new, deleted, totalChanges); err != nil {
+		if totalChanges == 0 {
+			return err
+		}
+		err = e.recover(ctx, stack, err)
+		if clearErr := journal.Clear(def.StateDir, def.StackName); clearErr != nil {
+			return errors.Join(err, fmt.Errorf("failed to remove deployment journal: %w", clearErr))
+		}
+		return err
+	}
+
+	// dropped before the lock file is written, an interruption in between
+	// leaves quadlets newer than the lock file, which the next deployment
+	// updates again
+	if err := journal.Clear(def.StateDir, def.StackName); err != nil {
+		return fmt.Errorf("failed to remove deployment journal: %w", err)
+	}
+	s = e.step("hash", "", "", "Computing change hashes...")
+	if err := changes.SaveStackData(def); err != nil {
+		return s.fail("Failed to store stack definition.", fmt.Errorf("failed to store stack definition: %w", err))
+	}
+	s.succeed("Change hashes computed and stored successfully!")
+
+	if totalChanges != 0 {
+		if err := e.recordRevision(ctx, def, &history.Revision{Status: history.StatusDeployed}); err != nil {
+			return fmt.Errorf("failed to record stack revision: %w", err)
+		}
+	}
+
+	stack.deployed, err = changes.LoadStackData(def)
+	if err != nil {
+		return &LoadError{Op: LoadLock, Path: stack.Path, Err: err}
+	}
+	return nil
+}
+
+// checkFrozen makes sure the lock file pins the image of every container of
+// the stack.
+func checkFrozen(stack *Stack) error {
+	if stack.deployed == nil {
+		return fmt.Errorf("%w: stack '%s' has no lock file", ErrLockMissing, stack.Name())
+	}
+	if unpinned := changes.Unpinned(stack.Definition); len(unpinned) > 0 {
+		slices.Sort(unpinned)
+		return fmt.Errorf("%w: the images of %s are not pinned, run 'otari lock' to update it", ErrLockStale, strings.Join(unpinned, ", "))
+	}
+	return nil
+}
+
+// deploy stops deleted resources, swaps the quadlets of changed and deleted
+// resources in and out together and starts the stack. Changed containers
+// with a healthcheck have to become healthy within the health grace period.
+func (e *Engine) deploy(ctx context.Context, def, new, deleted *definition.Stack, totalChanges int) error {
+	if totalChanges != 0 {
+		var removed []string
+		if deleted != nil {
+			var err error
+			if removed, err = e.removeDeleted(ctx, def, deleted); err != nil {
+				return err
+			}
+		}
+		if err := e.generate(def, new, removed); err != nil {
+			return err
+		}
+		if deleted != nil {
+			if err := e.removeSecrets(ctx, deleted); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := e.services.ReloadDaemon(); err != nil {
+		return fmt.Errorf("failed to reload systemd daemon: %w", err)
+	}
+
+	// Re-run the units of changed networks and volumes, they only create
+	// their resource when started, e.g. after it was removed outside of otari
+	if totalChanges > 0 {
+		if err := e.recreateResources(def, new); err != nil {
+			return err
+		}
+	}
+
+	if def.IsKube() {
+		return e.startKube(def, totalChanges != 0)
+	}
+	if err := e.startContainers(ctx, def, new, totalChanges); err != nil {
+		return err
+	}
+	if totalChanges != 0 {
+		return e.awaitHealthy(ctx, def, new)
+	}
+	return nil
+}
+
+// awaitHealthy waits for the changed containers with a healthcheck to
+// become healthy.
+func (e *Engine) awaitHealthy(ctx context.Context, def, new *definition.Stack) error {
+	names := slices.Sorted(maps.Keys(new.Containers))
+	for _, containerName := range names {
+		if def.Containers[containerName].Healthcheck == nil {
+			continue
+		}
+		containerUnitName := def.ResourceName(containerName)
+		s := e.step("wait", KindContainer, containerUnitName, fmt.Sprintf("Waiting for container '%s' to be healthy...", containerUnitName))
+		graceCtx, cancel := context.WithTimeout(ctx, e.healthGrace)
+		err := e.waitForCondition(graceCtx, containerUnitName, definition.DependencyConditionHealthy)
+		cancel()
+		if err != nil {
+			return s.fail(fmt.Sprintf("Container '%s' did not become healthy.", containerUnitName), &ResourceError{Op: "wait for", Kind: KindContainer, Name: containerUnitName, Err: err})
+		}
+		s.succeed(fmt.Sprintf("Container '%s' is healthy.", containerUnitName))
+	}
+	return nil
+}
+
+// prepareImages pulls the images and builds the build contexts of the
+// changed containers that are missing locally. Images the lock file pins
+// are pulled by digest, the others are pinned to the digest their tag
+// resolved to. Build contexts that changed since they were deployed are
+// built again. Images are prepared in parallel.
+func (e *Engine) prepareImages(ctx context.Context, stack, new *definition.Stack, deployed *changes.StackData) error {
+	builds := make(map[string]*definition.Build)
+	// the containers that use an image, keyed by image
+	users := make(map[string][]*definition.Container)
+	for _, container := range new.Containers {
+		if container.Build != nil {
+			builds[container.ContainerName] = container.Build
+			users[container.ContainerName] = append(users[container.ContainerName], container)
+		} else if container.Image != nil {
+			users[container.ImageRef()] = append(users[container.ImageRef()], container)
+		}
+	}
+
+	return e.parallel(ctx, slices.Sorted(maps.Keys(users)), func(ctx context.Context, image string) error {
+		build, local := builds[image]
+		exists := false
+		if local {
+			stale := deployed == nil || changes.BuildChanged(image, users[image][0], deployed)
+			exists = !stale && e.runtime.ImageExists(ctx, fmt.Sprintf("%s_%s", stack.StackName, image))
+		} else {
+			exists = e.runtime.ImageExists(ctx, image)
+		}
+
+		if exists {
+			e.step("check", KindImage, image, fmt.Sprintf("Checking image '%s'", image)).
+				skip(fmt.Sprintf("Image '%s' already exists.", image))
+		} else if !local && users[image][0].EffectivePullPolicy() == definition.PullNever {
+			return &ResourceError{Op: "pull", Kind: KindImage, Name: image, Err: errPullNever}
+		} else if !local {
+			s := e.step("pull", KindImage, image, fmt.Sprintf("Pulling image '%s'", image))
+			if err := e.runtime.PullImage(ctx, image, definition.PullMissing, s.progress); err != nil {
+				return s.fail(fmt.Sprintf("Failed to pull image '%s'", image), &ResourceError{Op: "pull", Kind: KindImage, Name: image, Err: err})
+			}
+			s.succeed(fmt.Sprintf("Pulled image '%s'.", image))
+		} else {
+			s := e.step("build", KindImage, image, fmt.Sprintf("Building image '%s'", image))
+			if err := e.runtime.BuildImage(ctx, build, fmt.Sprintf("%s_%s", stack.StackName, image), false, s.progress); err != nil {
+				return s.fail(fmt.Sprintf("Failed to build image '%s'", image), &ResourceError{Op: "build", Kind: KindImage, Name: image, Err: err})
+			}
+			s.succeed(fmt.Sprintf("Built image '%s'.", image))
+		}
+
+		if local {
+			// built images are recorded but never pinned
+			if digest, err := e.runtime.ImageDigest(ctx, fmt.Sprintf("%s_%s", stack.StackName, image)); err == nil {
+				users[image][0].ImageDigest = digest
+			}
+			return nil
+		}
+		if err := e.pin(ctx, image, users[image]); err != nil {
+			return err
+		}
+		return nil
+	})
+}
+
+// errPullNever is the cause of failing to pull an image whose pull policy
+// is never.
+var errPullNever = errors.New("image is not present locally and its pull policy is never")
+
+// refreshImages pulls the images of the containers whose pull policy asks
+// for it on every start and points them at the digest their tag resolves
+// to now. Containers whose image did not change stay as they are.
+func (e *Engine) refreshImages(ctx context.Context, stack *definition.Stack) error {
+	users := make(map[string][]*definition.Container)
+	policies := make(map[string]definition.PullPolicy)
+	for _, container := range stack.Containers {
+		if container.Build != nil || container.Image == nil || container.Image.Digest != "" || !container.Refreshes() {
+			continue
+		}
+		image := container.Image.String()
+		users[image] = append(users[image], container)
+		// newer is only used if every container that uses the image asks
+		// for it
+		if policies[image] != definition.PullAlways {
+			policies[image] = container.EffectivePullPolicy()
+		}
+	}
+
+	return e.parallel(ctx, slices.Sorted(maps.Keys(users)), func(ctx context.Context, image string) error {
+		s := e.step("pull", KindImage, image, fmt.Sprintf("Pulling image '%s'", image))
+		if err := e.runtime.PullImage(ctx, image, policies[image], s.progress); err != nil {
+			return s.fail(fmt.Sprintf("Failed to pull image '%s'", image), &ResourceError{Op: "pull", Kind: KindImage, Name: image, Err: err})
+		}
+		digest, err := e.runtime.ImageDigest(ctx, image)
+		if err != nil {
+			return s.fail(fmt.Sprintf("Failed to resolve the digest of '%s'.", image), &ResourceError{Op: "resolve the digest of", Kind: KindImage, Name: image, Err: err})
+		}
+		if digest == "" || users[image][0].ImageDigest == digest {
+			s.skip(fmt.Sprintf("Image '%s' is up to date.", image))
+			return nil
+		}
+		for _, container := range users[image] {
+			container.ImageDigest = digest
+		}
+		s.succeed(fmt.Sprintf("Pulled a new image for '%s'.", image))
+		return nil
+	})
+}
+
+// pin points the containers that use image at the digest it resolved to,
+// unless they are pinned already.
+func (e *Engine) pin(ctx context.Context, image string, containers []*definition.Container) error {
+	if containers[0].ImageDigest != "" || containers[0].Image.Digest != "" {
+		return nil
+	}
+	if e.frozen {
+		return fmt.Errorf("%w: image '%s' is not pinned", ErrLockStale, image)
+	}
+	digest, err := e.runtime.ImageDigest(ctx, image)
+	if err != nil {
+		return &ResourceError{Op: "resolve the digest of", Kind: KindImage, Name: image, Err: err}
+	}
+	if digest == "" {
+		// images that never came from a registry cannot be pinned
+		return nil
+	}
+	for _, container := range containers {
+		container.ImageDigest = digest
+	}
+	return nil
+}
+
+// storeSecrets creates or rotates the changed secrets.
+func (e *Engine) storeSecrets(ctx context.Context, stack, new *definition.Stack) error {
+	for _, secret := range new.Secrets {
+		secretName := stack.ResourceName(secret.SecretName)
+		s := e.step("store", KindSecret, secretName, fmt.Sprintf("Storing secret '%s'...", secretName))
+		value := secret.Value()
+		if stack.IsKube() {
+			// kube play reads secrets stored as Kubernetes secrets
+			var err error
+			if value, err = kube.SecretData(stack, secret.SecretName, value); err != nil {
+				return s.fail(fmt.Sprintf("Failed to encode secret '%s'.", secretName), &ResourceError{Op: "encode", Kind: KindSecret, Name: secretName, Err: err})
+			}
+		}
+		if err := e.runtime.CreateSecret(ctx, secretName, value, stack.StackLabelValue()); err != nil {
+			return s.fail(fmt.Sprintf("Failed to store secret '%s'.", secretName), &ResourceError{Op: "store", Kind: KindSecret, Name: secretName, Err: err})
+		}
+		s.succeed(fmt.Sprintf("Secret '%s' stored.", secretName))
+	}
+	return nil
+}
+
+// generate writes the quadlets of the changed resources and removes the
+// removed ones. They are staged and swapped together, the journal of the
+// swap is dropped once the deployment finished.
+func (e *Engine) generate(stack, new *definition.Stack, removed []string) error {
+	if len(new.Containers)+len(new.Volumes)+len(new.Networks)+len(new.Pods) == 0 && len(removed) == 0 {
+		e.notice("No changes detected that require quadlet generation.")
+		return nil
+	}
+
+	s := e.step("generate", "", "", "Generating systemd quadlets...")
+	outputDir := utils.OutputLocation()
+	if err := os.MkdirAll(outputDir, 0755); err != nil {
+		return s.fail("Failed to create output directory.", fmt.Errorf("failed to create output directory: %w", err))
+	}
+
+	quadletFiles, err := generate.Changed(stack, new, quadlets.Generator())
+	if err != nil {
+		return s.fail("Failed to generate systemd quadlets.", err)
+	}
+	files := make(map[string][]byte, len(quadletFiles)+len(removed))
+	for _, fileName := range removed {
+		// a nil content removes the quadlet when swapping
+		files[fileName] = nil
+	}
+	for _, q := range quadletFiles {
+		files[q.FileName] = q.Content
+	}
+	j, err := journal.Stage(stack.StateDir, stack.StackName, outputDir, files)
+	if err != nil {
+		return s.fail("Failed to write systemd quadlets.", fmt.Errorf("failed to stage quadlets: %w", err))
+	}
+	if err := j.Swap(); err != nil {
+		return s.fail("Failed to write systemd quadlets.", errors.Join(err, j.Revert()))
+	}
+	for _, q := range quadletFiles {
+		s.progress(fmt.Sprintf("Generated '%s'", q.FileName))
+	}
+	for _, fileName := range removed {
+		s.progress(fmt.Sprintf("Removed '%s'", fileName))
+	}
+	s.succeed("Generated systemd quadlets.")
+	return nil
+}
+
+// resume reverts a deployment of the stack that was interrupted, e.g. by a
+// crash. The definition it deployed is gone, so the quadlets are restored
+// to those the lock file describes. If they were already swapped in, the
+// units the deployment added are stopped and those it changed or removed
+// are restarted.
+func (e *Engine) resume(stack *definition.Stack) error {
+	j, err := journal.Load(stack.StateDir, stack.StackName)
+	if err != nil {
+		return fmt.Errorf("failed to read deployment journal: %w", err)
+	}
+	if j == nil {
+		return nil
+	}
+
+	s := e.step("revert", KindStack, stack.StackName, fmt.Sprintf("Reverting the interrupted deployment of stack '%s'...", stack.StackName))
+	swapped := j.Phase == journal.PhaseSwapped
+	var restored []string
+	if swapped {
+		for _, file := range j.Files {
+			if file.Existed {
+				restored = append(restored, file.Name)
+				continue
+			}
+			// the quadlet is removed by the revert
+			unitName, kind := quadletUnit(file.Name)
+			if unitName == "" {
+				continue
+			}
+			if err := e.services.StopUnit(unitName); err != nil {
+				name := strings.TrimSuffix(file.Name, filepath.Ext(file.Name))
+				return s.fail(fmt.Sprintf("Failed to stop '%s'.", unitName), &ResourceError{Op: "stop", Kind: kind, Name: name, Err: err})
+			}
+		}
+	}
+	if err := j.Revert(); err != nil {
+		return s.fail("Failed to revert the interrupted deployment.", fmt.Errorf("failed to revert interrupted deployment: %w", err))
+	}
+	if err := e.services.ReloadDaemon(); err != nil {
+		return s.fail("Failed to revert the interrupted deployment.", fmt.Errorf("failed to reload systemd daemon: %w", err))
+	}
+	s.succeed(fmt.Sprintf("Reverted the interrupted deployment of stack '%s' started at %s.", stack.StackName, j.StartedAt.Local().Format(time.DateTime)))
+	if swapped {
+		return e.restoreUnits(stack, restored)
+	}
+	return nil
+}
+
+// removeDeleted stops the resources that are no longer part of the stack,
+// keeping those still used by the remaining containers. It returns the
+// quadlets of the stopped resources, which generate removes in the same
+// journal as it writes the changed ones.
+func (e *Engine) removeDeleted(ctx context.Context, stack, deleted *definition.Stack) ([]string, error) {
+	var removed []string
+	if deleted.IsKube() {
+		// containers and pods are part of the manifest, restarting the
+		// stack replaces them unless it left kube mode
+		if !stack.IsKube() {
+			if err := e.stopKube(deleted); err != nil {
+				return nil, err
+			}
+			removed = append(removed, kube.FileNames(deleted)...)
+		}
+	} else {
+		for _, container := range deleted.Containers {
+			containerUnitName := deleted.ResourceName(container.ContainerName)
+			s := e.step("remove", KindContainer, containerUnitName, fmt.Sprintf("Removing container '%s'...", containerUnitName))
+			if err := e.services.StopUnit(containerUnitName); err != nil {
+				return nil, s.fail(fmt.Sprintf("Failed to stop container '%s'.", containerUnitName), &ResourceError{Op: "stop", Kind: KindContainer, Name: containerUnitName, Err: err})
+			}
+			removed = append(removed, containerUnitName+".container")
+			s.succeed(fmt.Sprintf("Container '%s' removed.", containerUnitName))
+		}
+
+		// Check if pods are used by other containers
+		for _, pod := range deleted.Pods {
+			podUnitName := deleted.ResourceName(pod.PodName)
+			s := e.step("remove", KindPod, podUnitName, fmt.Sprintf("Removing pod '%s'...", podUnitName))
+			podUsed := false
+			for _, container := range stack.Containers {
+				if container.Pod == pod.PodName && deleted.Containers[container.ContainerName] == nil {
+					podUsed = true
+					break
+				}
+			}
+			if podUsed {
+				s.skip(fmt.Sprintf("Pod '%s' is still in use.", pod.PodName))
+				continue
+			}
+
+			// Stop the pod, its quadlet is removed with the others
+			if err := e.services.StopUnit(quadlets.PodServiceName(podUnitName)); err != nil {
+				return nil, s.fail(fmt.Sprintf("Failed to stop pod '%s'.", podUnitName), &ResourceError{Op: "stop", Kind: KindPod, Name: podUnitName, Err: err})
+			}
+			removed = append(removed, podUnitName+".pod")
+			s.succeed(fmt.Sprintf("Pod '%s' removed.", podUnitName))
+		}
+	}
+
+	// Check if container networks are used by other containers
+	for _, network := range deleted.Networks {
+		networkUnitName := deleted.ResourceName(network.NetworkName)
+		s := e.step("remove", KindNetwork, networkUnitName, fmt.Sprintf("Removing network '%s'...", networkUnitName))
+		networkUsed := false
+		for _, container := range stack.Containers {
+			if slices.Contains(container.Networks, network.NetworkName) && deleted.Containers[container.ContainerName] == nil {
+				networkUsed = true
+				break
+			}
+		}
+		if networkUsed {
+			s.skip(fmt.Sprintf("Network '%s' is still in use.", network.NetworkName))
+			continue
+		}
+
+		removed = append(removed, networkUnitName+".network")
+		s.succeed(fmt.Sprintf("Network '%s' removed.", networkUnitName))
+	}
+
+	// Check if container volumes are used by other containers
+	for _, volume := range deleted.Volumes {
+		volumeUnitName := deleted.ResourceName(volume.VolumeName)
+		s := e.step("remove", KindVolume, volumeUnitName, fmt.Sprintf("Removing volume '%s'...", volumeUnitName))
+		volumeUsed := false
+		for _, container := range stack.Containers {
+			for _, vol := range container.Volumes {
+				if vol.Source == volume.VolumeName && deleted.Containers[container.ContainerName] == nil {
+					volumeUsed = true
+					break
+				}
+			}
+			if volumeUsed {
+				break
+			}
+		}
+		if volumeUsed {
+			s.skip(fmt.Sprintf("Volume '%s' is still in use.", volume.VolumeName))
+			continue
+		}
+
+		removed = append(removed, volumeUnitName+".volume")
+		s.succeed(fmt.Sprintf("Volume '%s' removed.", volumeUnitName))
+	}
+	return removed, nil
+}
+
+// removeSecrets removes the secrets that are no longer part of the stack.
+func (e *Engine) removeSecrets(ctx context.Context, deleted *definition.Stack) error {
+	for _, secret := range deleted.Secrets {
+		secretName := deleted.ResourceName(secret.SecretName)
+		s := e.step("remove", KindSecret, secretName, fmt.Sprintf("Removing secret '%s'...", secretName))
+		if err := e.runtime.RemoveSecret(ctx, secretName); err != nil {
+			return s.fail(fmt.Sprintf("Failed to remove secret '%s'.", secretName), &ResourceError{Op: "remove", Kind: KindSecret, Name: secretName, Err: err})
+		}
+		s.succeed(fmt.Sprintf("Secret '%s' removed.", secretName))
+	}
+	return nil
+}
+
+// recreateResources restarts the active units of changed networks and
+// volumes.
+func (e *Engine) recreateResources(stack, new *definition.Stack) error {
+	for _, kind := range []string{KindNetwork, KindVolume} {
+		for _, name := range generate.Names(new, kind) {
+			resourceName := stack.ResourceName(name)
+			unitName := quadlets.NetworkServiceName(resourceName)
+			if kind == KindVolume {
+				unitName = quadlets.VolumeServiceName(resourceName)
+			}
+			state, err := e.services.GetUnitState(unitName)
+			if err != nil || state.ActiveState != "active" {
+				continue
+			}
+			s := e.step("recreate", kind, resourceName, fmt.Sprintf("Recreating %s '%s'...", kind, resourceName))
+			if err := e.services.RestartUnit(unitName); err != nil {
+				return s.fail(fmt.Sprintf("Failed to recreate %s '%s'.", kind, resourceName), &ResourceError{Op: "recreate", Kind: kind, Name: resourceName, Err: err})
+			}
+			s.succeed(fmt.Sprintf("Recreated %s '%s'.", kind, resourceName))
+		}
+	}
+	return nil
+}
+
+// startContainers starts the containers of a stack in dependency order,
+// restarting running containers that changed.
+func (e *Engine) startContainers(ctx context.Context, stack, new *definition.Stack, totalChanges int) error {
+	active, err := e.runtime.ActiveContainers(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get active containers: %w", err)
+	}
+	layers, err := rules.DependencyLayers(stack)
+	if err != nil {
+		return fmt.Errorf("failed to order containers by dependency: %w", err)
+	}
+	waits := dependencyWaits(stack)
+
+	for _, layer := range layers {
+		for _, containerName := range layer {
+			containerUnitName := stack.ResourceName(containerName)
+			s := e.step("start", KindContainer, containerUnitName, fmt.Sprintf("Starting container '%s'...", containerUnitName))
+
+			// check if container is already running
+			if isActive := slices.Contains(active, containerUnitName); isActive {
+				// restart running containers whose definition or secrets changed
+				if _, changed := new.Containers[containerName]; changed && totalChanges != 0 {
+					s.update(fmt.Sprintf("Restarting container '%s'...", containerUnitName))
+					stop := e.watch(s, containerUnitName)
+					err := e.services.RestartUnit(containerUnitName)
+					stop()
+					if err != nil {
+						return s.fail(fmt.Sprintf("Failed to restart container '%s'", containerUnitName), &ResourceError{Op: "restart", Kind: KindContainer, Name: containerUnitName, Err: err})
+					}
+					s.succeed(fmt.Sprintf("Container '%s' restarted.", containerUnitName))
+					continue
+				}
+				s.skip(fmt.Sprintf("Container '%s' is already running.", containerUnitName))
+				continue
+			}
+
+			stop := e.watch(s, containerUnitName)
+			err := e.services.StartUnit(containerUnitName)
+			stop()
+			if err != nil {
+				return s.fail(fmt.Sprintf("Failed to start container '%s'", containerUnitName), &ResourceError{Op: "start", Kind: KindContainer, Name: containerUnitName, Err: err})
+			}
+			s.succeed(fmt.Sprintf("Container '%s' started.", containerUnitName))
+		}
+
+		// wait for the layer to satisfy the conditions of its dependents
+		for _, containerName := range layer {
+			containerUnitName := stack.ResourceName(containerName)
+			for _, condition := range waits[containerName] {
+				s := e.step("wait", KindContainer, containerUnitName, fmt.Sprintf("Waiting for container '%s' to be %s...", containerUnitName, condition))
+				if err := e.waitForCondition(ctx, containerUnitName, condition); err != nil {
+					return s.fail(fmt.Sprintf("Container '%s' did not become %s.", containerUnitName, condition), &ResourceError{Op: "wait for", Kind: KindContainer, Name: containerUnitName, Err: err})
+				}
+				s.succeed(fmt.Sprintf("Container '%s' is %s.", containerUnitName, condition))
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,155 @@
+package otari
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+
+	"github.com/danecwalker/otari/internal/changes"
+	"github.com/danecwalker/otari/internal/definition"
+	"github.com/danecwalker/otari/internal/kube"
+	"github.com/danecwalker/otari/internal/podman"
+)
+
+// hashBuilds hashes the build contexts of the containers of the stack that
+// are built, so changes to their files are detected.
+func (e *Engine) hashBuilds(stack *definition.Stack) error {
+	for _, containerName := range slices.Sorted(maps.Keys(stack.Containers)) {
+		build := stack.Containers[containerName].Build
+		if build == nil || build.ContextHash != "" {
+			continue
+		}
+		hash, err := podman.ContextHash(build)
+		if err != nil {
+			return &ResourceError{Op: "hash the build context of", Kind: KindImage, Name: containerName, Err: err}
+		}
+		build.ContextHash = hash
+	}
+	return nil
+}
+
+// Build builds the images of the containers of the stack that have a build,
+// or only that of containerName if it is set, even if their build context
+// did not change. Running containers of a deployed stack are restarted on
+// the new image.
+func (e *Engine) Build(ctx context.Context, stack *Stack, containerName string, noCache bool) error {
+	unlock, err := e.lockStack(ctx, stack)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	def := stack.Definition
+	var names []string
+	if containerName != "" {
+		container, exists := def.Containers[containerName]
+		if !exists {
+			return fmt.Errorf("%w: '%s'", ErrContainerNotFound, containerName)
+		}
+		if container.Build == nil {
+			return fmt.Errorf("container '%s' has no build", containerName)
+		}
+		names = []string{containerName}
+	} else {
+		for _, name := range slices.Sorted(maps.Keys(def.Containers)) {
+			if def.Containers[name].Build != nil {
+				names = append(names, name)
+			}
+		}
+	}
+	if len(names) == 0 {
+		e.notice("No containers with a build defined in the stack.")
+		return nil
+	}
+	if err := e.hashBuilds(def); err != nil {
+		return err
+	}
+
+	err = e.parallel(ctx, names, func(ctx context.Context, name string) error {
+		container := def.Containers[name]
+		image := fmt.Sprintf("%s_%s", def.StackName, name)
+		s := e.step("build", KindImage, name, fmt.Sprintf("Building image '%s'", name))
+		if err := e.runtime.BuildImage(ctx, container.Build, image, noCache, s.progress); err != nil {
+			return s.fail(fmt.Sprintf("Failed to build image '%s'", name), &ResourceError{Op: "build", Kind: KindImage, Name: name, Err: err})
+		}
+		s.succeed(fmt.Sprintf("Built image '%s'.", name))
+		if digest, err := e.runtime.ImageDigest(ctx, image); err == nil {
+			container.ImageDigest = digest
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if stack.deployed == nil {
+		return nil
+	}
+	if err := e.restartBuilt(ctx, def, names); err != nil {
+		return err
+	}
+
+	// the deployed containers now run the current build contexts
+	stackData := stack.deployed
+	if stackData.Builds == nil {
+		stackData.Builds = make(map[string]string)
+	}
+	locked := changes.LockedImages(def)
+	for _, name := range names {
+		if _, deployed := stackData.Containers[name]; !deployed {
+			continue
+		}
+		stackData.Builds[name] = def.Containers[name].Build.ContextHash
+		if image, ok := locked[name]; ok {
+			if stackData.Images == nil {
+				stackData.Images = make(map[string]changes.LockedImage)
+			}
+			stackData.Images[name] = image
+		}
+	}
+	if err := changes.WriteStackData(def, stackData); err != nil {
+		return fmt.Errorf("failed to write stack lock file: %w", err)
+	}
+	return nil
+}
+
+// restartBuilt restarts the running containers that were rebuilt, or the
+// whole stack in kube mode.
+func (e *Engine) restartBuilt(ctx context.Context, stack *definition.Stack, names []string) error {
+	if stack.IsKube() {
+		unitName := kube.ServiceName(stack)
+		if state, err := e.services.GetUnitState(unitName); err != nil || state.ActiveState != "active" {
+			return nil
+		}
+		s := e.step("restart", KindStack, stack.StackName, fmt.Sprintf("Restarting stack '%s'...", stack.StackName))
+		stop := e.watch(s, unitName)
+		err := e.services.RestartUnit(unitName)
+		stop()
+		if err != nil {
+			return s.fail(fmt.Sprintf("Failed to restart stack '%s'", stack.StackName), &ResourceError{Op: "restart", Kind: KindStack, Name: stack.StackName, Err: err})
+		}
+		s.succeed(fmt.Sprintf("Stack '%s' restarted.", stack.StackName))
+		return nil
+	}
+
+	active, err := e.runtime.ActiveContainers(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get active containers: %w", err)
+	}
+	for _, name := range names {
+		containerUnitName := stack.ResourceName(name)
+		if !slices.Contains(active, containerUnitName) {
+			continue
+		}
+		s := e.step("restart", KindContainer, containerUnitName, fmt.Sprintf("Restarting container '%s'...", containerUnitName))
+		stop := e.watch(s, containerUnitName)
+		err := e.services.RestartUnit(containerUnitName)
+		stop()
+		if err != nil {
+			return s.fail(fmt.Sprintf("Failed to restart container '%s'", containerUnitName), &ResourceError{Op: "restart", Kind: KindContainer, Name: containerUnitName, Err: err})
+		}
+		s.succeed(fmt.Sprintf("Container '%s' restarted.", containerUnitName))
+	}
+	return nil
+}
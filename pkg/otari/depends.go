@@ -0,0 +1,93 @@
+package otari
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/danecwalker/otari/internal/definition"
+)
+
+const (
+	dependencyTimeout      = 5 * time.Minute
+	dependencyPollInterval = time.Second
+)
+
+// dependencyWaits returns, for every container, the conditions other
+// containers wait on before they are started.
+func dependencyWaits(stack *definition.Stack) map[string][]definition.DependencyCondition {
+	waits := make(map[string][]definition.DependencyCondition)
+	for _, container := range stack.Containers {
+		for _, dep := range container.Depends {
+			if dep.Condition == definition.DependencyConditionStarted {
+				// systemctl start only returns once the unit is started
+				continue
+			}
+			if !slices.Contains(waits[dep.Name], dep.Condition) {
+				waits[dep.Name] = append(waits[dep.Name], dep.Condition)
+			}
+		}
+	}
+	return waits
+}
+
+// waitForCondition blocks until the container satisfies the condition.
+func (e *Engine) waitForCondition(ctx context.Context, containerName string, condition definition.DependencyCondition) error {
+	ctx, cancel := context.WithTimeout(ctx, dependencyTimeout)
+	defer cancel()
+
+	ticker := time.NewTicker(dependencyPollInterval)
+	defer ticker.Stop()
+
+	for {
+		done, err := e.checkCondition(ctx, containerName, condition)
+		if err != nil {
+			return err
+		}
+		if done {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("timed out waiting for container '%s' to be %s", containerName, condition)
+		case <-ticker.C:
+		}
+	}
+}
+
+func (e *Engine) checkCondition(ctx context.Context, containerName string, condition definition.DependencyCondition) (bool, error) {
+	switch condition {
+	case definition.DependencyConditionHealthy:
+		state, err := e.runtime.InspectContainer(ctx, containerName)
+		if err != nil {
+			// the container may not have been created yet
+			return false, nil
+		}
+		switch state.Health {
+		case "healthy":
+			return true, nil
+		case "unhealthy":
+			return false, fmt.Errorf("container '%s' is unhealthy", containerName)
+		}
+		if !state.Running && state.Status != "created" {
+			return false, fmt.Errorf("container '%s' stopped before becoming healthy", containerName)
+		}
+		return false, nil
+	case definition.DependencyConditionCompletedSuccessfully:
+		// quadlet containers are removed on exit, so ask systemd instead
+		state, err := e.services.GetUnitState(containerName)
+		if err != nil {
+			return false, err
+		}
+		if !state.IsFinished() {
+			return false, nil
+		}
+		if !state.Succeeded() {
+			return false, fmt.Errorf("container '%s' did not complete successfully (result: %s, exit status: %d)", containerName, state.Result, state.ExecMainStatus)
+		}
+		return true, nil
+	}
+	return true, nil
+}
@@ -0,0 +1,655 @@
+package otari
+
+// Runs whole stack operations against the in-memory podman and systemd.
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/danecwalker/otari/internal/fake"
+	"github.com/danecwalker/otari/internal/journal"
+	"github.com/danecwalker/otari/internal/stacklock"
+	"github.com/danecwalker/otari/internal/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const e2eStack = `
+networks:
+  backend:
+volumes:
+  data:
+secrets:
+  token:
+    environment: OTARI_TEST_TOKEN
+containers:
+  db:
+    image: postgres:16
+    volumes: [data:/var/lib/postgresql/data]
+    networks: [backend]
+    healthcheck:
+      test: ["CMD", "pg_isready"]
+  migrate:
+    image: migrate:1
+    restart: "no"
+    networks: [backend]
+    depends:
+      db:
+        condition: healthy
+  web:
+    image: nginx:1.27
+    networks: [backend]
+    secrets: [token]
+    environment:
+      MODE: prod
+    depends:
+      db:
+        condition: started
+      migrate:
+        condition: completed_successfully
+`
+
+// setup runs the test in an empty directory, so the lock file and quadlets
+// end up there, and returns an engine backed by a fake host.
+func setup(t *testing.T) (*Engine, *fake.Host, string) {
+	t.Helper()
+	dir := t.TempDir()
+	t.Chdir(dir)
+	t.Setenv("OTARI_TEST_TOKEN", "s3cret")
+
+	host := fake.New()
+	host.Exits["demo-migrate"] = 0
+	engine := New(Options{Runtime: host, Services: host})
+	return engine, host, filepath.Join(dir, "demo.yaml")
+}
+
+func load(t *testing.T, engine *Engine, path, content string) *Stack {
+	t.Helper()
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+	stack, err := engine.Load(path, nil)
+	require.NoError(t, err)
+	return stack
+}
+
+func quadletExists(fileName string) bool {
+	return utils.PathExists(filepath.Join(utils.OutputLocation(), fileName))
+}
+
+func TestApplyLifecycle(t *testing.T) {
+	ctx := context.Background()
+	engine, host, path := setup(t)
+
+	// first deployment
+	stack := load(t, engine, path, e2eStack)
+	require.NoError(t, engine.Apply(ctx, stack))
+	assert.True(t, stack.Deployed())
+	assert.ElementsMatch(t, []string{"pull migrate:1", "pull nginx:1.27", "pull postgres:16"}, host.Recorded("pull"))
+	assert.Equal(t, []byte("s3cret"), host.Secrets["demo-token"])
+	assert.Equal(t, []string{"start demo-db", "start demo-migrate", "start demo-web"}, host.Recorded("start"))
+	for _, fileName := range []string{"demo-backend.network", "demo-data.volume", "demo-db.container", "demo-migrate.container", "demo-web.container"} {
+		assert.True(t, quadletExists(fileName), fileName)
+	}
+	assert.True(t, utils.PathExists("demo.lock"))
+
+	// nothing changed, nothing is pulled or restarted
+	host.Reset()
+	stack = load(t, engine, path, e2eStack)
+	require.NoError(t, engine.Apply(ctx, stack))
+	assert.Empty(t, host.Recorded("pull"))
+	assert.Empty(t, host.Recorded("restart"))
+	assert.NotContains(t, host.Recorded("start"), "start demo-web")
+
+	// only the changed container is restarted
+	host.Reset()
+	stack = load(t, engine, path, strings.Replace(e2eStack, "MODE: prod", "MODE: dev", 1))
+	require.NoError(t, engine.Apply(ctx, stack))
+	assert.Equal(t, []string{"restart demo-web"}, host.Recorded("restart"))
+
+	// containers dropped from the stack are stopped, their quadlets are
+	// swapped out together with the changed ones
+	host.Reset()
+	withoutMigrate := strings.Replace(e2eStack, `  migrate:
+    image: migrate:1
+    restart: "no"
+    networks: [backend]
+    depends:
+      db:
+        condition: healthy
+`, "", 1)
+	withoutMigrate = strings.Replace(withoutMigrate, `      migrate:
+        condition: completed_successfully
+`, "", 1)
+	stack = load(t, engine, path, strings.Replace(withoutMigrate, "MODE: prod", "MODE: dev", 1))
+	require.NoError(t, engine.Apply(ctx, stack))
+	assert.Contains(t, host.Calls, "stop demo-migrate")
+	assert.NotContains(t, host.Calls, "delete demo-migrate.container")
+	assert.False(t, quadletExists("demo-migrate.container"))
+	assert.NoDirExists(t, journal.Dir(".", "demo"))
+
+	// dependents are stopped first
+	host.Reset()
+	require.NoError(t, engine.Stop(ctx, stack))
+	assert.Equal(t, []string{"stop demo-web", "stop demo-db"}, host.Recorded("stop"))
+	assert.False(t, host.Active("demo-web"))
+
+	// removing deletes everything the stack created
+	host.Reset()
+	require.NoError(t, engine.Remove(ctx, stack))
+	assert.False(t, stack.Deployed())
+	assert.Contains(t, host.Calls, "remove volume demo-data")
+	assert.Contains(t, host.Calls, "remove network demo-backend")
+	assert.Contains(t, host.Calls, "remove secret demo-token")
+	assert.False(t, quadletExists("demo-web.container"))
+	assert.False(t, quadletExists("demo-backend.network"))
+	assert.False(t, utils.PathExists("demo.lock"))
+}
+
+func TestApplyKeepsStateNextToStackFile(t *testing.T) {
+	ctx := context.Background()
+	engine, host, path := setup(t)
+	stackDir := filepath.Join(filepath.Dir(path), "stacks")
+	require.NoError(t, os.MkdirAll(filepath.Join(stackDir, "html"), 0o755))
+
+	// run from the parent directory of the stack file
+	stack := load(t, engine, filepath.Join(stackDir, "demo.yaml"), `
+containers:
+  web:
+    image: nginx:1.27
+    volumes: [./html:/usr/share/nginx/html]
+`)
+	require.NoError(t, engine.Apply(ctx, stack))
+	assert.FileExists(t, filepath.Join(stackDir, "demo.lock"))
+	assert.DirExists(t, filepath.Join(stackDir, ".otari", "history", "demo"))
+	assert.NoFileExists(t, "demo.lock")
+	assert.NoDirExists(t, ".otari")
+	quadlet, err := os.ReadFile(filepath.Join(utils.OutputLocation(), "demo-web.container"))
+	require.NoError(t, err)
+	assert.Contains(t, string(quadlet), "Volume="+filepath.Join(stackDir, "html")+":/usr/share/nginx/html")
+
+	// a central state directory keeps the state of every stack
+	stateDir := filepath.Join(filepath.Dir(path), "state")
+	engine = New(Options{Runtime: host, Services: host, StateDir: stateDir})
+	stack = load(t, engine, path, e2eStack)
+	require.NoError(t, engine.Apply(ctx, stack))
+	assert.FileExists(t, filepath.Join(stateDir, "demo.lock"))
+	assert.DirExists(t, filepath.Join(stateDir, ".otari", "history", "demo"))
+	assert.NoFileExists(t, "demo.lock")
+}
+
+func TestLoadNamesStack(t *testing.T) {
+	ctx := context.Background()
+	engine, _, path := setup(t)
+	const web = `
+containers:
+  web:
+    image: nginx:1.27
+`
+	shop := filepath.Join(filepath.Dir(path), "shop")
+	blog := filepath.Join(filepath.Dir(path), "blog")
+	require.NoError(t, os.MkdirAll(shop, 0o755))
+	require.NoError(t, os.MkdirAll(blog, 0o755))
+
+	// default stack files are named after their directory
+	stack := load(t, engine, filepath.Join(shop, "otari.yaml"), web)
+	assert.Equal(t, "shop", stack.Name())
+	require.NoError(t, engine.Apply(ctx, stack))
+	assert.True(t, quadletExists("shop-web.container"))
+	stack = load(t, engine, filepath.Join(blog, "otari.yml"), web)
+	assert.Equal(t, "blog", stack.Name())
+
+	// other stack files are named after the file
+	stack = load(t, engine, path, web)
+	assert.Equal(t, "demo", stack.Name())
+
+	// the name key wins
+	stack = load(t, engine, path, "name: web\n"+web)
+	assert.Equal(t, "web", stack.Name())
+	stack = load(t, engine, filepath.Join(shop, "otari.yaml"), "name: store\n"+web)
+	assert.Equal(t, "store", stack.Name())
+
+	// stacks deployed as 'otari' keep their name
+	stack = load(t, engine, filepath.Join(blog, "otari.yml"), "name: otari\n"+web)
+	require.NoError(t, engine.Apply(ctx, stack))
+	stack = load(t, engine, filepath.Join(blog, "otari.yml"), web)
+	assert.Equal(t, "otari", stack.Name())
+
+	stack = load(t, engine, path, "name: my stack\n"+web)
+	var validationErr *ValidationError
+	require.ErrorAs(t, engine.Validate(stack), &validationErr)
+	assert.Contains(t, validationErr.Problems, "Stack name 'my stack' is invalid, it may only contain letters, digits, '_', '.' and '-'. Set 'name' in the stack file.")
+}
+
+func TestApplyWaitsForHealthyDependencies(t *testing.T) {
+	engine, host, path := setup(t)
+	host.Health["demo-db"] = "unhealthy"
+
+	stack := load(t, engine, path, e2eStack)
+	err := engine.Apply(context.Background(), stack)
+
+	var resourceErr *ResourceError
+	require.ErrorAs(t, err, &resourceErr)
+	assert.Equal(t, "wait for", resourceErr.Op)
+	assert.Equal(t, "demo-db", resourceErr.Name)
+	assert.Equal(t, []string{"start demo-db"}, host.Recorded("start"))
+	assert.False(t, utils.PathExists("demo.lock"))
+}
+
+func TestApplyReportsFailedUnits(t *testing.T) {
+	engine, host, path := setup(t)
+	boom := errors.New("boom")
+	host.Failures["start demo-web"] = boom
+
+	var failed []Event
+	engine.observer = ObserverFunc(func(e Event) {
+		if e.Type == EventFailed {
+			failed = append(failed, e)
+		}
+	})
+
+	stack := load(t, engine, path, e2eStack)
+	err := engine.Apply(context.Background(), stack)
+	require.ErrorIs(t, err, boom)
+
+	require.Len(t, failed, 1)
+	assert.Equal(t, KindContainer, failed[0].Kind)
+	assert.Equal(t, "demo-web", failed[0].Name)
+	assert.False(t, stack.Deployed())
+}
+
+func TestApplyResumesInterruptedDeployments(t *testing.T) {
+	ctx := context.Background()
+	engine, host, path := setup(t)
+	outputDir := utils.OutputLocation()
+
+	stack := load(t, engine, path, e2eStack)
+	require.NoError(t, engine.Apply(ctx, stack))
+	assert.NoDirExists(t, journal.Dir(".", "demo"))
+	good, err := os.ReadFile(filepath.Join(outputDir, "demo-web.container"))
+	require.NoError(t, err)
+
+	// a deployment interrupted while swapping in its quadlets is reverted
+	j, err := journal.Stage(".", "demo", outputDir, map[string][]byte{"demo-web.container": []byte("half"), "demo-new.container": []byte("new")})
+	require.NoError(t, err)
+	require.NoError(t, os.Rename(filepath.Join(outputDir, ".demo-new.container.staged"), filepath.Join(outputDir, "demo-new.container")))
+	host.Reset()
+	stack = load(t, engine, path, e2eStack)
+	require.NoError(t, engine.Apply(ctx, stack))
+	assert.False(t, quadletExists("demo-new.container"))
+	web, err := os.ReadFile(filepath.Join(outputDir, "demo-web.container"))
+	require.NoError(t, err)
+	assert.Equal(t, good, web)
+	assert.NoDirExists(t, journal.Dir(".", "demo"))
+
+	// once every quadlet is swapped in, the units run the interrupted
+	// deployment, they are restored to what the lock file describes
+	goodLock, err := os.ReadFile("demo.lock")
+	require.NoError(t, err)
+	j, err = journal.Stage(".", "demo", outputDir, map[string][]byte{
+		"demo-web.container":     []byte("changed"),
+		"demo-new.container":     []byte("new"),
+		"demo-migrate.container": nil,
+	})
+	require.NoError(t, err)
+	require.NoError(t, j.Swap())
+	assert.False(t, quadletExists("demo-migrate.container"))
+	require.NoError(t, host.StartUnit("demo-new"))
+	host.Reset()
+	stack = load(t, engine, path, e2eStack)
+	require.NoError(t, engine.Apply(ctx, stack))
+	assert.Equal(t, []string{"stop demo-new"}, host.Recorded("stop"))
+	assert.Equal(t, []string{"restart demo-web"}, host.Recorded("restart"))
+	assert.Contains(t, host.Recorded("start"), "start demo-migrate")
+	assert.False(t, host.Active("demo-new"))
+	assert.False(t, quadletExists("demo-new.container"))
+	assert.True(t, quadletExists("demo-migrate.container"))
+	web, err = os.ReadFile(filepath.Join(outputDir, "demo-web.container"))
+	require.NoError(t, err)
+	assert.Equal(t, good, web)
+	lock, err := os.ReadFile("demo.lock")
+	require.NoError(t, err)
+	assert.Equal(t, goodLock, lock)
+	assert.NoDirExists(t, journal.Dir(".", "demo"))
+
+	// stopping the stack reverts an interrupted deployment too
+	_, err = journal.Stage(".", "demo", outputDir, map[string][]byte{"demo-new.container": []byte("new")})
+	require.NoError(t, err)
+	require.NoError(t, os.Rename(filepath.Join(outputDir, ".demo-new.container.staged"), filepath.Join(outputDir, "demo-new.container")))
+	require.NoError(t, engine.Stop(ctx, stack))
+	assert.False(t, quadletExists("demo-new.container"))
+	assert.NoDirExists(t, journal.Dir(".", "demo"))
+}
+
+func TestApplyLocksStack(t *testing.T) {
+	ctx := context.Background()
+	engine, host, path := setup(t)
+	stack := load(t, engine, path, e2eStack)
+
+	lock, err := stacklock.Acquire(ctx, ".", "demo", "otari start", false, 0, nil)
+	require.NoError(t, err)
+	var lockedErr *LockedError
+	require.ErrorAs(t, engine.Apply(ctx, stack), &lockedErr)
+	assert.Equal(t, "otari start", lockedErr.Holder.Command)
+	assert.ErrorIs(t, engine.Stop(ctx, stack), ErrStackLocked)
+	// operations that rewrite the lock file of the stack take the lock too
+	assert.ErrorIs(t, engine.Lock(ctx, stack, false), ErrStackLocked)
+	_, err = engine.Pull(ctx, stack)
+	assert.ErrorIs(t, err, ErrStackLocked)
+	assert.ErrorIs(t, engine.Build(ctx, stack, "", false), ErrStackLocked)
+	assert.Empty(t, host.Calls)
+	assert.NoFileExists(t, "demo.lock")
+
+	// a waiting engine deploys once the lock is released
+	engine = New(Options{Runtime: host, Services: host, WaitTimeout: time.Minute})
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		lock.Release()
+	}()
+	require.NoError(t, engine.Apply(ctx, stack))
+	assert.True(t, stack.Deployed())
+}
+
+func TestApplyRollsBackFailedDeployments(t *testing.T) {
+	ctx := context.Background()
+	engine, host, path := setup(t)
+
+	stack := load(t, engine, path, e2eStack)
+	require.NoError(t, engine.Apply(ctx, stack))
+	good, err := os.ReadFile(filepath.Join(utils.OutputLocation(), "demo-web.container"))
+	require.NoError(t, err)
+	goodLock, err := os.ReadFile("demo.lock")
+	require.NoError(t, err)
+
+	// the changed container fails once, the rollback restarts it again
+	boom := errors.New("boom")
+	host.Failures["restart demo-web"] = boom
+	engine.observer = ObserverFunc(func(e Event) {
+		if e.Type == EventFailed {
+			delete(host.Failures, "restart demo-web")
+		}
+	})
+	host.Reset()
+	stack = load(t, engine, path, strings.Replace(e2eStack, "MODE: prod", "MODE: dev", 1))
+	err = engine.Apply(ctx, stack)
+
+	var rolledBack *RolledBackError
+	require.ErrorAs(t, err, &rolledBack)
+	assert.Equal(t, 1, rolledBack.Revision)
+	assert.ErrorIs(t, err, boom)
+	assert.Equal(t, []string{"restart demo-web", "restart demo-web"}, host.Recorded("restart"))
+	restored, err := os.ReadFile(filepath.Join(utils.OutputLocation(), "demo-web.container"))
+	require.NoError(t, err)
+	assert.Equal(t, good, restored)
+
+	revisions, err := engine.History(stack)
+	require.NoError(t, err)
+	require.Len(t, revisions, 3)
+	assert.Equal(t, RevisionDeployed, revisions[0].Status)
+	assert.Equal(t, RevisionFailed, revisions[1].Status)
+	assert.Contains(t, revisions[1].Error, "boom")
+	assert.Equal(t, RevisionDeployed, revisions[2].Status)
+	assert.Equal(t, 1, revisions[2].RollbackOf)
+
+	// the restored lock file still describes the good deployment
+	lock, err := os.ReadFile("demo.lock")
+	require.NoError(t, err)
+	assert.Equal(t, goodLock, lock)
+
+	// a deployment that succeeds can be rolled back by hand, the quadlets
+	// it added are removed by the same swap
+	stack = load(t, engine, path, strings.Replace(e2eStack, "MODE: prod", "MODE: dev", 1)+"  extra:\n    image: busybox\n")
+	require.NoError(t, engine.Apply(ctx, stack))
+	assert.True(t, quadletExists("demo-extra.container"))
+	host.Reset()
+	require.NoError(t, engine.Rollback(ctx, stack, 0))
+	assert.Equal(t, []string{"restart demo-web"}, host.Recorded("restart"))
+	assert.Contains(t, host.Recorded("stop"), "stop demo-extra")
+	assert.False(t, quadletExists("demo-extra.container"))
+	restored, err = os.ReadFile(filepath.Join(utils.OutputLocation(), "demo-web.container"))
+	require.NoError(t, err)
+	assert.Equal(t, good, restored)
+	assert.NoDirExists(t, journal.Dir(".", "demo"))
+
+	// failed revisions cannot be restored
+	assert.ErrorIs(t, engine.Rollback(ctx, stack, 2), ErrNoRevision)
+	assert.ErrorIs(t, engine.Rollback(ctx, stack, 42), ErrNoRevision)
+}
+
+func TestApplyWithoutRollback(t *testing.T) {
+	ctx := context.Background()
+	_, host, path := setup(t)
+	engine := New(Options{Runtime: host, Services: host, NoRollback: true})
+
+	stack := load(t, engine, path, e2eStack)
+	require.NoError(t, engine.Apply(ctx, stack))
+
+	boom := errors.New("boom")
+	host.Failures["restart demo-web"] = boom
+	stack = load(t, engine, path, strings.Replace(e2eStack, "MODE: prod", "MODE: dev", 1))
+	err := engine.Apply(ctx, stack)
+	require.ErrorIs(t, err, boom)
+	var rolledBack *RolledBackError
+	assert.False(t, errors.As(err, &rolledBack))
+
+	revisions, err := engine.History(stack)
+	require.NoError(t, err)
+	require.Len(t, revisions, 2)
+	assert.Equal(t, RevisionFailed, revisions[1].Status)
+}
+
+func TestApplyPinsImages(t *testing.T) {
+	ctx := context.Background()
+	engine, host, path := setup(t)
+
+	stack := load(t, engine, path, e2eStack)
+	require.NoError(t, engine.Apply(ctx, stack))
+	quadlet, err := os.ReadFile(filepath.Join(utils.OutputLocation(), "demo-web.container"))
+	require.NoError(t, err)
+	assert.Contains(t, string(quadlet), "Image=nginx@"+fake.Digest("nginx:1.27")+"\n")
+	lock, err := os.ReadFile("demo.lock")
+	require.NoError(t, err)
+	assert.Contains(t, string(lock), fake.Digest("nginx:1.27"))
+
+	// a moved tag does not change the deployment
+	host.Reset()
+	host.Digests["nginx:1.27"] = "sha256:moved"
+	stack = load(t, engine, path, e2eStack)
+	require.NoError(t, engine.Apply(ctx, stack))
+	assert.Empty(t, host.Recorded("pull"))
+	assert.Empty(t, host.Recorded("restart"))
+
+	// until the lock is updated
+	host.Reset()
+	require.NoError(t, engine.Lock(ctx, stack, true))
+	assert.ElementsMatch(t, []string{"pull migrate:1", "pull nginx:1.27", "pull postgres:16"}, host.Recorded("pull"))
+	host.Reset()
+	stack = load(t, engine, path, e2eStack)
+	require.NoError(t, engine.Apply(ctx, stack))
+	// the image was pulled by the update already
+	assert.Empty(t, host.Recorded("pull"))
+	assert.Equal(t, []string{"restart demo-web"}, host.Recorded("restart"))
+}
+
+func TestApplyFrozen(t *testing.T) {
+	ctx := context.Background()
+	_, host, path := setup(t)
+	engine := New(Options{Runtime: host, Services: host, Frozen: true})
+
+	stack := load(t, engine, path, e2eStack)
+	assert.ErrorIs(t, engine.Apply(ctx, stack), ErrLockMissing)
+	assert.Empty(t, host.Calls)
+
+	// a lock written before the first deployment pins every image
+	require.NoError(t, engine.Lock(ctx, stack, false))
+	stack = load(t, engine, path, e2eStack)
+	require.NoError(t, engine.Apply(ctx, stack))
+
+	host.Reset()
+	stack = load(t, engine, path, strings.Replace(e2eStack, "nginx:1.27", "nginx:1.28", 1))
+	err := engine.Apply(ctx, stack)
+	assert.ErrorIs(t, err, ErrLockStale)
+	assert.ErrorContains(t, err, "web")
+	assert.Empty(t, host.Calls)
+}
+
+func TestApplyRebuildsChangedContexts(t *testing.T) {
+	ctx := context.Background()
+	engine, host, path := setup(t)
+	require.NoError(t, os.MkdirAll("web", 0o755))
+	require.NoError(t, os.WriteFile("web/Containerfile", []byte("FROM alpine\nCOPY . /app\n"), 0o644))
+	require.NoError(t, os.WriteFile("web/.containerignore", []byte("*.log\n"), 0o644))
+	require.NoError(t, os.WriteFile("web/main.go", []byte("package main\n"), 0o644))
+	const buildStack = `
+containers:
+  web:
+    build: web
+`
+
+	stack := load(t, engine, path, buildStack)
+	require.NoError(t, engine.Apply(ctx, stack))
+	assert.Equal(t, []string{"build demo_web"}, host.Recorded("build"))
+	assert.Equal(t, []string{"start demo-web"}, host.Recorded("start"))
+
+	// ignored files do not count
+	host.Reset()
+	require.NoError(t, os.WriteFile("web/debug.log", []byte("started\n"), 0o644))
+	stack = load(t, engine, path, buildStack)
+	require.NoError(t, engine.Apply(ctx, stack))
+	assert.Empty(t, host.Recorded("build"))
+	assert.Empty(t, host.Recorded("restart"))
+
+	// changed sources are rebuilt and restarted
+	host.Reset()
+	require.NoError(t, os.WriteFile("web/main.go", []byte("package main\n\nfunc main() {}\n"), 0o644))
+	stack = load(t, engine, path, buildStack)
+	plan, err := engine.Plan(ctx, stack)
+	require.NoError(t, err)
+	assert.True(t, plan.Pending())
+	require.NoError(t, engine.Apply(ctx, stack))
+	assert.Equal(t, []string{"build demo_web"}, host.Recorded("build"))
+	assert.Equal(t, []string{"restart demo-web"}, host.Recorded("restart"))
+
+	// forced rebuilds restart the container and are recorded
+	host.Reset()
+	require.NoError(t, engine.Build(ctx, stack, "web", true))
+	assert.Equal(t, []string{"build demo_web --no-cache", "restart demo-web"}, host.Calls)
+	host.Reset()
+	stack = load(t, engine, path, buildStack)
+	require.NoError(t, engine.Apply(ctx, stack))
+	assert.Empty(t, host.Recorded("build"))
+
+	assert.ErrorIs(t, engine.Build(ctx, stack, "db", false), ErrContainerNotFound)
+}
+
+func TestApplyPullPolicies(t *testing.T) {
+	ctx := context.Background()
+	engine, host, path := setup(t)
+	const policyStack = `
+containers:
+  web:
+    image: nginx:latest
+    pull_policy: always
+  api:
+    image: api:latest
+    pull_policy: newer
+  cache:
+    image: redis:7
+  worker:
+    image: worker:local
+    pull_policy: never
+`
+	host.Images["worker:local"] = true
+
+	stack := load(t, engine, path, policyStack)
+	require.NoError(t, engine.Apply(ctx, stack))
+	assert.ElementsMatch(t, []string{"pull api:latest", "pull nginx:latest", "pull redis:7"}, host.Recorded("pull"))
+
+	// images that did not change do not restart their containers
+	host.Reset()
+	stack = load(t, engine, path, policyStack)
+	require.NoError(t, engine.Apply(ctx, stack))
+	assert.ElementsMatch(t, []string{"pull api:latest", "pull nginx:latest"}, host.Recorded("pull"))
+	assert.Empty(t, host.Recorded("restart"))
+
+	// only the container whose tag moved is restarted
+	host.Reset()
+	host.Digests["nginx:latest"] = "sha256:moved"
+	stack = load(t, engine, path, policyStack)
+	require.NoError(t, engine.Apply(ctx, stack))
+	assert.Equal(t, []string{"restart demo-web"}, host.Recorded("restart"))
+
+	// never pulling fails for missing images
+	delete(host.Images, "worker:local")
+	stack = load(t, engine, path, strings.Replace(policyStack, "worker:local", "worker:next", 1))
+	assert.ErrorIs(t, engine.Apply(ctx, stack), errPullNever)
+}
+
+func TestPull(t *testing.T) {
+	ctx := context.Background()
+	engine, host, path := setup(t)
+
+	stack := load(t, engine, path, e2eStack)
+	require.NoError(t, engine.Apply(ctx, stack))
+
+	host.Reset()
+	host.Digests["postgres:16"] = "sha256:moved"
+	pulled, err := engine.Pull(ctx, stack)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"pull migrate:1", "pull nginx:1.27", "pull postgres:16"}, host.Recorded("pull"))
+	require.Len(t, pulled, 3)
+	var changed []string
+	for _, image := range pulled {
+		if image.Changed() {
+			changed = append(changed, image.Image)
+			assert.Equal(t, []string{"db"}, image.Containers)
+			assert.Equal(t, fake.Digest("postgres:16"), image.Previous)
+		}
+	}
+	assert.Equal(t, []string{"postgres:16"}, changed)
+
+	host.Reset()
+	stack = load(t, engine, path, e2eStack)
+	require.NoError(t, engine.Apply(ctx, stack))
+	assert.Equal(t, []string{"restart demo-db"}, host.Recorded("restart"))
+}
+
+func TestStatus(t *testing.T) {
+	ctx := context.Background()
+	engine, host, path := setup(t)
+
+	stack := load(t, engine, path, e2eStack)
+	st, err := engine.Status(ctx, stack)
+	require.NoError(t, err)
+	assert.False(t, st.Deployed)
+
+	require.NoError(t, engine.Apply(ctx, stack))
+	st, err = engine.Status(ctx, stack)
+	require.NoError(t, err)
+	assert.True(t, st.Deployed)
+	assert.Empty(t, st.Problems())
+	web := statusOf(t, st, "web")
+	assert.Equal(t, "active", web.ActiveState)
+	require.NotNil(t, web.Container)
+	assert.True(t, web.Container.Running)
+
+	// a unit stopped behind otari's back shows up through the service manager
+	require.NoError(t, host.StopUnit("demo-web.service"))
+	st, err = engine.Status(ctx, stack)
+	require.NoError(t, err)
+	assert.Equal(t, "inactive", statusOf(t, st, "web").ActiveState)
+}
+
+func statusOf(t *testing.T, st *Status, name string) *ResourceStatus {
+	t.Helper()
+	for _, r := range st.Resources {
+		if r.Kind == KindContainer && r.Name == name {
+			return r
+		}
+	}
+	require.FailNow(t, "no status for container "+name)
+	return nil
+}
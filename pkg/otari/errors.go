@@ -0,0 +1,108 @@
+package otari
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/danecwalker/otari/internal/stacklock"
+)
+
+// ErrContainerNotFound is returned for containers the stack does not
+// define.
+var ErrContainerNotFound = errors.New("container not found in stack definition")
+
+// ErrNoRevision is returned by Rollback if the stack has no revision to
+// roll back to.
+var ErrNoRevision = errors.New("no revision to roll back to")
+
+// ErrLockMissing and ErrLockStale are returned by Apply in frozen mode if
+// the stack has no lock file or it does not pin the image of every
+// container.
+var (
+	ErrLockMissing = errors.New("lock file missing")
+	ErrLockStale   = errors.New("lock file out of date")
+)
+
+// ErrStackLocked is returned by operations that change a stack while
+// another process holds its lock, wrapped in a LockedError that names the
+// holder.
+var ErrStackLocked = stacklock.ErrLocked
+
+// LockedError is returned if another process holds the lock of a stack.
+type LockedError = stacklock.LockedError
+
+// LoadOp is the step of loading a stack that failed.
+type LoadOp string
+
+const (
+	LoadRead  LoadOp = "read"
+	LoadParse LoadOp = "parse"
+	LoadLock  LoadOp = "lock"
+)
+
+// LoadError is returned by Load if the stack file or its lock file could
+// not be read.
+type LoadError struct {
+	Op   LoadOp
+	Path string
+	Err  error
+}
+
+func (e *LoadError) Error() string {
+	switch e.Op {
+	case LoadParse:
+		return fmt.Sprintf("failed to parse stack definition %s: %v", e.Path, e.Err)
+	case LoadLock:
+		return fmt.Sprintf("failed to read stack lock file: %v", e.Err)
+	}
+	return fmt.Sprintf("failed to read %s: %v", e.Path, e.Err)
+}
+
+func (e *LoadError) Unwrap() error {
+	return e.Err
+}
+
+// ValidationError is returned if a stack breaks one or more rules.
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return "invalid stack: " + strings.Join(e.Problems, "; ")
+}
+
+// ResourceError is returned if an operation on a single stack resource
+// failed. Kind is one of the Kind constants and Name the name of the
+// resource in podman.
+type ResourceError struct {
+	Op   string
+	Kind string
+	Name string
+	Err  error
+}
+
+func (e *ResourceError) Error() string {
+	return fmt.Sprintf("failed to %s %s '%s': %v", e.Op, e.Kind, e.Name, e.Err)
+}
+
+func (e *ResourceError) Unwrap() error {
+	return e.Err
+}
+
+// RolledBackError is returned by Apply if the deployment failed and the
+// stack was rolled back to its last successful revision.
+type RolledBackError struct {
+	// Revision is the revision the stack was rolled back to.
+	Revision int
+	// Err is why the deployment failed.
+	Err error
+}
+
+func (e *RolledBackError) Error() string {
+	return fmt.Sprintf("deployment failed and was rolled back to revision %d: %v", e.Revision, e.Err)
+}
+
+func (e *RolledBackError) Unwrap() error {
+	return e.Err
+}
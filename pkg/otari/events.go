@@ -0,0 +1,131 @@
+package otari
+
+import (
+	"fmt"
+
+	"github.com/danecwalker/otari/internal/changes"
+	"github.com/danecwalker/otari/internal/generate"
+	"github.com/danecwalker/otari/internal/systemd"
+)
+
+// Resource kinds that events and errors refer to.
+const (
+	KindNetwork   = generate.KindNetwork
+	KindVolume    = generate.KindVolume
+	KindPod       = generate.KindPod
+	KindContainer = generate.KindContainer
+	KindSecret    = changes.KindSecret
+	KindImage     = "image"
+	// KindStack refers to the stack as a whole, e.g. the .kube unit of a
+	// stack in kube mode.
+	KindStack = "stack"
+)
+
+// EventType tells what happened to a step of an operation. A step starts
+// with EventStarted, which is sent again when its message changes, may
+// report EventProgress and ends with one of EventSucceeded, EventSkipped or
+// EventFailed.
+type EventType string
+
+const (
+	EventStarted   EventType = "started"
+	EventProgress  EventType = "progress"
+	EventSucceeded EventType = "succeeded"
+	EventSkipped   EventType = "skipped"
+	EventFailed    EventType = "failed"
+	// EventNotice is a message that does not belong to a step.
+	EventNotice EventType = "notice"
+)
+
+// Event reports the progress of an operation.
+type Event struct {
+	Type EventType
+	// Action is what the step does, e.g. "pull" or "start".
+	Action string
+	// Kind and Name identify the resource the step acts on. They are empty
+	// for steps that act on the whole stack.
+	Kind    string
+	Name    string
+	Message string
+	// Err is set for EventFailed.
+	Err error
+}
+
+// Observer receives the events of the operations of an Engine. Events are
+// delivered synchronously and one at a time. Images are pulled and built in
+// parallel, so the steps of different images interleave, they are told
+// apart by Kind and Name.
+type Observer interface {
+	OnEvent(e Event)
+}
+
+// ObserverFunc adapts a function to an Observer.
+type ObserverFunc func(e Event)
+
+func (f ObserverFunc) OnEvent(e Event) {
+	f(e)
+}
+
+// Discard is an Observer that ignores every event.
+var Discard Observer = ObserverFunc(func(Event) {})
+
+// step emits the events of a single step of an operation.
+type step struct {
+	observer Observer
+	action   string
+	kind     string
+	name     string
+}
+
+func (e *Engine) step(action, kind, name, message string) *step {
+	s := &step{observer: e.observer, action: action, kind: kind, name: name}
+	s.emit(EventStarted, message, nil)
+	return s
+}
+
+func (s *step) emit(t EventType, message string, err error) {
+	s.observer.OnEvent(Event{Type: t, Action: s.action, Kind: s.kind, Name: s.name, Message: message, Err: err})
+}
+
+func (s *step) update(message string) {
+	s.emit(EventStarted, message, nil)
+}
+
+func (s *step) progress(message string) {
+	s.emit(EventProgress, message, nil)
+}
+
+func (s *step) succeed(message string) {
+	s.emit(EventSucceeded, message, nil)
+}
+
+func (s *step) skip(message string) {
+	s.emit(EventSkipped, message, nil)
+}
+
+// fail reports the step as failed and returns err for the caller to
+// return.
+func (s *step) fail(message string, err error) error {
+	s.emit(EventFailed, message, err)
+	return err
+}
+
+func (e *Engine) notice(message string) {
+	e.observer.OnEvent(Event{Type: EventNotice, Message: message})
+}
+
+// watch reports the state transitions of a unit as progress of s, if the
+// service manager can observe them. The returned function ends the watch.
+func (e *Engine) watch(s *step, unitName string) func() {
+	watcher, ok := e.services.(systemd.Watcher)
+	if !ok {
+		return func() {}
+	}
+	stop, err := watcher.WatchUnit(unitName, func(state *systemd.UnitState) {
+		s.progress(fmt.Sprintf("Unit '%s' is %s (%s).", unitName, state.ActiveState, state.SubState))
+	})
+	if err != nil {
+		return func() {}
+	}
+	return stop
+}
@@ -0,0 +1,320 @@
+package otari
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/danecwalker/otari/internal/changes"
+	"github.com/danecwalker/otari/internal/definition"
+	"github.com/danecwalker/otari/internal/history"
+	"github.com/danecwalker/otari/internal/journal"
+	"github.com/danecwalker/otari/internal/kube"
+	"github.com/danecwalker/otari/internal/quadlets"
+	"github.com/danecwalker/otari/internal/utils"
+)
+
+// Revision is a deployment of a stack kept in its history.
+type Revision = history.Revision
+
+// Revision statuses.
+const (
+	RevisionDeployed = history.StatusDeployed
+	RevisionFailed   = history.StatusFailed
+)
+
+// History returns the revisions of the stack, oldest first.
+func (e *Engine) History(stack *Stack) ([]*Revision, error) {
+	return history.List(stack.Definition.StateDir, stack.Name())
+}
+
+// Rollback restores the quadlets and lock file of a revision of the stack
+// and restarts the units that changed. Revision 0 rolls back to the last
+// successful revision before the current one. The quadlets are swapped in
+// together like in Apply.
+func (e *Engine) Rollback(ctx context.Context, stack *Stack, revision int) error {
+	unlock, err := e.lockStack(ctx, stack)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	def := stack.Definition
+	if err := e.resume(def); err != nil {
+		return err
+	}
+	stackName := def.StackName
+	current, err := history.Latest(def.StateDir, stackName)
+	if err != nil {
+		return err
+	}
+	if current == nil {
+		return fmt.Errorf("%w: stack '%s' has no history", ErrNoRevision, stackName)
+	}
+
+	var target *Revision
+	if revision == 0 {
+		target, err = history.LastGood(def.StateDir, stackName, current.Number)
+		if err == nil && target == nil {
+			err = fmt.Errorf("%w: stack '%s' has no successful revision before revision %d", ErrNoRevision, stackName, current.Number)
+		}
+	} else {
+		target, err = history.Get(def.StateDir, stackName, revision)
+		if errors.Is(err, history.ErrNotFound) {
+			err = fmt.Errorf("%w: stack '%s' has no revision %d", ErrNoRevision, stackName, revision)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if target.Status != RevisionDeployed {
+		return fmt.Errorf("%w: revision %d failed to deploy", ErrNoRevision, target.Number)
+	}
+
+	if err := e.rollback(def, current, target); err != nil {
+		return err
+	}
+	stack.deployed, err = changes.LoadStackData(def)
+	if err != nil {
+		return &LoadError{Op: LoadLock, Path: stack.Path, Err: err}
+	}
+	return nil
+}
+
+// recordRevision records the quadlets of the stack as a new revision. The
+// image digests and the lock file are only kept for deployed revisions.
+func (e *Engine) recordRevision(ctx context.Context, stack *definition.Stack, revision *Revision) error {
+	lockPath := ""
+	if revision.Status == RevisionDeployed {
+		lockPath = stack.LockPath()
+		revision.Images = e.imageDigests(ctx, stack)
+	}
+	return history.Record(stack.StateDir, stack.StackName, revision, lockPath, utils.OutputLocation(), changes.QuadletFileNames(stack))
+}
+
+// imageDigests returns the digests of the images the running containers of
+// the stack were started from, keyed by unit name.
+func (e *Engine) imageDigests(ctx context.Context, stack *definition.Stack) map[string]string {
+	if stack.IsKube() {
+		// the containers of kube stacks are named by podman kube play
+		return nil
+	}
+	digests := make(map[string]string)
+	for containerName := range stack.Containers {
+		containerUnitName := stack.ResourceName(containerName)
+		state, err := e.runtime.InspectContainer(ctx, containerUnitName)
+		if err == nil && state.ImageDigest != "" {
+			digests[containerUnitName] = state.ImageDigest
+		}
+	}
+	return digests
+}
+
+// recover records a deployment that failed with deployErr and rolls the
+// stack back to its last successful revision, unless rollbacks are
+// disabled or there is none.
+func (e *Engine) recover(ctx context.Context, stack *Stack, deployErr error) error {
+	def := stack.Definition
+	failed := &Revision{Status: RevisionFailed, Error: deployErr.Error()}
+	if err := e.recordRevision(ctx, def, failed); err != nil {
+		return errors.Join(deployErr, fmt.Errorf("failed to record stack revision: %w", err))
+	}
+	if e.noRollback {
+		return deployErr
+	}
+
+	good, err := history.LastGood(def.StateDir, def.StackName, failed.Number)
+	if err != nil || good == nil {
+		return deployErr
+	}
+	e.notice(fmt.Sprintf("Deployment failed, rolling back stack '%s' to revision %d.", def.StackName, good.Number))
+	// the quadlets of the failed deployment are in place, the rollback
+	// journals its own swap
+	if err := journal.Clear(def.StateDir, def.StackName); err != nil {
+		return errors.Join(deployErr, fmt.Errorf("failed to remove deployment journal: %w", err))
+	}
+	if err := e.rollback(def, failed, good); err != nil {
+		return errors.Join(deployErr, fmt.Errorf("failed to roll back to revision %d: %w", good.Number, err))
+	}
+	stack.deployed, err = changes.LoadStackData(def)
+	if err != nil {
+		return &LoadError{Op: LoadLock, Path: stack.Path, Err: err}
+	}
+	return &RolledBackError{Revision: good.Number, Err: deployErr}
+}
+
+// restoreOrder is the order units are restarted in on rollback, resources
+// before the containers that use them.
+var restoreOrder = []string{".network", ".volume", ".pod", ".container", ".kube"}
+
+// quadletUnit returns the unit and resource kind of a quadlet file, the
+// unit is empty for files that are not quadlets.
+func quadletUnit(fileName string) (unitName, kind string) {
+	ext := filepath.Ext(fileName)
+	name := strings.TrimSuffix(fileName, ext)
+	switch ext {
+	case ".container":
+		return name, KindContainer
+	case ".network":
+		return quadlets.NetworkServiceName(name), KindNetwork
+	case ".volume":
+		return quadlets.VolumeServiceName(name), KindVolume
+	case ".pod":
+		return quadlets.PodServiceName(name), KindPod
+	case ".kube":
+		return name + ".service", KindStack
+	}
+	return "", ""
+}
+
+// rollback replaces the quadlets of the current revision with those of the
+// target revision, restores its lock file and restarts the changed units.
+// It is recorded as a new revision.
+func (e *Engine) rollback(stack *definition.Stack, current, target *Revision) error {
+	stackName := stack.StackName
+	currentFiles, err := current.Quadlets()
+	if err != nil {
+		return fmt.Errorf("failed to read revision %d: %w", current.Number, err)
+	}
+	targetFiles, err := target.Quadlets()
+	if err != nil {
+		return fmt.Errorf("failed to read revision %d: %w", target.Number, err)
+	}
+	// run the exact images the revision ran
+	for fileName, content := range targetFiles {
+		if digest := target.Images[strings.TrimSuffix(fileName, ".container")]; digest != "" && strings.HasSuffix(fileName, ".container") {
+			targetFiles[fileName] = history.PinImage(content, digest)
+		}
+	}
+
+	// stop what the target revision did not have, its quadlets are removed
+	// by the swap below
+	outputDir := utils.OutputLocation()
+	files := make(map[string][]byte)
+	for _, fileName := range slices.Sorted(maps.Keys(currentFiles)) {
+		if _, ok := targetFiles[fileName]; ok {
+			continue
+		}
+		unitName, kind := quadletUnit(fileName)
+		name := strings.TrimSuffix(fileName, filepath.Ext(fileName))
+		if unitName != "" {
+			s := e.step("stop", kind, name, fmt.Sprintf("Stopping '%s'...", unitName))
+			if err := e.services.StopUnit(unitName); err != nil {
+				return s.fail(fmt.Sprintf("Failed to stop '%s'.", unitName), &ResourceError{Op: "stop", Kind: kind, Name: name, Err: err})
+			}
+			s.succeed(fmt.Sprintf("Stopped '%s'.", unitName))
+		}
+		files[fileName] = nil
+	}
+
+	// swap in the quadlets that differ
+	if err := os.MkdirAll(outputDir, 0o755); err != nil {
+		return err
+	}
+	var changed []string
+	for _, fileName := range slices.Sorted(maps.Keys(targetFiles)) {
+		onDisk, err := os.ReadFile(filepath.Join(outputDir, fileName))
+		if err == nil && bytes.Equal(onDisk, targetFiles[fileName]) {
+			continue
+		}
+		files[fileName] = targetFiles[fileName]
+		changed = append(changed, fileName)
+	}
+	s := e.step("restore", KindStack, stackName, fmt.Sprintf("Restoring the quadlets of revision %d...", target.Number))
+	j, err := journal.Stage(stack.StateDir, stackName, outputDir, files)
+	if err != nil {
+		return s.fail("Failed to restore the quadlets.", fmt.Errorf("failed to stage quadlets: %w", err))
+	}
+	if err := j.Swap(); err != nil {
+		return s.fail("Failed to restore the quadlets.", errors.Join(err, j.Revert()))
+	}
+	if err := restoreLock(stack, target, targetFiles); err != nil {
+		return s.fail("Failed to restore the quadlets.", errors.Join(fmt.Errorf("failed to restore stack lock file: %w", err), j.Revert()))
+	}
+	if err := j.Done(); err != nil {
+		return s.fail("Failed to restore the quadlets.", fmt.Errorf("failed to remove deployment journal: %w", err))
+	}
+	s.succeed(fmt.Sprintf("Restored the quadlets of revision %d.", target.Number))
+
+	if err := e.services.ReloadDaemon(); err != nil {
+		return fmt.Errorf("failed to reload systemd daemon: %w", err)
+	}
+
+	if err := e.restoreUnits(stack, changed); err != nil {
+		return err
+	}
+
+	restored := &Revision{Status: RevisionDeployed, RollbackOf: target.Number, Images: target.Images}
+	if err := history.Record(stack.StateDir, stackName, restored, stack.LockPath(), outputDir, slices.Collect(maps.Keys(targetFiles))); err != nil {
+		return fmt.Errorf("failed to record stack revision: %w", err)
+	}
+	e.notice(fmt.Sprintf("Stack '%s' rolled back to revision %d.", stackName, target.Number))
+	return nil
+}
+
+// restoreUnits starts or restarts the units of restored quadlets, resources
+// before the containers that use them.
+func (e *Engine) restoreUnits(stack *definition.Stack, changed []string) error {
+	// kube manifests are run by the .kube unit of the stack
+	kubeFile := kube.QuadletFileName(stack)
+	for _, fileName := range changed {
+		if filepath.Ext(fileName) == ".yaml" && !slices.Contains(changed, kubeFile) && utils.PathExists(filepath.Join(utils.OutputLocation(), kubeFile)) {
+			changed = append(changed, kubeFile)
+		}
+	}
+	for _, ext := range restoreOrder {
+		for _, fileName := range changed {
+			if filepath.Ext(fileName) != ext {
+				continue
+			}
+			unitName, kind := quadletUnit(fileName)
+			name := strings.TrimSuffix(fileName, ext)
+			s := e.step("restore", kind, name, fmt.Sprintf("Restoring %s '%s'...", kind, name))
+			start := e.services.StartUnit
+			if state, err := e.services.GetUnitState(unitName); err == nil && state.ActiveState == "active" {
+				start = e.services.RestartUnit
+			}
+			stop := e.watch(s, unitName)
+			err := start(unitName)
+			stop()
+			if err != nil {
+				return s.fail(fmt.Sprintf("Failed to restore %s '%s'.", kind, name), &ResourceError{Op: "restore", Kind: kind, Name: name, Err: err})
+			}
+			s.succeed(fmt.Sprintf("Restored %s '%s'.", kind, name))
+		}
+	}
+	return nil
+}
+
+// restoreLock writes the lock file of the target revision, with the hashes
+// of the quadlets as they were restored.
+func restoreLock(stack *definition.Stack, target *Revision, quadletFiles map[string][]byte) error {
+	data, err := target.Lock()
+	if err != nil {
+		return err
+	}
+	if data == nil {
+		err := os.Remove(stack.LockPath())
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	var stackData changes.StackData
+	if err := toml.Unmarshal(data, &stackData); err != nil {
+		return err
+	}
+	stackData.Quadlets = make(map[string]string)
+	for fileName, content := range quadletFiles {
+		stackData.Quadlets[fileName] = changes.HashQuadlet(content)
+	}
+	return changes.WriteStackData(stack, &stackData)
+}
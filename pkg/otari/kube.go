@@ -0,0 +1,74 @@
+package otari
+
+import (
+	"fmt"
+
+	"github.com/danecwalker/otari/internal/definition"
+	"github.com/danecwalker/otari/internal/kube"
+)
+
+// startKube starts the .kube unit of a stack, restarting it if the stack
+// changed while it was running.
+func (e *Engine) startKube(stack *definition.Stack, changed bool) error {
+	unitName := kube.ServiceName(stack)
+	s := e.step("start", KindStack, stack.StackName, fmt.Sprintf("Starting stack '%s'...", stack.StackName))
+
+	if state, err := e.services.GetUnitState(unitName); err == nil && state.ActiveState == "active" {
+		if !changed {
+			s.skip(fmt.Sprintf("Stack '%s' is already running.", stack.StackName))
+			return nil
+		}
+		s.update(fmt.Sprintf("Restarting stack '%s'...", stack.StackName))
+		stop := e.watch(s, unitName)
+		err := e.services.RestartUnit(unitName)
+		stop()
+		if err != nil {
+			return s.fail(fmt.Sprintf("Failed to restart stack '%s'", stack.StackName), &ResourceError{Op: "restart", Kind: KindStack, Name: stack.StackName, Err: err})
+		}
+		s.succeed(fmt.Sprintf("Stack '%s' restarted.", stack.StackName))
+		return nil
+	}
+
+	stop := e.watch(s, unitName)
+	err := e.services.StartUnit(unitName)
+	stop()
+	if err != nil {
+		return s.fail(fmt.Sprintf("Failed to start stack '%s'", stack.StackName), &ResourceError{Op: "start", Kind: KindStack, Name: stack.StackName, Err: err})
+	}
+	s.succeed(fmt.Sprintf("Stack '%s' started.", stack.StackName))
+	return nil
+}
+
+// stopKube stops the .kube unit of a stack, which tears down its pods.
+func (e *Engine) stopKube(stack *definition.Stack) error {
+	unitName := kube.ServiceName(stack)
+	s := e.step("stop", KindStack, stack.StackName, fmt.Sprintf("Stopping stack '%s'...", stack.StackName))
+
+	if state, err := e.services.GetUnitState(unitName); err != nil || state.ActiveState != "active" {
+		s.skip(fmt.Sprintf("Stack '%s' is already stopped.", stack.StackName))
+		return nil
+	}
+
+	if err := e.services.StopUnit(unitName); err != nil {
+		return s.fail(fmt.Sprintf("Failed to stop stack '%s'", stack.StackName), &ResourceError{Op: "stop", Kind: KindStack, Name: stack.StackName, Err: err})
+	}
+	s.succeed(fmt.Sprintf("Stack '%s' stopped.", stack.StackName))
+	return nil
+}
+
+// removeKube stops the .kube unit of a stack and deletes it along with its
+// manifest.
+func (e *Engine) removeKube(stack *definition.Stack) error {
+	if err := e.stopKube(stack); err != nil {
+		return err
+	}
+
+	s := e.step("remove", KindStack, stack.StackName, fmt.Sprintf("Removing Kubernetes manifest of stack '%s'...", stack.StackName))
+	for _, fileName := range kube.FileNames(stack) {
+		if err := e.services.DeleteUnitFile(fileName); err != nil {
+			return s.fail(fmt.Sprintf("Failed to remove '%s'.", fileName), &ResourceError{Op: "remove", Kind: KindStack, Name: stack.StackName, Err: err})
+		}
+	}
+	s.succeed(fmt.Sprintf("Kubernetes manifest of stack '%s' removed.", stack.StackName))
+	return nil
+}
@@ -0,0 +1,151 @@
+package otari
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/danecwalker/otari/internal/changes"
+	"github.com/danecwalker/otari/internal/definition"
+)
+
+// PulledImage is an image that was pulled to pin it to a digest.
+type PulledImage struct {
+	Image string
+	// Containers are the containers of the stack that use the image.
+	Containers []string
+	// Previous is the digest the image was pinned to before, if any.
+	Previous string
+	Digest   string
+}
+
+// Changed reports whether the image resolved to a different digest than
+// it was pinned to.
+func (p *PulledImage) Changed() bool {
+	return p.Previous != p.Digest
+}
+
+// Lock pins the image of every container of the stack to a digest in its
+// lock file. Images that are not pinned yet are resolved from the local
+// image, or pulled if it is missing. With update every tag is pulled again
+// and pinned to the digest it points to now. Containers whose digest
+// changed are restarted by the next Apply.
+func (e *Engine) Lock(ctx context.Context, stack *Stack, update bool) error {
+	unlock, err := e.lockStack(ctx, stack)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if _, err := e.pinImages(ctx, stack.Definition, update); err != nil {
+		return err
+	}
+	return e.writeLock(stack)
+}
+
+// Pull pulls the image of every container of the stack again, except those
+// whose pull policy is never, and pins them to the digest their tag points
+// to now. The next Apply restarts the containers whose image changed.
+func (e *Engine) Pull(ctx context.Context, stack *Stack) ([]*PulledImage, error) {
+	unlock, err := e.lockStack(ctx, stack)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	pulled, err := e.pinImages(ctx, stack.Definition, true)
+	if err != nil {
+		return nil, err
+	}
+	return pulled, e.writeLock(stack)
+}
+
+// pinImages resolves the digests of the images of the containers of the
+// stack that are not pinned yet, or of all of them with update.
+func (e *Engine) pinImages(ctx context.Context, def *definition.Stack, update bool) ([]*PulledImage, error) {
+	users := make(map[string][]*definition.Container)
+	for _, container := range def.Containers {
+		if container.Build != nil || container.Image == nil || container.Image.Digest != "" {
+			continue
+		}
+		if container.ImageDigest != "" && !update {
+			continue
+		}
+		image := container.Image.String()
+		users[image] = append(users[image], container)
+	}
+
+	images := slices.Sorted(maps.Keys(users))
+	pulled := make([]*PulledImage, len(images))
+	err := e.parallel(ctx, images, func(ctx context.Context, image string) error {
+		result := &PulledImage{Image: image, Previous: users[image][0].ImageDigest}
+		pulled[slices.Index(images, image)] = result
+		for _, container := range users[image] {
+			result.Containers = append(result.Containers, container.ContainerName)
+		}
+		slices.Sort(result.Containers)
+
+		exists := e.runtime.ImageExists(ctx, image)
+		if result.Previous == "" && exists {
+			result.Previous, _ = e.runtime.ImageDigest(ctx, image)
+		}
+		if users[image][0].EffectivePullPolicy() == definition.PullNever {
+			if !exists {
+				return &ResourceError{Op: "pin", Kind: KindImage, Name: image, Err: errPullNever}
+			}
+		} else if update || !exists {
+			s := e.step("pull", KindImage, image, fmt.Sprintf("Pulling image '%s'", image))
+			if err := e.runtime.PullImage(ctx, image, definition.PullAlways, s.progress); err != nil {
+				return s.fail(fmt.Sprintf("Failed to pull image '%s'", image), &ResourceError{Op: "pull", Kind: KindImage, Name: image, Err: err})
+			}
+			s.succeed(fmt.Sprintf("Pulled image '%s'.", image))
+		}
+
+		s := e.step("resolve", KindImage, image, fmt.Sprintf("Resolving the digest of '%s'...", image))
+		digest, err := e.runtime.ImageDigest(ctx, image)
+		if err == nil && digest == "" {
+			err = errors.New("image has no digest")
+		}
+		if err != nil {
+			return s.fail(fmt.Sprintf("Failed to resolve the digest of '%s'.", image), &ResourceError{Op: "resolve the digest of", Kind: KindImage, Name: image, Err: err})
+		}
+		result.Digest = digest
+		if users[image][0].ImageDigest == digest {
+			s.skip(fmt.Sprintf("Image '%s' is up to date.", image))
+			return nil
+		}
+		for _, container := range users[image] {
+			container.ImageDigest = digest
+		}
+		s.succeed(fmt.Sprintf("Pinned '%s' to %s.", image, digest))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return pulled, nil
+}
+
+// writeLock writes the pinned images of the stack to its lock file.
+func (e *Engine) writeLock(stack *Stack) error {
+	def := stack.Definition
+	stackData := stack.deployed
+	if stackData == nil {
+		// the rest of the lock file is written by the first deployment
+		stackData = &changes.StackData{
+			Version:      changes.StackDataVersion,
+			GeneratedAt:  time.Now().UTC().Truncate(time.Second),
+			NameTemplate: def.EffectiveNameTemplate(),
+			Mode:         string(def.EffectiveMode()),
+		}
+	}
+	stackData.Images = changes.LockedImages(def)
+	if err := changes.WriteStackData(def, stackData); err != nil {
+		return fmt.Errorf("failed to write stack lock file: %w", err)
+	}
+	stack.deployed = stackData
+	return nil
+}
@@ -0,0 +1,24 @@
+package otari
+
+import (
+	"context"
+	"fmt"
+	"io"
+)
+
+// Logs writes the journal of a container of the stack to w.
+func (e *Engine) Logs(ctx context.Context, stack *Stack, containerName string, w io.Writer) error {
+	def := stack.Definition
+	if _, exists := def.Containers[containerName]; !exists {
+		return fmt.Errorf("%w: '%s'", ErrContainerNotFound, containerName)
+	}
+
+	containerUnitName := def.ResourceName(containerName)
+	logs, err := e.services.GetLogs(containerUnitName)
+	if err != nil {
+		return &ResourceError{Op: "get logs of", Kind: KindContainer, Name: containerUnitName, Err: err}
+	}
+
+	_, err = w.Write(logs)
+	return err
+}
@@ -0,0 +1,230 @@
+// Package otari is the library behind the otari command line. An Engine
+// loads stack definitions and plans, applies, stops and removes them,
+// reporting progress to an Observer and failures as typed errors instead
+// of printing them.
+package otari
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/danecwalker/otari/internal/changes"
+	"github.com/danecwalker/otari/internal/definition"
+	"github.com/danecwalker/otari/internal/podman"
+	"github.com/danecwalker/otari/internal/rules"
+	"github.com/danecwalker/otari/internal/systemd"
+	"github.com/danecwalker/otari/internal/utils"
+)
+
+// Options configure an Engine. The zero value is ready to use.
+type Options struct {
+	// Observer receives progress events. Events are discarded if it is nil.
+	Observer Observer
+	// Stdin is read for secrets that are prompted for. Defaults to os.Stdin.
+	Stdin io.Reader
+	// Runtime manages images, containers and other podman resources.
+	// Defaults to the podman socket, or the podman binary if the socket
+	// does not answer.
+	Runtime Runtime
+	// Services controls the systemd units of the stack. Defaults to
+	// systemd over D-Bus, or systemctl if the bus cannot be reached.
+	Services ServiceManager
+	// NoRollback keeps a failed deployment in place instead of rolling the
+	// stack back to its last successful revision.
+	NoRollback bool
+	// HealthGrace is how long Apply waits for the containers with a
+	// healthcheck to become healthy before it considers the deployment
+	// failed. Defaults to DefaultHealthGrace.
+	HealthGrace time.Duration
+	// Frozen makes Apply fail instead of resolving image digests if the
+	// lock file is missing or does not pin the image of every container.
+	// Pull policies that pull on every start are ignored, the pinned
+	// digests are deployed as they are.
+	Frozen bool
+	// Parallelism is how many images are pulled or built at once. Defaults
+	// to DefaultParallelism.
+	Parallelism int
+	// Wait makes operations that change a stack wait for another process
+	// holding its lock, instead of failing with a LockedError.
+	Wait bool
+	// WaitTimeout limits how long operations wait for the lock of a stack,
+	// it implies Wait. 0 waits without limit.
+	WaitTimeout time.Duration
+	// StateDir keeps the lock files and history of all stacks in one
+	// directory, such as utils.StateLocation, instead of next to their stack
+	// files. Stacks are told apart by name only.
+	StateDir string
+}
+
+// DefaultHealthGrace is the HealthGrace used unless configured otherwise.
+const DefaultHealthGrace = time.Minute
+
+type (
+	// Runtime manages the podman resources of a stack.
+	Runtime = podman.Runtime
+	// ServiceManager controls the systemd units generated from quadlets.
+	ServiceManager = systemd.ServiceManager
+)
+
+// Engine runs stack operations against podman and systemd.
+type Engine struct {
+	observer Observer
+	stdin    io.Reader
+	runtime  Runtime
+	services ServiceManager
+
+	noRollback  bool
+	healthGrace time.Duration
+	frozen      bool
+	parallelism int
+	wait        bool
+	waitTimeout time.Duration
+	stateDir    string
+}
+
+func New(opts Options) *Engine {
+	e := &Engine{
+		observer: opts.Observer,
+		stdin:    opts.Stdin,
+		runtime:  opts.Runtime,
+		services: opts.Services,
+
+		noRollback:  opts.NoRollback,
+		healthGrace: opts.HealthGrace,
+		frozen:      opts.Frozen,
+		parallelism: opts.Parallelism,
+		wait:        opts.Wait || opts.WaitTimeout > 0,
+		waitTimeout: opts.WaitTimeout,
+		stateDir:    opts.StateDir,
+	}
+	if e.observer == nil {
+		e.observer = Discard
+	}
+	e.observer = &lockedObserver{observer: e.observer}
+	if e.stdin == nil {
+		e.stdin = os.Stdin
+	}
+	if e.runtime == nil {
+		e.runtime = podman.DefaultRuntime()
+	}
+	if e.services == nil {
+		e.services = systemd.DefaultManager()
+	}
+	if e.healthGrace == 0 {
+		e.healthGrace = DefaultHealthGrace
+	}
+	if e.parallelism <= 0 {
+		e.parallelism = DefaultParallelism
+	}
+	return e
+}
+
+// Stack is a parsed stack definition together with the lock file of its
+// last deployment.
+type Stack struct {
+	// Path is the stack file the stack was loaded from.
+	Path string
+	// Definition is the parsed stack definition.
+	Definition *definition.Stack
+
+	deployed *changes.StackData
+}
+
+// Name returns the name of the stack, see the name key of the stack file.
+func (s *Stack) Name() string {
+	return s.Definition.StackName
+}
+
+// Deployed reports whether the stack has a lock file from an earlier
+// deployment.
+func (s *Stack) Deployed() bool {
+	return s.deployed != nil
+}
+
+// Load reads and parses the stack file at path, which defaults to the
+// stack file in the working directory. envFiles replace the .env file next
+// to the stack file for variable interpolation.
+func (e *Engine) Load(path string, envFiles []string) (*Stack, error) {
+	if path == "" {
+		path = utils.DefaultStackPath()
+	}
+	c, err := os.ReadFile(path)
+	if err != nil {
+		return nil, &LoadError{Op: LoadRead, Path: path, Err: err}
+	}
+
+	dir := filepath.Dir(path)
+	def, err := definition.ParseWithOptions(c, definition.ParseOptions{
+		Dir:      dir,
+		EnvFiles: envFiles,
+	})
+	if err != nil {
+		return nil, &LoadError{Op: LoadParse, Path: path, Err: err}
+	}
+
+	def.StateDir = e.stateDir
+	if def.StateDir == "" {
+		def.StateDir = dir
+	}
+	def.StackName = stackName(def, path)
+
+	deployed, err := changes.ResolveNaming(def)
+	if err != nil {
+		return nil, &LoadError{Op: LoadLock, Path: path, Err: err}
+	}
+	if deployed == nil {
+		e.checkStrayLock(def)
+	}
+	changes.PinImages(def, deployed)
+
+	return &Stack{Path: path, Definition: def, deployed: deployed}, nil
+}
+
+// stackName returns the name of a stack, set by its name key or derived
+// from its file name. Stacks in a default otari.yaml or otari.yml are named
+// after their directory so they do not collide across projects, unless
+// they were already deployed as 'otari'.
+func stackName(def *definition.Stack, path string) string {
+	if def.Name != "" {
+		return def.Name
+	}
+	name := utils.StackNameFromPath(path)
+	if base := filepath.Base(path); base != "otari.yaml" && base != "otari.yml" {
+		return name
+	}
+	if utils.PathExists(filepath.Join(def.StateDir, name+".lock")) {
+		return name
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return name
+	}
+	return filepath.Base(filepath.Dir(abs))
+}
+
+// checkStrayLock points out a lock file of the stack in the current working
+// directory, where lock files were kept before they moved to the state
+// directory of the stack.
+func (e *Engine) checkStrayLock(def *definition.Stack) {
+	if !utils.PathExists(def.StackName + ".lock") {
+		return
+	}
+	e.notice(fmt.Sprintf("Stack '%s' has no lock file at '%s', but '%s.lock' was found in the current directory. Move it there to keep the deployed state of the stack.", def.StackName, def.LockPath(), def.StackName))
+}
+
+// Validate checks the stack against every rule and returns a
+// *ValidationError listing the problems found.
+func (e *Engine) Validate(stack *Stack) error {
+	errs := rules.Validate(stack.Definition)
+	if len(errs) == 0 {
+		return nil
+	}
+	problems := make([]string, 0, len(errs))
+	for _, err := range errs {
+		problems = append(problems, err.Message)
+	}
+	return &ValidationError{Problems: problems}
+}
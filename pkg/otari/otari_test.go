@@ -0,0 +1,88 @@
+package otari
+
+// Tests loading, validating and planning stacks through the engine.
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeStack(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "demo.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+	return path
+}
+
+func TestLoad(t *testing.T) {
+	engine := New(Options{})
+
+	stack, err := engine.Load(writeStack(t, "containers:\n  web:\n    image: nginx\n"), nil)
+	require.NoError(t, err)
+	assert.Equal(t, "demo", stack.Name())
+	assert.False(t, stack.Deployed())
+	assert.Contains(t, stack.Definition.Containers, "web")
+
+	_, err = engine.Load(filepath.Join(t.TempDir(), "missing.yaml"), nil)
+	var loadErr *LoadError
+	require.ErrorAs(t, err, &loadErr)
+	assert.Equal(t, LoadRead, loadErr.Op)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	_, err = engine.Load(writeStack(t, "containers: [web"), nil)
+	require.ErrorAs(t, err, &loadErr)
+	assert.Equal(t, LoadParse, loadErr.Op)
+}
+
+func TestValidate(t *testing.T) {
+	// Apply takes the lock of the stack before it validates it
+	engine := New(Options{StateDir: t.TempDir()})
+
+	stack, err := engine.Load(writeStack(t, "containers:\n  web:\n    image: nginx\n    networks: [missing]\n"), nil)
+	require.NoError(t, err)
+
+	err = engine.Validate(stack)
+	var validationErr *ValidationError
+	require.ErrorAs(t, err, &validationErr)
+	assert.NotEmpty(t, validationErr.Problems)
+
+	// invalid stacks are never applied
+	assert.ErrorAs(t, engine.Apply(context.Background(), stack), &validationErr)
+}
+
+func TestPlan(t *testing.T) {
+	var events []Event
+	engine := New(Options{Observer: ObserverFunc(func(e Event) {
+		events = append(events, e)
+	})})
+
+	stack, err := engine.Load(writeStack(t, "containers:\n  web:\n    image: nginx\n"), nil)
+	require.NoError(t, err)
+
+	p, err := engine.Plan(context.Background(), stack)
+	require.NoError(t, err)
+	assert.False(t, p.Deployed)
+	assert.Equal(t, 1, p.Count(ActionAdd))
+
+	require.Len(t, events, 2)
+	assert.Equal(t, EventStarted, events[0].Type)
+	assert.Equal(t, EventSkipped, events[1].Type)
+}
+
+func TestLogs(t *testing.T) {
+	engine := New(Options{})
+
+	stack, err := engine.Load(writeStack(t, "containers:\n  web:\n    image: nginx\n"), nil)
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	err = engine.Logs(context.Background(), stack, "db", &buf)
+	assert.True(t, errors.Is(err, ErrContainerNotFound))
+}
@@ -0,0 +1,68 @@
+package otari
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// DefaultParallelism is the Parallelism used unless configured otherwise.
+const DefaultParallelism = 4
+
+// lockedObserver delivers the events of steps that run at the same time
+// one at a time.
+type lockedObserver struct {
+	mu       sync.Mutex
+	observer Observer
+}
+
+func (o *lockedObserver) OnEvent(e Event) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.observer.OnEvent(e)
+}
+
+// parallel calls fn for every key, running up to e.parallelism calls at
+// once. The first error cancels the context of the other calls and is
+// returned once they all returned.
+func (e *Engine) parallel(ctx context.Context, keys []string, fn func(ctx context.Context, key string) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+	)
+	slots := make(chan struct{}, e.parallelism)
+	for _, key := range keys {
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+			if err := fn(ctx, key); err != nil {
+				mu.Lock()
+				// calls that failed because another one did are not the cause
+				if firstErr == nil || errors.Is(firstErr, context.Canceled) && !errors.Is(err, context.Canceled) {
+					firstErr = err
+				}
+				mu.Unlock()
+				cancel()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if firstErr == nil {
+		// the caller's context ended before every key was started
+		return ctx.Err()
+	}
+	return firstErr
+}
@@ -0,0 +1,62 @@
+package otari
+
+// Tests the worker limit and cancellation of parallel steps.
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParallel(t *testing.T) {
+	ctx := context.Background()
+	engine := New(Options{Parallelism: 2})
+	keys := []string{"a", "b", "c", "d", "e", "f"}
+
+	var running, peak atomic.Int32
+	var mu sync.Mutex
+	var done []string
+	err := engine.parallel(ctx, keys, func(ctx context.Context, key string) error {
+		n := running.Add(1)
+		defer running.Add(-1)
+		for {
+			current := peak.Load()
+			if n <= current || peak.CompareAndSwap(current, n) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+		mu.Lock()
+		done = append(done, key)
+		mu.Unlock()
+		return nil
+	})
+	require.NoError(t, err)
+	assert.ElementsMatch(t, keys, done)
+	assert.Equal(t, int32(2), peak.Load())
+
+	// the first failure cancels the calls still running and is returned
+	boom := errors.New("boom")
+	err = engine.parallel(ctx, keys, func(ctx context.Context, key string) error {
+		if key == "b" {
+			return boom
+		}
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	assert.ErrorIs(t, err, boom)
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	err = engine.parallel(cancelled, keys, func(ctx context.Context, key string) error {
+		t.Errorf("called for %s after the context ended", key)
+		return nil
+	})
+	assert.ErrorIs(t, err, context.Canceled)
+}
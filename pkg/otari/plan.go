@@ -0,0 +1,54 @@
+package otari
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/danecwalker/otari/internal/plan"
+	"github.com/danecwalker/otari/internal/quadlets"
+	"github.com/danecwalker/otari/internal/utils"
+)
+
+type (
+	// Plan lists what applying a stack would change.
+	Plan = plan.Plan
+	// Change describes what applying a stack would do to a single resource.
+	Change = plan.Change
+	// Action is what applying a stack would do to a resource.
+	Action = plan.Action
+)
+
+const (
+	ActionAdd       = plan.ActionAdd
+	ActionModify    = plan.ActionModify
+	ActionDelete    = plan.ActionDelete
+	ActionUnchanged = plan.ActionUnchanged
+)
+
+// Plan works out what Apply would change without touching podman or
+// systemd.
+func (e *Engine) Plan(ctx context.Context, stack *Stack) (*Plan, error) {
+	def := stack.Definition
+	if err := e.Validate(stack); err != nil {
+		return nil, err
+	}
+	if err := def.ResolveSecrets(e.stdin); err != nil {
+		return nil, fmt.Errorf("failed to resolve secrets: %w", err)
+	}
+
+	if err := e.hashBuilds(def); err != nil {
+		return nil, err
+	}
+
+	s := e.step("plan", "", "", "Computing plan...")
+	p, err := plan.Compute(ctx, def, quadlets.Generator(), utils.OutputLocation())
+	if err != nil {
+		return nil, s.fail("Failed to compute plan.", fmt.Errorf("failed to compute plan: %w", err))
+	}
+	if p.Deployed {
+		s.succeed(fmt.Sprintf("Plan computed for stack '%s'.", p.StackName))
+	} else {
+		s.skip(fmt.Sprintf("No existing stack found for '%s'.", p.StackName))
+	}
+	return p, nil
+}
@@ -0,0 +1,194 @@
+package otari
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"slices"
+
+	"github.com/danecwalker/otari/internal/changes"
+	"github.com/danecwalker/otari/internal/definition"
+	"github.com/danecwalker/otari/internal/history"
+	"github.com/danecwalker/otari/internal/quadlets"
+	"github.com/danecwalker/otari/internal/rules"
+)
+
+// Remove stops the stack and deletes its quadlets, secrets, lock file and
+// history, along with the volumes and networks not marked to persist. A
+// deployment that was interrupted before is reverted first.
+func (e *Engine) Remove(ctx context.Context, stack *Stack) error {
+	unlock, err := e.lockStack(ctx, stack)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	def := stack.Definition
+	if err := e.resume(def); err != nil {
+		return err
+	}
+	kubeMode := changes.DeployedMode(def, stack.deployed) == definition.DeployModeKube
+
+	// Stop all containers
+	active, err := e.runtime.ActiveContainers(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get active containers: %w", err)
+	}
+	order, err := rules.StopOrder(def)
+	if err != nil {
+		return fmt.Errorf("failed to order containers by dependency: %w", err)
+	}
+	pods := def.Pods
+	if kubeMode {
+		// containers and pods belong to the .kube unit of the stack
+		if err := e.removeKube(def); err != nil {
+			return err
+		}
+		order, pods = nil, nil
+	}
+	for _, containerName := range order {
+		containerUnitName := def.ResourceName(containerName)
+		s := e.step("remove", KindContainer, containerUnitName, fmt.Sprintf("Removing container '%s'...", containerUnitName))
+
+		// check if container is already running
+		if isActive := slices.Contains(active, containerUnitName); !isActive {
+			s.progress(fmt.Sprintf("Container '%s' is already stopped.", containerUnitName))
+		} else {
+			s.progress(fmt.Sprintf("Container '%s' is running, stopping it first.", containerUnitName))
+			if err := e.services.StopUnit(containerUnitName); err != nil {
+				return s.fail(fmt.Sprintf("Failed to stop container '%s'", containerUnitName), &ResourceError{Op: "stop", Kind: KindContainer, Name: containerUnitName, Err: err})
+			}
+			s.progress(fmt.Sprintf("Container '%s' stopped, removing unit file.", containerUnitName))
+		}
+
+		if err := e.services.DeleteUnitFile(containerUnitName + ".container"); err != nil {
+			return s.fail(fmt.Sprintf("Failed to remove container unit file for '%s'", containerUnitName), &ResourceError{Op: "remove", Kind: KindContainer, Name: containerUnitName, Err: err})
+		}
+		s.succeed(fmt.Sprintf("Container '%s' removed.", containerUnitName))
+	}
+
+	active, err = e.runtime.ActiveContainers(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get active containers: %w", err)
+	}
+
+	// Remove pods
+	for _, pod := range pods {
+		podUnitName := def.ResourceName(pod.PodName)
+		s := e.step("remove", KindPod, podUnitName, fmt.Sprintf("Removing pod '%s'...", podUnitName))
+
+		if err := e.services.StopUnit(quadlets.PodServiceName(podUnitName)); err != nil {
+			return s.fail(fmt.Sprintf("Failed to stop pod '%s'.", podUnitName), &ResourceError{Op: "stop", Kind: KindPod, Name: podUnitName, Err: err})
+		}
+
+		if err := e.services.DeleteUnitFile(podUnitName + ".pod"); err != nil {
+			return s.fail(fmt.Sprintf("Failed to remove pod '%s'.", podUnitName), &ResourceError{Op: "remove", Kind: KindPod, Name: podUnitName, Err: err})
+		}
+
+		s.succeed(fmt.Sprintf("Pod '%s' removed.", podUnitName))
+	}
+
+	// Remove volumes
+	for _, volume := range def.Volumes {
+		if volume.PersistOnRemove {
+			continue
+		}
+		volumeUnitName := def.ResourceName(volume.VolumeName)
+		s := e.step("remove", KindVolume, volumeUnitName, fmt.Sprintf("Removing volume '%s'...", volumeUnitName))
+		// Check if volume is in use by any active container
+		volumeUsed := false
+		for _, container := range def.Containers {
+			for _, vol := range container.Volumes {
+				if vol.Source == volume.VolumeName && slices.Contains(active, def.ResourceName(container.ContainerName)) {
+					volumeUsed = true
+					break
+				}
+			}
+		}
+		if volumeUsed {
+			s.skip(fmt.Sprintf("Volume '%s' is still in use.", volumeUnitName))
+			continue
+		}
+
+		// Remove the volume quadlet, kube stacks claim their volumes from
+		// the manifest instead
+		if !kubeMode {
+			if err := e.services.StopUnit(quadlets.VolumeServiceName(volumeUnitName)); err != nil {
+				return s.fail(fmt.Sprintf("Failed to stop volume '%s'.", volumeUnitName), &ResourceError{Op: "stop", Kind: KindVolume, Name: volumeUnitName, Err: err})
+			}
+
+			if err := e.services.DeleteUnitFile(volumeUnitName + ".volume"); err != nil {
+				return s.fail(fmt.Sprintf("Failed to remove volume '%s'.", volumeUnitName), &ResourceError{Op: "remove", Kind: KindVolume, Name: volumeUnitName, Err: err})
+			}
+		}
+
+		if err := e.runtime.RemoveVolume(ctx, volumeUnitName); err != nil {
+			return s.fail(fmt.Sprintf("Failed to remove volume '%s' from Podman.", volumeUnitName), &ResourceError{Op: "remove", Kind: KindVolume, Name: volumeUnitName, Err: err})
+		}
+
+		s.succeed(fmt.Sprintf("Volume '%s' removed.", volumeUnitName))
+	}
+
+	// Remove networks
+	for _, network := range def.Networks {
+		if network.PersistOnRemove {
+			continue
+		}
+		networkUnitName := def.ResourceName(network.NetworkName)
+		s := e.step("remove", KindNetwork, networkUnitName, fmt.Sprintf("Removing network '%s'...", networkUnitName))
+		// Check if network is in use by any active container
+		networkUsed := false
+		for _, container := range def.Containers {
+			if slices.Contains(container.Networks, network.NetworkName) && slices.Contains(active, def.ResourceName(container.ContainerName)) {
+				networkUsed = true
+				break
+			}
+		}
+		if networkUsed {
+			s.skip(fmt.Sprintf("Network '%s' is still in use.", networkUnitName))
+			continue
+		}
+
+		// Remove the network quadlet
+		if err := e.services.StopUnit(quadlets.NetworkServiceName(networkUnitName)); err != nil {
+			return s.fail(fmt.Sprintf("Failed to stop network '%s'.", networkUnitName), &ResourceError{Op: "stop", Kind: KindNetwork, Name: networkUnitName, Err: err})
+		}
+
+		if err := e.services.DeleteUnitFile(networkUnitName + ".network"); err != nil {
+			return s.fail(fmt.Sprintf("Failed to remove network '%s'.", networkUnitName), &ResourceError{Op: "remove", Kind: KindNetwork, Name: networkUnitName, Err: err})
+		}
+
+		if err := e.runtime.RemoveNetwork(ctx, networkUnitName); err != nil {
+			return s.fail(fmt.Sprintf("Failed to remove network '%s' from Podman.", networkUnitName), &ResourceError{Op: "remove", Kind: KindNetwork, Name: networkUnitName, Err: err})
+		}
+
+		s.succeed(fmt.Sprintf("Network '%s' removed.", networkUnitName))
+	}
+
+	// Remove secrets
+	for _, secret := range def.Secrets {
+		secretName := def.ResourceName(secret.SecretName)
+		s := e.step("remove", KindSecret, secretName, fmt.Sprintf("Removing secret '%s'...", secretName))
+		if err := e.runtime.RemoveSecret(ctx, secretName); err != nil {
+			s.skip(fmt.Sprintf("Secret '%s' does not exist.", secretName))
+			continue
+		}
+		s.succeed(fmt.Sprintf("Secret '%s' removed.", secretName))
+	}
+
+	// reload systemd daemon to apply changes
+	if err := e.services.ReloadDaemon(); err != nil {
+		return fmt.Errorf("failed to reload systemd daemon: %w", err)
+	}
+
+	// remove lock file
+	if err := os.Remove(def.LockPath()); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove stack lock file: %w", err)
+	}
+	if err := history.Remove(def.StateDir, def.StackName); err != nil {
+		return fmt.Errorf("failed to remove stack history: %w", err)
+	}
+	stack.deployed = nil
+
+	return nil
+}
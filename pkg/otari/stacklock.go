@@ -0,0 +1,27 @@
+package otari
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/danecwalker/otari/internal/stacklock"
+)
+
+// lockStack takes the lock of the stack for the operation that changes it,
+// the returned function releases it.
+func (e *Engine) lockStack(ctx context.Context, stack *Stack) (func(), error) {
+	command := strings.Join(append([]string{filepath.Base(os.Args[0])}, os.Args[1:]...), " ")
+	lock, err := stacklock.Acquire(ctx, stack.Definition.StateDir, stack.Name(), command, e.wait, e.waitTimeout, func(err *LockedError) {
+		e.notice(fmt.Sprintf("Waiting for the lock: %s.", err.Error()))
+	})
+	if err != nil {
+		return nil, err
+	}
+	if lock.Stale != nil {
+		e.notice(fmt.Sprintf("Took over the lock of stack '%s' from %s, which ended without releasing it.", stack.Name(), lock.Stale))
+	}
+	return func() { lock.Release() }, nil
+}
@@ -0,0 +1,29 @@
+package otari
+
+import (
+	"context"
+
+	"github.com/danecwalker/otari/internal/status"
+	"github.com/danecwalker/otari/internal/utils"
+)
+
+type (
+	// Status is the live state of the resources of a stack.
+	Status = status.Status
+	// ResourceStatus is the combined state of a single stack resource.
+	ResourceStatus = status.Resource
+	// Problem marks a resource that is missing or no longer defined.
+	Problem = status.Problem
+)
+
+const (
+	ProblemNone      = status.ProblemNone
+	ProblemMissing   = status.ProblemMissing
+	ProblemUndefined = status.ProblemUndefined
+)
+
+// Status combines the stack, its lock file and the quadlets on disk with
+// the state podman and systemd report for its resources.
+func (e *Engine) Status(ctx context.Context, stack *Stack) (*Status, error) {
+	return status.Collect(ctx, stack.Definition, stack.deployed, utils.OutputLocation(), status.NewProbe(e.runtime, e.services))
+}
@@ -0,0 +1,56 @@
+package otari
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"github.com/danecwalker/otari/internal/changes"
+	"github.com/danecwalker/otari/internal/definition"
+	"github.com/danecwalker/otari/internal/rules"
+)
+
+// Stop stops the containers of the stack in reverse dependency order. The
+// quadlets stay in place so the stack can be started again. A deployment
+// that was interrupted before is reverted first.
+func (e *Engine) Stop(ctx context.Context, stack *Stack) error {
+	unlock, err := e.lockStack(ctx, stack)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	def := stack.Definition
+	if err := e.resume(def); err != nil {
+		return err
+	}
+	// the containers of a kube stack all belong to its .kube unit
+	if changes.DeployedMode(def, stack.deployed) == definition.DeployModeKube {
+		return e.stopKube(def)
+	}
+
+	active, err := e.runtime.ActiveContainers(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get active containers: %w", err)
+	}
+	order, err := rules.StopOrder(def)
+	if err != nil {
+		return fmt.Errorf("failed to order containers by dependency: %w", err)
+	}
+	for _, containerName := range order {
+		containerUnitName := def.ResourceName(containerName)
+		s := e.step("stop", KindContainer, containerUnitName, fmt.Sprintf("Stopping container '%s'...", containerUnitName))
+
+		// check if container is already running
+		if isActive := slices.Contains(active, containerUnitName); !isActive {
+			s.skip(fmt.Sprintf("Container '%s' is already stopped.", containerUnitName))
+			continue
+		}
+
+		if err := e.services.StopUnit(containerUnitName); err != nil {
+			return s.fail(fmt.Sprintf("Failed to stop container '%s'", containerUnitName), &ResourceError{Op: "stop", Kind: KindContainer, Name: containerUnitName, Err: err})
+		}
+		s.succeed(fmt.Sprintf("Container '%s' stopped.", containerUnitName))
+	}
+	return nil
+}
@@ -0,0 +1,294 @@
+package spinner
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Group renders one live line per task, e.g. per image pulled in parallel.
+// The lines are redrawn in place as a block below everything printed
+// before, so tasks updating their lines from different goroutines never
+// corrupt each other's output. Finished lines at the top of the block are
+// left behind as plain output.
+//
+// A plain group only prints the final message of every line, for output
+// that is not a terminal.
+type Group struct {
+	frames []string
+	plain  bool
+
+	successSymbol string
+	errorSymbol   string
+	infoSymbol    string
+
+	out        io.Writer
+	width      int
+	mu         sync.Mutex
+	lines      []*Line
+	rendered   int
+	frameIndex int
+	ticker     *time.Ticker
+	done       chan struct{}
+}
+
+// Line is a line of a Group.
+type Line struct {
+	group    *Group
+	message  string
+	detail   string
+	started  time.Time
+	finished bool
+	final    string
+}
+
+// NewGroup returns a group that animates its lines with frames. The
+// symbols set by opts prefix the lines once they finish.
+func NewGroup(frames []string, opts ...SpinnerOptionFunc) *Group {
+	// the options configure a spinner, the group borrows its symbols
+	s := &Spinner{}
+	for _, opt := range opts {
+		opt(s)
+	}
+	out := s.out
+	if out == nil {
+		out = os.Stdout
+	}
+	return &Group{
+		frames:        frames,
+		plain:         s.plain,
+		successSymbol: s.successSymbol,
+		errorSymbol:   s.errorSymbol,
+		infoSymbol:    s.infoSymbol,
+		out:           out,
+		width:         outputWidth(out),
+	}
+}
+
+// SetOutput makes the group render to w, lines are cut to width runes.
+// A width of 0 does not cut them.
+func (g *Group) SetOutput(w io.Writer, width int) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.out = w
+	g.width = width
+}
+
+// Enable starts redrawing the lines every interval.
+func (g *Group) Enable(interval time.Duration) {
+	if g.plain {
+		return
+	}
+	g.mu.Lock()
+	g.ticker = time.NewTicker(interval)
+	g.done = make(chan struct{})
+	ticker, done := g.ticker, g.done
+	fmt.Fprint(g.out, hideCursor)
+	g.mu.Unlock()
+
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				g.mu.Lock()
+				g.frameIndex = (g.frameIndex + 1) % len(g.frames)
+				g.render()
+				g.mu.Unlock()
+			case <-done:
+				return
+			}
+		}
+	}()
+}
+
+// Add appends a line for a new task.
+func (g *Group) Add(message string) *Line {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	l := &Line{group: g, message: message, started: time.Now()}
+	g.lines = append(g.lines, l)
+	g.render()
+	return l
+}
+
+// Active returns the number of lines that did not finish yet.
+func (g *Group) Active() int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	active := 0
+	for _, l := range g.lines {
+		if !l.finished {
+			active++
+		}
+	}
+	return active
+}
+
+// Println prints msg above the lines of the group.
+func (g *Group) Println(msg string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.clear()
+	fmt.Fprintln(g.out, msg)
+	g.render()
+}
+
+// Stop draws the lines a last time and stops redrawing them. Lines that
+// did not finish are left as they are.
+func (g *Group) Stop() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.ticker != nil {
+		g.ticker.Stop()
+		close(g.done)
+		g.ticker = nil
+	}
+	g.render()
+	g.lines = nil
+	g.rendered = 0
+	if !g.plain {
+		fmt.Fprint(g.out, showCursor)
+	}
+}
+
+// clear moves the cursor to the top of the block and clears it.
+func (g *Group) clear() {
+	if g.rendered == 0 {
+		return
+	}
+	fmt.Fprintf(g.out, cursorUp, g.rendered)
+	for range g.rendered {
+		fmt.Fprint(g.out, clearLine+"\n")
+	}
+	fmt.Fprintf(g.out, cursorUp, g.rendered)
+	g.rendered = 0
+}
+
+// render redraws the block, the cursor is left on the line below it.
+func (g *Group) render() {
+	if g.plain {
+		// finished lines are printed once, the others are not shown
+		lines := g.lines[:0]
+		for _, l := range g.lines {
+			if l.finished {
+				fmt.Fprintln(g.out, l.final)
+			} else {
+				lines = append(lines, l)
+			}
+		}
+		g.lines = lines
+		return
+	}
+	if g.rendered > 0 {
+		fmt.Fprintf(g.out, cursorUp, g.rendered)
+	}
+	frame := ""
+	if len(g.frames) > 0 {
+		frame = g.frames[g.frameIndex%len(g.frames)]
+	}
+	for _, l := range g.lines {
+		fmt.Fprintf(g.out, "\r%s%s\n", clearLine, truncate(l.text(frame), g.width))
+	}
+	g.rendered = len(g.lines)
+
+	// finished lines at the top stay where they are
+	for len(g.lines) > 0 && g.lines[0].finished {
+		g.lines = g.lines[1:]
+		g.rendered--
+	}
+}
+
+func (l *Line) text(frame string) string {
+	if l.finished {
+		return l.final
+	}
+	var sb strings.Builder
+	sb.WriteString(frame)
+	sb.WriteString(" ")
+	sb.WriteString(l.message)
+	if l.detail != "" {
+		sb.WriteString("  ")
+		sb.WriteString(l.detail)
+	}
+	fmt.Fprintf(&sb, " (%s)", time.Since(l.started).Round(time.Second))
+	return sb.String()
+}
+
+// SetMessage replaces the message of the line.
+func (l *Line) SetMessage(msg string) {
+	l.group.mu.Lock()
+	defer l.group.mu.Unlock()
+	l.message = msg
+}
+
+// SetDetail sets the progress shown after the message, e.g. how much of
+// an image was downloaded.
+func (l *Line) SetDetail(detail string) {
+	l.group.mu.Lock()
+	defer l.group.mu.Unlock()
+	l.detail = detail
+}
+
+func (l *Line) finish(symbol, msg string) {
+	l.group.mu.Lock()
+	defer l.group.mu.Unlock()
+	if symbol != "" {
+		msg = fmt.Sprintf("%s %s", symbol, msg)
+	}
+	l.finished = true
+	l.final = msg
+	l.group.render()
+}
+
+func (l *Line) FinishWithSuccess(msg string) { l.finish(l.group.successSymbol, msg) }
+func (l *Line) FinishWithError(msg string)   { l.finish(l.group.errorSymbol, msg) }
+func (l *Line) FinishWithInfo(msg string)    { l.finish(l.group.infoSymbol, msg) }
+
+// outputWidth returns the width of the terminal w writes to, or 0.
+func outputWidth(w io.Writer) int {
+	if f, ok := w.(*os.File); ok {
+		return terminalWidth(f)
+	}
+	return 0
+}
+
+// truncate cuts s to width visible runes, ANSI escape sequences do not
+// count. Lines must not wrap, the block could not be redrawn in place.
+func truncate(s string, width int) string {
+	if width <= 0 {
+		return s
+	}
+	var sb strings.Builder
+	visible := 0
+	escaped := false
+	for i := 0; i < len(s); {
+		if s[i] == '\x1b' {
+			// copy the escape sequence up to its final byte
+			end := i + 2
+			for end < len(s) && (s[end] < '@' || s[end] > '~') {
+				end++
+			}
+			end = min(end+1, len(s))
+			sb.WriteString(s[i:end])
+			escaped = true
+			i = end
+			continue
+		}
+		if visible == width-1 && i+1 < len(s) {
+			sb.WriteString("…")
+			if escaped {
+				sb.WriteString("\x1b[0m")
+			}
+			return sb.String()
+		}
+		r, size := utf8.DecodeRuneInString(s[i:])
+		sb.WriteRune(r)
+		visible++
+		i += size
+	}
+	return sb.String()
+}
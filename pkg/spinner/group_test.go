@@ -0,0 +1,114 @@
+package spinner
+
+// Tests rendering the lines of a group from concurrent goroutines.
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// screen replays the output of a group on a virtual terminal that only
+// understands the sequences the group writes.
+func screen(out string) []string {
+	var lines []string
+	row := 0
+	for len(out) > 0 {
+		var n int
+		switch {
+		case strings.HasPrefix(out, hideCursor), strings.HasPrefix(out, showCursor):
+			out = out[len(hideCursor):]
+			continue
+		case strings.HasPrefix(out, clearLine):
+			for len(lines) <= row {
+				lines = append(lines, "")
+			}
+			lines[row] = ""
+			out = out[len(clearLine):]
+			continue
+		case strings.HasPrefix(out, "\x1b["):
+			if _, err := fmt.Sscanf(out, cursorUp, &n); err == nil {
+				row -= n
+				out = out[strings.IndexByte(out, 'A')+1:]
+				continue
+			}
+		case out[0] == '\r':
+			out = out[1:]
+			continue
+		case out[0] == '\n':
+			row++
+			out = out[1:]
+			continue
+		}
+		end := strings.IndexAny(out, "\r\n\x1b")
+		if end == -1 {
+			end = len(out)
+		}
+		for len(lines) <= row {
+			lines = append(lines, "")
+		}
+		lines[row] += out[:end]
+		out = out[end:]
+	}
+	return lines
+}
+
+func TestGroup(t *testing.T) {
+	var out bytes.Buffer
+	g := NewGroup([]string{"*"}, WithSuccessSymbol("[+]"), WithErrorSymbol("[x]"))
+	g.SetOutput(&out, 0)
+
+	var wg sync.WaitGroup
+	for i := range 3 {
+		line := g.Add(fmt.Sprintf("Pulling image %d", i))
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range 10 {
+				line.SetDetail(fmt.Sprintf("%d/10 layers", j))
+			}
+			if i == 1 {
+				line.FinishWithError(fmt.Sprintf("Failed to pull image %d", i))
+				return
+			}
+			line.FinishWithSuccess(fmt.Sprintf("Pulled image %d", i))
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, 0, g.Active())
+	g.Println("done")
+	g.Stop()
+
+	lines := screen(out.String())
+	assert.ElementsMatch(t, []string{"[+] Pulled image 0", "[x] Failed to pull image 1", "[+] Pulled image 2"}, lines[:3])
+	assert.Equal(t, "done", lines[3])
+}
+
+func TestGroupPlain(t *testing.T) {
+	var out bytes.Buffer
+	g := NewGroup([]string{"*"}, WithSuccessSymbol("[+]"), WithPlain(true), WithOutput(&out))
+	g.Enable(time.Millisecond)
+
+	first := g.Add("Pulling image 0")
+	second := g.Add("Pulling image 1")
+	second.SetDetail("1/2 layers")
+	second.FinishWithSuccess("Pulled image 1")
+	g.Println("note")
+	first.FinishWithSuccess("Pulled image 0")
+	g.Stop()
+
+	// no escape sequences, only finished lines in the order they finished
+	assert.Equal(t, "[+] Pulled image 1\nnote\n[+] Pulled image 0\n", out.String())
+}
+
+func TestTruncate(t *testing.T) {
+	assert.Equal(t, "short", truncate("short", 10))
+	assert.Equal(t, "Pulling…", truncate("Pulling image nginx", 8))
+	assert.Equal(t, "\x1b[32m[+]\x1b[0m P…\x1b[0m", truncate("\x1b[32m[+]\x1b[0m Pulled", 6))
+	assert.Equal(t, "anything", truncate("anything", 0))
+}
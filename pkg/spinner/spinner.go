@@ -35,9 +35,26 @@ func WithInfoSymbol(symbol string) SpinnerOptionFunc {
 	}
 }
 
+// WithOutput makes the spinner write to w instead of stdout.
+func WithOutput(w io.Writer) SpinnerOptionFunc {
+	return func(s *Spinner) {
+		s.out = w
+	}
+}
+
+// WithPlain makes the spinner print plain lines without animating or
+// moving the cursor, for output that is not a terminal. Only printed lines
+// and the final message are written.
+func WithPlain(plain bool) SpinnerOptionFunc {
+	return func(s *Spinner) {
+		s.plain = plain
+	}
+}
+
 type Spinner struct {
 	message string
 	frames  []string
+	plain   bool
 
 	successSymbol string
 	errorSymbol   string
@@ -109,6 +126,11 @@ func (s *Spinner) Println(msg string) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	if s.plain {
+		fmt.Fprintln(s.out, msg)
+		return
+	}
+
 	// Clear spinner line before printing
 	fmt.Fprintf(s.out, "\r%s\r", clearLine)
 
@@ -130,6 +152,10 @@ func (s *Spinner) Enable(interval time.Duration) {
 	if s.out == nil {
 		s.out = os.Stdout
 	}
+	if s.plain {
+		s.mu.Unlock()
+		return
+	}
 	s.ticker = time.NewTicker(interval)
 	s.done = make(chan struct{})
 
@@ -199,6 +225,12 @@ func (s *Spinner) stop() {
 
 func (s *Spinner) FinishWithMessage(msg string) {
 	s.stop()
+	if s.plain {
+		if msg != "" {
+			fmt.Fprintln(s.out, msg)
+		}
+		return
+	}
 	s.clearOutputBlock()
 	fmt.Fprint(s.out, showCursor)
 	fmt.Fprintf(s.out, "%s\n", msg)
@@ -0,0 +1,15 @@
+//go:build !unix
+
+package spinner
+
+import "os"
+
+// terminalWidth returns 0, lines are not cut outside of unix terminals.
+func terminalWidth(f *os.File) int {
+	return 0
+}
+
+// IsTerminal reports false, spinners are plain outside of unix terminals.
+func IsTerminal(f *os.File) bool {
+	return false
+}